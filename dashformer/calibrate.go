@@ -0,0 +1,92 @@
+package main
+
+import (
+	"dashformer/config"
+	"dashformer/reference"
+	"dashformer/utils"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runCalibrate 在校准序列上计算明文前向传播，报告注意力分数、ReLU 的输入和 LayerNorm 输入的方差的范围，
+// 建议 ReLU 和 1/sqrt 多项式的定义域以及每个块的 softmax 常数，-write 时把 softmax 常数写到 model.json
+func runCalibrate(args []string) error {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	mode := fs.String("mode", "approximate", "reference forward pass: approximate (the values the ciphertexts hold) or exact (the original model)")
+	margin := fs.Float64("margin", 0.1, "widen the proposed domains on each side by this fraction of the observed range")
+	write := fs.Bool("write", false, "write the proposed softmax constants of every block to model.json in the model directory")
+	reportFile := fs.String("report", "", "write the report to this file instead of the standard output")
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, config.InputFile|config.TokenizerFile|config.ModelDir)
+	if err != nil {
+		return err
+	}
+	modes, err := referenceModes(*mode)
+	if err != nil {
+		return err
+	}
+	if len(modes) != 1 {
+		return fmt.Errorf("calibrate on one reference mode, exact or approximate")
+	}
+
+	tokenizerDate, err := utils.ReadWordIndex(cfg.Tokenizer)
+	if err != nil {
+		return fmt.Errorf("reading tokenizer %s: %v", cfg.Tokenizer, err)
+	}
+	dashModelParam, err := readModel(cfg)
+	if err != nil {
+		return err
+	}
+	sequences, err := utils.ReadSequences(cfg.Input, tokenizerDate, sequencePolicy(cfg, dashModelParam.Shape))
+	if err != nil {
+		return fmt.Errorf("reading sequences %s: %v", cfg.Input, err)
+	}
+	printWarnings(sequences.Warnings)
+
+	calibration, err := reference.Calibrate(dashModelParam, sequences.Data, modes[0], *margin)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *reportFile != "" {
+		f, err := os.Create(*reportFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	reference.PrintCalibration(w, calibration)
+	fmt.Fprintln(w, "# fit the polynomials on the proposed domains")
+	fmt.Fprintf(w, "dashformer fit -activation relu -interval=%.4g,%.4g\n", calibration.ReluDomain[0], calibration.ReluDomain[1])
+	for l, d := range calibration.InvSqrtDomain {
+		fmt.Fprintf(w, "dashformer fit -activation invsqrt -interval %.4g,%.4g -role sqrt_layer%d_coefficients\n", d[0], d[1], l+1)
+	}
+
+	if !*write {
+		return nil
+	}
+	var specs []utils.TensorSpec
+	for _, block := range calibration.Blocks {
+		b, c := make([]float64, len(block.Softmax)), make([]float64, len(block.Softmax))
+		for h, p := range block.Softmax {
+			b[h], c[h] = p.B, p.C
+		}
+		specs = append(specs,
+			utils.TensorSpec{Role: utils.BlockRole("softmax_b", block.Block), Shape: []int{len(b)}, DType: utils.DTypeFloat64, Values: b},
+			utils.TensorSpec{Role: utils.BlockRole("softmax_c", block.Block), Shape: []int{len(c)}, DType: utils.DTypeFloat64, Values: c})
+	}
+	path, err := utils.SetManifestTensors(cfg.ModelDir, specs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote the softmax constants of %d blocks to %s\n", len(calibration.Blocks), path)
+	if len(cfg.Coefficients.SoftMaxB) > 0 {
+		fmt.Printf("warning: softmax_b and softmax_c of the configuration override those in %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"dashformer/coefficient"
+	"dashformer/config"
+	"dashformer/encryption"
+	"dashformer/maths"
+	"dashformer/utils"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+)
+
+// 各子命令之间交换的文件名
+const (
+	defaultInputCtName  = "input.ct"
+	defaultResultCtName = "result.ct"
+	// 输入序列的 ID 和窗口 (utils.SequenceLayout)，只留在数据方，decrypt 用它写结果文件
+	defaultIDsName = "input.ids"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: dashformer [command] [flags]
+
+Commands:
+  keygen    generate the secret key, the public key and the evaluation keys
+  encrypt   encrypt the example sequences with the public key
+  eval      evaluate Dashformer on encrypted sequences with the public keys only
+  decrypt   decrypt the encrypted result with the secret key
+  serve     serve encrypted inference over HTTP for clients holding their own keys
+            (no authentication: expose it to trusted clients only)
+  compare   compare the encrypted result with a cleartext forward pass of the model
+  calibrate measure the activation ranges on sample sequences and propose domains and softmax constants
+  fit       fit a polynomial to an activation and write its coefficients to model.json
+  run       run all the steps above in one process (default)
+
+Every command accepts -config with a JSON or YAML file, the flags override the file.
+Run 'dashformer [command] -h' for the flags of a command.
+`)
+}
+
+// loadConfig 在 fs 解析后读取配置 (默认值 < 配置文件 < 命令行参数)，检查 need 中的文件并设置线程数
+func loadConfig(flags *config.Flags, need config.Files) (config.Config, error) {
+	cfg, err := flags.Load()
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(need); err != nil {
+		return cfg, err
+	}
+	if need&config.ModelDir != 0 {
+		if err := utils.CheckModelParameterFiles(cfg.ModelDir); err != nil {
+			return cfg, err
+		}
+	}
+	utils.SetWorkers(cfg.Threads)
+	return cfg, nil
+}
+
+// writeResult 将解密结果按配置的格式写入 <output>，返回结果文件的路径。
+// layout 不为 nil 时按配置合并同一条序列各窗口的 logits 并写入 ID
+func writeResult(cfg config.Config, layout *utils.SequenceLayout, valueTensor [][][]float64) (string, error) {
+	logits := utils.ResultLogits(valueTensor)
+	var ids []string
+	if layout != nil {
+		var err error
+		if ids, logits, err = layout.Aggregate(logits, cfg.Sequences.Aggregate); err != nil {
+			return "", err
+		}
+	}
+	var labels []string
+	if cfg.Labels != "" {
+		var err error
+		if labels, err = utils.ReadLabels(cfg.Labels); err != nil {
+			return "", err
+		}
+	}
+	return utils.WriteResults(cfg.Output, cfg.ResultFormat, ids, logits, labels)
+}
+
+// sequencePolicy 返回配置的序列长度处理方式，长度为模型的序列长度 (位置编码的长度)
+func sequencePolicy(cfg config.Config, shape utils.ModelShape) utils.SequencePolicy {
+	return utils.SequencePolicy{
+		Length:   shape.SeqLength,
+		PadIndex: cfg.Sequences.PadIndex,
+		Long:     cfg.Sequences.Long,
+		Stride:   cfg.Sequences.WindowStride,
+		OOV:      cfg.Sequences.OOV,
+	}
+}
+
+// maxWarnings 是每个命令最多打印的输入警告条数
+const maxWarnings = 10
+
+// printWarnings 在标准错误输出中打印读取输入时的警告，超过 maxWarnings 条时只打印条数
+func printWarnings(warnings []string) {
+	for i, w := range warnings {
+		if i == maxWarnings {
+			fmt.Fprintf(os.Stderr, "warning: ... and %d more\n", len(warnings)-maxWarnings)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+}
+
+// presetParams 返回预设的 CKKS 参数，安全性没有验证的预设 (稀疏私钥) 在标准错误输出中打印警告
+func presetParams(name string) (hefloat.Parameters, error) {
+	params, err := encryption.NewHERealParamsFromPreset(name)
+	if err != nil {
+		return params, err
+	}
+	if err := encryption.CheckSecurity(params); errors.Is(err, encryption.ErrSecurityUnverified) {
+		fmt.Fprintf(os.Stderr, "warning: CKKS preset %q: %v\n", name, err)
+	}
+	return params, nil
+}
+
+// evalContext 返回密文计算使用的 context：收到 Ctrl-C (SIGINT) 或 SIGTERM 时取消，设置了 cfg.Timeout 时到期取消
+func evalContext(cfg config.Config) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if cfg.Timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(cfg.Timeout))
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// readModel 读取模型参数，并使用配置中设置的近似系数和分类层缩小的倍数
+func readModel(cfg config.Config) (utils.DashformerModelParameters, error) {
+	dashModelParam, err := utils.ReadModelParameterFile(cfg.ModelDir)
+	if err != nil {
+		return dashModelParam, err
+	}
+	dashModelParam.SetApproximationCoefficients(cfg.Coefficients)
+	dashModelParam.SetLayerNorm(cfg.LayerNorm)
+	for k := 0; k < dashModelParam.NumBlocks(); k++ {
+		if heads, softMaxB := dashModelParam.Shape.Heads, dashModelParam.Block(k).SoftMaxB; len(softMaxB) != heads {
+			return dashModelParam, fmt.Errorf("the model has %d attention heads, give %s and %s for each in %s or in the coefficients of the configuration, got %d values",
+				heads, utils.BlockRole("softmax_b", k), utils.BlockRole("softmax_c", k), utils.ModelManifestName, len(softMaxB))
+		}
+	}
+	if len(dashModelParam.ReluCoefficients) == 0 || len(dashModelParam.SqrtLayerCoefficients1) == 0 || len(dashModelParam.SqrtLayerCoefficients2) == 0 {
+		return dashModelParam, fmt.Errorf("the relu, sqrt_layer1 and sqrt_layer2 coefficients are missing from %s and from the configuration", utils.ModelManifestName)
+	}
+	dashModelParam.OutputScale = cfg.OutputScale
+	if cfg.OutputScale == 0 {
+		fmt.Printf("  - output scale %g derived from the classifier\n", dashModelParam.ResultScale())
+	}
+	return dashModelParam, nil
+}
+
+// evaluation 是 cfg.Evaluation 选择的密文计算方式：unfolded 使用折叠一个 Transformer 块的展开系数，
+// layers 逐层计算所有的块
+type evaluation struct {
+	mode           string
+	dashModelParam utils.DashformerModelParameters
+	// 只有 unfolded 使用
+	coeff_dash  coefficient.Coefficient_dash
+	coeff_QKV   coefficient.Coefficient_QKV
+	coeff_sqmax coefficient.Coefficient_sqmax
+	babyStep    int
+	giantStep   int
+	// 自举前密文中的值的上界 (见 encryption.BootstrapTensor)
+	bootstrapBound int
+}
+
+// newEvaluation 准备 cfg.Evaluation 的计算，unfolded 时计算展开系数
+func newEvaluation(cfg config.Config, dashModelParam utils.DashformerModelParameters) (*evaluation, error) {
+	e := &evaluation{mode: cfg.Evaluation, dashModelParam: dashModelParam, babyStep: cfg.BabyStep, giantStep: cfg.GiantStep, bootstrapBound: cfg.BootstrapBound}
+	if e.mode == "layers" {
+		return e, nil
+	}
+	var err error
+	e.coeff_dash, e.coeff_QKV, e.coeff_sqmax, err = coefficient.GenerateCoefficient(dashModelParam)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// levelPlan 返回逐层计算的层数预算
+func (e *evaluation) levelPlan() maths.LevelPlan {
+	return maths.PlanLevels(e.dashModelParam.NumBlocks(), len(e.dashModelParam.ReluCoefficients)-1, e.giantStep, e.dashModelParam.LayerNormInvSqrt)
+}
+
+// inputLevel 返回输入密文至少需要的层数：layers 是逐层计算的总层数 (没有自举时)，
+// unfolded 没有层数预算，需要 params 的最高层 (encrypt 加密的层)
+func (e *evaluation) inputLevel(params hefloat.Parameters) int {
+	if e.mode == "layers" {
+		return e.levelPlan().Total()
+	}
+	return params.MaxLevel()
+}
+
+// checkLevels 在 layers 计算前打印层数预算，params 的层数不够时返回 LevelError；
+// 有 bootstrapper 时报告自举的次数，自举后的层数不够一个块时返回 LevelError
+func (e *evaluation) checkLevels(params hefloat.Parameters, bootstrapper *bootstrapping.Evaluator) error {
+	if e.mode != "layers" {
+		return nil
+	}
+	plan := e.levelPlan()
+	if bootstrapper != nil {
+		fmt.Print(plan.BootstrapReport(params.MaxLevel(), bootstrapper.OutputLevel()))
+		if plan.Refreshes(params.MaxLevel(), bootstrapper.OutputLevel()) < 0 {
+			return &encryption.LevelError{Op: "bootstrapping between transformer blocks", Level: bootstrapper.OutputLevel(), Need: plan.BlockLevels()}
+		}
+		return nil
+	}
+	fmt.Print(plan.Report(params.MaxLevel()))
+	if !plan.Fits(params.MaxLevel()) {
+		return &encryption.LevelError{Op: fmt.Sprintf("layer-by-layer evaluation of %d blocks", plan.Blocks), Level: params.MaxLevel(), Need: plan.Total()}
+	}
+	return nil
+}
+
+// evalShard 对一个分片运行选择的密文计算
+func (e *evaluation) evalShard(ctx context.Context, publicKeys *encryption.PublicParametersKeys, shard *encryption.CiphertextTensor) (*encryption.CiphertextTensor, error) {
+	if e.mode == "layers" {
+		return evalLayersDashformer(ctx, publicKeys, shard, e.dashModelParam, e.babyStep, e.giantStep, e.bootstrapBound)
+	}
+	return evalUnfoldDashformerWithBSGSMultiTread(ctx, publicKeys, shard, e.dashModelParam, e.coeff_dash, e.coeff_QKV, e.coeff_sqmax, e.babyStep, e.giantStep)
+}
+
+// evalBatch 对每个分片运行 e 的密文计算，最多 parallelShards 个分片同时计算，结果按输入顺序排列
+func evalBatch(ctx context.Context, publicKeys *encryption.PublicParametersKeys, batch *encryption.CiphertextBatch, e *evaluation, parallelShards int) (*encryption.CiphertextBatch, error) {
+	if err := e.checkLevels(*publicKeys.Params, publicKeys.Bootstrapper); err != nil {
+		return nil, err
+	}
+	if len(batch.Shards) > 1 {
+		fmt.Printf("  - %d sequences in %d shards, %d evaluated at the same time\n", batch.NumRows(), len(batch.Shards), min(parallelShards, len(batch.Shards)))
+	}
+	return batch.MapShards(ctx, publicKeys, parallelShards, e.evalShard)
+}
+
+// orDefault 返回 path，未设置时返回 dir 下的 name
+func orDefault(path, dir, name string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(dir, name)
+}
+
+// runKeygen 数据方生成密钥，私钥只写入 keyDir，不会交给计算方
+func runKeygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, 0)
+	if err != nil {
+		return err
+	}
+
+	params, err := presetParams(cfg.Preset)
+	if err != nil {
+		return err
+	}
+	manifest, err := utils.ReadModelManifest(cfg.ModelDir)
+	if err != nil {
+		return err
+	}
+	keys, err := generateKeys(params, manifest.Shape.SeqLength, cfg.BabyStep, cfg.GiantStep)
+	if err != nil {
+		return err
+	}
+	if cfg.Bootstrap {
+		if err := generateBootstrappingKeys(cfg.Preset, keys); err != nil {
+			return err
+		}
+	}
+	// 逐层计算的层数由参数预设决定，在生成密钥时就报告是否够用
+	if cfg.Evaluation == "layers" {
+		plan := maths.PlanLevels(manifest.Shape.Blocks, reluDegree(cfg, manifest), cfg.GiantStep, layerNormPlan(cfg, manifest))
+		if keys.Btk != nil {
+			fmt.Print(plan.BootstrapReport(params.MaxLevel(), keys.Btk.Params.ResidualParameters.MaxLevel()))
+		} else {
+			fmt.Print(plan.Report(params.MaxLevel()))
+		}
+	}
+
+	if err := encryption.SaveKeyMaterial(cfg.Keys(), keys); err != nil {
+		return err
+	}
+	fmt.Printf("Keys written to %s (keep %s private)\n", cfg.Keys(), encryption.SecretKeyFileName)
+	return nil
+}
+
+// reluDegree 返回 ReLU 多项式的次数：配置中的系数，否则是 model.json 中的 relu_coefficients
+func reluDegree(cfg config.Config, manifest utils.ModelManifest) int {
+	return max(0, coefficientsLength(cfg.Coefficients.Relu, manifest, "relu_coefficients")-1)
+}
+
+// coefficientsLength 返回多项式系数的个数：配置中的系数 coeffs，否则是 model.json 中 role 的长度
+func coefficientsLength(coeffs []float64, manifest utils.ModelManifest, role string) int {
+	if len(coeffs) > 0 {
+		return len(coeffs)
+	}
+	for _, spec := range manifest.Tensors {
+		if spec.Role == role {
+			return spec.Shape[0]
+		}
+	}
+	return 0
+}
+
+// layerNormPlan 返回 LayerNorm1/2 的计算方式，只用于不读取模型参数时的层数预算：系数的值不影响层数
+func layerNormPlan(cfg config.Config, manifest utils.ModelManifest) [2]utils.InvSqrt {
+	var dashModelParam utils.DashformerModelParameters
+	dashModelParam.SqrtLayerCoefficients1 = make([]float64, coefficientsLength(cfg.Coefficients.SqrtLayer1, manifest, "sqrt_layer1_coefficients"))
+	dashModelParam.SqrtLayerCoefficients2 = make([]float64, coefficientsLength(cfg.Coefficients.SqrtLayer2, manifest, "sqrt_layer2_coefficients"))
+	dashModelParam.SetLayerNorm(cfg.LayerNorm)
+	return dashModelParam.LayerNormInvSqrt
+}
+
+// generateKeys 只生成 evalUnfoldDashformerWithBSGSMultiTread 对长度为 seqLength 的序列用到的旋转密钥，
+// 并报告相对 -50..50 全部旋转节省的密钥大小
+func generateKeys(params hefloat.Parameters, seqLength, babyStep, giantStep int) (*encryption.KeyMaterial, error) {
+	galEls, err := maths.DashformerGaloisElements(params, seqLength, babyStep, giantStep)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("CKKS key generation ...")
+	startTime := time.Now()
+	keys, err := encryption.GenHERealKeys(params, galEls)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf(" takes %s\n", time.Since(startTime))
+
+	defaultCount := len(encryption.DefaultGaloisElements(params))
+	var keySize int
+	for _, galoisKey := range keys.Evk.GaloisKeys {
+		keySize = galoisKey.BinarySize()
+		break
+	}
+	fmt.Printf("  - %d Galois keys instead of %d, saves %d MB of evaluation keys\n", len(galEls), defaultCount, (defaultCount-len(galEls))*keySize/1048576)
+	return keys, nil
+}
+
+// generateBootstrappingKeys 生成 preset 的自举密钥，保存在 keys.Btk 中
+func generateBootstrappingKeys(preset string, keys *encryption.KeyMaterial) error {
+	btpParams, err := encryption.NewBootstrappingParamsFromPreset(preset)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bootstrapping key generation ...")
+	startTime := time.Now()
+	if keys.Btk, err = encryption.GenBootstrappingKeys(btpParams, keys.Sk); err != nil {
+		return err
+	}
+	fmt.Printf(" takes %s\n", time.Since(startTime))
+	fmt.Printf("  - bootstrapping log N = %d, log QP = %d, %d MB of bootstrapping keys\n",
+		btpParams.BootstrappingParameters.LogN(), int(btpParams.BootstrappingParameters.LogQP()), keys.Btk.Keys.BinarySize()/1048576)
+	return nil
+}
+
+// newKeys 返回 keys 的计算方和数据方密钥，有自举密钥时计算方可以自举
+func newKeys(keys *encryption.KeyMaterial) (*encryption.PublicParametersKeys, *encryption.SecretParametersKeys, error) {
+	publicKeys := encryption.NewPublicParametersKeys(keys.Params, keys.Pk, keys.Evk)
+	if keys.Btk != nil {
+		if err := publicKeys.SetBootstrappingKeys(keys.Btk); err != nil {
+			return nil, nil, err
+		}
+	}
+	return publicKeys, encryption.NewSecretParametersKeys(keys.Params, keys.Sk), nil
+}
+
+// loadOrGenerateKeys 供 run 使用：keyDir 为空时每次重新生成密钥，否则优先读取 keyDir 中的密钥文件
+func loadOrGenerateKeys(cfg config.Config) (*encryption.PublicParametersKeys, *encryption.SecretParametersKeys, error) {
+	keyDir := cfg.KeyDir
+	if keyDir != "" && encryption.HasKeyMaterial(keyDir) {
+		fmt.Printf("Loading keys from %s ...", keyDir)
+		startTime := time.Now()
+		keys, err := encryption.LoadKeyMaterial(keyDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf(" takes %s\n", time.Since(startTime))
+		return newKeys(keys)
+	}
+
+	params, err := presetParams(cfg.Preset)
+	if err != nil {
+		return nil, nil, err
+	}
+	shape, err := utils.ReadModelShape(cfg.ModelDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := generateKeys(params, shape.SeqLength, cfg.BabyStep, cfg.GiantStep)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Bootstrap {
+		if err := generateBootstrappingKeys(cfg.Preset, keys); err != nil {
+			return nil, nil, err
+		}
+	}
+	fmt.Printf("  - log N = %d, log Q = %d, max_level = %d, log_scale = %d\n",
+		params.LogN(), int(params.LogQP()), params.MaxLevel(), params.LogDefaultScale())
+	if keyDir != "" {
+		if err := encryption.SaveKeyMaterial(keyDir, keys); err != nil {
+			return nil, nil, err
+		}
+		fmt.Printf("Keys written to %s\n", keyDir)
+	}
+	return newKeys(keys)
+}
+
+// runEncrypt 数据方用公钥加密输入序列
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	out := fs.String("out", "", "encrypted sequences output file (default <output>/"+defaultInputCtName+")")
+	idsFile := fs.String("ids", "", "record IDs and windows output file (default <output>/"+defaultIDsName+")")
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, config.InputFile|config.TokenizerFile)
+	if err != nil {
+		return err
+	}
+
+	tokenizerDate, err := utils.ReadWordIndex(cfg.Tokenizer)
+	if err != nil {
+		return fmt.Errorf("reading tokenizer %s: %v", cfg.Tokenizer, err)
+	}
+	shape, err := utils.ReadModelShape(cfg.ModelDir)
+	if err != nil {
+		return err
+	}
+	sequences, err := utils.ReadSequences(cfg.Input, tokenizerDate, sequencePolicy(cfg, shape))
+	if err != nil {
+		return fmt.Errorf("reading sequences %s: %v", cfg.Input, err)
+	}
+	printWarnings(sequences.Warnings)
+
+	params, pk, err := encryption.LoadPublicKey(filepath.Join(cfg.Keys(), encryption.PublicKeyFileName))
+	if err != nil {
+		return err
+	}
+	publicKeys := encryption.NewPublicParametersKeys(params, pk, nil)
+
+	fmt.Println("Encrypting data ... ")
+	startTime := time.Now()
+	batch, err := encryption.EncryptBatch(publicKeys, sequences.Data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encrypting data ... takes %s\n", time.Since(startTime))
+	fmt.Printf("  - %d sequences in %d shards\n", batch.NumRows(), len(batch.Shards))
+
+	if err := encryption.SaveCiphertextBatch(orDefault(*out, cfg.Output, defaultInputCtName), params, batch); err != nil {
+		return err
+	}
+
+	// 没有 ID 和窗口时删除上一次留下的文件，避免 decrypt 用错
+	idsPath := orDefault(*idsFile, cfg.Output, defaultIDsName)
+	if sequences.RowIDs() == nil {
+		if err := os.Remove(idsPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := utils.WriteSequenceLayout(idsPath, &sequences.SequenceLayout); err != nil {
+		return err
+	}
+	fmt.Printf("  - record IDs and windows written to %s\n", idsPath)
+	return nil
+}
+
+// runEval 计算方只持有公钥和计算密钥
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	in := fs.String("in", "", "encrypted sequences file (default <output>/"+defaultInputCtName+")")
+	out := fs.String("out", "", "encrypted result output file (default <output>/"+defaultResultCtName+")")
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, config.ModelDir)
+	if err != nil {
+		return err
+	}
+
+	publicKeys, err := encryption.LoadPublicParametersKeys(cfg.Keys())
+	if err != nil {
+		return err
+	}
+	params := *publicKeys.Params
+
+	dashModelParam, err := readModel(cfg)
+	if err != nil {
+		return err
+	}
+	eval, err := newEvaluation(cfg, dashModelParam)
+	if err != nil {
+		return err
+	}
+
+	batch, err := encryption.LoadCiphertextBatch(orDefault(*in, cfg.Output, defaultInputCtName), params)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := evalContext(cfg)
+	defer cancel()
+	poolingAndClassification, err := evalBatch(ctx, publicKeys, batch, eval, cfg.ParallelShards)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("  - ciphertexts now at level:%d\n", poolingAndClassification.Shards[0].Ciphertexts[0].Level())
+
+	return encryption.SaveCiphertextBatch(orDefault(*out, cfg.Output, defaultResultCtName), params, poolingAndClassification)
+}
+
+// runDecrypt 数据方用私钥解密结果
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	in := fs.String("in", "", "encrypted result file (default <output>/"+defaultResultCtName+")")
+	idsFile := fs.String("ids", "", "record IDs and windows written by encrypt (default <output>/"+defaultIDsName+" when it exists)")
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, 0)
+	if err != nil {
+		return err
+	}
+
+	secretKeys, err := encryption.LoadSecretParametersKeys(cfg.Keys())
+	if err != nil {
+		return err
+	}
+	params := *secretKeys.Params
+
+	batch, err := encryption.LoadCiphertextBatch(orDefault(*in, cfg.Output, defaultResultCtName), params)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Decrypting and writing the result ...")
+	valueTensor, err := encryption.DecryptBatch(secretKeys, batch)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cfg.Output, 0755); err != nil {
+		return err
+	}
+	layout, err := utils.ReadSequenceLayout(orDefault(*idsFile, cfg.Output, defaultIDsName))
+	if err != nil && (*idsFile != "" || !os.IsNotExist(err)) {
+		return err
+	}
+	resultPath, err := writeResult(cfg, layout, valueTensor)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Result written to %s\n", resultPath)
+	return nil
+}
@@ -12,12 +12,12 @@ type Coefficient_input struct {
 	W_e [][]float64
 	P   [][]float64
 
-	W_Q [4][][]float64
-	B_Q [4][]float64
-	W_K [4][][]float64
-	B_K [4][]float64
-	W_V [4][][]float64
-	B_V [4][]float64
+	W_Q [][][]float64
+	B_Q [][]float64
+	W_K [][][]float64
+	B_K [][]float64
+	W_V [][][]float64
+	B_V [][]float64
 
 	W_c [][]float64 //
 	B_c []float64   //
@@ -29,15 +29,17 @@ type Coefficient_input struct {
 
 	Sigma_1_diag []float64
 	Sigma_2_diag []float64
-	Sigma_1      [][]float64 // 50
+	Sigma_1      [][]float64 // seqLength X seqLength
 	Sigma_2      [][]float64
-	Gamma_1      [][]float64 // 128 X 128
+	Gamma_1      [][]float64 // embedDim X embedDim
 	Gamma_2      [][]float64
 	Beta_1       []float64
 	Beta_2       []float64
 
-	W_d [][]float64 // 128 X 25
+	W_d [][]float64 // embedDim X numClasses
 	B_d []float64
+
+	OutputScale float64 // W_d 和 B_d 缩小的倍数
 }
 
 type Coefficient_dash struct {
@@ -58,6 +60,9 @@ type Coefficient_dash struct {
 
 	Constant_Dash []float64
 	Constant_Relu [][]float64
+
+	// 结果密文中的 logits 缩小的倍数，记录在结果密文中，解密时乘回来
+	OutputScale float64
 }
 
 // type Coefficient_head struct {
@@ -68,23 +73,23 @@ type Coefficient_dash struct {
 // }
 
 type Coefficient_QKV struct {
-	A_Q [][][]float64 // 4 X 25 X 32
+	A_Q [][][]float64 // heads X vocabSize X headSize
 	A_K [][][]float64
 	A_V [][][]float64
 
-	Constant_Q [][][]float64 // 4 X 50 X 32
+	Constant_Q [][][]float64 // heads X seqLength X headSize
 	Constant_K [][][]float64
 	Constant_V [][][]float64
 }
 
 type Coefficient_sqmax struct {
 
-	// G_g []float64 // len = 4
+	// G_g []float64 // len = heads
 
-	Item_1 [][][]float64 // 4, include g
-	Item_2 [][][]float64 // 4, include g
-	Item_3 [][][]float64 // 4, include g
-	Item_4 [][][]float64 // 4, constant_sqmax
+	Item_1 [][][]float64 // heads, include g
+	Item_2 [][][]float64 // heads, include g
+	Item_3 [][][]float64 // heads, include g
+	Item_4 [][][]float64 // heads, constant_sqmax
 }
 
 // 获取字段的维数
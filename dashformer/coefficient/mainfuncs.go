@@ -8,31 +8,30 @@ import (
 
 func Create_Coefficient_input(dash utils.DashformerModelParameters) Coefficient_input {
 
-	d := 128.0
+	// embedding 宽度和序列长度
+	d := float64(dash.Shape.EmbedDim)
+	seqLength := dash.Shape.SeqLength
 
-	sigma_1_diag := dash.LayerNormSqrtVariance1
-	sigma_2_diag := dash.LayerNormSqrtVariance2
-	for i := 0; i < len(sigma_1_diag); i++ {
-		sigma_1_diag[i] = sigma_1_diag[i] / d
-	}
-	for i := 0; i < len(sigma_2_diag); i++ {
-		sigma_2_diag[i] = sigma_2_diag[i] / d
-	}
+	// 复制后再缩放，dash 中的模型参数保持不变 (参考实现等还会用到)
+	sigma_1_diag := utils.ScaleVector(dash.LayerNormSqrtVariance1, 1/d)
+	sigma_2_diag := utils.ScaleVector(dash.LayerNormSqrtVariance2, 1/d)
 
 	gam_1 := Compute_Gamma(d, dash.LayerNormVectorR1)
 	gam_2 := Compute_Gamma(d, dash.LayerNormVectorR2)
 
-	one_row := make([]float64, 50)
+	one_row := make([]float64, seqLength)
 	for i := range one_row {
 		one_row[i] = 1.0
 	}
-	one_coloum := make([][]float64, 50)
-	for i := 0; i < 50; i++ {
+	one_coloum := make([][]float64, seqLength)
+	for i := 0; i < seqLength; i++ {
 		one_coloum[i] = append(one_coloum[i], 1.0)
 	}
 
-	dash.ClassifierWeightMatrix = utils.ScaleMatrix(dash.ClassifierWeightMatrix, 1/2649.372705)
-	dash.ClassifierBiasVector = utils.ScaleVector(dash.ClassifierBiasVector, 1/2649.372705)
+	// 分类层缩小 ResultScale 倍，密文中的 logits 落在 [-1, 1] 内，解密时再乘回来
+	outputScale := dash.ResultScale()
+	dash.ClassifierWeightMatrix = utils.ScaleMatrix(dash.ClassifierWeightMatrix, 1/outputScale)
+	dash.ClassifierBiasVector = utils.ScaleVector(dash.ClassifierBiasVector, 1/outputScale)
 
 	return Coefficient_input{
 		One_50_row:    one_row,
@@ -67,6 +66,8 @@ func Create_Coefficient_input(dash utils.DashformerModelParameters) Coefficient_
 
 		W_d: dash.ClassifierWeightMatrix,
 		B_d: dash.ClassifierBiasVector,
+
+		OutputScale: outputScale,
 	}
 }
 
@@ -82,7 +83,7 @@ func Compute_coefficient_dash(in Coefficient_input) Coefficient_dash {
 	c_dash := MatrixChainAdd_slice(MatrixChainMultiply_slice(in.One_50_row, in.Sigma_2, c_y2, in.Gamma_2, in.W_d),
 		MatrixChainMultiply_slice(in.One_50_row, in.Sigma_2, in.One_50_coloum, in.B_2, in.Gamma_2, in.W_d),
 		MatrixChainMultiply_slice(in.One_50_row, in.One_50_coloum, in.Beta_2, in.W_d))[0]
-	for i := 0; i < 25; i++ {
+	for i := range in.B_d {
 		c_dash[i] = c_dash[i] + in.B_d[i]
 	}
 
@@ -108,6 +109,8 @@ func Compute_coefficient_dash(in Coefficient_input) Coefficient_dash {
 
 		Constant_Dash: c_dash,
 		Constant_Relu: c_relu,
+
+		OutputScale: in.OutputScale,
 	}
 }
 
@@ -116,7 +119,7 @@ func Compute_coefficient_QKV(in Coefficient_input) Coefficient_QKV {
 	var a_Q [][][]float64
 	var a_K [][][]float64
 	var a_V [][][]float64
-	for i := 0; i < 4; i++ {
+	for i := range in.W_Q {
 		a_Q = append(a_Q, MatrixChainMultiply_slice(in.W_e, in.W_Q[i]))
 		a_K = append(a_K, MatrixChainMultiply_slice(in.W_e, in.W_K[i]))
 		a_V = append(a_V, MatrixChainMultiply_slice(in.W_e, in.W_V[i]))
@@ -125,7 +128,7 @@ func Compute_coefficient_QKV(in Coefficient_input) Coefficient_QKV {
 	var constant_Q [][][]float64
 	var constant_K [][][]float64
 	var constant_V [][][]float64
-	for i := 0; i < 4; i++ {
+	for i := range in.W_Q {
 		constant_Q = append(constant_Q, MatrixChainAdd_slice(MatrixChainMultiply_slice(in.P, in.W_Q[i]), MatrixChainMultiply_slice(in.One_50_coloum, in.B_Q[i])))
 		constant_K = append(constant_K, MatrixChainAdd_slice(MatrixChainMultiply_slice(in.P, in.W_K[i]), MatrixChainMultiply_slice(in.One_50_coloum, in.B_K[i])))
 		constant_V = append(constant_V, MatrixChainAdd_slice(MatrixChainMultiply_slice(in.P, in.W_V[i]), MatrixChainMultiply_slice(in.One_50_coloum, in.B_V[i])))
@@ -142,24 +145,26 @@ func Compute_coefficient_QKV(in Coefficient_input) Coefficient_QKV {
 	}
 }
 
-func Compute_coefficient_sqmax(coeffi_QKV Coefficient_QKV, b [4]float64, c [4]float64) Coefficient_sqmax {
-
-	if len(b) != 4 || len(c) != 4 {
-		fmt.Println("len(b) != 4 or len(c) != 4 !!!")
+// Compute_coefficient_sqmax 计算每个注意力头的 softmax 近似系数，b 和 c 每个头一个值，头的宽度为 A_Q[i] 的列数
+func Compute_coefficient_sqmax(coeffi_QKV Coefficient_QKV, b []float64, c []float64) (Coefficient_sqmax, error) {
+	heads := len(coeffi_QKV.A_Q)
+	if len(b) != heads || len(c) != heads {
+		return Coefficient_sqmax{}, fmt.Errorf("%d softmax_b and %d softmax_c values for %d attention heads", len(b), len(c), heads)
 	}
 
-	g := make([]float64, 4)
-	// h := make([]float64, 4)
-	for i := 0; i < 4; i++ {
-		g[i] = 1.0 / (math.Sqrt(32.0 * c[i]))
+	g := make([]float64, heads)
+	// h := make([]float64, heads)
+	for i := 0; i < heads; i++ {
+		headSize := float64(len(coeffi_QKV.A_Q[i][0]))
+		g[i] = 1.0 / (math.Sqrt(headSize * c[i]))
 		// h[i] = b[i] / math.Sqrt(c[i])
 	}
 
-	item_1 := make([][][]float64, 4)
-	item_2 := make([][][]float64, 4)
-	item_3 := make([][][]float64, 4)
-	item_4 := make([][][]float64, 4)
-	for i := 0; i < 4; i++ {
+	item_1 := make([][][]float64, heads)
+	item_2 := make([][][]float64, heads)
+	item_3 := make([][][]float64, heads)
+	item_4 := make([][][]float64, heads)
+	for i := 0; i < heads; i++ {
 		item_1[i] = MatrixChainMultiply_slice(coeffi_QKV.A_Q[i], Transp(coeffi_QKV.A_K[i]))
 		item_1[i] = MultiplyByScalar(item_1[i], g[i])
 
@@ -181,7 +186,7 @@ func Compute_coefficient_sqmax(coeffi_QKV Coefficient_QKV, b [4]float64, c [4]fl
 		Item_2: item_2,
 		Item_3: item_3,
 		Item_4: item_4,
-	}
+	}, nil
 }
 
 // func Compute_coefficient_head(coeffi_in Coefficient_input) (Coefficient_head) {
@@ -194,7 +199,12 @@ func Compute_coefficient_sqmax(coeffi_QKV Coefficient_QKV, b [4]float64, c [4]fl
 //			W_Head: SplitMatrixIntoFourChunks_byRow(W_Head_whole),
 //		}
 //	}
-func GenerateCoefficient(dashModelParam utils.DashformerModelParameters) (Coefficient_dash, Coefficient_QKV, Coefficient_sqmax) {
+// GenerateCoefficient 计算密文计算使用的展开系数，softmax 常数的个数和头数不符时返回错误。
+// 展开的系数只折叠一个 Transformer 块，多个块的模型返回错误 (须逐层计算)
+func GenerateCoefficient(dashModelParam utils.DashformerModelParameters) (Coefficient_dash, Coefficient_QKV, Coefficient_sqmax, error) {
+	if n := dashModelParam.NumBlocks(); n > 1 {
+		return Coefficient_dash{}, Coefficient_QKV{}, Coefficient_sqmax{}, fmt.Errorf("the unfolded coefficients fold one transformer block, the model has %d: use -evaluation layers", n)
+	}
 	coeff_in := Create_Coefficient_input(dashModelParam)
 	// coeff_in.PrintDimensions()
 
@@ -204,8 +214,11 @@ func GenerateCoefficient(dashModelParam utils.DashformerModelParameters) (Coeffi
 	coeff_QKV := Compute_coefficient_QKV(coeff_in)
 	// coeff_QKV.PrintDimensions()
 
-	coeff_sqmax := Compute_coefficient_sqmax(coeff_QKV, dashModelParam.SoftMaxB, dashModelParam.SoftMaxC)
+	coeff_sqmax, err := Compute_coefficient_sqmax(coeff_QKV, dashModelParam.SoftMaxB, dashModelParam.SoftMaxC)
+	if err != nil {
+		return coeff_dash, coeff_QKV, coeff_sqmax, err
+	}
 	// coeff_sqmax.PrintDimensions()
 
-	return coeff_dash, coeff_QKV, coeff_sqmax
+	return coeff_dash, coeff_QKV, coeff_sqmax, nil
 }
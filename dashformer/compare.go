@@ -0,0 +1,141 @@
+package main
+
+import (
+	"dashformer/config"
+	"dashformer/encryption"
+	"dashformer/reference"
+	"dashformer/utils"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// referenceModes 解析 compare 的 -mode
+func referenceModes(mode string) ([]reference.Mode, error) {
+	switch mode {
+	case "exact":
+		return []reference.Mode{reference.Exact}, nil
+	case "approximate":
+		return []reference.Mode{reference.Approximate}, nil
+	case "both":
+		return []reference.Mode{reference.Exact, reference.Approximate}, nil
+	}
+	return nil, fmt.Errorf("unknown reference mode %q, use exact, approximate or both", mode)
+}
+
+// runCompare 在同一批序列上运行密文计算和明文参考实现，报告两者 logits 的差：
+// 和 exact 的差是近似加 CKKS 的误差，和 approximate 的差只有 CKKS 的误差
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	in := fs.String("in", "", "encrypted result of the input sequences, decrypted with the secret key of -keys (default: encrypt and evaluate the sequences now)")
+	topK := fs.Int("k", 3, "number of classes of the top-k agreement")
+	mode := fs.String("mode", "both", "reference forward pass: exact, approximate or both")
+	reportFile := fs.String("report", "", "write the report to this file instead of the standard output")
+	fs.Parse(args)
+	cfg, err := loadConfig(flags, config.InputFile|config.TokenizerFile|config.ModelDir)
+	if err != nil {
+		return err
+	}
+	modes, err := referenceModes(*mode)
+	if err != nil {
+		return err
+	}
+
+	tokenizerDate, err := utils.ReadWordIndex(cfg.Tokenizer)
+	if err != nil {
+		return fmt.Errorf("reading tokenizer %s: %v", cfg.Tokenizer, err)
+	}
+	dashModelParam, err := readModel(cfg)
+	if err != nil {
+		return err
+	}
+	sequences, err := utils.ReadSequences(cfg.Input, tokenizerDate, sequencePolicy(cfg, dashModelParam.Shape))
+	if err != nil {
+		return fmt.Errorf("reading sequences %s: %v", cfg.Input, err)
+	}
+	printWarnings(sequences.Warnings)
+
+	// 明文参考实现很快，先计算，维数不对时不必等密文计算
+	referenceLogits := make([][][]float64, len(modes))
+	for i, m := range modes {
+		if referenceLogits[i], err = reference.Forward(dashModelParam, sequences.Data, m); err != nil {
+			return fmt.Errorf("%v reference: %v", m, err)
+		}
+	}
+	layerNormReports, err := reference.LayerNormAccuracy(dashModelParam, sequences.Data)
+	if err != nil {
+		return fmt.Errorf("layernorm accuracy: %v", err)
+	}
+
+	var valueTensor [][][]float64
+	if *in != "" {
+		secretKeys, err := encryption.LoadSecretParametersKeys(cfg.Keys())
+		if err != nil {
+			return err
+		}
+		batch, err := encryption.LoadCiphertextBatch(*in, *secretKeys.Params)
+		if err != nil {
+			return err
+		}
+		if valueTensor, err = encryption.DecryptBatch(secretKeys, batch); err != nil {
+			return err
+		}
+	} else {
+		eval, err := newEvaluation(cfg, dashModelParam)
+		if err != nil {
+			return err
+		}
+		publicKeys, secretKeys, err := loadOrGenerateKeys(cfg)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("Encrypting data ... ")
+		startTime := time.Now()
+		batch, err := encryption.EncryptBatch(publicKeys, sequences.Data)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Encrypting data ... takes %s\n", time.Since(startTime))
+
+		ctx, cancel := evalContext(cfg)
+		defer cancel()
+		result, err := evalBatch(ctx, publicKeys, batch, eval, cfg.ParallelShards)
+		if err != nil {
+			return err
+		}
+		if valueTensor, err = encryption.DecryptBatch(secretKeys, result); err != nil {
+			return err
+		}
+	}
+	logits := utils.ResultLogits(valueTensor)
+
+	var w io.Writer = os.Stdout
+	if *reportFile != "" {
+		file, err := os.Create(*reportFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	for i, m := range modes {
+		report, err := reference.Compare(logits, referenceLogits[i], *topK)
+		if err != nil {
+			return fmt.Errorf("comparing with the %v reference: %v", m, err)
+		}
+		fmt.Fprintf(w, "# encrypted vs %v reference\n", m)
+		report.Print(w)
+		fmt.Fprintln(w)
+	}
+	// LayerNorm 的近似误差只和明文有关 (-layernorm1/-layernorm2 选择的计算方式)
+	fmt.Fprintln(w, "# layernorm 1/sqrt(variance) vs exact")
+	reference.PrintLayerNormReports(w, layerNormReports)
+	if *reportFile != "" {
+		fmt.Printf("Report written to %s\n", *reportFile)
+	}
+	return nil
+}
@@ -1,23 +1,375 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the paths and the settings shared by all the commands.
+// It is read from a JSON or YAML file (see Load) and every field can be overridden by a flag (see AddFlags).
+type Config struct {
+	Input     string `json:"input" yaml:"input"`
+	Tokenizer string `json:"tokenizer" yaml:"tokenizer"`
+	ModelDir  string `json:"model_dir" yaml:"model_dir"`
+	Output    string `json:"output" yaml:"output"`
+	// 结果文件的格式：txt (output.txt)、jsonl 或 csv
+	ResultFormat string `json:"result_format" yaml:"result_format"`
+	// 类别名文件，每行一个类别名，为空时 jsonl 和 csv 只给出类别编号
+	Labels string `json:"labels" yaml:"labels"`
+	// 密钥目录，为空时 keygen/encrypt/eval/decrypt 使用 DefaultKeyDir，run 每次重新生成密钥
+	KeyDir string `json:"key_dir" yaml:"key_dir"`
+
+	// CKKS 参数预设 (见 encryption.Presets)
+	Preset string `json:"preset" yaml:"preset"`
+	// 密文计算的方式：unfolded 将唯一的 Transformer 块折叠成展开的系数，
+	// layers 逐层计算 (attention → combine → LayerNorm → FFN → LayerNorm)，可以计算多个块
+	Evaluation string `json:"evaluation" yaml:"evaluation"`
+	// keygen 和 run 同时生成自举密钥 (btk.bin)，需要能自举的预设；layers 计算在层数不够下一个块时自举
+	Bootstrap bool `json:"bootstrap" yaml:"bootstrap"`
+	// 自举前密文中的值除以这个整数，须不小于块之间的值的绝对值
+	BootstrapBound int `json:"bootstrap_bound" yaml:"bootstrap_bound"`
+	// 密文计算和密钥生成共享的工作 goroutine 数，默认为 CPU 核数
+	Threads int `json:"threads" yaml:"threads"`
+	// 注意力 BSGS 的步长，babyStep*giantStep 需不小于序列长度
+	BabyStep  int `json:"baby_step" yaml:"baby_step"`
+	GiantStep int `json:"giant_step" yaml:"giant_step"`
+	// 序列数超过一条密文的槽数时按分片计算，同时计算的分片数
+	ParallelShards int `json:"parallel_shards" yaml:"parallel_shards"`
+	// 分类层系数缩小的倍数，解密时乘回来；0 表示由模型推出 logits 的上界 (见 utils.DashformerModelParameters.LogitBound)
+	OutputScale float64 `json:"output_scale" yaml:"output_scale"`
+	// 一次密文计算的最长时间，0 表示不限制；serve 对每个请求分别计时
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+
+	Sequences    Sequences    `json:"sequences" yaml:"sequences"`
+	LayerNorm    LayerNorm    `json:"layernorm" yaml:"layernorm"`
+	Coefficients Coefficients `json:"coefficients" yaml:"coefficients"`
+}
+
+// Sequences sets how the input sequences are brought to the length of the model (see utils.SequencePolicy).
+type Sequences struct {
+	// 长序列的处理方式：truncate、window 或 error
+	Long string `json:"long" yaml:"long"`
+	// window 相邻窗口起点的距离
+	WindowStride int `json:"window_stride" yaml:"window_stride"`
+	// 同一条序列各窗口的 logits 的合并方式：none、mean、max 或 attention
+	Aggregate string `json:"aggregate" yaml:"aggregate"`
+	// 短序列末尾补的词编号
+	PadIndex int `json:"pad_index" yaml:"pad_index"`
+	// tokenizer 中没有的残基按这个残基编码，为空时报错
+	OOV string `json:"oov" yaml:"oov"`
+}
+
+// LayerNorm chooses how the layers evaluation computes 1/sqrt(variance) in LayerNorm1 and LayerNorm2 (see utils.InvSqrt).
+type LayerNorm struct {
+	// precomputed 使用模型中预先计算的 1/sqrt(variance) (1 层，只在校准数据的分布上正确)，
+	// encrypted 在密文上计算方差，以 sqrt_layer1/2 多项式的值为初始值做牛顿迭代
+	Layer1 string `json:"layer1" yaml:"layer1"`
+	Layer2 string `json:"layer2" yaml:"layer2"`
+	// encrypted 的牛顿迭代次数，每次消耗 3 层
+	NewtonIterations int `json:"newton_iterations" yaml:"newton_iterations"`
+}
+
+// Coefficients are the constants of the polynomial approximations (ReLU, 1/sqrt of the LayerNorm variance)
+// and of the softmax approximation of each attention head.
+// The constants left empty are those of the model (its model.json, or DefaultCoefficients for the released model).
+type Coefficients struct {
+	Relu       []float64 `json:"relu" yaml:"relu"`
+	SqrtLayer1 []float64 `json:"sqrt_layer1" yaml:"sqrt_layer1"`
+	SqrtLayer2 []float64 `json:"sqrt_layer2" yaml:"sqrt_layer2"`
+	SoftMaxB   []float64 `json:"softmax_b" yaml:"softmax_b"`
+	SoftMaxC   []float64 `json:"softmax_c" yaml:"softmax_c"`
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "1h30m" in the configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration %s: want a string such as \"30m\"", data)
+	}
+	return d.set(s)
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.set(value.Value)
+}
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultKeyDir is the key directory of the commands that need keys when KeyDir is not set.
+const DefaultKeyDir = "data/keys"
+
+// Default returns the configuration used when no file and no flag is given.
+func Default() Config {
+	return Config{
+		// 初始化文件读取地址
+		Input:     "data/example_AA_sequences.list",
+		Tokenizer: "data/dashformer_tokenizer.json",
+		ModelDir:  "data/dashformer_model_parameters",
+		Output:    "data/output",
+
+		ResultFormat: "txt",
+
+		Preset:         "default",
+		Evaluation:     "unfolded",
+		BootstrapBound: 16,
+		Threads:        runtime.NumCPU(),
+		BabyStep:       7,
+		GiantStep:      8,
+
+		ParallelShards: 2,
+
+		Sequences: Sequences{
+			Long:         "truncate",
+			WindowStride: 25,
+			Aggregate:    "mean",
+			PadIndex:     0,
+			OOV:          "x",
+		},
+		LayerNorm: LayerNorm{
+			Layer1:           "precomputed",
+			Layer2:           "precomputed",
+			NewtonIterations: 1,
+		},
+	}
+}
+
+// Load reads the configuration file at path on top of the defaults, the format is chosen by the extension (.json, .yaml or .yml).
+// Fields missing from the file keep their default value, unknown fields are an error.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cfg)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+	default:
+		return cfg, fmt.Errorf("config file %s: unknown extension, want .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Flags are the command-line flags that override the configuration file.
+type Flags struct {
+	fs   *flag.FlagSet
+	path string
+	// set copies the value of each flag, by name, into a Config
+	set map[string]func(*Config)
+}
+
+// bindFlag registers the flag name with define, its default taken from the field of Default,
+// and records how to copy its value into the field of a Config.
+func bindFlag[T any](f *Flags, define func(p *T, name string, value T, usage string), name string, field func(*Config) *T, usage string) {
+	def := Default()
+	value := new(T)
+	define(value, name, *field(&def), usage)
+	f.set[name] = func(cfg *Config) { *field(cfg) = *value }
+}
+
+// AddFlags registers -config and one flag per setting on fs.
+// Call Load once fs is parsed: only the flags set on the command line override the file.
+func AddFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{fs: fs, set: map[string]func(*Config){}}
+	fs.StringVar(&f.path, "config", "", "JSON or YAML configuration file")
+	bindFlag(f, fs.StringVar, "input", func(c *Config) *string { return &c.Input }, "sequences file")
+	bindFlag(f, fs.StringVar, "tokenizer", func(c *Config) *string { return &c.Tokenizer }, "tokenizer JSON file")
+	bindFlag(f, fs.StringVar, "model", func(c *Config) *string { return &c.ModelDir }, "model parameter directory")
+	bindFlag(f, fs.StringVar, "output", func(c *Config) *string { return &c.Output }, "output directory")
+	bindFlag(f, fs.StringVar, "format", func(c *Config) *string { return &c.ResultFormat }, "result file format: txt, jsonl or csv")
+	bindFlag(f, fs.StringVar, "labels", func(c *Config) *string { return &c.Labels }, "class names file, one per line (jsonl and csv)")
+	bindFlag(f, fs.StringVar, "keys", func(c *Config) *string { return &c.KeyDir }, "key directory (default "+DefaultKeyDir+", run generates fresh keys unless set)")
+	bindFlag(f, fs.StringVar, "preset", func(c *Config) *string { return &c.Preset }, "CKKS parameter preset: fast-test, default, high-precision, logn15, deep, deep-layernorm or bootstrap")
+	bindFlag(f, fs.StringVar, "evaluation", func(c *Config) *string { return &c.Evaluation }, "encrypted evaluation: unfolded (one transformer block) or layers (layer by layer, any number of blocks)")
+	bindFlag(f, fs.BoolVar, "bootstrap", func(c *Config) *bool { return &c.Bootstrap }, "generate bootstrapping keys with keygen and run (preset bootstrap), layers evaluation then refreshes the ciphertexts between blocks")
+	bindFlag(f, fs.IntVar, "bootstrap-bound", func(c *Config) *int { return &c.BootstrapBound }, "bound on the absolute values of the ciphertexts refreshed by bootstrapping")
+	bindFlag(f, fs.IntVar, "threads", func(c *Config) *int { return &c.Threads }, "number of worker goroutines of the encrypted computation and key generation")
+	bindFlag(f, fs.IntVar, "baby-step", func(c *Config) *int { return &c.BabyStep }, "baby step of the BSGS attention")
+	bindFlag(f, fs.IntVar, "giant-step", func(c *Config) *int { return &c.GiantStep }, "giant step of the BSGS attention")
+	bindFlag(f, fs.IntVar, "parallel-shards", func(c *Config) *int { return &c.ParallelShards }, "number of shards of a large batch evaluated at the same time")
+	bindFlag(f, fs.Float64Var, "output-scale", func(c *Config) *float64 { return &c.OutputScale }, "divide the classifier by this factor during the encrypted evaluation (0: derived from the model)")
+	bindFlag(f, fs.DurationVar, "timeout", func(c *Config) *time.Duration { return (*time.Duration)(&c.Timeout) }, "maximum duration of an encrypted evaluation, per request for serve (0 means no limit)")
+	bindFlag(f, fs.StringVar, "long-sequences", func(c *Config) *string { return &c.Sequences.Long }, "sequences longer than the model: truncate, window or error")
+	bindFlag(f, fs.IntVar, "window-stride", func(c *Config) *int { return &c.Sequences.WindowStride }, "distance between the starts of two windows of a long sequence")
+	bindFlag(f, fs.StringVar, "aggregate", func(c *Config) *string { return &c.Sequences.Aggregate }, "merge the logits of the windows of a sequence: none, mean, max or attention")
+	bindFlag(f, fs.IntVar, "pad-index", func(c *Config) *int { return &c.Sequences.PadIndex }, "token index appended to sequences shorter than the model")
+	bindFlag(f, fs.StringVar, "oov", func(c *Config) *string { return &c.Sequences.OOV }, "residue whose token encodes the residues missing from the tokenizer (empty: error)")
+	bindFlag(f, fs.StringVar, "layernorm1", func(c *Config) *string { return &c.LayerNorm.Layer1 }, "1/sqrt(variance) of LayerNorm1 in layers evaluation: precomputed or encrypted")
+	bindFlag(f, fs.StringVar, "layernorm2", func(c *Config) *string { return &c.LayerNorm.Layer2 }, "1/sqrt(variance) of LayerNorm2 in layers evaluation: precomputed or encrypted")
+	bindFlag(f, fs.IntVar, "newton-iterations", func(c *Config) *int { return &c.LayerNorm.NewtonIterations }, "Newton iterations after the polynomial initial guess of an encrypted LayerNorm (3 levels each)")
+	return f
+}
+
+// Load returns the defaults, overridden by the -config file, overridden by the flags set on the command line.
+func (f *Flags) Load() (Config, error) {
+	cfg := Default()
+	if f.path != "" {
+		var err error
+		if cfg, err = Load(f.path); err != nil {
+			return cfg, err
+		}
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		if set, ok := f.set[fl.Name]; ok {
+			set(&cfg)
+		}
+	})
+	return cfg, nil
+}
+
+// Keys returns KeyDir, or DefaultKeyDir when it is not set.
+func (c Config) Keys() string {
+	if c.KeyDir == "" {
+		return DefaultKeyDir
+	}
+	return c.KeyDir
+}
+
+// Files lists the inputs a command reads, Validate only checks those.
+type Files int
+
+const (
+	InputFile Files = 1 << iota
+	TokenizerFile
+	ModelDir
 )
 
-// Init initializes the configuration paths and returns them.
-func Init() (string, string, string, string) {
-	fmt.Println("Initializing configuration...")
+// Validate checks the settings and that the files in need exist.
+func (c Config) Validate(need Files) error {
+	var errs []string
+	checkFile := func(name, path string, dir bool) {
+		if path == "" {
+			errs = append(errs, fmt.Sprintf("%s is not set", name))
+			return
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		case dir && !info.IsDir():
+			errs = append(errs, fmt.Sprintf("%s: %s is not a directory", name, path))
+		case !dir && info.IsDir():
+			errs = append(errs, fmt.Sprintf("%s: %s is a directory", name, path))
+		}
+	}
+	if need&InputFile != 0 {
+		checkFile("input", c.Input, false)
+	}
+	if need&TokenizerFile != 0 {
+		checkFile("tokenizer", c.Tokenizer, false)
+	}
+	if need&ModelDir != 0 {
+		checkFile("model directory", c.ModelDir, true)
+	}
 
-	// 初始化文件读取地址
-	examplePath := "data/example_AA_sequences.list"
-	tokenizerPath := "data/dashformer_tokenizer.json"
-	modelParamPath := "data/dashformer_model_parameters"
-	outputPath := "data/output"
+	if c.Labels != "" {
+		checkFile("labels", c.Labels, false)
+	}
 
-	return examplePath, tokenizerPath, modelParamPath, outputPath
+	switch c.ResultFormat {
+	case "txt", "jsonl", "csv":
+	default:
+		errs = append(errs, fmt.Sprintf("result_format must be txt, jsonl or csv, got %q", c.ResultFormat))
+	}
+	switch c.Evaluation {
+	case "unfolded", "layers":
+	default:
+		errs = append(errs, fmt.Sprintf("evaluation must be unfolded or layers, got %q", c.Evaluation))
+	}
+	if c.BootstrapBound <= 0 {
+		errs = append(errs, fmt.Sprintf("bootstrap_bound must be positive, got %d", c.BootstrapBound))
+	}
+	if c.Threads <= 0 {
+		errs = append(errs, fmt.Sprintf("threads must be positive, got %d", c.Threads))
+	}
+	if c.ParallelShards <= 0 {
+		errs = append(errs, fmt.Sprintf("parallel_shards must be positive, got %d", c.ParallelShards))
+	}
+	if c.OutputScale < 0 {
+		errs = append(errs, fmt.Sprintf("output_scale must not be negative, got %g", c.OutputScale))
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, fmt.Sprintf("timeout must not be negative, got %v", time.Duration(c.Timeout)))
+	}
+	if c.BabyStep <= 0 || c.GiantStep <= 0 {
+		errs = append(errs, fmt.Sprintf("baby step %d and giant step %d must be positive", c.BabyStep, c.GiantStep))
+	}
+	switch c.Sequences.Long {
+	case "truncate", "window", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("sequences.long must be truncate, window or error, got %q", c.Sequences.Long))
+	}
+	switch c.Sequences.Aggregate {
+	case "none", "mean", "max", "attention":
+	default:
+		errs = append(errs, fmt.Sprintf("sequences.aggregate must be none, mean, max or attention, got %q", c.Sequences.Aggregate))
+	}
+	if c.Sequences.WindowStride <= 0 {
+		errs = append(errs, fmt.Sprintf("sequences.window_stride must be positive, got %d", c.Sequences.WindowStride))
+	}
+	if c.Sequences.PadIndex < 0 {
+		errs = append(errs, fmt.Sprintf("sequences.pad_index must not be negative, got %d", c.Sequences.PadIndex))
+	}
+	for _, l := range []struct{ name, value string }{{"layernorm.layer1", c.LayerNorm.Layer1}, {"layernorm.layer2", c.LayerNorm.Layer2}} {
+		switch l.value {
+		case "precomputed":
+		case "encrypted":
+			if c.Evaluation != "layers" {
+				errs = append(errs, fmt.Sprintf("%s encrypted needs the layers evaluation, got %q", l.name, c.Evaluation))
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s must be precomputed or encrypted, got %q", l.name, l.value))
+		}
+	}
+	if c.LayerNorm.NewtonIterations < 0 {
+		errs = append(errs, fmt.Sprintf("layernorm.newton_iterations must not be negative, got %d", c.LayerNorm.NewtonIterations))
+	}
+	if len(c.Coefficients.SoftMaxB) != len(c.Coefficients.SoftMaxC) {
+		errs = append(errs, fmt.Sprintf("softmax_b and softmax_c must hold one value per attention head, got %d and %d values", len(c.Coefficients.SoftMaxB), len(c.Coefficients.SoftMaxC)))
+	}
+	for i, v := range c.Coefficients.SoftMaxC {
+		if v <= 0 {
+			errs = append(errs, fmt.Sprintf("softmax_c[%d] must be positive, got %g", i, v))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
 }
 
-func InitCoeffients() ([]float64, []float64, []float64, [4]float64, [4]float64) {
+// DefaultCoefficients returns the polynomial and softmax constants fitted for the released model.
+func DefaultCoefficients() Coefficients {
 	// reluCoefficients := []float64{
 	// 	6.37605427e-01, // x^0
 	// 	3.43799506e-01, // x^1
@@ -77,8 +429,8 @@ func InitCoeffients() ([]float64, []float64, []float64, [4]float64, [4]float64)
 	// softMaxB := [4]float64{1.95, 1.95, 1.95, 1.95}
 	// softMaxC := [4]float64{200, 200, 200, 200}
 
-	softMaxB := [4]float64{1.32, 0.75, 0.66, 1.14}
-	softMaxC := [4]float64{450, 181, 158, 376}
+	softMaxB := []float64{1.32, 0.75, 0.66, 1.14}
+	softMaxC := []float64{450, 181, 158, 376}
 	reluCoefficients := []float64{
 		9.43651501e-01,
 		3.59049720e-01,
@@ -89,5 +441,11 @@ func InitCoeffients() ([]float64, []float64, []float64, [4]float64, [4]float64)
 		-6.99022399e-09,
 	}
 
-	return reluCoefficients, sqrtLayerCoefficients1, sqrtLayerCoefficients2, softMaxB, softMaxC
+	return Coefficients{
+		Relu:       reluCoefficients,
+		SqrtLayer1: sqrtLayerCoefficients1,
+		SqrtLayer2: sqrtLayerCoefficients2,
+		SoftMaxB:   softMaxB,
+		SoftMaxC:   softMaxC,
+	}
 }
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// changedValue 返回和标志的默认值不同的值
+func changedValue(t *testing.T, fl *flag.Flag) string {
+	switch v := fl.Value.(flag.Getter).Get().(type) {
+	case string:
+		return v + "-changed"
+	case bool:
+		return fmt.Sprint(!v)
+	case int:
+		return fmt.Sprint(v + 7)
+	case float64:
+		return fmt.Sprint(v + 0.5)
+	case time.Duration:
+		return (v + 3*time.Second).String()
+	default:
+		t.Fatalf("flag -%s: unexpected type %T", fl.Name, v)
+		return ""
+	}
+}
+
+func TestFlagsOverrideConfig(t *testing.T) {
+	var names []string
+	AddFlags(flag.NewFlagSet("", flag.ContinueOnError)).fs.VisitAll(func(fl *flag.Flag) {
+		if fl.Name != "config" {
+			names = append(names, fl.Name)
+		}
+	})
+
+	for _, name := range names {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		flags := AddFlags(fs)
+		value := changedValue(t, fs.Lookup(name))
+		if err := fs.Parse([]string{"-" + name + "=" + value}); err != nil {
+			t.Fatalf("-%s=%s: %v", name, value, err)
+		}
+		cfg, err := flags.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(cfg, Default()) {
+			t.Errorf("-%s=%s does not override the configuration", name, value)
+		}
+	}
+}
+
+func TestFlagsOverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("input: file.txt\noutput: file-output\nthreads: 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	flags := AddFlags(fs)
+	if err := fs.Parse([]string{"-config", path, "-input", "flag.txt", "-threads", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 命令行上的标志覆盖文件，没有设置的标志不覆盖文件
+	if cfg.Input != "flag.txt" || cfg.Threads != 5 || cfg.Output != "file-output" {
+		t.Errorf("got input %q, threads %d and output %q, want flag.txt, 5 and file-output", cfg.Input, cfg.Threads, cfg.Output)
+	}
+	if cfg.Tokenizer != Default().Tokenizer {
+		t.Errorf("got tokenizer %q, want the default %q", cfg.Tokenizer, Default().Tokenizer)
+	}
+}
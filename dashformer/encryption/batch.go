@@ -0,0 +1,267 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"dashformer/utils"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+// CiphertextBatch 是按行 (序列) 切分的密文张量：一个 CiphertextTensor 的每条密文只能放下
+// MaxSlots/NumCols 条序列，更多的序列依次放进后面的分片 (shard)
+type CiphertextBatch struct {
+	Shards []*CiphertextTensor
+}
+
+// NumRows returns the number of rows (sequences) of all the shards.
+func (b *CiphertextBatch) NumRows() int {
+	numRows := 0
+	for _, shard := range b.Shards {
+		numRows += shard.NumRows
+	}
+	return numRows
+}
+
+// RowsPerShard returns how many rows of numCols values fit in the slots of one ciphertext.
+func RowsPerShard(numCols, slots int) (int, error) {
+	if numCols <= 0 || numCols > slots {
+		return 0, fmt.Errorf("rows of %d values do not fit in %d slots", numCols, slots)
+	}
+	return slots / numCols, nil
+}
+
+/*
+ * EncryptBatch
+ * Input:  PublicParametersKeys,ptTensor Slice[][][]
+ * Output: CiphertextBatch,error
+ * Compute: Encrypting ptTensor to shards of at most MaxSlots/NumCols rows, in the order of the rows
+ */
+func EncryptBatch(publicKeys *PublicParametersKeys, plainTensorValue [][][]float64) (*CiphertextBatch, error) {
+	if len(plainTensorValue) == 0 || len(plainTensorValue[0]) == 0 {
+		return nil, fmt.Errorf("nothing to encrypt")
+	}
+	rowsPerShard, err := RowsPerShard(len(plainTensorValue[0]), publicKeys.Params.MaxSlots())
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &CiphertextBatch{}
+	for start := 0; start < len(plainTensorValue); start += rowsPerShard {
+		end := min(start+rowsPerShard, len(plainTensorValue))
+		shard, err := EncryptTensorValueMultiTread(publicKeys, plainTensorValue[start:end])
+		if err != nil {
+			return nil, err
+		}
+		batch.Shards = append(batch.Shards, shard)
+	}
+	return batch, nil
+}
+
+/*
+ * DecryptBatch
+ * Input:  SecretParametersKeys,CiphertextBatch
+ * Output: Slice[][][],error
+ * Compute: Decrypting every shard and concatenating the rows in the order of the shards
+ */
+func DecryptBatch(secretKeys *SecretParametersKeys, batch *CiphertextBatch) ([][][]float64, error) {
+	valueTensor := make([][][]float64, 0, batch.NumRows())
+	for _, shard := range batch.Shards {
+		shardValue, err := DecryptTensorValueMultiThread(secretKeys, shard)
+		if err != nil {
+			return nil, err
+		}
+		valueTensor = append(valueTensor, shardValue...)
+	}
+	return valueTensor, nil
+}
+
+// MapShards 对每个分片调用 f，最多 parallel 个分片同时计算，结果按分片顺序排列。
+// 每个 goroutine 使用 publicKeys 的浅拷贝，f 中可以直接使用它的 Evaluator；
+// 出错时取消传给其余分片的 ctx，返回第一个错误
+func (b *CiphertextBatch) MapShards(ctx context.Context, publicKeys *PublicParametersKeys, parallel int,
+	f func(ctx context.Context, publicKeys *PublicParametersKeys, shard *CiphertextTensor) (*CiphertextTensor, error)) (*CiphertextBatch, error) {
+	if parallel <= 0 {
+		parallel = 1
+	}
+	results := make([]*CiphertextTensor, len(b.Shards))
+
+	g, gctx := utils.WithContext(ctx)
+	sem := make(chan struct{}, parallel)
+	for i, shard := range b.Shards {
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			defer func() { <-sem }()
+			result, err := f(gctx, publicKeys.ShallowCopy(), shard)
+			if err != nil {
+				return fmt.Errorf("shard %d: %w", i, err)
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return &CiphertextBatch{Shards: results}, nil
+}
+
+/*
+ * CiphertextBatch 二进制格式 (little endian)
+ *   magic       [4]byte  "DFCB"
+ *   version     uint8    CiphertextBatchVersion
+ *   count       uint64   number of shards
+ *   count × CiphertextTensor (see serialization.go)
+ * A file holding a single CiphertextTensor is read as a batch of one shard.
+ */
+
+// CiphertextBatchVersion is bumped every time the binary layout of a CiphertextBatch changes.
+const CiphertextBatchVersion uint8 = 1
+
+var ciphertextBatchMagic = [4]byte{'D', 'F', 'C', 'B'}
+
+// SetParameters records the fingerprint of params in every shard.
+func (b *CiphertextBatch) SetParameters(params hefloat.Parameters) error {
+	for _, shard := range b.Shards {
+		if err := shard.SetParameters(params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckParameters returns an error if a shard was not encrypted under params.
+func (b *CiphertextBatch) CheckParameters(params hefloat.Parameters) error {
+	for i, shard := range b.Shards {
+		if err := shard.CheckParameters(params); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// WriteTo writes the header and every shard of the batch to w.
+func (b *CiphertextBatch) WriteTo(w io.Writer) (int64, error) {
+	var header bytes.Buffer
+	header.Write(ciphertextBatchMagic[:])
+	header.WriteByte(CiphertextBatchVersion)
+	binary.Write(&header, binary.LittleEndian, uint64(len(b.Shards)))
+
+	n, err := header.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	for i, shard := range b.Shards {
+		inc, err := shard.WriteTo(w)
+		n += inc
+		if err != nil {
+			return n, fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom reads a batch written by WriteTo, or a single CiphertextTensor as a batch of one shard.
+// Unless r is a *bufio.Reader, it is buffered and may be read past the end of the batch.
+func (b *CiphertextBatch) ReadFrom(reader io.Reader) (int64, error) {
+	r := newFullReader(reader)
+
+	magic, err := r.Peek(len(ciphertextBatchMagic))
+	if err != nil {
+		return 0, fmt.Errorf("reading ciphertext batch header: %v", err)
+	}
+	if bytes.Equal(magic, ciphertextTensorMagic[:]) {
+		shard := new(CiphertextTensor)
+		n, err := shard.ReadFrom(r.Reader)
+		if err != nil {
+			return n, err
+		}
+		*b = CiphertextBatch{Shards: []*CiphertextTensor{shard}}
+		return n, nil
+	}
+
+	var n int64
+	var prefix [5]byte
+	inc, err := io.ReadFull(r, prefix[:])
+	n += int64(inc)
+	if err != nil {
+		return n, fmt.Errorf("reading ciphertext batch header: %v", err)
+	}
+	if !bytes.Equal(prefix[:4], ciphertextBatchMagic[:]) {
+		return n, fmt.Errorf("not a ciphertext batch (bad magic %q)", prefix[:4])
+	}
+	if prefix[4] != CiphertextBatchVersion {
+		return n, fmt.Errorf("unsupported ciphertext batch version %d, this build reads version %d", prefix[4], CiphertextBatchVersion)
+	}
+
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return n, fmt.Errorf("reading shard count: %v", err)
+	}
+	n += 8
+
+	shards := make([]*CiphertextTensor, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		shard := new(CiphertextTensor)
+		inc, err := shard.ReadFrom(r.Reader)
+		n += inc
+		if err != nil {
+			return n, fmt.Errorf("shard %d: %v", i, err)
+		}
+		shards = append(shards, shard)
+	}
+	*b = CiphertextBatch{Shards: shards}
+	return n, nil
+}
+
+// SaveCiphertextBatch writes batch to path, stamped with the fingerprint of params.
+func SaveCiphertextBatch(path string, params hefloat.Parameters, batch *CiphertextBatch) error {
+	if err := batch.SetParameters(params); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if _, err := batch.WriteTo(w); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// LoadCiphertextBatch reads a batch (or a single tensor) from path and checks it was encrypted under params.
+func LoadCiphertextBatch(path string, params hefloat.Parameters) (*CiphertextBatch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	batch := new(CiphertextBatch)
+	if _, err := batch.ReadFrom(bufio.NewReader(file)); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	if err := batch.CheckParameters(params); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return batch, nil
+}
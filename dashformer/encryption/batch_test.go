@@ -0,0 +1,131 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCiphertextBatch(t *testing.T) {
+	publicKeys, secretKeys := newTestKeys(t, []int{40, 30, 30})
+	params := *publicKeys.Params
+
+	// LogN 12: 每个分片最多 2048/50 = 40 条序列，90 条序列分成 40、40、10 三个分片
+	numRows, numCols := 90, 50
+	plainTensorValue := make([][][]float64, numRows)
+	for i := range plainTensorValue {
+		plainTensorValue[i] = make([][]float64, numCols)
+		for j := range plainTensorValue[i] {
+			plainTensorValue[i][j] = []float64{float64(i), float64(j) / 10}
+		}
+	}
+
+	batch, err := EncryptBatch(publicKeys, plainTensorValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Shards) != 3 || batch.Shards[0].NumRows != 40 || batch.Shards[2].NumRows != 10 || batch.NumRows() != numRows {
+		t.Fatalf("unexpected shards for %d rows", numRows)
+	}
+
+	// 分片并发计算：每个分片加上自己的行数，检查结果的顺序
+	result, err := batch.MapShards(context.Background(), publicKeys, 2, func(ctx context.Context, publicKeys *PublicParametersKeys, shard *CiphertextTensor) (*CiphertextTensor, error) {
+		out := shard.ShallowCopy()
+		for _, ct := range out.Ciphertexts {
+			if err := publicKeys.Evaluator.Add(ct, float64(shard.NumRows), ct); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := result.SetParameters(params); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := result.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(CiphertextBatch)
+	if _, err := decoded.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.CheckParameters(params); err != nil {
+		t.Fatal(err)
+	}
+
+	have, err := DecryptBatch(secretKeys, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(have) != numRows {
+		t.Fatalf("decrypted %d rows, want %d", len(have), numRows)
+	}
+	for i := range have {
+		added := 40.0
+		if i >= 80 {
+			added = 10
+		}
+		for j := range have[i] {
+			for d := range have[i][j] {
+				if want := plainTensorValue[i][j][d] + added; math.Abs(have[i][j][d]-want) > 1e-3 {
+					t.Fatalf("value (%d,%d,%d): got %f, want %f", i, j, d, have[i][j][d], want)
+				}
+			}
+		}
+	}
+}
+
+func TestCiphertextBatchReadsSingleTensor(t *testing.T) {
+	publicKeys, _ := newTestKeys(t, []int{40, 30})
+	ciphertextTensor, err := EncryptTensorValue(publicKeys, [][][]float64{{{1}, {2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ciphertextTensor.SetParameters(*publicKeys.Params); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ciphertextTensor.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batch := new(CiphertextBatch)
+	n, err := batch.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != len(data) || len(batch.Shards) != 1 || batch.NumRows() != 1 {
+		t.Fatalf("read %d of %d bytes into %d shards", n, len(data), len(batch.Shards))
+	}
+
+	if _, err := RowsPerShard(3000, publicKeys.Params.MaxSlots()); err == nil {
+		t.Error("expected an error for rows longer than the slots")
+	}
+}
+
+func TestMapShardsCancelsOnError(t *testing.T) {
+	publicKeys, _ := newTestKeys(t, []int{40, 30})
+	batch := &CiphertextBatch{}
+	for i := 0; i < 4; i++ {
+		batch.Shards = append(batch.Shards, &CiphertextTensor{NumRows: i})
+	}
+
+	// 分片 0 出错后，其余分片的 ctx 被取消，不会一直等下去
+	errShard := errors.New("shard failed")
+	_, err := batch.MapShards(context.Background(), publicKeys, len(batch.Shards), func(ctx context.Context, publicKeys *PublicParametersKeys, shard *CiphertextTensor) (*CiphertextTensor, error) {
+		if shard.NumRows == 0 {
+			return nil, errShard
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, errShard) {
+		t.Errorf("got %v, want the error of shard 0", err)
+	}
+}
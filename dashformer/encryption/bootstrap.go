@@ -0,0 +1,252 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"dashformer/utils"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+)
+
+// BootstrappingKeys 是自举的参数和计算密钥，和 Evk 一样只含公开的计算密钥，交给计算方
+type BootstrappingKeys struct {
+	Params bootstrapping.Parameters
+	Keys   *bootstrapping.EvaluationKeys
+}
+
+// GenBootstrappingKeys generates the bootstrapping keys of btpParams for the secret key sk of the residual parameters.
+func GenBootstrappingKeys(btpParams bootstrapping.Parameters, sk *rlwe.SecretKey) (*BootstrappingKeys, error) {
+	keys, _, err := btpParams.GenEvaluationKeys(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &BootstrappingKeys{Params: btpParams, Keys: keys}, nil
+}
+
+// switchingKeys 返回可以为 nil 的换钥密钥，按文件中的顺序
+func (btk *BootstrappingKeys) switchingKeys() []**rlwe.EvaluationKey {
+	k := btk.Keys
+	return []**rlwe.EvaluationKey{&k.EvkN1ToN2, &k.EvkN2ToN1, &k.EvkRealToCmplx, &k.EvkCmplxToReal, &k.EvkDenseToSparse, &k.EvkSparseToDense}
+}
+
+// WriteTo writes the keys (not the parameters): for each optional switching key a presence byte followed by the key,
+// then the relinearization and Galois keys as a rlwe.MemEvaluationKeySet.
+func (btk *BootstrappingKeys) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, key := range btk.switchingKeys() {
+		present := byte(0)
+		if *key != nil {
+			present = 1
+		}
+		inc, err := w.Write([]byte{present})
+		n += int64(inc)
+		if err != nil {
+			return n, err
+		}
+		if *key == nil {
+			continue
+		}
+		inc64, err := (*key).WriteTo(w)
+		n += inc64
+		if err != nil {
+			return n, err
+		}
+	}
+	inc, err := btk.Keys.MemEvaluationKeySet.WriteTo(w)
+	return n + inc, err
+}
+
+// ReadFrom reads keys written by WriteTo, Params is left unchanged.
+func (btk *BootstrappingKeys) ReadFrom(reader io.Reader) (int64, error) {
+	var n int64
+	r := newFullReader(reader)
+	btk.Keys = &bootstrapping.EvaluationKeys{MemEvaluationKeySet: &rlwe.MemEvaluationKeySet{}}
+	for _, key := range btk.switchingKeys() {
+		var present [1]byte
+		inc, err := r.Read(present[:])
+		n += int64(inc)
+		if err != nil {
+			return n, err
+		}
+		if present[0] == 0 {
+			continue
+		}
+		*key = new(rlwe.EvaluationKey)
+		inc64, err := (*key).ReadFrom(r)
+		n += inc64
+		if err != nil {
+			return n, err
+		}
+	}
+	inc, err := btk.Keys.MemEvaluationKeySet.ReadFrom(r)
+	return n + inc, err
+}
+
+// SaveBootstrappingKeys writes the bootstrapping parameters and keys to path.
+func SaveBootstrappingKeys(path string, btk *BootstrappingKeys) error {
+	return writeKeyFile(path, bootstrappingKeysMagic, btk.Params, btk)
+}
+
+// LoadBootstrappingKeys reads a file written by SaveBootstrappingKeys.
+func LoadBootstrappingKeys(path string) (*BootstrappingKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := newFullReader(bufio.NewReader(file))
+	btk := &BootstrappingKeys{}
+	paramsData, err := readKeyHeader(r, bootstrappingKeysMagic)
+	if err == nil {
+		err = btk.Params.UnmarshalBinary(paramsData)
+	}
+	if err == nil {
+		_, err = btk.ReadFrom(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return btk, nil
+}
+
+// SetBootstrappingKeys lets the keys bootstrap ciphertexts (see BootstrapTensor),
+// the residual parameters of btk must be the parameters of the keys.
+func (publicKeys *PublicParametersKeys) SetBootstrappingKeys(btk *BootstrappingKeys) error {
+	if !btk.Params.ResidualParameters.Equal(publicKeys.Params) {
+		return fmt.Errorf("the bootstrapping keys were generated for other CKKS parameters")
+	}
+	evaluator, err := bootstrapping.NewEvaluator(btk.Params, btk.Keys)
+	if err != nil {
+		return err
+	}
+	publicKeys.Bootstrapper = evaluator
+	return nil
+}
+
+// shallowCopyBootstrapper 返回可以在另一个 goroutine 中使用的 Bootstrapper。lattigo v5.0.2 的
+// bootstrapping.Evaluator.ShallowCopy 丢掉了参数和密钥，这里只替换有缓冲区的 evaluator，其余只读的部分共享
+func shallowCopyBootstrapper(bootstrapper *bootstrapping.Evaluator) *bootstrapping.Evaluator {
+	evaluators := bootstrapper.ShallowCopy()
+	copied := *bootstrapper
+	copied.Evaluator, copied.DFTEvaluator, copied.Mod1Evaluator = evaluators.Evaluator, evaluators.DFTEvaluator, evaluators.Mod1Evaluator
+	return &copied
+}
+
+/*
+ * BootstrapTensor
+ * Input:  PublicParametersKeys (带自举密钥), ciphertextTensor CiphertextTensor, bound int
+ * Output: CiphertextTensor,error
+ * Compute: 自举每条密文，密文回到 Bootstrapper.OutputLevel() 层。自举只对 [-1,1] 中的值精确：
+ *          自举前除以 bound (只改 scale，不消耗层数)，自举后乘回 bound (整数，不消耗层数)，要求 |值| <= bound
+ */
+func BootstrapTensor(ctx context.Context, publicKeys *PublicParametersKeys, ciphertextTensor *CiphertextTensor, bound int) (*CiphertextTensor, error) {
+	if publicKeys.Bootstrapper == nil {
+		return nil, fmt.Errorf("bootstrapping: the keys have no bootstrapping keys")
+	}
+	if bound <= 0 {
+		return nil, fmt.Errorf("bootstrapping: the bound must be positive, got %d", bound)
+	}
+	newCiphertexts := make([]*rlwe.Ciphertext, len(ciphertextTensor.Ciphertexts))
+	g, gctx := utils.WithContext(ctx)
+	for i, ct := range ciphertextTensor.Ciphertexts {
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			bootstrapper := shallowCopyBootstrapper(publicKeys.Bootstrapper)
+			evaluator := publicKeys.Evaluator.ShallowCopy()
+			scaled := ct.CopyNew()
+			scaled.Scale = scaled.Scale.Mul(rlwe.NewScale(bound))
+			refreshed, err := bootstrapper.Bootstrap(scaled)
+			if err != nil {
+				return err
+			}
+			if err := evaluator.Mul(refreshed, bound, refreshed); err != nil {
+				return err
+			}
+			newCiphertexts[i] = refreshed
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &CiphertextTensor{
+		Ciphertexts: newCiphertexts,
+		NumRows:     ciphertextTensor.NumRows,
+		NumCols:     ciphertextTensor.NumCols,
+		NumDepth:    ciphertextTensor.NumDepth,
+		OutputScale: ciphertextTensor.OutputScale,
+	}, nil
+}
+
+// NewBootstrappingParamsFromPreset returns the bootstrapping parameters of the named preset,
+// after checking that the parameters of the bootstrapping circuit reach 128-bit security.
+// Like NewHERealParamsFromPreset, it skips the check for a sparse secret.
+func NewBootstrappingParamsFromPreset(name string) (bootstrapping.Parameters, error) {
+	params, err := NewHERealParamsFromPreset(name)
+	if err != nil {
+		return bootstrapping.Parameters{}, err
+	}
+	for _, preset := range presets {
+		if preset.Name != name {
+			continue
+		}
+		if preset.Bootstrapping == nil {
+			return bootstrapping.Parameters{}, fmt.Errorf("CKKS preset %q cannot bootstrap, use %s", name, strings.Join(BootstrappingPresetNames(), " or "))
+		}
+		btpParams, err := bootstrapping.NewParametersFromLiteral(params, *preset.Bootstrapping)
+		if err != nil {
+			return btpParams, fmt.Errorf("bootstrapping of CKKS preset %q: %v", name, err)
+		}
+		if err := CheckSecurity(btpParams.BootstrappingParameters); err != nil && !errors.Is(err, ErrSecurityUnverified) {
+			return btpParams, fmt.Errorf("bootstrapping of CKKS preset %q: %v", name, err)
+		}
+		return btpParams, nil
+	}
+	return bootstrapping.Parameters{}, fmt.Errorf("unknown CKKS preset %q", name)
+}
+
+// BootstrappingPresetNames returns the names of the presets that can bootstrap.
+func BootstrappingPresetNames() []string {
+	var names []string
+	for _, preset := range presets {
+		if preset.Bootstrapping != nil {
+			names = append(names, preset.Name)
+		}
+	}
+	return names
+}
+
+// NewBootstrappingTestParameters returns residual parameters with logQ and bootstrapping parameters in the ring
+// of degree 2^logN with fewer slots, as the tests of lattigo: they are NOT secure and only meant for tests
+// that bootstrap on a laptop.
+func NewBootstrappingTestParameters(logN int, logQ []int) (hefloat.Parameters, bootstrapping.Parameters, error) {
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            logN,
+		LogQ:            logQ,
+		LogP:            []int{61, 61},
+		LogDefaultScale: 40,
+	})
+	if err != nil {
+		return params, bootstrapping.Parameters{}, err
+	}
+	btpParams, err := bootstrapping.NewParametersFromLiteral(params, bootstrapping.ParametersLiteral{LogN: &logN})
+	if err != nil {
+		return params, btpParams, err
+	}
+	btpParams.SlotsToCoeffsParameters.LogSlots = btpParams.BootstrappingParameters.LogN() - 1
+	btpParams.CoeffsToSlotsParameters.LogSlots = btpParams.BootstrappingParameters.LogN() - 1
+	// 槽数少于 2^15 时保持和默认参数相同的精度
+	btpParams.Mod1ParametersLiteral.LogMessageRatio += 16 - logN
+	return params, btpParams, nil
+}
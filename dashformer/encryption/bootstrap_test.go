@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBootstrapTensor(t *testing.T) {
+	// 不安全的小参数，只用于测试
+	params, btpParams, err := NewBootstrappingTestParameters(10, []int{60, 40, 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := GenHERealKeys(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btk, err := GenBootstrappingKeys(btpParams, keys.Sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 自举密钥写入 btk.bin，计算方读取公钥时一起读入
+	keys.Btk = btk
+	dir := t.TempDir()
+	if err := SaveKeyMaterial(dir, keys); err != nil {
+		t.Fatal(err)
+	}
+	publicKeys, err := LoadPublicParametersKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKeys.Bootstrapper == nil {
+		t.Fatalf("%s was not loaded", BootstrappingKeysFileName)
+	}
+	secretKeys := NewSecretParametersKeys(params, keys.Sk)
+
+	const bound = 8
+	values := make([][][]float64, 2)
+	for i := range values {
+		values[i] = make([][]float64, 3)
+		for j := range values[i] {
+			values[i][j] = []float64{float64(i+j) - 3.5, 7.25, -0.5 * float64(j)}
+		}
+	}
+	tensor, err := EncryptTensorValue(publicKeys, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用完所有的层再自举
+	for _, ct := range tensor.Ciphertexts {
+		publicKeys.Evaluator.DropLevel(ct, ct.Level())
+	}
+
+	refreshed, err := BootstrapTensor(context.Background(), publicKeys, tensor, bound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range refreshed.Ciphertexts {
+		if ct.Level() != params.MaxLevel() {
+			t.Fatalf("bootstrapped ciphertext at level %d, want %d", ct.Level(), params.MaxLevel())
+		}
+	}
+	got, err := DecryptTensorValue(secretKeys, refreshed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		for j := range values[i] {
+			for d, want := range values[i][j] {
+				if math.Abs(got[i][j][d]-want) > 1e-3 {
+					t.Errorf("value (%d,%d,%d): got %g, want %g", i, j, d, got[i][j][d], want)
+				}
+			}
+		}
+	}
+
+	publicKeys.Bootstrapper = nil
+	if _, err := BootstrapTensor(context.Background(), publicKeys, tensor, bound); err == nil {
+		t.Error("expected an error without bootstrapping keys")
+	}
+	if _, err := LoadBootstrappingKeys(filepath.Join(dir, EvaluationKeysFileName)); err == nil {
+		t.Error("expected an error reading the evaluation keys as bootstrapping keys")
+	}
+}
+
+func TestBootstrappingPreset(t *testing.T) {
+	if _, err := NewBootstrappingParamsFromPreset(DefaultPreset); err == nil {
+		t.Error("expected an error for a preset without bootstrapping")
+	}
+	names := BootstrappingPresetNames()
+	if len(names) != 1 || names[0] != "bootstrap" {
+		t.Fatalf("got bootstrapping presets %v", names)
+	}
+	btpParams, err := NewBootstrappingParamsFromPreset("bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if btpParams.ResidualParameters.MaxLevel() != 15 {
+		t.Errorf("the bootstrapped ciphertexts have %d levels, want 15", btpParams.ResidualParameters.MaxLevel())
+	}
+}
@@ -1,13 +1,21 @@
 package encryption
 
 import (
+	"dashformer/utils"
 	"fmt"
-	"runtime"
-	"sync"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 )
 
+// valueScale 返回解密后的值要乘的倍数，没有记录 OutputScale 时为 1
+func (ct *CiphertextTensor) valueScale() float64 {
+	if ct.OutputScale == 0 {
+		return 1
+	}
+	return ct.OutputScale
+}
+
+// DecryptTensorValue 解密 ciphertextTensor，解密后的值乘以 OutputScale
 func DecryptTensorValue(secretKeys *SecretParametersKeys, ciphertextTensor *CiphertextTensor) ([][][]float64, error) {
 
 	// 初始化三维张量valueTensor
@@ -19,22 +27,24 @@ func DecryptTensorValue(secretKeys *SecretParametersKeys, ciphertextTensor *Ciph
 		}
 	}
 
+	scale := ciphertextTensor.valueScale()
 	for i, ct := range ciphertextTensor.Ciphertexts {
 		pt := secretKeys.Decryptor.DecryptNew(ct)
 		// Decodes the plaintext
 		have := make([]float64, secretKeys.Params.MaxSlots())
 		if err := secretKeys.Encoder.Decode(pt, have); err != nil {
-			panic(err)
+			return nil, err
 		}
 		for j := 0; j < ciphertextTensor.NumRows; j++ {
 			for k := 0; k < ciphertextTensor.NumCols; k++ {
-				valueTensor[j][k][i] = have[j*ciphertextTensor.NumCols+k]
+				valueTensor[j][k][i] = have[j*ciphertextTensor.NumCols+k] * scale
 			}
 		}
 	}
 	return valueTensor, nil
 }
 
+// DecryptTensorValueMultiThread 每条密文一个 goroutine 解密 ciphertextTensor，解密后的值乘以 OutputScale
 func DecryptTensorValueMultiThread(secretKeys *SecretParametersKeys, ciphertextTensor *CiphertextTensor) ([][][]float64, error) {
 
 	// 初始化三维张量valueTensor
@@ -46,17 +56,14 @@ func DecryptTensorValueMultiThread(secretKeys *SecretParametersKeys, ciphertextT
 		}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	var g utils.Group
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 
+	scale := ciphertextTensor.valueScale()
 	for i, ct := range ciphertextTensor.Ciphertexts {
-		wg.Add(1)
-		go func(i int, ct *rlwe.Ciphertext) {
-			defer wg.Done()
+		g.Go(func() error {
 			decryptor := secretKeys.Decryptor.ShallowCopy()
 			encoder := secretKeys.Encoder.ShallowCopy()
 
@@ -64,18 +71,21 @@ func DecryptTensorValueMultiThread(secretKeys *SecretParametersKeys, ciphertextT
 			// Decodes the plaintext
 			have := make([]float64, secretKeys.Params.MaxSlots())
 			if err := encoder.Decode(pt, have); err != nil {
-				panic(err)
+				return err
 			}
 			// mu.Lock()
 			// defer mu.Unlock()
 			for j := 0; j < ciphertextTensor.NumRows; j++ {
 				for k := 0; k < ciphertextTensor.NumCols; k++ {
-					valueTensor[j][k][i] = have[j*ciphertextTensor.NumCols+k]
+					valueTensor[j][k][i] = have[j*ciphertextTensor.NumCols+k] * scale
 				}
 			}
-		}(i, ct)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	return valueTensor, nil
 }
 
@@ -89,7 +99,7 @@ func DecryptVectorValue(secretKeys *SecretParametersKeys, ct *rlwe.Ciphertext) {
 	// Decodes the plaintext
 	have := make([]float64, secretKeys.Params.MaxSlots())
 	if err = secretKeys.Encoder.Decode(pt, have); err != nil {
-		panic(err)
+		return
 	}
 
 	// Pretty prints some values
@@ -1,9 +1,8 @@
 package encryption
 
 import (
-	"fmt"
-	"runtime"
-	"sync"
+	"context"
+	"dashformer/utils"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
@@ -14,6 +13,12 @@ type CiphertextTensor struct {
 	NumRows     int
 	NumCols     int
 	NumDepth    int
+
+	// Fingerprint 是加密所用参数的 SHA-256，只在序列化时使用 (见 SetParameters)
+	Fingerprint [32]byte
+
+	// OutputScale 是解密后的值要乘的倍数 (分类层缩小的倍数)，0 表示不缩放，如加密的输入序列
+	OutputScale float64
 }
 
 // ShallowCopy 方法为 CiphertextTensor 结构体实现浅拷贝
@@ -24,6 +29,8 @@ func (ct *CiphertextTensor) ShallowCopy() *CiphertextTensor {
 		NumRows:     ct.NumRows,
 		NumCols:     ct.NumCols,
 		NumDepth:    ct.NumDepth,
+		Fingerprint: ct.Fingerprint,
+		OutputScale: ct.OutputScale,
 	}
 
 	// 逐个拷贝 Ciphertexts 切片中的每个 Ciphertext
@@ -64,11 +71,11 @@ func EncryptTensorValue(publicKeys *PublicParametersKeys, plainTensorValue [][][
 		// 加密该切片
 		pt := hefloat.NewPlaintext(*publicKeys.Params, publicKeys.Params.MaxLevel())
 		if err := publicKeys.Encoder.Encode(plainSlice, pt); err != nil {
-			panic(err)
+			return nil, err
 		}
 		ct, err := publicKeys.Encryptor.EncryptNew(pt)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ciphertexts[d] = ct
 	}
@@ -94,18 +101,14 @@ func EncryptTensorValueMultiTread(publicKeys *PublicParametersKeys, plainTensorV
 
 	ciphertexts := make([]*rlwe.Ciphertext, numDepth)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	var g utils.Group
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 
 	for d := 0; d < numDepth; d++ {
-		wg.Add(1)
 		// 提取第三维的一个切片
-		go func(d int) {
-			defer wg.Done()
+		g.Go(func() error {
 			encoder := publicKeys.Encoder.ShallowCopy()
 			encryptor := publicKeys.Encryptor.ShallowCopy()
 			plainSlice := make([]float64, numRows*numCols)
@@ -121,18 +124,21 @@ func EncryptTensorValueMultiTread(publicKeys *PublicParametersKeys, plainTensorV
 			// 加密该切片
 			pt := hefloat.NewPlaintext(*publicKeys.Params, publicKeys.Params.MaxLevel())
 			if err := encoder.Encode(plainSlice, pt); err != nil {
-				panic(err)
+				return err
 			}
 			ct, err := encryptor.EncryptNew(pt)
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// mu.Lock()
 			ciphertexts[d] = ct
 			// mu.Unlock()
-		}(d)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	return &CiphertextTensor{
 		Ciphertexts: ciphertexts,
@@ -158,7 +164,7 @@ func MergeAndAddCiphertextTensors(tensor1, tensor2 *CiphertextTensor) (*Cipherte
 	}
 
 	if tensor1.NumRows != tensor2.NumRows || tensor1.NumCols != tensor2.NumCols {
-		return &CiphertextTensor{}, fmt.Errorf("CipherTensor can not add, tensor1 is (%d,%d), tensor2 is (%d,%d)", tensor1.NumRows, tensor1.NumCols, tensor2.NumRows, tensor2.NumCols)
+		return &CiphertextTensor{}, &ShapeError{Op: "merge ciphertext tensors", Want: []int{tensor1.NumRows, tensor1.NumCols}, Got: []int{tensor2.NumRows, tensor2.NumCols}}
 	}
 	// 合并 Ciphertexts 列表
 	mergedCiphertexts := append(tensor1.Ciphertexts, tensor2.Ciphertexts...)
@@ -180,7 +186,7 @@ func MergeAndAddCiphertextTensors(tensor1, tensor2 *CiphertextTensor) (*Cipherte
  * Output: *CiphertextTensor,error
  * Compute: Merge and Add ciphertextTensor
  */
-func AddTwoCipherTensorNew(publicKeys *PublicParametersKeys, tensor1, tensor2 *CiphertextTensor) (*CiphertextTensor, error) {
+func AddTwoCipherTensorNew(ctx context.Context, publicKeys *PublicParametersKeys, tensor1, tensor2 *CiphertextTensor) (*CiphertextTensor, error) {
 	// 检查是否有一个 tensor 为空
 	if tensor1 == nil || len(tensor1.Ciphertexts) == 0 {
 		return tensor2, nil
@@ -190,7 +196,7 @@ func AddTwoCipherTensorNew(publicKeys *PublicParametersKeys, tensor1, tensor2 *C
 	}
 
 	if tensor1.NumRows != tensor2.NumRows || tensor1.NumCols != tensor2.NumCols || tensor1.NumDepth != tensor2.NumDepth {
-		return &CiphertextTensor{}, fmt.Errorf("CipherTensor can not add, tensor1 is (%d,%d,%d), tensor2 is (%d,%d,%d)", tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth, tensor2.NumRows, tensor2.NumCols, tensor2.NumDepth)
+		return &CiphertextTensor{}, &ShapeError{Op: "add ciphertext tensors", Want: []int{tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth}, Got: []int{tensor2.NumRows, tensor2.NumCols, tensor2.NumDepth}}
 	}
 	// 对密文进行相加
 	newCiphertexts := make([]*rlwe.Ciphertext, tensor1.NumDepth)
@@ -199,7 +205,7 @@ func AddTwoCipherTensorNew(publicKeys *PublicParametersKeys, tensor1, tensor2 *C
 		// tensor2.Ciphertexts[i].Scale = tensor1.Ciphertexts[i].Scale
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(tensor1.Ciphertexts[i], tensor2.Ciphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -217,7 +223,7 @@ func AddTwoCipherTensorNew(publicKeys *PublicParametersKeys, tensor1, tensor2 *C
  * Output: *CiphertextTensor,error
  * Compute: Merge and Add ciphertextTensor
  */
-func AddTwoCipherTensorNewMultiThread(publicKeys *PublicParametersKeys, tensor1, tensor2 *CiphertextTensor) (*CiphertextTensor, error) {
+func AddTwoCipherTensorNewMultiThread(ctx context.Context, publicKeys *PublicParametersKeys, tensor1, tensor2 *CiphertextTensor) (*CiphertextTensor, error) {
 	// 检查是否有一个 tensor 为空
 	if tensor1 == nil || len(tensor1.Ciphertexts) == 0 {
 		return tensor2, nil
@@ -227,30 +233,32 @@ func AddTwoCipherTensorNewMultiThread(publicKeys *PublicParametersKeys, tensor1,
 	}
 
 	if tensor1.NumRows != tensor2.NumRows || tensor1.NumCols != tensor2.NumCols || tensor1.NumDepth != tensor2.NumDepth {
-		return &CiphertextTensor{}, fmt.Errorf("CipherTensor can not add, tensor1 is (%d,%d), tensor2 is (%d,%d)", tensor1.NumRows, tensor1.NumCols, tensor2.NumRows, tensor2.NumCols)
+		return &CiphertextTensor{}, &ShapeError{Op: "add ciphertext tensors", Want: []int{tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth}, Got: []int{tensor2.NumRows, tensor2.NumCols, tensor2.NumDepth}}
 	}
 	// 对密文进行相加
 	newCiphertexts := make([]*rlwe.Ciphertext, tensor1.NumDepth)
-	var err error
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// // 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 	for i := 0; i < tensor1.NumDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// tensor2.Ciphertexts[i].Scale = tensor1.Ciphertexts[i].Scale
-			newCiphertexts[i], err = evaluator.AddNew(tensor1.Ciphertexts[i], tensor2.Ciphertexts[i])
+			ct, err := evaluator.AddNew(tensor1.Ciphertexts[i], tensor2.Ciphertexts[i])
 			if err != nil {
-				panic(err)
+				return err
 			}
-		}(i)
+			newCiphertexts[i] = ct
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	return &CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -266,52 +274,53 @@ func AddTwoCipherTensorNewMultiThread(publicKeys *PublicParametersKeys, tensor1,
  * Output: *CiphertextTensor, error
  * Compute: Merge and Add three CiphertextTensors
  */
-func AddThreeCipherTensorNewMultiThread(publicKeys *PublicParametersKeys, tensor1, tensor2, tensor3 *CiphertextTensor) (*CiphertextTensor, error) {
+func AddThreeCipherTensorNewMultiThread(ctx context.Context, publicKeys *PublicParametersKeys, tensor1, tensor2, tensor3 *CiphertextTensor) (*CiphertextTensor, error) {
 	// 检查是否有一个 tensor 为空
 	if tensor1 == nil || len(tensor1.Ciphertexts) == 0 {
-		return AddTwoCipherTensorNewMultiThread(publicKeys, tensor2, tensor3)
+		return AddTwoCipherTensorNewMultiThread(ctx, publicKeys, tensor2, tensor3)
 	}
 	if tensor2 == nil || len(tensor2.Ciphertexts) == 0 {
-		return AddTwoCipherTensorNewMultiThread(publicKeys, tensor1, tensor3)
+		return AddTwoCipherTensorNewMultiThread(ctx, publicKeys, tensor1, tensor3)
 	}
 	if tensor3 == nil || len(tensor3.Ciphertexts) == 0 {
-		return AddTwoCipherTensorNewMultiThread(publicKeys, tensor1, tensor2)
+		return AddTwoCipherTensorNewMultiThread(ctx, publicKeys, tensor1, tensor2)
 	}
 
 	// 检查张量维度是否一致
 	if tensor1.NumRows != tensor2.NumRows || tensor1.NumCols != tensor2.NumCols || tensor1.NumDepth != tensor2.NumDepth ||
 		tensor1.NumRows != tensor3.NumRows || tensor1.NumCols != tensor3.NumCols || tensor1.NumDepth != tensor3.NumDepth {
-		return &CiphertextTensor{}, fmt.Errorf("CipherTensor dimensions do not match: tensor1 is (%d,%d,%d), tensor2 is (%d,%d,%d), tensor3 is (%d,%d,%d)",
-			tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth,
-			tensor2.NumRows, tensor2.NumCols, tensor2.NumDepth,
-			tensor3.NumRows, tensor3.NumCols, tensor3.NumDepth)
+		return &CiphertextTensor{}, &ShapeError{Op: "add ciphertext tensors",
+			Want: []int{tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth, tensor1.NumRows, tensor1.NumCols, tensor1.NumDepth},
+			Got:  []int{tensor2.NumRows, tensor2.NumCols, tensor2.NumDepth, tensor3.NumRows, tensor3.NumCols, tensor3.NumDepth}}
 	}
 
 	// 对密文进行相加
 	newCiphertexts := make([]*rlwe.Ciphertext, tensor1.NumDepth)
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 
 	for i := 0; i < tensor1.NumDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 先将 tensor1 和 tensor2 相加
 			sum, err := evaluator.AddNew(tensor1.Ciphertexts[i], tensor2.Ciphertexts[i])
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// 再将上一步的结果与 tensor3 相加
 			newCiphertexts[i], err = evaluator.AddNew(sum, tensor3.Ciphertexts[i])
 			if err != nil {
-				panic(err)
+				return err
 			}
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	return &CiphertextTensor{
 		Ciphertexts: newCiphertexts,
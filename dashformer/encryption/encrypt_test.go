@@ -1,6 +1,7 @@
 package encryption
 
 import (
+	"context"
 	"dashformer/utils"
 	"fmt"
 	"testing"
@@ -10,6 +11,7 @@ import (
 )
 
 func TestEncryptAndDecryptTensorValue(t *testing.T) {
+	ctx := context.Background()
 	// 初始化参数和密钥对（假设已经定义好）
 
 	publicKeys, secretKeys, err := SetHERealParams()
@@ -95,7 +97,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("======================================\n")
 	fmt.Printf("\n")
 
-	addCipherTensor, err := AddTwoCipherTensorNewMultiThread(publicKeys, ciphertextTensor, ciphertextTensor)
+	addCipherTensor, err := AddTwoCipherTensorNewMultiThread(ctx, publicKeys, ciphertextTensor, ciphertextTensor)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,138 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+// 密文计算中可以用 errors.Is 判断的错误
+var (
+	ErrShapeMismatch      = errors.New("shape mismatch")
+	ErrLevelExhausted     = errors.New("ciphertext level exhausted")
+	ErrMissingRotationKey = errors.New("missing rotation key")
+)
+
+// ShapeError 是运算 Op 的两个操作数 (密文张量、明文矩阵或向量) 维数不匹配
+type ShapeError struct {
+	Op   string
+	Want []int
+	Got  []int
+}
+
+func (e *ShapeError) Error() string {
+	return fmt.Sprintf("%s: %v: want %s, got %s", e.Op, ErrShapeMismatch, formatShape(e.Want), formatShape(e.Got))
+}
+
+func (e *ShapeError) Unwrap() error { return ErrShapeMismatch }
+
+func formatShape(shape []int) string {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = fmt.Sprint(d)
+	}
+	return "(" + strings.Join(dims, ",") + ")"
+}
+
+// LevelError 是运算 Op 需要 Need 个 level，而密文只剩 Level 个
+type LevelError struct {
+	Op    string
+	Level int
+	Need  int
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("%s: %v: ciphertext at level %d, need %d", e.Op, ErrLevelExhausted, e.Level, e.Need)
+}
+
+func (e *LevelError) Unwrap() error { return ErrLevelExhausted }
+
+// RotationKeyError 是计算密钥中没有旋转 Rotation 的 Galois 密钥
+type RotationKeyError struct {
+	Rotation      int
+	GaloisElement uint64
+}
+
+func (e *RotationKeyError) Error() string {
+	return fmt.Sprintf("%v: no Galois key for rotation %d (Galois element %d)", ErrMissingRotationKey, e.Rotation, e.GaloisElement)
+}
+
+func (e *RotationKeyError) Unwrap() error { return ErrMissingRotationKey }
+
+// CheckLevel 返回 LevelError，如果 ct 剩下的 level 少于 need
+func CheckLevel(op string, ct *rlwe.Ciphertext, need int) error {
+	if ct.Level() < need {
+		return &LevelError{Op: op, Level: ct.Level(), Need: need}
+	}
+	return nil
+}
+
+// checkGaloisKey 返回 RotationKeyError，如果 evaluator 没有 galEl 的 Galois 密钥
+func checkGaloisKey(evaluator *hefloat.Evaluator, galEl uint64) error {
+	if galEl == 1 {
+		return nil
+	}
+	if _, err := evaluator.CheckAndGetGaloisKey(galEl); err != nil {
+		params := evaluator.GetRLWEParameters()
+		return &RotationKeyError{Rotation: params.SolveDiscreteLogGaloisElement(galEl), GaloisElement: galEl}
+	}
+	return nil
+}
+
+// Rescale 和 evaluator.Rescale(ct, ct) 相同，密文已经在 level 0 时返回 LevelError
+func Rescale(evaluator *hefloat.Evaluator, ct *rlwe.Ciphertext) error {
+	if err := CheckLevel("rescale", ct, 1); err != nil {
+		return err
+	}
+	return evaluator.Rescale(ct, ct)
+}
+
+// Rotate 和 evaluator.Rotate 相同，缺少旋转密钥时返回 RotationKeyError
+func Rotate(evaluator *hefloat.Evaluator, ct *rlwe.Ciphertext, k int, ctOut *rlwe.Ciphertext) error {
+	if err := checkGaloisKey(evaluator, evaluator.GetRLWEParameters().GaloisElement(k)); err != nil {
+		return err
+	}
+	return evaluator.Rotate(ct, k, ctOut)
+}
+
+// RotateNew 和 evaluator.RotateNew 相同，缺少旋转密钥时返回 RotationKeyError
+func RotateNew(evaluator *hefloat.Evaluator, ct *rlwe.Ciphertext, k int) (*rlwe.Ciphertext, error) {
+	if err := checkGaloisKey(evaluator, evaluator.GetRLWEParameters().GaloisElement(k)); err != nil {
+		return nil, err
+	}
+	return evaluator.RotateNew(ct, k)
+}
+
+// InnerSum 和 evaluator.InnerSum 相同，缺少旋转密钥时返回 RotationKeyError
+func InnerSum(evaluator *hefloat.Evaluator, ct *rlwe.Ciphertext, batchSize, n int, ctOut *rlwe.Ciphertext) error {
+	params := evaluator.GetRLWEParameters()
+	for _, rot := range InnerSumRotations(batchSize, n) {
+		if err := checkGaloisKey(evaluator, params.GaloisElement(rot)); err != nil {
+			return err
+		}
+	}
+	return evaluator.InnerSum(ct, batchSize, n, ctOut)
+}
+
+// InnerSumRotations 返回 rlwe.Evaluator.InnerSum(ct, batchSize, n) 实际使用的旋转，
+// rlwe.GaloisElementsForInnerSum 会多给出几个用不到的旋转
+func InnerSumRotations(batchSize, n int) []int {
+	var rotations []int
+	state := false
+	for i, j := 0, n; j > 0 && n > 1; i, j = i+1, j>>1 {
+		if j&1 == 1 {
+			if k := n - (n & ((2 << i) - 1)); k != 0 {
+				rotations = append(rotations, k*batchSize)
+			} else {
+				state = true
+			}
+		}
+		if !state {
+			rotations = append(rotations, (1<<i)*batchSize)
+		}
+	}
+	return rotations
+}
@@ -0,0 +1,75 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTypedErrors(t *testing.T) {
+	ctx := context.Background()
+	publicKeys, _ := newTestKeys(t, []int{40, 30})
+
+	plainTensorValue := [][][]float64{
+		{{1, 2}, {3, 4}},
+		{{5, 6}, {7, 8}},
+	}
+	ciphertextTensor, err := EncryptTensorValue(publicKeys, plainTensorValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// level 1 -> 0 可以 rescale，之后 level 用完
+	ct := ciphertextTensor.Ciphertexts[0].CopyNew()
+	if err := Rescale(publicKeys.Evaluator, ct); err != nil {
+		t.Fatal(err)
+	}
+	err = Rescale(publicKeys.Evaluator, ct)
+	var levelErr *LevelError
+	if !errors.Is(err, ErrLevelExhausted) || !errors.As(err, &levelErr) || levelErr.Level != 0 || levelErr.Need != 1 {
+		t.Errorf("rescale at level 0: got %v, want a LevelError", err)
+	}
+
+	// newTestKeys 不生成旋转密钥
+	if err := Rotate(publicKeys.Evaluator, ciphertextTensor.Ciphertexts[0], 1, ct); !errors.Is(err, ErrMissingRotationKey) {
+		t.Errorf("rotate without key: got %v, want ErrMissingRotationKey", err)
+	}
+	if err := Rotate(publicKeys.Evaluator, ciphertextTensor.Ciphertexts[0], 0, ct); err != nil {
+		t.Errorf("rotate by 0 needs no key: %v", err)
+	}
+	if err := InnerSum(publicKeys.Evaluator, ciphertextTensor.Ciphertexts[0], 1, 2, ct); !errors.Is(err, ErrMissingRotationKey) {
+		t.Errorf("inner sum without key: got %v, want ErrMissingRotationKey", err)
+	}
+
+	other := &CiphertextTensor{
+		Ciphertexts: ciphertextTensor.Ciphertexts,
+		NumRows:     ciphertextTensor.NumRows,
+		NumCols:     ciphertextTensor.NumCols + 1,
+		NumDepth:    ciphertextTensor.NumDepth,
+	}
+	_, err = AddTwoCipherTensorNewMultiThread(ctx, publicKeys, ciphertextTensor, other)
+	var shapeErr *ShapeError
+	if !errors.Is(err, ErrShapeMismatch) || !errors.As(err, &shapeErr) {
+		t.Fatalf("adding tensors of different shapes: got %v, want a ShapeError", err)
+	}
+	if want := "add ciphertext tensors: shape mismatch: want (2,2,2), got (2,3,2)"; shapeErr.Error() != want {
+		t.Errorf("got %q, want %q", shapeErr.Error(), want)
+	}
+
+	// 工作 goroutine 中的 panic 作为错误返回，不会让进程退出
+	broken := &CiphertextTensor{
+		Ciphertexts: ciphertextTensor.Ciphertexts[:1],
+		NumRows:     ciphertextTensor.NumRows,
+		NumCols:     ciphertextTensor.NumCols,
+		NumDepth:    ciphertextTensor.NumDepth,
+	}
+	if _, err := AddTwoCipherTensorNewMultiThread(ctx, publicKeys, ciphertextTensor, broken); err == nil {
+		t.Error("expected an error for a tensor with missing ciphertexts")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := AddTwoCipherTensorNewMultiThread(canceled, publicKeys, ciphertextTensor, ciphertextTensor); !errors.Is(err, context.Canceled) {
+		t.Errorf("adding with a canceled context: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,300 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+/*
+ * 密钥文件格式 (little endian)
+ *   magic   [4]byte  "DFSK" / "DFPK" / "DFEK" / "DFBK"
+ *   version uint8    KeyFileVersion
+ *   length  uint64   length of the serialized CKKS parameters
+ *   params  []byte   hefloat.Parameters.MarshalBinary (bootstrapping.Parameters.MarshalBinary for DFBK)
+ *   key              lattigo serialization of the key (the evaluation-key bundle is a rlwe.MemEvaluationKeySet,
+ *                    the bootstrapping keys are written by BootstrappingKeys.WriteTo)
+ */
+
+// KeyFileVersion is bumped every time the layout of the key files changes.
+const KeyFileVersion uint8 = 1
+
+// 密钥目录下的文件名
+const (
+	SecretKeyFileName      = "sk.bin"
+	PublicKeyFileName      = "pk.bin"
+	EvaluationKeysFileName = "evk.bin"
+	// 自举密钥，只有 keygen -bootstrap 时生成
+	BootstrappingKeysFileName = "btk.bin"
+)
+
+var (
+	secretKeyMagic         = [4]byte{'D', 'F', 'S', 'K'}
+	publicKeyMagic         = [4]byte{'D', 'F', 'P', 'K'}
+	evaluationKeysMagic    = [4]byte{'D', 'F', 'E', 'K'}
+	bootstrappingKeysMagic = [4]byte{'D', 'F', 'B', 'K'}
+)
+
+func writeKeyFile(path string, magic [4]byte, params encoding.BinaryMarshaler, key io.WriterTo) error {
+	paramsData, err := params.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	w.Write(magic[:])
+	w.WriteByte(KeyFileVersion)
+	binary.Write(w, binary.LittleEndian, uint64(len(paramsData)))
+	w.Write(paramsData)
+	if _, err := key.WriteTo(w); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+func readKeyFile(path string, magic [4]byte, newKey func(params hefloat.Parameters) io.ReaderFrom) (hefloat.Parameters, io.ReaderFrom, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	defer file.Close()
+
+	params, key, err := readKey(bufio.NewReader(file), magic, newKey)
+	if err != nil {
+		return hefloat.Parameters{}, nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return params, key, nil
+}
+
+// readKeyHeader 检查文件头的 magic 和版本，返回序列化的参数
+func readKeyHeader(r io.Reader, magic [4]byte) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:4], magic[:]) {
+		return nil, fmt.Errorf("not a %s key file (magic %q)", magic[:], prefix[:4])
+	}
+	if prefix[4] != KeyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d, this build reads version %d", prefix[4], KeyFileVersion)
+	}
+
+	var length uint64
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > 1<<20 {
+		return nil, fmt.Errorf("parameters length %d is too large", length)
+	}
+	paramsData := make([]byte, length)
+	if _, err := io.ReadFull(r, paramsData); err != nil {
+		return nil, err
+	}
+	return paramsData, nil
+}
+
+// readKey 读取文件头中的参数，再用 newKey 按参数分配密钥并读入
+func readKey(reader io.Reader, magic [4]byte, newKey func(params hefloat.Parameters) io.ReaderFrom) (hefloat.Parameters, io.ReaderFrom, error) {
+	r := newFullReader(reader)
+	paramsData, err := readKeyHeader(r, magic)
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	var params hefloat.Parameters
+	if err := params.UnmarshalBinary(paramsData); err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+
+	key := newKey(params)
+	if _, err := key.ReadFrom(r); err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	return params, key, nil
+}
+
+// SaveSecretKey writes the secret key and its parameters to path.
+func SaveSecretKey(path string, params hefloat.Parameters, sk *rlwe.SecretKey) error {
+	return writeKeyFile(path, secretKeyMagic, params, sk)
+}
+
+// LoadSecretKey reads a file written by SaveSecretKey.
+func LoadSecretKey(path string) (hefloat.Parameters, *rlwe.SecretKey, error) {
+	params, key, err := readKeyFile(path, secretKeyMagic, func(params hefloat.Parameters) io.ReaderFrom {
+		return rlwe.NewSecretKey(params)
+	})
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	return params, key.(*rlwe.SecretKey), nil
+}
+
+// SavePublicKey writes the public key and its parameters to path.
+func SavePublicKey(path string, params hefloat.Parameters, pk *rlwe.PublicKey) error {
+	return writeKeyFile(path, publicKeyMagic, params, pk)
+}
+
+// LoadPublicKey reads a file written by SavePublicKey.
+func LoadPublicKey(path string) (hefloat.Parameters, *rlwe.PublicKey, error) {
+	params, key, err := readKeyFile(path, publicKeyMagic, func(params hefloat.Parameters) io.ReaderFrom {
+		return rlwe.NewPublicKey(params)
+	})
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	return params, key.(*rlwe.PublicKey), nil
+}
+
+// SaveEvaluationKeys writes the relinearization key and the Galois keys as one bundle.
+func SaveEvaluationKeys(path string, params hefloat.Parameters, evk *rlwe.MemEvaluationKeySet) error {
+	return writeKeyFile(path, evaluationKeysMagic, params, evk)
+}
+
+// LoadEvaluationKeys reads a bundle written by SaveEvaluationKeys.
+func LoadEvaluationKeys(path string) (hefloat.Parameters, *rlwe.MemEvaluationKeySet, error) {
+	params, key, err := readKeyFile(path, evaluationKeysMagic, newEvaluationKeySet)
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	return params, key.(*rlwe.MemEvaluationKeySet), nil
+}
+
+// ReadEvaluationKeys reads a bundle in the format of SaveEvaluationKeys from r, e.g. an uploaded evk.bin.
+func ReadEvaluationKeys(r io.Reader) (hefloat.Parameters, *rlwe.MemEvaluationKeySet, error) {
+	params, key, err := readKey(r, evaluationKeysMagic, newEvaluationKeySet)
+	if err != nil {
+		return hefloat.Parameters{}, nil, err
+	}
+	return params, key.(*rlwe.MemEvaluationKeySet), nil
+}
+
+func newEvaluationKeySet(params hefloat.Parameters) io.ReaderFrom {
+	return &rlwe.MemEvaluationKeySet{}
+}
+
+// SaveKeyMaterial writes sk.bin, pk.bin and evk.bin to dir, and btk.bin when there are bootstrapping keys.
+func SaveKeyMaterial(dir string, keys *KeyMaterial) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := SaveSecretKey(filepath.Join(dir, SecretKeyFileName), keys.Params, keys.Sk); err != nil {
+		return err
+	}
+	if err := SavePublicKey(filepath.Join(dir, PublicKeyFileName), keys.Params, keys.Pk); err != nil {
+		return err
+	}
+	if err := SaveEvaluationKeys(filepath.Join(dir, EvaluationKeysFileName), keys.Params, keys.Evk); err != nil {
+		return err
+	}
+	if keys.Btk == nil {
+		return nil
+	}
+	return SaveBootstrappingKeys(filepath.Join(dir, BootstrappingKeysFileName), keys.Btk)
+}
+
+// LoadKeyMaterial reads the key files written by SaveKeyMaterial.
+func LoadKeyMaterial(dir string) (*KeyMaterial, error) {
+	params, sk, err := LoadSecretKey(filepath.Join(dir, SecretKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	pkParams, pk, err := LoadPublicKey(filepath.Join(dir, PublicKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	if !params.Equal(&pkParams) {
+		return nil, fmt.Errorf("%s and %s use different parameters", SecretKeyFileName, PublicKeyFileName)
+	}
+	evkParams, evk, err := LoadEvaluationKeys(filepath.Join(dir, EvaluationKeysFileName))
+	if err != nil {
+		return nil, err
+	}
+	if !params.Equal(&evkParams) {
+		return nil, fmt.Errorf("%s and %s use different parameters", SecretKeyFileName, EvaluationKeysFileName)
+	}
+	btk, err := loadBootstrappingKeysIfPresent(dir, params)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyMaterial{Params: params, Sk: sk, Pk: pk, Evk: evk, Btk: btk}, nil
+}
+
+// loadBootstrappingKeysIfPresent 读取 dir 中的 btk.bin，没有这个文件时返回 nil
+func loadBootstrappingKeysIfPresent(dir string, params hefloat.Parameters) (*BootstrappingKeys, error) {
+	path := filepath.Join(dir, BootstrappingKeysFileName)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	btk, err := LoadBootstrappingKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	if !params.Equal(&btk.Params.ResidualParameters) {
+		return nil, fmt.Errorf("%s and %s use different parameters", BootstrappingKeysFileName, EvaluationKeysFileName)
+	}
+	return btk, nil
+}
+
+// HasKeyMaterial reports whether dir holds the three key files.
+func HasKeyMaterial(dir string) bool {
+	for _, name := range []string{SecretKeyFileName, PublicKeyFileName, EvaluationKeysFileName} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadPublicParametersKeys rebuilds PublicParametersKeys from pk.bin and evk.bin, and btk.bin when present;
+// the secret key is never read.
+func LoadPublicParametersKeys(dir string) (*PublicParametersKeys, error) {
+	params, pk, err := LoadPublicKey(filepath.Join(dir, PublicKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	evkParams, evk, err := LoadEvaluationKeys(filepath.Join(dir, EvaluationKeysFileName))
+	if err != nil {
+		return nil, err
+	}
+	if !params.Equal(&evkParams) {
+		return nil, fmt.Errorf("%s and %s use different parameters", PublicKeyFileName, EvaluationKeysFileName)
+	}
+	publicKeys := NewPublicParametersKeys(params, pk, evk)
+	btk, err := loadBootstrappingKeysIfPresent(dir, params)
+	if err != nil {
+		return nil, err
+	}
+	if btk != nil {
+		if err := publicKeys.SetBootstrappingKeys(btk); err != nil {
+			return nil, err
+		}
+	}
+	return publicKeys, nil
+}
+
+// LoadSecretParametersKeys rebuilds SecretParametersKeys from sk.bin.
+func LoadSecretParametersKeys(dir string) (*SecretParametersKeys, error) {
+	params, sk, err := LoadSecretKey(filepath.Join(dir, SecretKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretParametersKeys(params, sk), nil
+}
@@ -0,0 +1,110 @@
+package encryption
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+func TestKeyMaterialFiles(t *testing.T) {
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            12,
+		LogQ:            []int{40, 30, 30},
+		LogP:            []int{40},
+		LogDefaultScale: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kgen := rlwe.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairNew()
+	rlk := kgen.GenRelinearizationKeyNew(sk)
+	galEls := params.GaloisElements([]int{1, -1})
+	galoisKeys := kgen.GenGaloisKeysNew(galEls, sk)
+	keys := &KeyMaterial{
+		Params: params,
+		Sk:     sk,
+		Pk:     pk,
+		Evk:    rlwe.NewMemEvaluationKeySet(rlk, galoisKeys...),
+	}
+
+	dir := t.TempDir()
+	if HasKeyMaterial(dir) {
+		t.Fatal("empty directory reported as holding keys")
+	}
+	if err := SaveKeyMaterial(dir, keys); err != nil {
+		t.Fatal(err)
+	}
+	if !HasKeyMaterial(dir) {
+		t.Fatal("saved keys not found")
+	}
+
+	// 不读取私钥即可重建计算方使用的密钥
+	if err := os.Rename(filepath.Join(dir, SecretKeyFileName), filepath.Join(dir, "sk.hidden")); err != nil {
+		t.Fatal(err)
+	}
+	publicKeys, err := LoadPublicParametersKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKeys.Params.Equal(&params) {
+		t.Error("loaded parameters differ from the saved ones")
+	}
+	if err := os.Rename(filepath.Join(dir, "sk.hidden"), filepath.Join(dir, SecretKeyFileName)); err != nil {
+		t.Fatal(err)
+	}
+	secretKeys, err := LoadSecretParametersKeys(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 用读回的密钥加密、旋转、乘法再解密
+	values := make([]float64, params.MaxSlots())
+	for i := range values {
+		values[i] = float64(i%7) / 7
+	}
+	pt := hefloat.NewPlaintext(params, params.MaxLevel())
+	if err := publicKeys.Encoder.Encode(values, pt); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := publicKeys.Encryptor.EncryptNew(pt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publicKeys.Evaluator.MulRelin(ct, ct, ct); err != nil {
+		t.Fatal(err)
+	}
+	if err := publicKeys.Evaluator.Rescale(ct, ct); err != nil {
+		t.Fatal(err)
+	}
+	if err := publicKeys.Evaluator.Rotate(ct, 1, ct); err != nil {
+		t.Fatal(err)
+	}
+	result := make([]float64, params.MaxSlots())
+	if err := secretKeys.Encoder.Decode(secretKeys.Decryptor.DecryptNew(ct), result); err != nil {
+		t.Fatal(err)
+	}
+	for i := range result {
+		want := values[(i+1)%len(values)] * values[(i+1)%len(values)]
+		if math.Abs(result[i]-want) > 1e-3 {
+			t.Fatalf("slot %d is %f, want %f", i, result[i], want)
+		}
+	}
+
+	// 密钥种类不匹配的文件必须被拒绝
+	if _, _, err := LoadPublicKey(filepath.Join(dir, SecretKeyFileName)); err == nil {
+		t.Error("expected LoadPublicKey to reject a secret key file")
+	}
+
+	loaded, err := LoadKeyMaterial(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Sk.Equal(sk) || !loaded.Pk.Equal(pk) {
+		t.Error("loaded keys differ from the saved ones")
+	}
+}
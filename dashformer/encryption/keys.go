@@ -1,13 +1,13 @@
 package encryption
 
 import (
+	"dashformer/utils"
 	"fmt"
-	"runtime"
-	"sync"
 	"time"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
 )
 
 type PublicParametersKeys struct {
@@ -15,6 +15,8 @@ type PublicParametersKeys struct {
 	Encoder   *hefloat.Encoder
 	Encryptor *rlwe.Encryptor
 	Evaluator *hefloat.Evaluator
+	// Bootstrapper 为 nil 时不能自举 (见 SetBootstrappingKeys)
+	Bootstrapper *bootstrapping.Evaluator
 }
 
 type SecretParametersKeys struct {
@@ -30,35 +32,41 @@ func CopyKeyGenerator(params rlwe.ParameterProvider, enc *rlwe.Encryptor) *rlwe.
 	}
 }
 
-func SetHERealParams() (*PublicParametersKeys, *SecretParametersKeys, error) {
-	fmt.Printf("CKKS initialization ...")
-	ckksIniStartTime := time.Now()
-	var err error
-	var params hefloat.Parameters
-	if params, err = hefloat.NewParametersFromLiteral(
-		hefloat.ParametersLiteral{
-			LogN: 14,
-			LogQ: []int{38, 33, 33, 33, 33, 33, 33, 33, 33, 33, 33},
-			LogP: []int{36, 36},
-			// RingType:        ring.ConjugateInvariant,
-			LogDefaultScale: 33,
-		}); err != nil {
-		panic(err)
+// KeyMaterial 保存一次密钥生成得到的全部密钥，数据方持有 Sk，计算方只拿到 Pk 和 Evk
+type KeyMaterial struct {
+	Params hefloat.Parameters
+	Sk     *rlwe.SecretKey
+	Pk     *rlwe.PublicKey
+	Evk    *rlwe.MemEvaluationKeySet
+	// Btk 是自举密钥，不自举时为 nil
+	Btk *BootstrappingKeys
+}
+
+// NewHERealParams returns the CKKS parameters of DefaultPreset.
+func NewHERealParams() (hefloat.Parameters, error) {
+	return NewHERealParamsFromPreset(DefaultPreset)
+}
+
+// DefaultGaloisElements returns the Galois elements of all the rotations -50..50.
+// It covers any rotation by columns of a 50-column tensor, see maths.DashformerGaloisElements for the keys Dashformer actually uses.
+func DefaultGaloisElements(params hefloat.Parameters) []uint64 {
+	// 生成旋转步数
+	var rotNumbers []int
+	for i := -50; i <= 50; i++ {
+		rotNumbers = append(rotNumbers, i)
 	}
+	return params.GaloisElements(rotNumbers)
+}
 
+// GenHERealKeys generates the secret key, the public key and the evaluation keys
+// (relinearization key and the Galois keys of galEls) for params.
+func GenHERealKeys(params hefloat.Parameters, galEls []uint64) (*KeyMaterial, error) {
 	kgen := rlwe.NewKeyGenerator(params)
 	sk := kgen.GenSecretKeyNew()
 	pk := kgen.GenPublicKeyNew(sk) // Note that we can generate any number of public keys associated to the same Secret Key.
 	rlk := kgen.GenRelinearizationKeyNew(sk)
-	ecd := hefloat.NewEncoder(params)
 	enc := rlwe.NewEncryptor(params, pk)
 
-	// 生成旋转步数
-	var rotNumbers []int
-	for i := -50; i <= 50; i++ {
-		rotNumbers = append(rotNumbers, i)
-	}
-	galEls := params.GaloisElements(rotNumbers)
 	// fmt.Printf("galEls: %v\n", galEls)
 	// eval = eval.WithKey(rlwe.NewMemEvaluationKeySet(rlk, kgen.GenGaloisKeysNew(galEls, sk)...))
 
@@ -75,15 +83,12 @@ func SetHERealParams() (*PublicParametersKeys, *SecretParametersKeys, error) {
 	// }
 	// fmt.Printf("Galois keys generation ... completed\n")
 
-	// FOUR threads
+	// 与 -threads 配置的工作池大小相同，每个 goroutine 生成一段 Galois 密钥
 
 	galoisKeys := make([]*rlwe.GaloisKey, len(galEls))
-	runtime.GOMAXPROCS(4)
-	var wg sync.WaitGroup
-	numThreads := 4
+	var g utils.Group
+	numThreads := utils.Workers()
 	chunkSize := (len(galEls) + numThreads - 1) / numThreads
-	// fmt.Printf("dimension of galEls: %d\n", len(galEls))
-	// fmt.Printf("dimension of chunkSize: %d\n", chunkSize)
 
 	for t := 0; t < numThreads; t++ {
 		start := t * chunkSize
@@ -91,51 +96,95 @@ func SetHERealParams() (*PublicParametersKeys, *SecretParametersKeys, error) {
 		if end > len(galEls) {
 			end = len(galEls)
 		}
-		// fmt.Printf("(start, end): (%d, %d)\n", start, end)
-		wg.Add(1)
-		go func(start, end, threadNum int) {
-			defer wg.Done()
+		g.Go(func() error {
+			// 每个 goroutine 使用自己的 KeyGenerator，共享 kgen 会产生数据竞争
 			localEncryptor := enc.ShallowCopy()
 			localKgen := CopyKeyGenerator(params, localEncryptor)
-
-			// localKgen := OurKeyGenerator(params, enc)
 			for i := start; i < end; i++ {
-				galEl := galEls[i]
-				// fmt.Printf("galEl @ thread %d: %d\n", threadNum, galEl)
-				if galoisKeys[i] == nil {
-					// fmt.Printf("I AM thread %d ... OK\n    -- current i: %d\n", threadNum, i)
-					galoisKeys[i] = localKgen.GenGaloisKeyNew(galEl, sk)
-				} else {
-					// fmt.Printf("I AM thread %d ... OK\n", threadNum)
-					kgen.GenGaloisKey(galEl, sk, galoisKeys[i])
-				}
-				// fmt.Printf("Generated Galois Key for rotation %d\n", galEl)
+				galoisKeys[i] = localKgen.GenGaloisKeyNew(galEls[i], sk)
 			}
-		}(start, end, t)
+			return nil
+		})
 	}
 
-	wg.Wait()
-
-	eval := hefloat.NewEvaluator(params, rlwe.NewMemEvaluationKeySet(rlk, galoisKeys...))
-	// // fmt.Println("I AM HERE")
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 	// // END
 
-	dec := rlwe.NewDecryptor(params, sk)
+	return &KeyMaterial{
+		Params: params,
+		Sk:     sk,
+		Pk:     pk,
+		Evk:    rlwe.NewMemEvaluationKeySet(rlk, galoisKeys...),
+	}, nil
+}
+
+// NewPublicParametersKeys builds the compute side of the scheme from the public key and the evaluation keys only.
+// pk may be nil when the keys are only used for evaluation, the Encryptor is then nil.
+func NewPublicParametersKeys(params hefloat.Parameters, pk *rlwe.PublicKey, evk rlwe.EvaluationKeySet) *PublicParametersKeys {
+	var encryptor *rlwe.Encryptor
+	if pk != nil {
+		encryptor = rlwe.NewEncryptor(params, pk)
+	}
+	return &PublicParametersKeys{
+		Params:    &params,
+		Encoder:   hefloat.NewEncoder(params),
+		Encryptor: encryptor,
+		Evaluator: hefloat.NewEvaluator(params, evk),
+	}
+}
+
+// ShallowCopy returns keys sharing the key material but with their own Encoder, Encryptor and Evaluator,
+// so that they can be used by another goroutine.
+func (publicKeys *PublicParametersKeys) ShallowCopy() *PublicParametersKeys {
+	var encryptor *rlwe.Encryptor
+	if publicKeys.Encryptor != nil {
+		encryptor = publicKeys.Encryptor.ShallowCopy()
+	}
+	var bootstrapper *bootstrapping.Evaluator
+	if publicKeys.Bootstrapper != nil {
+		bootstrapper = shallowCopyBootstrapper(publicKeys.Bootstrapper)
+	}
+	return &PublicParametersKeys{
+		Params:       publicKeys.Params,
+		Encoder:      publicKeys.Encoder.ShallowCopy(),
+		Encryptor:    encryptor,
+		Evaluator:    publicKeys.Evaluator.ShallowCopy(),
+		Bootstrapper: bootstrapper,
+	}
+}
+
+// NewSecretParametersKeys builds the decryption side of the scheme from the secret key.
+func NewSecretParametersKeys(params hefloat.Parameters, sk *rlwe.SecretKey) *SecretParametersKeys {
+	return &SecretParametersKeys{
+		Params:    &params,
+		Sk:        sk,
+		Encoder:   hefloat.NewEncoder(params),
+		Decryptor: rlwe.NewDecryptor(params, sk),
+	}
+}
+
+func SetHERealParams() (*PublicParametersKeys, *SecretParametersKeys, error) {
+	fmt.Printf("CKKS initialization ...")
+	ckksIniStartTime := time.Now()
+	params, err := NewHERealParams()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys, err := GenHERealKeys(params, DefaultGaloisElements(params))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKeys := NewPublicParametersKeys(params, keys.Pk, keys.Evk)
+	secretKeys := NewSecretParametersKeys(params, keys.Sk)
 	fmt.Printf(" takes %s\n", time.Since(ckksIniStartTime))
 	fmt.Printf("  - log N = %d, log Q = %d, max_level = %d, log_scale = %d\n",
 		params.LogN(), int(params.LogQP()), params.MaxLevel(), params.LogDefaultScale())
 
-	return &PublicParametersKeys{
-			Params:    &params,
-			Encoder:   ecd,
-			Encryptor: enc,
-			Evaluator: eval,
-		}, &SecretParametersKeys{
-			Params:    &params,
-			Sk:        sk,
-			Encoder:   ecd,
-			Decryptor: dec,
-		}, nil
+	return publicKeys, secretKeys, nil
 }
 
 // func SetBSGSHERealParams(babyStep int, giantStep int, cols int) (*PublicParametersKeys, *SecretParametersKeys, error) {
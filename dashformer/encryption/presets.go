@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+	"github.com/tuneinsight/lattigo/v5/ring"
+)
+
+// DefaultPreset is the name of the CKKS parameters used when no preset is configured.
+const DefaultPreset = "default"
+
+// Preset is a named CKKS parameter set selectable with -preset.
+type Preset struct {
+	Name        string
+	Description string
+	Literal     hefloat.ParametersLiteral
+	// Bootstrapping 为 nil 时不能自举
+	Bootstrapping *bootstrapping.ParametersLiteral
+}
+
+// presets 按环维数从小到大排列，除了使用稀疏私钥的 bootstrap 都满足 128-bit 安全 (见 CheckSecurity)
+var presets = []Preset{
+	{
+		Name:        "fast-test",
+		Description: "LogN 13, 8 levels of 20 bits: quick tests of keygen, encrypt and the key files, too shallow and imprecise for the model",
+		Literal: hefloat.ParametersLiteral{
+			LogN:            13,
+			LogQ:            []int{30, 20, 20, 20, 20, 20, 20, 20, 20},
+			LogP:            []int{27},
+			LogDefaultScale: 20,
+		},
+	},
+	{
+		Name:        DefaultPreset,
+		Description: "LogN 14, 10 levels of 33 bits",
+		Literal: hefloat.ParametersLiteral{
+			LogN: 14,
+			LogQ: []int{38, 33, 33, 33, 33, 33, 33, 33, 33, 33, 33},
+			// LogP 原为 {36, 36}，log QP = 440 超过了 LogN 14 的 128-bit 上限 438
+			LogP: []int{36, 33},
+			// RingType:        ring.ConjugateInvariant,
+			LogDefaultScale: 33,
+		},
+	},
+	{
+		Name:        "high-precision",
+		Description: "LogN 15, 10 levels of 45 bits",
+		Literal: hefloat.ParametersLiteral{
+			LogN:            15,
+			LogQ:            []int{55, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45},
+			LogP:            []int{55, 55},
+			LogDefaultScale: 45,
+		},
+	},
+	{
+		Name:        "logn15",
+		Description: "LogN 15 with the moduli of default: twice as many sequences per ciphertext",
+		Literal: hefloat.ParametersLiteral{
+			LogN:            15,
+			LogQ:            []int{38, 33, 33, 33, 33, 33, 33, 33, 33, 33, 33},
+			LogP:            []int{36, 33},
+			LogDefaultScale: 33,
+		},
+	},
+	{
+		Name:        "deep",
+		Description: "LogN 15, 18 levels of 40 bits: one transformer block evaluated layer by layer (-evaluation layers)",
+		Literal: hefloat.ParametersLiteral{
+			LogN:            15,
+			LogQ:            []int{50, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40},
+			LogP:            []int{50, 50},
+			LogDefaultScale: 40,
+		},
+	},
+	{
+		Name:        "deep-layernorm",
+		Description: "LogN 16, 32 levels of 40 bits: one transformer block with both LayerNorms computed on the ciphertexts (-layernorm1 encrypted -layernorm2 encrypted)",
+		Literal: hefloat.ParametersLiteral{
+			LogN:            16,
+			LogQ:            append([]int{60}, repeatLogQ(40, 32)...),
+			LogP:            []int{61, 61, 61},
+			LogDefaultScale: 40,
+		},
+	},
+	{
+		Name:        "bootstrap",
+		Description: "LogN 16, 15 levels of 40 bits refreshed by bootstrapping: one transformer block between two bootstrappings (-bootstrap)",
+		Literal: hefloat.ParametersLiteral{
+			LogN: 16,
+			LogQ: []int{60, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40, 40},
+			LogP: []int{61, 61, 61},
+			// 和 lattigo 默认自举参数一样使用 H=192 的稀疏私钥，HE 标准的上限不适用，安全性没有验证
+			Xs:              ring.Ternary{H: 192},
+			LogDefaultScale: 40,
+		},
+		// lattigo 的默认自举电路 (CoeffsToSlots 4 层, EvalMod 8 层, SlotsToCoeffs 3 层)，
+		// 4 个 61 bit 的 P 使自举参数的 log QP 不超过 LogN 16 的上限 1761 (只是 ternary 私钥的上限)
+		Bootstrapping: &bootstrapping.ParametersLiteral{
+			LogP: []int{61, 61, 61, 61},
+		},
+	},
+}
+
+// repeatLogQ 返回 n 个 logQi
+func repeatLogQ(logQi, n int) []int {
+	logQ := make([]int, n)
+	for i := range logQ {
+		logQ[i] = logQi
+	}
+	return logQ
+}
+
+// Presets returns the available CKKS parameter presets.
+func Presets() []Preset {
+	return append([]Preset(nil), presets...)
+}
+
+// PresetNames returns the names of the available presets.
+func PresetNames() []string {
+	names := make([]string, len(presets))
+	for i, preset := range presets {
+		names[i] = preset.Name
+	}
+	return names
+}
+
+// NewHERealParamsFromPreset returns the CKKS parameters of the named preset,
+// after checking that they reach 128-bit security. The check is skipped for presets
+// with a sparse secret, whose security is not verified (see CheckSecurity).
+func NewHERealParamsFromPreset(name string) (hefloat.Parameters, error) {
+	for _, preset := range presets {
+		if preset.Name != name {
+			continue
+		}
+		params, err := hefloat.NewParametersFromLiteral(preset.Literal)
+		if err != nil {
+			return params, fmt.Errorf("CKKS preset %q: %v", name, err)
+		}
+		if err := CheckSecurity(params); err != nil && !errors.Is(err, ErrSecurityUnverified) {
+			return params, fmt.Errorf("CKKS preset %q: %v", name, err)
+		}
+		return params, nil
+	}
+	return hefloat.Parameters{}, fmt.Errorf("unknown CKKS preset %q, available: %s", name, strings.Join(PresetNames(), ", "))
+}
+
+// maxLogQP128 是 HE 标准 (ternary 私钥, 经典攻击) 中 128-bit 安全允许的最大 log QP，LogN 16 为常用的外推值
+var maxLogQP128 = map[int]float64{
+	10: 27,
+	11: 54,
+	12: 109,
+	13: 218,
+	14: 438,
+	15: 881,
+	16: 1761,
+}
+
+// MaxLogQP returns the largest log QP with 128-bit security for the ring degree 2^logN,
+// from the table of the Homomorphic Encryption Standard for ternary secrets.
+func MaxLogQP(logN int) (float64, error) {
+	bound, ok := maxLogQP128[logN]
+	if !ok {
+		return 0, fmt.Errorf("no 128-bit security bound for LogN %d", logN)
+	}
+	return bound, nil
+}
+
+// ErrSecurityUnverified is returned by CheckSecurity for parameters with a sparse secret.
+var ErrSecurityUnverified = errors.New("128-bit security not verified")
+
+// CheckSecurity returns an error when the total log QP of params exceeds the 128-bit security bound of its ring degree.
+// The bounds only hold for a uniform ternary secret: for a sparse secret, which needs stricter bounds
+// this package does not have, it returns an error wrapping ErrSecurityUnverified.
+func CheckSecurity(params hefloat.Parameters) error {
+	if xs, ok := params.Xs().(ring.Ternary); !ok || xs.H != 0 {
+		return fmt.Errorf("%w: the bounds of the Homomorphic Encryption Standard are for uniform ternary secrets, these parameters use a sparse secret", ErrSecurityUnverified)
+	}
+	bound, err := MaxLogQP(params.LogN())
+	if err != nil {
+		return err
+	}
+	if logQP := params.LogQP(); logQP > bound {
+		return fmt.Errorf("log QP = %.2f exceeds the 128-bit security bound %.0f for LogN %d", logQP, bound, params.LogN())
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+func TestPresets(t *testing.T) {
+	for _, preset := range Presets() {
+		params, err := NewHERealParamsFromPreset(preset.Name)
+		if err != nil {
+			t.Errorf("%s: %v", preset.Name, err)
+			continue
+		}
+		if params.LogN() != preset.Literal.LogN || params.MaxLevel() != len(preset.Literal.LogQ)-1 {
+			t.Errorf("%s: got LogN %d and max level %d", preset.Name, params.LogN(), params.MaxLevel())
+		}
+	}
+
+	params, err := NewHERealParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.LogN() != 14 {
+		t.Errorf("the default preset has LogN %d, want 14", params.LogN())
+	}
+
+	if _, err := NewHERealParamsFromPreset("unknown"); err == nil {
+		t.Error("expected an error for an unknown preset")
+	}
+}
+
+func TestCheckSecurity(t *testing.T) {
+	for _, literal := range []hefloat.ParametersLiteral{
+		// 原来的默认参数，log QP = 440
+		{LogN: 14, LogQ: []int{38, 33, 33, 33, 33, 33, 33, 33, 33, 33, 33}, LogP: []int{36, 36}, LogDefaultScale: 33},
+		// 测试中使用的小参数
+		{LogN: 12, LogQ: []int{45, 35, 35, 35, 35, 35, 35, 35, 35, 35}, LogP: []int{45, 45}, LogDefaultScale: 35},
+	} {
+		params, err := hefloat.NewParametersFromLiteral(literal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckSecurity(params); err == nil {
+			t.Errorf("LogN %d with log QP %.2f should be rejected", params.LogN(), params.LogQP())
+		}
+	}
+
+	// bootstrap 使用稀疏私钥，HE 标准的上限不适用
+	params, err := NewHERealParamsFromPreset("bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSecurity(params); !errors.Is(err, ErrSecurityUnverified) {
+		t.Errorf("the sparse secret of bootstrap should not be verified, got %v", err)
+	}
+	if params, err = NewHERealParamsFromPreset(DefaultPreset); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSecurity(params); err != nil {
+		t.Errorf("%s: %v", DefaultPreset, err)
+	}
+
+	if _, err := MaxLogQP(9); err == nil {
+		t.Error("expected an error for a ring degree without a bound")
+	}
+}
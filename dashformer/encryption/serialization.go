@@ -0,0 +1,283 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+/*
+ * CiphertextTensor 二进制格式 (little endian)
+ *   magic       [4]byte  "DFCT"
+ *   version     uint8    CiphertextTensorVersion
+ *   NumRows     uint64
+ *   NumCols     uint64
+ *   NumDepth    uint64
+ *   fingerprint [32]byte SHA-256 of the CKKS parameters
+ *   outputScale float64  multiplier of the decrypted values, 0 for none (version 2)
+ *   count       uint64   number of ciphertexts
+ *   count × rlwe.Ciphertext (lattigo serialization)
+ */
+
+// CiphertextTensorVersion is bumped every time the binary layout of a CiphertextTensor changes.
+const CiphertextTensorVersion uint8 = 2
+
+var ciphertextTensorMagic = [4]byte{'D', 'F', 'C', 'T'}
+
+// Bounds on the shape read by ReadFrom, so that a corrupted or malicious header is rejected before
+// anything is allocated: NumRows × NumCols values share the slots of a ciphertext (at most 2^16 for LogN 17),
+// and NumDepth is the number of ciphertexts.
+const (
+	maxTensorSlots = 1 << 16
+	maxTensorDepth = 1 << 16
+)
+
+// ParametersFingerprint returns the SHA-256 digest of the serialized CKKS parameters.
+func ParametersFingerprint(params hefloat.Parameters) ([32]byte, error) {
+	data, err := params.MarshalBinary()
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// SetParameters records the fingerprint of the parameters the tensor is encrypted under.
+func (ct *CiphertextTensor) SetParameters(params hefloat.Parameters) error {
+	fingerprint, err := ParametersFingerprint(params)
+	if err != nil {
+		return err
+	}
+	ct.Fingerprint = fingerprint
+	return nil
+}
+
+// CheckParameters returns an error if the tensor was not encrypted under params.
+func (ct *CiphertextTensor) CheckParameters(params hefloat.Parameters) error {
+	fingerprint, err := ParametersFingerprint(params)
+	if err != nil {
+		return err
+	}
+	if ct.Fingerprint != fingerprint {
+		// 默认参数的 LogP 从 36+36 改成了 36+33，之前加密的密文和生成的密钥都不能再用
+		return fmt.Errorf("ciphertext tensor parameter fingerprint %x does not match the current parameters %x: "+
+			"it was encrypted under other CKKS parameters (another -preset, or the default preset before its LogP changed from 36+36 to 36+33), "+
+			"encrypt it again with the current keys", ct.Fingerprint[:8], fingerprint[:8])
+	}
+	return nil
+}
+
+// BinarySize returns the size in bytes of the serialized tensor.
+func (ct *CiphertextTensor) BinarySize() int {
+	size := len(ciphertextTensorMagic) + 1 + 3*8 + len(ct.Fingerprint) + 8 + 8
+	for _, c := range ct.Ciphertexts {
+		size += c.BinarySize()
+	}
+	return size
+}
+
+// WriteTo writes the header and every ciphertext of the tensor to w.
+func (ct *CiphertextTensor) WriteTo(w io.Writer) (int64, error) {
+	if ct.Fingerprint == [32]byte{} {
+		return 0, fmt.Errorf("ciphertext tensor has no parameter fingerprint, call SetParameters first")
+	}
+	for i, c := range ct.Ciphertexts {
+		if c == nil {
+			return 0, fmt.Errorf("ciphertext %d of the tensor is nil", i)
+		}
+	}
+	if ct.OutputScale < 0 || math.IsNaN(ct.OutputScale) || math.IsInf(ct.OutputScale, 0) {
+		return 0, fmt.Errorf("invalid output scale %g", ct.OutputScale)
+	}
+
+	var header bytes.Buffer
+	header.Write(ciphertextTensorMagic[:])
+	header.WriteByte(CiphertextTensorVersion)
+	binary.Write(&header, binary.LittleEndian, []uint64{uint64(ct.NumRows), uint64(ct.NumCols), uint64(ct.NumDepth)})
+	header.Write(ct.Fingerprint[:])
+	binary.Write(&header, binary.LittleEndian, ct.OutputScale)
+	binary.Write(&header, binary.LittleEndian, uint64(len(ct.Ciphertexts)))
+
+	n, err := header.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	for _, c := range ct.Ciphertexts {
+		inc, err := c.WriteTo(w)
+		n += inc
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// fullReader 保证每次 Read 都读满切片：rlwe.MetaData.ReadFrom 只调用一次 Read，
+// 数据跨过 bufio 缓冲区边界或来自网络时会读到不完整的元数据
+type fullReader struct {
+	*bufio.Reader
+}
+
+func newFullReader(r io.Reader) fullReader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return fullReader{br}
+	}
+	return fullReader{bufio.NewReader(r)}
+}
+
+func (r fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(r.Reader, p)
+}
+
+// ReadFrom reads a tensor written by WriteTo, rejecting files with another magic or version.
+// Unless r is a *bufio.Reader, it is buffered and may be read past the end of the tensor.
+func (ct *CiphertextTensor) ReadFrom(reader io.Reader) (int64, error) {
+	var n int64
+	r := newFullReader(reader)
+
+	var prefix [5]byte
+	inc, err := io.ReadFull(r, prefix[:])
+	n += int64(inc)
+	if err != nil {
+		return n, fmt.Errorf("reading ciphertext tensor header: %v", err)
+	}
+	if !bytes.Equal(prefix[:4], ciphertextTensorMagic[:]) {
+		return n, fmt.Errorf("not a ciphertext tensor (bad magic %q)", prefix[:4])
+	}
+	if prefix[4] != CiphertextTensorVersion {
+		return n, fmt.Errorf("unsupported ciphertext tensor version %d, this build reads version %d", prefix[4], CiphertextTensorVersion)
+	}
+
+	shape := make([]uint64, 3)
+	if err := binary.Read(r, binary.LittleEndian, shape); err != nil {
+		return n, fmt.Errorf("reading ciphertext tensor shape: %v", err)
+	}
+	n += 3 * 8
+	if shape[0] == 0 || shape[1] == 0 || shape[0] > maxTensorSlots || shape[1] > maxTensorSlots || shape[0]*shape[1] > maxTensorSlots {
+		return n, fmt.Errorf("invalid ciphertext tensor shape %d×%d, at most %d values per ciphertext", shape[0], shape[1], maxTensorSlots)
+	}
+	if shape[2] == 0 || shape[2] > maxTensorDepth {
+		return n, fmt.Errorf("invalid ciphertext tensor depth %d, at most %d", shape[2], maxTensorDepth)
+	}
+
+	var fingerprint [32]byte
+	inc, err = io.ReadFull(r, fingerprint[:])
+	n += int64(inc)
+	if err != nil {
+		return n, fmt.Errorf("reading ciphertext tensor fingerprint: %v", err)
+	}
+
+	var outputScale float64
+	if err := binary.Read(r, binary.LittleEndian, &outputScale); err != nil {
+		return n, fmt.Errorf("reading ciphertext tensor output scale: %v", err)
+	}
+	n += 8
+	if outputScale < 0 || math.IsNaN(outputScale) || math.IsInf(outputScale, 0) {
+		return n, fmt.Errorf("invalid output scale %g", outputScale)
+	}
+
+	var count uint64
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return n, fmt.Errorf("reading ciphertext count: %v", err)
+	}
+	n += 8
+	if count != shape[2] {
+		return n, fmt.Errorf("ciphertext tensor holds %d ciphertexts for depth %d", count, shape[2])
+	}
+
+	// count is bounded by maxTensorDepth, but the ciphertexts may still be missing from the stream
+	ciphertexts := make([]*rlwe.Ciphertext, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		c := new(rlwe.Ciphertext)
+		inc, err := c.ReadFrom(r)
+		n += inc
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, fmt.Errorf("reading ciphertext %d: %v", i, err)
+		}
+		ciphertexts = append(ciphertexts, c)
+	}
+
+	*ct = CiphertextTensor{
+		Ciphertexts: ciphertexts,
+		NumRows:     int(shape[0]),
+		NumCols:     int(shape[1]),
+		NumDepth:    int(shape[2]),
+		Fingerprint: fingerprint,
+		OutputScale: outputScale,
+	}
+	return n, nil
+}
+
+// MarshalBinary encodes the tensor in the format written by WriteTo.
+func (ct *CiphertextTensor) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, ct.BinarySize()))
+	if _, err := ct.WriteTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a tensor encoded by MarshalBinary.
+func (ct *CiphertextTensor) UnmarshalBinary(data []byte) error {
+	n, err := ct.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int(n) != len(data) {
+		return fmt.Errorf("%d trailing bytes after ciphertext tensor", len(data)-int(n))
+	}
+	return nil
+}
+
+// SaveCiphertextTensor writes ciphertextTensor to path, stamped with the fingerprint of params.
+func SaveCiphertextTensor(path string, params hefloat.Parameters, ciphertextTensor *CiphertextTensor) error {
+	if err := ciphertextTensor.SetParameters(params); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if _, err := ciphertextTensor.WriteTo(w); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return file.Close()
+}
+
+// LoadCiphertextTensor reads a tensor from path and checks it was encrypted under params.
+func LoadCiphertextTensor(path string, params hefloat.Parameters) (*CiphertextTensor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ciphertextTensor := new(CiphertextTensor)
+	if _, err := ciphertextTensor.ReadFrom(bufio.NewReader(file)); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	if err := ciphertextTensor.CheckParameters(params); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return ciphertextTensor, nil
+}
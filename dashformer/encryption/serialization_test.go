@@ -0,0 +1,177 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+// newTestKeys 使用小参数生成不带旋转密钥的加解密对象，只用于序列化测试
+func newTestKeys(t *testing.T, logQ []int) (*PublicParametersKeys, *SecretParametersKeys) {
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            12,
+		LogQ:            logQ,
+		LogP:            []int{40},
+		LogDefaultScale: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kgen := rlwe.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairNew()
+	return NewPublicParametersKeys(params, pk, nil), NewSecretParametersKeys(params, sk)
+}
+
+func TestCiphertextTensorSerialization(t *testing.T) {
+	publicKeys, secretKeys := newTestKeys(t, []int{40, 30, 30})
+
+	plainTensorValue := [][][]float64{
+		{{1.1, 2.2, 3.3}, {4.4, 5.5, 6.6}},
+		{{7.7, 8.8, 9.9}, {10.10, 11.11, 12.12}},
+	}
+	ciphertextTensor, err := EncryptTensorValue(publicKeys, plainTensorValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ciphertextTensor.MarshalBinary(); err == nil {
+		t.Fatal("expected an error when marshaling a tensor without fingerprint")
+	}
+	if err := ciphertextTensor.SetParameters(*publicKeys.Params); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ciphertextTensor.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != ciphertextTensor.BinarySize() {
+		t.Errorf("BinarySize is %d, marshaled %d bytes", ciphertextTensor.BinarySize(), len(data))
+	}
+
+	var decoded CiphertextTensor
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.NumRows != 2 || decoded.NumCols != 2 || decoded.NumDepth != 3 {
+		t.Fatalf("decoded shape (%d,%d,%d), want (2,2,3)", decoded.NumRows, decoded.NumCols, decoded.NumDepth)
+	}
+	if err := decoded.CheckParameters(*publicKeys.Params); err != nil {
+		t.Fatal(err)
+	}
+
+	valueTensor, err := DecryptTensorValue(secretKeys, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range plainTensorValue {
+		for j := range plainTensorValue[i] {
+			for k := range plainTensorValue[i][j] {
+				if math.Abs(valueTensor[i][j][k]-plainTensorValue[i][j][k]) > 1e-3 {
+					t.Errorf("value (%d,%d,%d) is %f, want %f", i, j, k, valueTensor[i][j][k], plainTensorValue[i][j][k])
+				}
+			}
+		}
+	}
+
+	// WriteTo 与 MarshalBinary 的结果一致
+	var buf bytes.Buffer
+	if _, err := ciphertextTensor.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("WriteTo and MarshalBinary disagree")
+	}
+
+	// 网络连接每次只返回部分数据
+	if _, err := new(CiphertextTensor).ReadFrom(iotest.OneByteReader(bytes.NewReader(data))); err != nil {
+		t.Errorf("reading from a slow reader: %v", err)
+	}
+	if err := new(CiphertextTensor).UnmarshalBinary(append(append([]byte{}, data...), 0)); err == nil {
+		t.Error("expected an error for trailing bytes")
+	}
+
+	// 版本号不同的文件必须被拒绝
+	badVersion := append([]byte{}, data...)
+	badVersion[4] = CiphertextTensorVersion + 1
+	if err := new(CiphertextTensor).UnmarshalBinary(badVersion); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+
+	// 其他参数下加密的密文必须被拒绝
+	otherKeys, _ := newTestKeys(t, []int{40, 30})
+	if err := decoded.CheckParameters(*otherKeys.Params); err == nil {
+		t.Error("expected a fingerprint mismatch for other parameters")
+	}
+
+	// 文件读写
+	path := filepath.Join(t.TempDir(), "input.ct")
+	if err := SaveCiphertextTensor(path, *publicKeys.Params, ciphertextTensor); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCiphertextTensor(path, *publicKeys.Params); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCiphertextTensor(path, *otherKeys.Params); err == nil {
+		t.Error("expected LoadCiphertextTensor to reject other parameters")
+	}
+
+	// 记录的 OutputScale 随密文保存，解密时乘到结果上
+	ciphertextTensor.OutputScale = 100
+	if err := SaveCiphertextTensor(path, *publicKeys.Params, ciphertextTensor); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCiphertextTensor(path, *publicKeys.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.OutputScale != 100 {
+		t.Fatalf("got output scale %g, want 100", loaded.OutputScale)
+	}
+	if valueTensor, err = DecryptTensorValueMultiThread(secretKeys, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if v, want := valueTensor[1][0][2], 100*plainTensorValue[1][0][2]; math.Abs(v-want) > 1e-1 {
+		t.Errorf("scaled value is %f, want %f", v, want)
+	}
+}
+
+// tensorHeader 返回 ReadFrom 读取的文件头，后面没有密文
+func tensorHeader(rows, cols, depth, count uint64) []byte {
+	var buf bytes.Buffer
+	buf.Write(ciphertextTensorMagic[:])
+	buf.WriteByte(CiphertextTensorVersion)
+	binary.Write(&buf, binary.LittleEndian, []uint64{rows, cols, depth})
+	buf.Write(make([]byte, 32))
+	binary.Write(&buf, binary.LittleEndian, 1.0)
+	binary.Write(&buf, binary.LittleEndian, count)
+	return buf.Bytes()
+}
+
+func TestCiphertextTensorReadFromCorrupt(t *testing.T) {
+	// 不可信的文件头不能使 ReadFrom panic 或分配大量内存
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated header", tensorHeader(2, 2, 3, 3)[:20], "shape"},
+		{"oversized depth", tensorHeader(2, 2, 1<<62, 1<<62), "depth"},
+		{"oversized shape", tensorHeader(1<<40, 1<<40, 3, 3), "shape"},
+		{"zero rows", tensorHeader(0, 2, 3, 3), "shape"},
+		{"count mismatch", tensorHeader(2, 2, 3, 1<<62), "holds"},
+		{"missing ciphertexts", tensorHeader(2, 2, maxTensorDepth, maxTensorDepth), "ciphertext 0"},
+	} {
+		_, err := new(CiphertextTensor).ReadFrom(bytes.NewReader(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error about %q", tc.name, err, tc.want)
+		}
+	}
+}
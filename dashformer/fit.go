@@ -0,0 +1,107 @@
+package main
+
+import (
+	"dashformer/config"
+	"dashformer/polyfit"
+	"dashformer/utils"
+	"flag"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// parseInterval 解析 fit 的 -interval "a,b"
+func parseInterval(s string) ([2]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return [2]float64{}, fmt.Errorf("interval %q: want a,b", s)
+	}
+	var interval [2]float64
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return [2]float64{}, fmt.Errorf("interval %q: %v", s, err)
+		}
+		interval[i] = v
+	}
+	return interval, nil
+}
+
+// runFit 为激活函数拟合多项式，报告区间上的误差，并把系数写到模型目录的 model.json
+func runFit(args []string) error {
+	fs := flag.NewFlagSet("fit", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	name := fs.String("activation", "relu", "function to fit: "+strings.Join(polyfit.ActivationNames(), ", "))
+	intervalFlag := fs.String("interval", "", "interval a,b of the fit (default: the interval of the activation, -50,40 for relu and gelu, -8,0 for exp, 15,100 for invsqrt)")
+	degree := fs.Int("degree", 0, "degree of the polynomial (default: 6, 5 for invsqrt)")
+	method := fs.String("method", "remez", "fit: "+strings.Join(polyfit.Methods(), " or ")+" (minimax)")
+	role := fs.String("role", "", "tensor of model.json to write the coefficients to: "+strings.Join(utils.PolynomialRoles(), ", ")+" (default: the role of the activation, none for exp)")
+	dryRun := fs.Bool("n", false, "only print the coefficients, do not write model.json")
+	fs.Parse(args)
+
+	activation, err := polyfit.LookupActivation(*name)
+	if err != nil {
+		return err
+	}
+	interval := activation.Interval
+	if *intervalFlag != "" {
+		if interval, err = parseInterval(*intervalFlag); err != nil {
+			return err
+		}
+	}
+	if *degree == 0 {
+		*degree = activation.Degree
+	}
+	if *role == "" {
+		*role = activation.Role
+	}
+
+	coeffs, err := polyfit.Fit(*method, activation.Func, interval[0], interval[1], *degree)
+	if err != nil {
+		return fmt.Errorf("fitting %s: %v", activation.Name, err)
+	}
+	errs := polyfit.MeasureErrors(activation.Func, coeffs, interval[0], interval[1])
+	fmt.Printf("%s on [%g, %g], degree %d, %s\n", activation.Name, interval[0], interval[1], *degree, *method)
+	fmt.Printf("coefficients (x^0 first): %s\n", formatCoefficients(coeffs))
+	fmt.Printf("max error %.6g at %.6g, mean error %.6g, depth %d levels\n", errs.Max, errs.MaxAt, errs.Mean, bits.Len(uint(*degree)))
+
+	if *dryRun || *role == "" {
+		return nil
+	}
+	cfg, err := loadConfig(flags, config.ModelDir)
+	if err != nil {
+		return err
+	}
+	path, err := utils.SetManifestCoefficients(cfg.ModelDir, *role, coeffs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s to %s\n", *role, path)
+	if configCoefficients(cfg.Coefficients, *role) {
+		fmt.Printf("warning: the coefficients of the configuration override %s in %s\n", *role, path)
+	}
+	return nil
+}
+
+// formatCoefficients 以 JSON 数组的形式打印系数，可以直接写入配置文件
+func formatCoefficients(coeffs []float64) string {
+	s := make([]string, len(coeffs))
+	for i, c := range coeffs {
+		s[i] = strconv.FormatFloat(c, 'g', -1, 64)
+	}
+	return "[" + strings.Join(s, ", ") + "]"
+}
+
+// configCoefficients 返回配置是否给出了 role 的系数 (见 SetApproximationCoefficients)
+func configCoefficients(coeffs config.Coefficients, role string) bool {
+	switch role {
+	case "relu_coefficients":
+		return len(coeffs.Relu) > 0
+	case "sqrt_layer1_coefficients":
+		return len(coeffs.SqrtLayer1) > 0
+	case "sqrt_layer2_coefficients":
+		return len(coeffs.SqrtLayer2) > 0
+	}
+	return false
+}
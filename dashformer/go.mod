@@ -6,6 +6,7 @@ require (
 	github.com/tuneinsight/lattigo/v5 v5.0.2
 	golang.org/x/exp v0.0.0-20240823005443-9b4947da3948
 	gonum.org/v1/gonum v0.15.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -17,5 +18,4 @@ require (
 	github.com/stretchr/testify v1.8.0 // indirect
 	golang.org/x/crypto v0.0.0-20220926161630-eccd6366d1be // indirect
 	golang.org/x/sys v0.23.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,513 +1,326 @@
 package main
 
 import (
+	"context"
 	"dashformer/coefficient"
 	"dashformer/config"
 	"dashformer/encryption"
 	"dashformer/maths"
 	"dashformer/utils"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
-// func evalDashformerMultiTread(publicKeys *encryption.PublicParametersKeys, secretKeys *encryption.SecretParametersKeys,
-// 	exampleData [][][]float64, dashModelParam utils.DashformerModelParameters) (*encryption.CiphertextTensor, error) {
-// 	// 2.2.加密example数据
-// 	ciphertextTensor, err := encryption.EncryptTensorValueMultiTread(publicKeys, exampleData)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	fmt.Printf("Encrypte data already, Rows:%d, Cols:%d, Depths:%d\n", ciphertextTensor.NumRows, ciphertextTensor.NumCols, ciphertextTensor.NumDepth)
-
-// 	// 3.1.Embedding
-// 	startTime := time.Now()
-// 	embeddingCipherTensor, err := maths.CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys, ciphertextTensor, dashModelParam.EmbeddingMatrix)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime := time.Since(startTime)
-// 	fmt.Printf("Embedding took %s to run.\n", elapsedTime)
-
-// 	// 3.2.Encoding
-// 	startTime = time.Now()
-// 	encodingCipherTensor, err := maths.CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys, embeddingCipherTensor, dashModelParam.EncodingMatrix)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("Encoding took %s to run.\n", elapsedTime)
-
-// 	var concatenateHeader *encryption.CiphertextTensor
-// 	// 3.3.Attention
-// 	startTime = time.Now()
-// 	for i := 0; i < 4; i++ {
-// 		// 3.3.1. Compute Q,K,V
-// 		multiAttentionQ, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, encodingCipherTensor, dashModelParam.QueryWeightAttentionMatrixs[i], dashModelParam.QueryBiasAttentionVectors[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		multiAttentionK, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, encodingCipherTensor, dashModelParam.KeyWeightAttentionMatrixs[i], dashModelParam.KeyBiasAttentionVectors[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		multiAttentionV, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, encodingCipherTensor, dashModelParam.ValueWeightAttentionMatrixs[i], dashModelParam.ValueBiasAttentionVectors[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		// // 3.3.2 Compute Q X K^T --> Halevi-Shoup
-// 		// // **为了优化矩阵乘法层数，将softMax(X)=(x+0.95)^2/400 --> softMax(X)= (QXK^T/(sqrt(32)*20)+ 0.95/20 )^2 **
-// 		// multiAttentionQMulKT, err := maths.CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(publicKeys, multiAttentionQ, multiAttentionK, 1/(math.Sqrt(32)*20))
-// 		// if err != nil {
-// 		// 	panic(err)
-// 		// }
-
-// 		// // 3.3.3 Compute SoftMax
-// 		// multiAttentionQMulKTSoftMax, err := maths.ApproximateSoftmax(publicKeys, multiAttentionQMulKT, 0.95/20, 1)
-// 		// if err != nil {
-// 		// 	panic(err)
-// 		// }
-
-// 		// // 3.3.4 Compute softMax(Q X K^T/sqrt(32)) X V --> X
-// 		// multiAttentionHeader, err := maths.CiphertextTensorHSMultiplyCiphertextTensorMultiThread(publicKeys, multiAttentionQMulKTSoftMax, multiAttentionV)
-// 		// if err != nil {
-// 		// 	panic(err)
-// 		// }
-
-// 		multiAttentionHeader, err := maths.CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys, multiAttentionQ, multiAttentionK, multiAttentionV, 7, 8, dashModelParam.SoftMaxB[i], dashModelParam.SoftMaxC[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-// 		// 3.3.5 concatenate header
-// 		concatenateHeader, err = encryption.MergeAndAddCiphertextTensors(concatenateHeader, multiAttentionHeader)
-// 		if err != nil {
-// 			panic(err)
-// 		}
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("Attention took %s to run.\n", elapsedTime)
-
-// 	// 3.3. Combining Header
-// 	startTime = time.Now()
-// 	combiningHeader, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, concatenateHeader, dashModelParam.CombineWeightMatrixs, dashModelParam.CombineBiasVectors)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("Combining Header took %s to run.\n", elapsedTime)
-
-// 	// 3.4. Add and LayNorm-1  ***近似1/x需要在layerNorm函数内部进行修改
-// 	startTime = time.Now()
-// 	headerAddEncoding, err := encryption.AddTwoCipherTensorNewMultiThread(publicKeys, combiningHeader, encodingCipherTensor)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	layerNorm1, err := maths.CiphertextTensorLayerNormReplaceVarianceMultiThread(publicKeys, headerAddEncoding, dashModelParam.LayerNormVectorR1, dashModelParam.LayerNormVectorB1, dashModelParam.LayerNormSqrtVariance1)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("LayerNorm-1 took %s to run.\n", elapsedTime)
-
-// 	// 3.5. Feed Forward with ReLu
-// 	startTime = time.Now()
-// 	feedForwardRelu1, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, layerNorm1, dashModelParam.FeedForwardWeightMatrix1, dashModelParam.FeedForwardBiasVector1)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	// Relu
-// 	feedForwardReluAppromate, err := maths.ApproximatePolynomialCipherTensorMultiThread(publicKeys, feedForwardRelu1, dashModelParam.ReluCoefficients, [2]float64{-50, 40})
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	feedForwardRelu2, err := maths.CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys, feedForwardReluAppromate, dashModelParam.FeedForwardWeightMatrix2, dashModelParam.FeedForwardBiasVector2)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("Feed Forward with ReLu took %s to run.\n", elapsedTime)
-
-// 	// 3.6. Add and LayNorm-2
-// 	startTime = time.Now()
-// 	feedForwardAddlayerNorm1, err := encryption.AddTwoCipherTensorNewMultiThread(publicKeys, layerNorm1, feedForwardRelu2)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	// fmt.Printf("feedForwardAddlayerNorm1 scale :%f\n", feedForwardAddlayerNorm1.Ciphertexts[0].LogScale())
-// 	layerNorm2, err := maths.CiphertextTensorLayerNormReplaceVarianceMultiThread(publicKeys, feedForwardAddlayerNorm1, dashModelParam.LayerNormVectorR2, dashModelParam.LayerNormVectorB2, dashModelParam.LayerNormSqrtVariance2)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("LayerNorm-2 took %s to run.\n", elapsedTime)
-
-// 	// 3.7. Average Pooling
-// 	// 3.8. Dense Classification
-// 	startTime = time.Now()
-// 	poolingAndClassification, err := maths.CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys, layerNorm2, dashModelParam.ClassifierWeightMatrix, dashModelParam.ClassifierBiasVector)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	elapsedTime = time.Since(startTime)
-// 	fmt.Printf("Pooling and dense classification took %s to run.\n", elapsedTime)
-
-// 	return poolingAndClassification, nil
-// }
-
-func evalUnfoldDashformerWithBSGSMultiTread(publicKeys *encryption.PublicParametersKeys, secretKeys *encryption.SecretParametersKeys, exampleData [][][]float64,
-	dashModelParam utils.DashformerModelParameters, coeff_dash coefficient.Coefficient_dash, coeff_QKV coefficient.Coefficient_QKV, coeff_sqmax coefficient.Coefficient_sqmax) (*encryption.CiphertextTensor, error) {
-	//! NOTE: Here, secretKeys are just for debug and is not used for encrypted computation.
-	// 2.2.加密example数据
-	fmt.Println("Encrypting data ... ")
-	startTime := time.Now()
-	ciphertextTensor, err := encryption.EncryptTensorValueMultiTread(publicKeys, exampleData)
-	if err != nil {
-		panic(err)
-	}
-	elapsedTime := time.Since(startTime)
-	fmt.Printf("Encrypting data ... takes %s\n", elapsedTime)
-	// fmt.Printf("Data encryption completed, Rows:%d, Cols:%d, Depths:%d\n", ciphertextTensor.NumRows, ciphertextTensor.NumCols, ciphertextTensor.NumDepth)
-	// fmt.Printf("Ciphertext Tensor X0 Level:%d\n", ciphertextTensor.Ciphertexts[0].Level())
+func evalUnfoldDashformerWithBSGSMultiTread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor,
+	dashModelParam utils.DashformerModelParameters, coeff_dash coefficient.Coefficient_dash, coeff_QKV coefficient.Coefficient_QKV, coeff_sqmax coefficient.Coefficient_sqmax,
+	babyStep, giantStep int) (*encryption.CiphertextTensor, error) {
+	//! NOTE: Only public keys are available here, the input is encrypted by the data owner (see runEncrypt).
 
 	fmt.Println("Start computing with encrypted data")
 	fmt.Printf("  ...")
-	startTime = time.Now()
+	startTime := time.Now()
 	startEncryptedComputation := time.Now()
-	X0RotTensor, X0TRotTensor, cipherTensorLeft, cipherTensorRight, err := maths.GenerateCipherTensorRot(publicKeys, ciphertextTensor, 7, 8)
+	X0RotTensor, X0TRotTensor, cipherTensorLeft, cipherTensorRight, err := maths.GenerateCipherTensorRot(ctx, publicKeys, ciphertextTensor, babyStep, giantStep)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("rotating the input: %w", err)
 	}
 
 	var concatenateHeader *encryption.CiphertextTensor
 	// 3.3.Attention
 	fmt.Printf("...")
-	for i := 0; i < 4; i++ {
+	for i := range coeff_sqmax.Item_1 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 
-		multiAttentionHeader, err := maths.CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys, ciphertextTensor, X0RotTensor, X0TRotTensor, cipherTensorLeft, cipherTensorRight, coeff_sqmax.Item_1[i], coeff_sqmax.Item_2[i], coeff_sqmax.Item_3[i], coeff_sqmax.Item_4[i], coeff_QKV.A_V[i], coeff_QKV.Constant_V[i], 7, 8, dashModelParam.SoftMaxB[i], dashModelParam.SoftMaxC[i])
+		multiAttentionHeader, err := maths.CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(ctx, publicKeys, ciphertextTensor, X0RotTensor, X0TRotTensor, cipherTensorLeft, cipherTensorRight, coeff_sqmax.Item_1[i], coeff_sqmax.Item_2[i], coeff_sqmax.Item_3[i], coeff_sqmax.Item_4[i], coeff_QKV.A_V[i], coeff_QKV.Constant_V[i], babyStep, giantStep, dashModelParam.SoftMaxB[i], dashModelParam.SoftMaxC[i])
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("attention head %d: %w", i, err)
 		}
-		// valueTensor, err := encryption.DecryptTensorValue(secretKeys, multiAttentionHeader)
-		// if err != nil {
-		// 	panic(err)
-		// }
-		// utils.PrintSliceInfo(valueTensor, "multiAttentionHeader")
-		// fmt.Println(valueTensor[0])
-
-		// fmt.Printf("Ciphertext Tensor multiAttentionHeader Level:%d\n", multiAttentionHeader.Ciphertexts[0].Level())
+
 		// 3.3.5 concatenate header
 		concatenateHeader, err = encryption.MergeAndAddCiphertextTensors(concatenateHeader, multiAttentionHeader)
 		if err != nil {
-			panic(err)
+			return nil, fmt.Errorf("concatenating head %d: %w", i, err)
 		}
 	}
 
-	// fmt.Printf("concatenateHeader Rows:%d, Cols:%d, Depth:%d\n", concatenateHeader.NumRows, concatenateHeader.NumCols, concatenateHeader.NumDepth)
-
 	// 开始计算展开式
 	// 1.计算rulu里面的内容
 	fmt.Printf("...")
-	cipherTensorHeaderBeforeRelu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, concatenateHeader, coeff_dash.Head_before_relu, coeff_dash.Head_rear_relu)
+	cipherTensorHeaderBeforeRelu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(ctx, publicKeys, concatenateHeader, coeff_dash.Head_before_relu, coeff_dash.Head_rear_relu)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("heads before relu: %w", err)
 	}
 
-	// valueTensor, err := encryption.DecryptTensorValue(secretKeys, cipherTensorHeaderBeforeRelu)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// utils.PrintSliceInfo(valueTensor, "cipherTensorHeaderBeforeRelu")
-	// fmt.Println(valueTensor[0])
 	fmt.Printf("...")
-	cipherTensorX0BeforeRulu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, ciphertextTensor, coeff_dash.X0_before_relu, coeff_dash.X0_rear_relu)
+	cipherTensorX0BeforeRulu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(ctx, publicKeys, ciphertextTensor, coeff_dash.X0_before_relu, coeff_dash.X0_rear_relu)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("input before relu: %w", err)
 	}
 	// cipherTensorBeforeRelu, err := encryption.AddTwoCipherTensorNewMultiThread(publicKeys, cipherTensorHeaderBeforeRelu, cipherTensorX0BeforeRulu)
-	cipherTensorBeforeRelu, err := encryption.AddTwoCipherTensorNew(publicKeys, cipherTensorHeaderBeforeRelu, cipherTensorX0BeforeRulu)
+	cipherTensorBeforeRelu, err := encryption.AddTwoCipherTensorNew(ctx, publicKeys, cipherTensorHeaderBeforeRelu, cipherTensorX0BeforeRulu)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("sum before relu: %w", err)
 	}
 
 	fmt.Printf("...\n")
-	cipherTensorBeforeReluResult, err := maths.CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys, cipherTensorBeforeRelu, coeff_dash.Constant_Relu)
+	cipherTensorBeforeReluResult, err := maths.CiphertextTensorAddPlaintextMatrixMultiThread(ctx, publicKeys, cipherTensorBeforeRelu, coeff_dash.Constant_Relu)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("relu constant: %w", err)
 	}
-	elapsedTime = time.Since(startTime)
+	elapsedTime := time.Since(startTime)
 	fmt.Printf("  - before relu takes %s \n", elapsedTime)
-	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorBeforeReluResult)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// utils.PrintSliceInfo(valueTensor, "cipherTensorBeforeReluResult")
-	// fmt.Println(valueTensor[0])
 	startTime = time.Now()
 	// 2.1进行relu
-	cipherTensorRelu, err := maths.ApproximatePolynomialCipherTensorMultiThread(publicKeys, cipherTensorBeforeReluResult, dashModelParam.ReluCoefficients, [2]float64{-50, 40})
+	cipherTensorRelu, err := maths.ApproximatePolynomialCipherTensorMultiThread(ctx, publicKeys, cipherTensorBeforeReluResult, dashModelParam.ReluCoefficients, reluDomain)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("relu: %w", err)
 	}
-	cipherTensorReluResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, cipherTensorRelu, coeff_dash.Relu_before, coeff_dash.Relu_rear)
+	cipherTensorReluResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(ctx, publicKeys, cipherTensorRelu, coeff_dash.Relu_before, coeff_dash.Relu_rear)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("after relu: %w", err)
 	}
 	elapsedTime = time.Since(startTime)
 	fmt.Printf("  - relu takes %s\n", elapsedTime)
 	startTime = time.Now()
-	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorReluResult)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// utils.PrintSliceInfo(valueTensor, "cipherTensorReluResult")
-	// fmt.Println(valueTensor[0])
 
 	// 2.2对HeadComplex进行计算
-	cipherTensorHeadResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, concatenateHeader, coeff_dash.Head_before, coeff_dash.Head_rear)
+	cipherTensorHeadResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(ctx, publicKeys, concatenateHeader, coeff_dash.Head_before, coeff_dash.Head_rear)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("heads: %w", err)
 	}
 
-	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorHeadResult)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// utils.PrintSliceInfo(valueTensor, "cipherTensorHeadResult")
-	// fmt.Println(valueTensor[0])
-
 	// 2.3对X0进行计算
-	cipherTensorX0Result, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, ciphertextTensor, coeff_dash.X0_before, coeff_dash.X0_rear)
+	cipherTensorX0Result, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(ctx, publicKeys, ciphertextTensor, coeff_dash.X0_before, coeff_dash.X0_rear)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("input: %w", err)
 	}
 
-	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorX0Result)
-	// if err != nil {
-	// 	panic(err)
-	// }
-	// utils.PrintSliceInfo(valueTensor, "cipherTensorX0Result")
-	// fmt.Println(valueTensor[0])
-
 	// 3.1将所有结果相加
-	cipherTensorBeforePooling, err := encryption.AddThreeCipherTensorNewMultiThread(publicKeys, cipherTensorReluResult, cipherTensorHeadResult, cipherTensorX0Result)
+	cipherTensorBeforePooling, err := encryption.AddThreeCipherTensorNewMultiThread(ctx, publicKeys, cipherTensorReluResult, cipherTensorHeadResult, cipherTensorX0Result)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("sum before pooling: %w", err)
 	}
 
 	// 3.2进行pooling
-	cipherTensorPoolingResult, err := maths.CipherTensorPoolingAndAddConstantMultiThread(publicKeys, cipherTensorBeforePooling, coeff_dash.Constant_Dash)
+	cipherTensorPoolingResult, err := maths.CipherTensorPoolingAndAddConstantMultiThread(ctx, publicKeys, cipherTensorBeforePooling, coeff_dash.Constant_Dash)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("pooling: %w", err)
 	}
+	// 记录分类层缩小的倍数，解密时乘回来
+	cipherTensorPoolingResult.OutputScale = coeff_dash.OutputScale
 	elapsedTime = time.Since(startTime)
 	fmt.Printf("  - after relu takes %s\n", elapsedTime)
 	fmt.Printf("Encrypted computation takes %s\n", time.Since(startEncryptedComputation))
 	return cipherTensorPoolingResult, nil
 }
 
-// func evalUnfoldDashformerMultiTread(publicKeys *encryption.PublicParametersKeys, secretKeys *encryption.SecretParametersKeys,
-// 	exampleData [][][]float64, dashModelParam utils.DashformerModelParameters, coefficient coefficient.Coefficient_output) (*encryption.CiphertextTensor, error) {
-// 	// 2.2.加密example数据
-// 	ciphertextTensor, err := encryption.EncryptTensorValueMultiTread(publicKeys, exampleData)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	fmt.Printf("Encrypte data already, Rows:%d, Cols:%d, Depths:%d\n", ciphertextTensor.NumRows, ciphertextTensor.NumCols, ciphertextTensor.NumDepth)
-// 	// fmt.Printf("Ciphertext Tensor X0 Level:%d\n", ciphertextTensor.Ciphertexts[0].Level())
-
-// 	var concatenateHeader *encryption.CiphertextTensor
-// 	// 3.3.Attention
-// 	startTime := time.Now()
-// 	for i := 0; i < 4; i++ {
-// 		// 3.3.1. Compute Q,K,V
-// 		multiAttentionQ, err := maths.CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys, ciphertextTensor, coefficient.X0_rear_Q[i], coefficient.Constant_Q[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		multiAttentionK, err := maths.CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys, ciphertextTensor, coefficient.X0_rear_K[i], coefficient.Constant_K[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		multiAttentionV, err := maths.CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys, ciphertextTensor, coefficient.X0_rear_V[i], coefficient.Constant_V[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		// fmt.Printf("Ciphertext Tensor Q Level:%d\n", multiAttentionQ.Ciphertexts[0].Level())
-// 		// fmt.Printf("Ciphertext Tensor K Level:%d\n", multiAttentionK.Ciphertexts[0].Level())
-// 		// fmt.Printf("Ciphertext Tensor V Level:%d\n", multiAttentionV.Ciphertexts[0].Level())
-
-// 		multiAttentionHeader, err := maths.CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys, multiAttentionQ, multiAttentionK, multiAttentionV, 7, 8, dashModelParam.SoftMaxB[i], dashModelParam.SoftMaxC[i])
-// 		if err != nil {
-// 			panic(err)
-// 		}
-
-// 		fmt.Printf("Ciphertext Tensor multiAttentionHeader Level:%d\n", multiAttentionHeader.Ciphertexts[0].Level())
-// 		// 3.3.5 concatenate header
-// 		concatenateHeader, err = encryption.MergeAndAddCiphertextTensors(concatenateHeader, multiAttentionHeader)
-// 		if err != nil {
-// 			panic(err)
-// 		}
-// 	}
-// 	elapsedTime := time.Since(startTime)
-// 	fmt.Printf("Attention took %s to run.\n", elapsedTime)
-
-// 	// 开始计算展开式
-// 	// 1.计算rulu里面的内容
-// 	cipherTensorHeaderBeforeRelu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, concatenateHeader, coefficient.Head_before_relu, coefficient.Head_rear_relu)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	cipherTensorX0BeforeRulu, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, ciphertextTensor, coefficient.X0_before_relu, coefficient.X0_rear_relu)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	cipherTensorBeforeRelu, err := encryption.AddTwoCipherTensorNew(publicKeys, cipherTensorHeaderBeforeRelu, cipherTensorX0BeforeRulu)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	cipherTensorBeforeReluResult, err := maths.CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys, cipherTensorBeforeRelu, coefficient.Constant_Relu)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// valueTensor, err := encryption.DecryptTensorValue(secretKeys, cipherTensorBeforeReluResult)
-// 	// if err != nil {
-// 	// 	panic(err)
-// 	// }
-// 	// utils.PrintSliceInfo(valueTensor, "cipherTensorBeforeReluResult")
-// 	// fmt.Println(valueTensor[0])
-
-// 	// 2.1进行relu
-// 	cipherTensorRelu, err := maths.ApproximatePolynomialCipherTensorMultiThread(publicKeys, cipherTensorBeforeReluResult, dashModelParam.ReluCoefficients, [2]float64{-50, 40})
-// 	if err != nil {
-// 		panic(err)
-// 	}
-// 	cipherTensorReluResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, cipherTensorRelu, coefficient.Relu_before, coefficient.Relu_rear)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorReluResult)
-// 	// if err != nil {
-// 	// 	panic(err)
-// 	// }
-// 	// utils.PrintSliceInfo(valueTensor, "cipherTensorReluResult")
-// 	// fmt.Println(valueTensor[0])
-
-// 	// 2.2对Head进行计算
-// 	cipherTensorHeadResult, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, concatenateHeader, coefficient.Head_before, coefficient.Head_rear)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorHeadResult)
-// 	// if err != nil {
-// 	// 	panic(err)
-// 	// }
-// 	// utils.PrintSliceInfo(valueTensor, "cipherTensorHeadResult")
-// 	// fmt.Println(valueTensor[0])
-
-// 	// 2.3对X0进行计算
-// 	cipherTensorX0Result, err := maths.PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys, ciphertextTensor, coefficient.X0_before, coefficient.X0_rear)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, cipherTensorX0Result)
-// 	// if err != nil {
-// 	// 	panic(err)
-// 	// }
-// 	// utils.PrintSliceInfo(valueTensor, "cipherTensorX0Result")
-// 	// fmt.Println(valueTensor[0])
-
-// 	// 3.1将所有结果相加
-// 	cipherTensorBeforePooling, err := encryption.AddThreeCipherTensorNewMultiThread(publicKeys, cipherTensorReluResult, cipherTensorHeadResult, cipherTensorX0Result)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	// 3.2进行pooling
-// 	cipherTensorPoolingResult, err := maths.CipherTensorPoolingAndAddConstantMultiThread(publicKeys, cipherTensorBeforePooling, coefficient.Constant_Dash)
-// 	if err != nil {
-// 		panic(err)
-// 	}
-
-// 	return cipherTensorPoolingResult, nil
-
-// }
+// reluDomain 是 ReLU 多项式的定义域，calibrate 由校准序列上 ReLU 的输入建议新的定义域 (用 fit 在上面拟合系数)
+var reluDomain = [2]float64{-50, 40}
+
+// evalLayersDashformer 逐层计算 Dashformer：embedding，每个 Transformer 块 (maths.TransformerBlockMultiThread)，
+// 对所有位置求和和分类层；模型可以有多个块，层数不够下一个块或分类层时自举 (值的上界为 bootstrapBound)，
+// 没有自举密钥时层数须够用 (见 maths.PlanLevels)
+func evalLayersDashformer(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor,
+	dashModelParam utils.DashformerModelParameters, babyStep, giantStep, bootstrapBound int) (*encryption.CiphertextTensor, error) {
+	//! NOTE: Only public keys are available here, the input is encrypted by the data owner (see runEncrypt).
+
+	fmt.Println("Start computing with encrypted data, layer by layer")
+	startEncryptedComputation := time.Now()
+	X, err := maths.EmbeddingMultiThread(ctx, publicKeys, ciphertextTensor, dashModelParam)
+	if err != nil {
+		return nil, fmt.Errorf("embedding: %w", err)
+	}
+
+	plan := maths.PlanLevels(dashModelParam.NumBlocks(), len(dashModelParam.ReluCoefficients)-1, giantStep, dashModelParam.LayerNormInvSqrt)
+	// refresh 在剩下的层数少于 need 时自举 X
+	refresh := func(need int, before string) error {
+		level := X.Ciphertexts[0].Level()
+		startTime := time.Now()
+		if X, err = maths.RefreshMultiThread(ctx, publicKeys, X, need, bootstrapBound); err != nil {
+			return fmt.Errorf("bootstrapping before %s: %w", before, err)
+		}
+		if X.Ciphertexts[0].Level() != level {
+			fmt.Printf("  - bootstrapping before %s takes %s, ciphertexts from level %d to %d\n", before, time.Since(startTime), level, X.Ciphertexts[0].Level())
+		}
+		return nil
+	}
+
+	for k := 0; k < dashModelParam.NumBlocks(); k++ {
+		if err := refresh(plan.BlockLevels(), fmt.Sprintf("block %d", k)); err != nil {
+			return nil, err
+		}
+		startTime := time.Now()
+		X, err = maths.TransformerBlockMultiThread(ctx, publicKeys, X, dashModelParam.Block(k), dashModelParam.ReluCoefficients, reluDomain, dashModelParam.LayerNormInvSqrt, babyStep, giantStep)
+		if err != nil {
+			return nil, fmt.Errorf("transformer block %d: %w", k, err)
+		}
+		fmt.Printf("  - block %d takes %s, ciphertexts at level %d\n", k, time.Since(startTime), X.Ciphertexts[0].Level())
+	}
+
+	if err := refresh(plan.Classifier, "the classifier"); err != nil {
+		return nil, err
+	}
+	logits, err := maths.ClassifierMultiThread(ctx, publicKeys, X, dashModelParam)
+	if err != nil {
+		return nil, fmt.Errorf("pooling and classifier: %w", err)
+	}
+	fmt.Printf("Encrypted computation takes %s\n", time.Since(startEncryptedComputation))
+	return logits, nil
+}
 
 func main() {
+	command := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "keygen":
+		err = runKeygen(args)
+	case "encrypt":
+		err = runEncrypt(args)
+	case "eval":
+		err = runEval(args)
+	case "decrypt":
+		err = runDecrypt(args)
+	case "serve":
+		err = runServe(args)
+	case "compare":
+		err = runCompare(args)
+	case "calibrate":
+		err = runCalibrate(args)
+	case "fit":
+		err = runFit(args)
+	case "run":
+		err = runAll(args)
+	case "help":
+		usage()
+	default:
+		usage()
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		if hint := errorHint(err); hint != "" {
+			log.Fatalf("dashformer %s: %v\n%s", command, err, hint)
+		}
+		log.Fatalf("dashformer %s: %v", command, err)
+	}
+}
+
+// errorHint 对密文计算中的常见错误给出处理建议
+func errorHint(err error) string {
+	switch {
+	case errors.Is(err, encryption.ErrMissingRotationKey):
+		return "the evaluation keys lack a rotation: generate them again with the -baby-step and -giant-step used by eval"
+	case errors.Is(err, encryption.ErrLevelExhausted):
+		return "the ciphertexts ran out of levels: use a preset with more levels (see -preset), or bootstrap with -preset bootstrap -bootstrap -evaluation layers"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "the evaluation took longer than the timeout: raise -timeout, or set it to 0 for no limit"
+	case errors.Is(err, encryption.ErrShapeMismatch):
+		return "the shape of the input does not match the model: check the input, the tokenizer and the model parameters"
+	}
+	return ""
+}
+
+// runAll 在同一个进程中完成密钥生成、加密、密文计算和解密
+func runAll(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	fs.Parse(args)
+
 	startTime := time.Now()
 	fmt.Println("Data reading ...")
 
-	// 初始化配置
-	examplePath, tokenizerPath, modelParamPath, outputPath := config.Init()
-	// fmt.Println("Example Path:", examplePath)
-	// fmt.Println("Tokenizer Path:", tokenizerPath)
-	// fmt.Println("Model Parameter Path:", modelParamPath)
-	// fmt.Println("Output Path:", outputPath)
+	// 初始化配置 (run 的 -keys 默认为空：每次重新生成密钥；指定目录时第一次生成并保存，之后读取)
+	cfg, err := loadConfig(flags, config.InputFile|config.TokenizerFile|config.ModelDir)
+	if err != nil {
+		return err
+	}
 
 	// 1. 读取文件数据
 	// 1.1.读词向量文件，并解析成字典
-	tokenizerDate, err := utils.ReadWordIndex(tokenizerPath)
+	tokenizerDate, err := utils.ReadWordIndex(cfg.Tokenizer)
 	if err != nil {
 		fmt.Println("Error reading dashformer_tokenizer.json file:", err)
-		panic(err)
+		return err
 	}
 	// fmt.Println(tokenizerDate)
 
-	// 1.2.读输入示例，根据字典进行转换
-	exampleData, err := utils.ReadExampleData(examplePath, tokenizerDate)
+	// 1.2.读模型参数文件
+	dashModelParam, err := readModel(cfg)
 	if err != nil {
-		log.Fatalf("Error reading example_AA_sequences.list file: %v", err)
-		panic(err)
+		return err
 	}
-	// utils.PrintSliceInfo(exampleData, "exampleDataTensor")
 
-	// 1.3.读模型参数文件
-	dashModelParam, err := utils.ReadModelParameterFile(modelParamPath)
+	// 1.3.读输入示例，根据字典进行转换，补齐或截断到模型的序列长度
+	sequences, err := utils.ReadSequences(cfg.Input, tokenizerDate, sequencePolicy(cfg, dashModelParam.Shape))
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error reading %s: %v", cfg.Input, err)
 	}
+	printWarnings(sequences.Warnings)
 	// 显示读取结果
 	// dashModelParam.PrintDimensions()
 
-	// 1.4.生成系数(unfold)
-	coeff_dash, coeff_QKV, coeff_sqmax := coefficient.GenerateCoefficient(dashModelParam)
+	// 1.4.生成系数 (unfolded)
+	eval, err := newEvaluation(cfg, dashModelParam)
+	if err != nil {
+		return err
+	}
 
-	// 2.1.生成加密参数
-	publicKeys, secretKeys, err := encryption.SetHERealParams()
+	// 2.1.生成加密参数 (或从 keyDir 读取)
+	publicKeys, secretKeys, err := loadOrGenerateKeys(cfg)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	// 2.2.加密example数据
+	fmt.Println("Encrypting data ... ")
+	encryptStartTime := time.Now()
+	// 序列数超过槽数时分成多个分片
+	batch, err := encryption.EncryptBatch(publicKeys, sequences.Data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encrypting data ... takes %s\n", time.Since(encryptStartTime))
+
 	// 进行密文计算
-	// 调用 evalDashformer 函数并处理结果
-	poolingAndClassification, err := evalUnfoldDashformerWithBSGSMultiTread(publicKeys, secretKeys, exampleData, dashModelParam, coeff_dash, coeff_QKV, coeff_sqmax)
+	// 对每个分片调用 evalDashformer 函数并处理结果
+	ctx, cancel := evalContext(cfg)
+	defer cancel()
+	poolingAndClassification, err := evalBatch(ctx, publicKeys, batch, eval, cfg.ParallelShards)
 	if err != nil {
-		fmt.Println("Error in evalDashformer:", err)
+		return fmt.Errorf("error in evalDashformer: %w", err)
 	}
 
-	fmt.Printf("  - ciphertexts now at level:%d\n", poolingAndClassification.Ciphertexts[0].Level())
+	fmt.Printf("  - ciphertexts now at level:%d\n", poolingAndClassification.Shards[0].Ciphertexts[0].Level())
 
 	fmt.Println("Decrypting and writing the result ...")
-	// 解密结果
-	valueTensor, err := encryption.DecryptTensorValueMultiThread(secretKeys, poolingAndClassification)
+	// 解密结果，按输入顺序拼接各分片
+	valueTensor, err := encryption.DecryptBatch(secretKeys, poolingAndClassification)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// 解密到文件中
-	utils.WriteResultToFile(outputPath, valueTensor)
+	if err := os.MkdirAll(cfg.Output, 0755); err != nil {
+		return err
+	}
+	resultPath, err := writeResult(cfg, &sequences.SequenceLayout, valueTensor)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Result written to %s\n", resultPath)
 
 	elapsedTime := time.Since(startTime)
 	fmt.Printf("Total running time is %s.\n", elapsedTime)
-
+	return nil
 }
@@ -0,0 +1,270 @@
+package maths
+
+import (
+	"context"
+	"dashformer/encryption"
+	"dashformer/utils"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+/*
+ * EmbeddingMultiThread
+ * Input:  PublicParametersKeys, one-hot ctTensor CiphertextTensor (序列数 × 序列长度 × 词表大小), DashformerModelParameters
+ * Output: CiphertextTensor,error
+ * Compute: ctTensor X EmbeddingMatrix + EncodingMatrix --> X (序列数 × 序列长度 × embed_dim)
+ * 1CMul
+ */
+func EmbeddingMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, dashModelParam utils.DashformerModelParameters) (*encryption.CiphertextTensor, error) {
+	return CipherTensorMulPlainMatAndAddPlainMatMultiThread(ctx, publicKeys, ciphertextTensor, dashModelParam.EmbeddingMatrix, dashModelParam.EncodingMatrix)
+}
+
+/*
+ * TransformerBlockMultiThread
+ * Input:  PublicParametersKeys, X CiphertextTensor (序列数 × 序列长度 × embed_dim), TransformerBlock,
+ *         ReLU 多项式系数 (单项式基) 和定义域, LayerNorm1/2 的 1/sqrt(variance), babyStep, giantStep int
+ * Output: CiphertextTensor,error
+ * Compute: 逐层计算一个 Transformer 块：每个头的 Q,K,V --> BSGS 注意力 --> 拼接 --> combine --> +X --> LayerNorm1
+ *          --> FFN1 --> ReLU --> FFN2 --> +out1 --> LayerNorm2，和 reference.Approximate 一致
+ * Levels(BlockPlan(len(reluCoeffs)-1, giantStep, layerNorm)) 层
+ */
+func TransformerBlockMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X *encryption.CiphertextTensor, block utils.TransformerBlock,
+	reluCoeffs []float64, reluDomain [2]float64, layerNorm [2]utils.InvSqrt, babyStep, giantStep int) (*encryption.CiphertextTensor, error) {
+	if err := encryption.CheckLevel("transformer block", X.Ciphertexts[0], Levels(BlockPlan(len(reluCoeffs)-1, giantStep, layerNorm))); err != nil {
+		return nil, err
+	}
+
+	// 1. 多头注意力，各个头的输出按深度拼接
+	var concatenateHeader *encryption.CiphertextTensor
+	for h := range block.QueryWeightAttentionMatrixs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		Q, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, X, block.QueryWeightAttentionMatrixs[h], block.QueryBiasAttentionVectors[h])
+		if err != nil {
+			return nil, fmt.Errorf("query of head %d: %w", h, err)
+		}
+		K, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, X, block.KeyWeightAttentionMatrixs[h], block.KeyBiasAttentionVectors[h])
+		if err != nil {
+			return nil, fmt.Errorf("key of head %d: %w", h, err)
+		}
+		V, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, X, block.ValueWeightAttentionMatrixs[h], block.ValueBiasAttentionVectors[h])
+		if err != nil {
+			return nil, fmt.Errorf("value of head %d: %w", h, err)
+		}
+		attentionHeader, err := CiphertextTensorQKVToAttentionWithBSGSMultiThread(ctx, publicKeys, Q, K, V, babyStep, giantStep, block.SoftMaxB[h], block.SoftMaxC[h])
+		if err != nil {
+			return nil, fmt.Errorf("attention head %d: %w", h, err)
+		}
+		concatenateHeader, err = encryption.MergeAndAddCiphertextTensors(concatenateHeader, attentionHeader)
+		if err != nil {
+			return nil, fmt.Errorf("concatenating head %d: %w", h, err)
+		}
+	}
+
+	// 2. combine，残差连接，LayerNorm1
+	attention, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, concatenateHeader, block.CombineWeightMatrixs, block.CombineBiasVectors)
+	if err != nil {
+		return nil, fmt.Errorf("combine: %w", err)
+	}
+	residual1, err := encryption.AddTwoCipherTensorNew(ctx, publicKeys, X, attention)
+	if err != nil {
+		return nil, fmt.Errorf("attention residual: %w", err)
+	}
+	out1, err := CiphertextTensorLayerNormMultiThread(ctx, publicKeys, residual1, block.LayerNormVectorR1, block.LayerNormVectorB1, block.LayerNormSqrtVariance1, layerNorm[0])
+	if err != nil {
+		return nil, fmt.Errorf("layernorm1: %w", err)
+	}
+
+	// 3. FFN，残差连接，LayerNorm2
+	hidden, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, out1, block.FeedForwardWeightMatrix1, block.FeedForwardBiasVector1)
+	if err != nil {
+		return nil, fmt.Errorf("ffn1: %w", err)
+	}
+	hidden, err = ApproximatePolynomialCipherTensorMultiThread(ctx, publicKeys, hidden, reluCoeffs, reluDomain)
+	if err != nil {
+		return nil, fmt.Errorf("relu: %w", err)
+	}
+	ffn, err := CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, hidden, block.FeedForwardWeightMatrix2, block.FeedForwardBiasVector2)
+	if err != nil {
+		return nil, fmt.Errorf("ffn2: %w", err)
+	}
+	residual2, err := encryption.AddTwoCipherTensorNew(ctx, publicKeys, out1, ffn)
+	if err != nil {
+		return nil, fmt.Errorf("ffn residual: %w", err)
+	}
+	out2, err := CiphertextTensorLayerNormMultiThread(ctx, publicKeys, residual2, block.LayerNormVectorR2, block.LayerNormVectorB2, block.LayerNormSqrtVariance2, layerNorm[1])
+	if err != nil {
+		return nil, fmt.Errorf("layernorm2: %w", err)
+	}
+	return out2, nil
+}
+
+/*
+ * RefreshMultiThread
+ * Input:  PublicParametersKeys, X CiphertextTensor, need int, bound int
+ * Output: CiphertextTensor,error
+ * Compute: X 剩下的层数少于下一步需要的 need 层时自举 X (|值| <= bound，见 encryption.BootstrapTensor)，否则原样返回；
+ *          没有自举密钥时返回 LevelError
+ */
+func RefreshMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X *encryption.CiphertextTensor, need, bound int) (*encryption.CiphertextTensor, error) {
+	if X.Ciphertexts[0].Level() >= need {
+		return X, nil
+	}
+	if publicKeys.Bootstrapper == nil {
+		return nil, encryption.CheckLevel("refresh without bootstrapping keys", X.Ciphertexts[0], need)
+	}
+	if output := publicKeys.Bootstrapper.OutputLevel(); output < need {
+		return nil, &encryption.LevelError{Op: "bootstrapping", Level: output, Need: need}
+	}
+	return encryption.BootstrapTensor(ctx, publicKeys, X, bound)
+}
+
+/*
+ * ClassifierMultiThread
+ * Input:  PublicParametersKeys, X CiphertextTensor (序列数 × 序列长度 × embed_dim), DashformerModelParameters
+ * Output: CiphertextTensor,error
+ * Compute: 对所有位置求和后乘分类层，分类层缩小 ResultScale 倍 (记录在 OutputScale 中，解密时乘回来)；
+ *          第 i 条序列的 logits 在第 i 行的第 0 列
+ * 1CMul
+ */
+func ClassifierMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X *encryption.CiphertextTensor, dashModelParam utils.DashformerModelParameters) (*encryption.CiphertextTensor, error) {
+	outputScale := dashModelParam.ResultScale()
+	logits, err := CiphertextTensorMultiplyClassificationAndPoolingMultiThread(ctx, publicKeys, X,
+		utils.ScaleMatrix(dashModelParam.ClassifierWeightMatrix, 1/outputScale), utils.ScaleVector(dashModelParam.ClassifierBiasVector, 1/outputScale))
+	if err != nil {
+		return nil, err
+	}
+	logits.OutputScale = outputScale
+	return logits, nil
+}
+
+// LevelStage 是逐层计算的一步和它消耗的层数
+type LevelStage struct {
+	Name   string
+	Levels int
+}
+
+// BlockPlan 返回一个 Transformer 块每一步消耗的层数：乘明文矩阵 1 层，
+// 注意力 5 层 (K 的旋转, QK^T, softmax, 乘 V, 旋转部分和；giantStep 为 1 时不旋转部分和)，
+// ReLU 为 reluDegree 次多项式的深度，LayerNorm 见 LayerNormLevels
+func BlockPlan(reluDegree, giantStep int, layerNorm [2]utils.InvSqrt) []LevelStage {
+	attention := 5
+	if giantStep == 1 {
+		attention = 4
+	}
+	return []LevelStage{
+		{"query/key/value", 1},
+		{"attention", attention},
+		{"combine", 1},
+		{"layernorm1", LayerNormLevels(layerNorm[0])},
+		{"ffn1", 1},
+		{"relu", bits.Len(uint(reluDegree))},
+		{"ffn2", 1},
+		{"layernorm2", LayerNormLevels(layerNorm[1])},
+	}
+}
+
+// LevelPlan 是逐层计算 Blocks 个块所需的层数：embedding，每个块 (BlockStages)，分类层
+type LevelPlan struct {
+	Embedding   int
+	BlockStages []LevelStage
+	Blocks      int
+	Classifier  int
+}
+
+// PlanLevels 返回 numBlocks 个块的层数预算，reluDegree 是 ReLU 多项式的次数，layerNorm 是 LayerNorm1/2 的计算方式
+func PlanLevels(numBlocks, reluDegree, giantStep int, layerNorm [2]utils.InvSqrt) LevelPlan {
+	return LevelPlan{Embedding: 1, BlockStages: BlockPlan(reluDegree, giantStep, layerNorm), Blocks: numBlocks, Classifier: 1}
+}
+
+// Levels 返回 stages 消耗的层数
+func Levels(stages []LevelStage) int {
+	n := 0
+	for _, s := range stages {
+		n += s.Levels
+	}
+	return n
+}
+
+// BlockLevels 返回一个块消耗的层数
+func (p LevelPlan) BlockLevels() int {
+	return Levels(p.BlockStages)
+}
+
+// Total 返回整个计算消耗的层数
+func (p LevelPlan) Total() int {
+	return p.Embedding + p.Blocks*p.BlockLevels() + p.Classifier
+}
+
+// Fits 返回从 maxLevel 层的新密文开始，不自举能否完成计算
+func (p LevelPlan) Fits(maxLevel int) bool {
+	return p.Total() <= maxLevel
+}
+
+// MaxBlocks 返回从 maxLevel 层的新密文开始，不自举能计算的块数
+func (p LevelPlan) MaxBlocks(maxLevel int) int {
+	return max(0, (maxLevel-p.Embedding-p.Classifier)/p.BlockLevels())
+}
+
+// BlocksPerRefresh 返回自举到 outputLevel 层后，下一次自举前能计算的块数
+func (p LevelPlan) BlocksPerRefresh(outputLevel int) int {
+	return max(0, outputLevel/p.BlockLevels())
+}
+
+// Refreshes 返回从 maxLevel 层的新密文开始，每次自举到 outputLevel 层时需要自举的次数：
+// 剩下的层数不够下一个块或分类层时自举。自举后连一个块也算不了时返回 -1
+func (p LevelPlan) Refreshes(maxLevel, outputLevel int) int {
+	if p.BlocksPerRefresh(outputLevel) == 0 {
+		return -1
+	}
+	refreshes := 0
+	level := maxLevel - p.Embedding
+	for k := 0; k < p.Blocks; k++ {
+		if level < p.BlockLevels() {
+			refreshes++
+			level = outputLevel
+		}
+		level -= p.BlockLevels()
+	}
+	if level < p.Classifier {
+		refreshes++
+	}
+	return refreshes
+}
+
+// budget 返回每一步的层数和总层数
+func (p LevelPlan) budget() string {
+	stages := make([]string, len(p.BlockStages))
+	for i, s := range p.BlockStages {
+		stages[i] = fmt.Sprintf("%s %d", s.Name, s.Levels)
+	}
+	return fmt.Sprintf("  - level budget: embedding %d + %d blocks × %d (%s) + classifier %d = %d levels\n",
+		p.Embedding, p.Blocks, p.BlockLevels(), strings.Join(stages, ", "), p.Classifier, p.Total())
+}
+
+// BootstrapReport 返回自举时的层数预算：每次自举到 outputLevel 层能计算的块数和自举的次数
+func (p LevelPlan) BootstrapReport(maxLevel, outputLevel int) string {
+	var b strings.Builder
+	b.WriteString(p.budget())
+	if refreshes := p.Refreshes(maxLevel, outputLevel); refreshes < 0 {
+		fmt.Fprintf(&b, "  - bootstrapping refreshes the ciphertexts to level %d, less than the %d levels of one block\n", outputLevel, p.BlockLevels())
+	} else {
+		fmt.Fprintf(&b, "  - bootstrapping to level %d: %d blocks per refresh, %d bootstrappings\n", outputLevel, p.BlocksPerRefresh(outputLevel), refreshes)
+	}
+	return b.String()
+}
+
+// Report 返回层数预算的说明：每一步的层数、总层数，以及 maxLevel 层是否够用
+func (p LevelPlan) Report(maxLevel int) string {
+	var b strings.Builder
+	b.WriteString(p.budget())
+	if p.Fits(maxLevel) {
+		fmt.Fprintf(&b, "  - fits the %d levels of the modulus chain, %d left\n", maxLevel, maxLevel-p.Total())
+	} else {
+		fmt.Fprintf(&b, "  - does not fit the %d levels of the modulus chain: %d blocks fit without bootstrapping, %d need bootstrapping or a preset with more levels\n",
+			maxLevel, p.MaxBlocks(maxLevel), p.Blocks-p.MaxBlocks(maxLevel))
+	}
+	return b.String()
+}
@@ -0,0 +1,288 @@
+package maths
+
+import (
+	"context"
+	"dashformer/encryption"
+	"dashformer/reference"
+	"dashformer/utils"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+// randomBlockModel 生成维数为 shape 的随机模型，softmax 的 c 和 1/sqrt(方差) 的范围比 utils.RandomModel 小，
+// 使权重为 0.5 的小模型的中间值留在近似多项式的定义域内
+func randomBlockModel(r *rand.Rand, shape utils.ModelShape) utils.DashformerModelParameters {
+	dash := utils.RandomModel(r, shape, 0.5)
+	for k := 0; k < dash.NumBlocks(); k++ {
+		b := dash.Block(k)
+		for h := range b.SoftMaxC {
+			b.SoftMaxC[h] = 20 + 20*r.Float64()
+		}
+		b.LayerNormSqrtVariance1 = utils.RandomVector(r, shape.SeqLength, 0.2)
+		b.LayerNormSqrtVariance2 = utils.RandomVector(r, shape.SeqLength, 0.2)
+		for i := 0; i < shape.SeqLength; i++ {
+			b.LayerNormSqrtVariance1[i] += 1
+			b.LayerNormSqrtVariance2[i] += 1
+		}
+		dash.SetBlock(k, b)
+	}
+	return dash
+}
+
+// minLevel 返回张量中密文的最低层数
+func minLevel(ciphertextTensor *encryption.CiphertextTensor) int {
+	level := math.MaxInt
+	for _, ct := range ciphertextTensor.Ciphertexts {
+		level = min(level, ct.Level())
+	}
+	return level
+}
+
+func TestTransformerBlocks(t *testing.T) {
+	ctx := context.Background()
+	shape := utils.ModelShape{SeqLength: 6, VocabSize: 5, EmbedDim: 4, Heads: 2, HeadSize: 2, FFNDim: 3, NumClasses: 2, Blocks: 2}
+	babyStep, giantStep := 2, 3
+	r := rand.New(rand.NewSource(7))
+	dash := randomBlockModel(r, shape)
+
+	plan := PlanLevels(shape.Blocks, len(dash.ReluCoefficients)-1, giantStep, dash.LayerNormInvSqrt)
+	logQ := []int{55}
+	for i := 0; i < plan.Total(); i++ {
+		logQ = append(logQ, 40)
+	}
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            11,
+		LogQ:            logQ,
+		LogP:            []int{61},
+		LogDefaultScale: 40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Fits(params.MaxLevel()) || PlanLevels(shape.Blocks+1, 2, giantStep, dash.LayerNormInvSqrt).Fits(params.MaxLevel()) {
+		t.Fatalf("the plan of %d levels should fit exactly in %d levels", plan.Total(), params.MaxLevel())
+	}
+	galEls, err := DashformerGaloisElements(params, shape.SeqLength, babyStep, giantStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.GenHERealKeys(params, galEls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := encryption.NewPublicParametersKeys(params, keys.Pk, keys.Evk)
+	secretKeys := encryption.NewSecretParametersKeys(params, keys.Sk)
+
+	sequences := make([][][]float64, 2)
+	for i := range sequences {
+		sequences[i] = make([][]float64, shape.SeqLength)
+		for j := range sequences[i] {
+			sequences[i][j] = make([]float64, shape.VocabSize)
+			sequences[i][j][r.Intn(shape.VocabSize)] = 1
+		}
+	}
+	X, err := encryption.EncryptTensorValue(publicKeys, sequences)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每一步消耗的层数和 plan 一致
+	level := minLevel(X)
+	if X, err = EmbeddingMultiThread(ctx, publicKeys, X, dash); err != nil {
+		t.Fatal(err)
+	}
+	if got := level - minLevel(X); got != plan.Embedding {
+		t.Errorf("the embedding consumed %d levels, planned %d", got, plan.Embedding)
+	}
+	for k := 0; k < dash.NumBlocks(); k++ {
+		level = minLevel(X)
+		if X, err = TransformerBlockMultiThread(ctx, publicKeys, X, dash.Block(k), dash.ReluCoefficients, [2]float64{-50, 40}, dash.LayerNormInvSqrt, babyStep, giantStep); err != nil {
+			t.Fatalf("block %d: %v", k, err)
+		}
+		if got := level - minLevel(X); got != plan.BlockLevels() {
+			t.Errorf("block %d consumed %d levels, planned %d", k, got, plan.BlockLevels())
+		}
+	}
+	level = minLevel(X)
+	logits, err := ClassifierMultiThread(ctx, publicKeys, X, dash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := level - minLevel(logits); got != plan.Classifier {
+		t.Errorf("the classifier consumed %d levels, planned %d", got, plan.Classifier)
+	}
+
+	values, err := encryption.DecryptTensorValue(secretKeys, logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sequence := range sequences {
+		want, err := reference.ForwardSequence(dash, sequence, reference.Approximate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := range want {
+			if got := values[i][0][c]; math.Abs(got-want[c]) > 1e-3*math.Max(1, math.Abs(want[c])) {
+				t.Errorf("sequence %d class %d: got %g, want %g", i, c, got, want[c])
+			}
+		}
+	}
+
+	// 层数不够时在计算前报错
+	if _, err := TransformerBlockMultiThread(ctx, publicKeys, X, dash.Block(0), dash.ReluCoefficients, [2]float64{-50, 40}, dash.LayerNormInvSqrt, babyStep, giantStep); err == nil {
+		t.Error("expected a level error for a block on an exhausted ciphertext")
+	}
+}
+
+func TestPlanLevels(t *testing.T) {
+	// 发布模型的 ReLU 是 6 次多项式
+	plan := PlanLevels(1, 6, 8, [2]utils.InvSqrt{})
+	if plan.BlockLevels() != 14 || plan.Total() != 16 {
+		t.Fatalf("got %d levels per block and %d in total, want 14 and 16", plan.BlockLevels(), plan.Total())
+	}
+	for _, tc := range []struct {
+		preset string
+		fits   bool
+	}{
+		{encryption.DefaultPreset, false},
+		{"deep", true},
+	} {
+		params, err := encryption.NewHERealParamsFromPreset(tc.preset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plan.Fits(params.MaxLevel()) != tc.fits {
+			t.Errorf("%s: fits %v, want %v", tc.preset, !tc.fits, tc.fits)
+		}
+	}
+	if n := PlanLevels(3, 6, 8, [2]utils.InvSqrt{}).MaxBlocks(18); n != 1 {
+		t.Errorf("%d blocks fit in 18 levels, want 1", n)
+	}
+	// 3 个块中 1 个放得下，另外 2 个需要自举
+	report := PlanLevels(3, 6, 8, [2]utils.InvSqrt{}).Report(18)
+	if want := "does not fit the 18 levels of the modulus chain: 1 blocks fit without bootstrapping, 2 need bootstrapping"; !strings.Contains(report, want) {
+		t.Errorf("report %q, want %q", report, want)
+	}
+	if report := plan.Report(20); !strings.Contains(report, "fits the 20 levels of the modulus chain, 4 left") {
+		t.Errorf("report %q for a plan that fits", report)
+	}
+	if PlanLevels(1, 6, 1, [2]utils.InvSqrt{}).BlockLevels() != 13 {
+		t.Error("without giant steps the attention does not rotate the partial sums")
+	}
+
+	// LayerNorm2 在密文上计算方差：5 次多项式 3 层，2 次牛顿迭代 6 层，另外 3 层
+	encrypted := [2]utils.InvSqrt{{}, {Coeffs: make([]float64, 6), NewtonIterations: 2}}
+	if n := PlanLevels(1, 6, 8, encrypted).BlockLevels(); n != 14-1+12 {
+		t.Errorf("got %d levels per block with an encrypted layernorm2, want %d", n, 14-1+12)
+	}
+}
+
+func TestTransformerBlocksBootstrapping(t *testing.T) {
+	ctx := context.Background()
+	shape := utils.ModelShape{SeqLength: 6, VocabSize: 5, EmbedDim: 4, Heads: 2, HeadSize: 2, FFNDim: 3, NumClasses: 2, Blocks: 2}
+	babyStep, giantStep := 2, 3
+	r := rand.New(rand.NewSource(11))
+	dash := randomBlockModel(r, shape)
+
+	// 自举后的层数正好够一个块：embedding 之后、每个块之后都要自举
+	plan := PlanLevels(shape.Blocks, len(dash.ReluCoefficients)-1, giantStep, dash.LayerNormInvSqrt)
+	logQ := []int{60}
+	for i := 0; i < plan.BlockLevels(); i++ {
+		logQ = append(logQ, 40)
+	}
+	params, btpParams, err := encryption.NewBootstrappingTestParameters(11, logQ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Refreshes(params.MaxLevel(), params.MaxLevel()); n != 3 {
+		t.Fatalf("planned %d bootstrappings, want 3", n)
+	}
+	if plan.BlocksPerRefresh(params.MaxLevel()) != 1 || PlanLevels(1, 6, giantStep, dash.LayerNormInvSqrt).Refreshes(params.MaxLevel(), params.MaxLevel()) != -1 {
+		t.Fatal("one block of degree 2 and none of degree 6 fit between two bootstrappings")
+	}
+
+	galEls, err := DashformerGaloisElements(params, shape.SeqLength, babyStep, giantStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.GenHERealKeys(params, galEls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	btk, err := encryption.GenBootstrappingKeys(btpParams, keys.Sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := encryption.NewPublicParametersKeys(params, keys.Pk, keys.Evk)
+	secretKeys := encryption.NewSecretParametersKeys(params, keys.Sk)
+
+	sequences := make([][][]float64, 2)
+	for i := range sequences {
+		sequences[i] = make([][]float64, shape.SeqLength)
+		for j := range sequences[i] {
+			sequences[i][j] = make([]float64, shape.VocabSize)
+			sequences[i][j][r.Intn(shape.VocabSize)] = 1
+		}
+	}
+	X, err := encryption.EncryptTensorValue(publicKeys, sequences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if X, err = EmbeddingMultiThread(ctx, publicKeys, X, dash); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有自举密钥时在计算前报层数不够
+	if _, err := RefreshMultiThread(ctx, publicKeys, X, plan.BlockLevels(), 16); !errors.Is(err, encryption.ErrLevelExhausted) {
+		t.Fatalf("got %v, want a level error without bootstrapping keys", err)
+	}
+	if err := publicKeys.SetBootstrappingKeys(btk); err != nil {
+		t.Fatal(err)
+	}
+
+	refreshes := 0
+	refresh := func(need int) {
+		level := minLevel(X)
+		if X, err = RefreshMultiThread(ctx, publicKeys, X, need, 16); err != nil {
+			t.Fatal(err)
+		}
+		if minLevel(X) != level {
+			refreshes++
+		}
+	}
+	for k := 0; k < dash.NumBlocks(); k++ {
+		refresh(plan.BlockLevels())
+		if X, err = TransformerBlockMultiThread(ctx, publicKeys, X, dash.Block(k), dash.ReluCoefficients, [2]float64{-50, 40}, dash.LayerNormInvSqrt, babyStep, giantStep); err != nil {
+			t.Fatalf("block %d: %v", k, err)
+		}
+	}
+	refresh(plan.Classifier)
+	if refreshes != 3 {
+		t.Errorf("bootstrapped %d times, planned 3", refreshes)
+	}
+	logits, err := ClassifierMultiThread(ctx, publicKeys, X, dash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := encryption.DecryptTensorValue(secretKeys, logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sequence := range sequences {
+		want, err := reference.ForwardSequence(dash, sequence, reference.Approximate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c := range want {
+			if got := values[i][0][c]; math.Abs(got-want[c]) > 1e-3*math.Max(1, math.Abs(want[c])) {
+				t.Errorf("sequence %d class %d: got %g, want %g", i, c, got, want[c])
+			}
+		}
+	}
+}
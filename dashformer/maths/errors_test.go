@@ -0,0 +1,63 @@
+package maths
+
+import (
+	"context"
+	"dashformer/encryption"
+	"errors"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+func TestMatrixErrors(t *testing.T) {
+	ctx := context.Background()
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            12,
+		LogQ:            []int{40, 30, 30},
+		LogP:            []int{40},
+		LogDefaultScale: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kgen := rlwe.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairNew()
+	// 只有重线性化密钥，没有旋转密钥
+	publicKeys := encryption.NewPublicParametersKeys(params, pk, rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(sk)))
+
+	// 2 条序列，长度 3，深度 2
+	plainTensorValue := [][][]float64{
+		{{1, 2}, {3, 4}, {5, 6}},
+		{{7, 8}, {9, 10}, {11, 12}},
+	}
+	ciphertextTensor, err := encryption.EncryptTensorValue(publicKeys, plainTensorValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 明文矩阵有 3 行，密文深度为 2
+	_, err = CiphertextTensorMultiplyPlaintextMatrixMultiThread(ctx, publicKeys, ciphertextTensor, [][]float64{{1}, {2}, {3}})
+	var shapeErr *encryption.ShapeError
+	if !errors.As(err, &shapeErr) || shapeErr.Op != "multiply plaintext matrix" {
+		t.Errorf("multiplying by a 3x1 matrix: got %v, want a ShapeError", err)
+	}
+
+	_, err = CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx, publicKeys, ciphertextTensor, [][]float64{{1}, {2}}, []float64{1, 2})
+	if !errors.Is(err, encryption.ErrShapeMismatch) {
+		t.Errorf("bias of length 2 for one output: got %v, want ErrShapeMismatch", err)
+	}
+
+	_, err = CiphertextTensorRotationByColsNewMultiThread(ctx, publicKeys.Evaluator, ciphertextTensor, 1, 1, params.MaxSlots())
+	var keyErr *encryption.RotationKeyError
+	if !errors.Is(err, encryption.ErrMissingRotationKey) || !errors.As(err, &keyErr) {
+		t.Errorf("rotation without Galois keys: got %v, want a RotationKeyError", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = CiphertextTensorMultiplyPlaintextMatrixMultiThread(canceled, publicKeys, ciphertextTensor, [][]float64{{1}, {2}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("multiplying with a canceled context: got %v, want context.Canceled", err)
+	}
+}
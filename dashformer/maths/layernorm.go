@@ -0,0 +1,227 @@
+package maths
+
+import (
+	"context"
+	"dashformer/encryption"
+	"dashformer/utils"
+	"math"
+	"math/bits"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/utils/bignum"
+)
+
+// invSqrtInputScale 是密文中方差缩小的倍数：单项式基的系数编码为整数 (系数 × 约 2^40)，方差约为 100 时
+// x^5 的系数只有 1e-10 量级，精度不够；在 v/invSqrtInputScale 上计算多项式 (系数乘以 invSqrtInputScale^k)，
+// 乘回整数 invSqrtInputScale 不消耗层数
+const invSqrtInputScale = 128
+
+// LayerNormLevels 返回 LayerNorm 消耗的层数：预先计算的 1/sqrt(variance) 1 层；在密文上计算时
+// 中心化 1 层，方差 1 层，初始值多项式的深度，每次牛顿迭代 3 层，乘 R 和 1/sqrt(variance) 1 层
+func LayerNormLevels(invSqrt utils.InvSqrt) int {
+	if !invSqrt.Encrypted() {
+		return 1
+	}
+	return 3 + bits.Len(uint(len(invSqrt.Coeffs)-1)) + 3*invSqrt.NewtonIterations
+}
+
+/*
+ * CiphertextTensorLayerNormMultiThread
+ * Input:  PublicParametersKeys, ctTensor *CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64, invSqrt utils.InvSqrt
+ * Output: *CiphertextTensor, error
+ * Compute: invSqrt.Encrypted() 时见 CiphertextTensorLayerNormEncryptedMultiThread，
+ *          否则见 CiphertextTensorLayerNormReplaceVarianceMultiThread (使用预先计算的 varVector)
+ * LayerNormLevels(invSqrt) 层
+ */
+func CiphertextTensorLayerNormMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64, invSqrt utils.InvSqrt) (*encryption.CiphertextTensor, error) {
+	if invSqrt.Encrypted() {
+		return CiphertextTensorLayerNormEncryptedMultiThread(ctx, publicKeys, ciphertextTensor, layerNormR, layerNormB, invSqrt)
+	}
+	return CiphertextTensorLayerNormReplaceVarianceMultiThread(ctx, publicKeys, ciphertextTensor, layerNormR, layerNormB, varVector)
+}
+
+/*
+ * CiphertextTensorLayerNormEncryptedMultiThread
+ * Input:  PublicParametersKeys, ctTensor *CiphertextTensor, layerNormR []float64, layerNormB []float64, invSqrt utils.InvSqrt
+ * Output: *CiphertextTensor, error
+ * Compute: 每个位置的特征在 d 条密文中 (同一个槽)，不需要旋转 (S = invSqrtInputScale)：
+ *          c_j = (d*x_j - Σx)/(d*sqrt(d*S)) = (x_j - mean)/sqrt(d*S), v/S = Σ c_j^2 (方差除以 S),
+ *          y = invSqrt 的多项式 (v), 牛顿迭代 y <- (y/2)(3 - (v*y)*y),
+ *          out_j = (c_j * R_j*sqrt(d*S)) * y + B_j，和 utils.InvSqrt.Eval 的步骤相同
+ * LayerNormLevels(invSqrt) 层
+ */
+func CiphertextTensorLayerNormEncryptedMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, invSqrt utils.InvSqrt) (*encryption.CiphertextTensor, error) {
+	d := ciphertextTensor.NumDepth
+	if d != len(layerNormR) || len(layerNormB) != len(layerNormR) {
+		return nil, &encryption.ShapeError{Op: "layernorm", Want: []int{d, d}, Got: []int{len(layerNormR), len(layerNormB)}}
+	}
+	if err := encryption.CheckLevel("encrypted layernorm", ciphertextTensor.Ciphertexts[0], LayerNormLevels(invSqrt)); err != nil {
+		return nil, err
+	}
+
+	// compute sum
+	ctSum := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
+	for i := 0; i < d; i++ {
+		if err := publicKeys.Evaluator.Add(ctSum, ciphertextTensor.Ciphertexts[i], ctSum); err != nil {
+			return nil, err
+		}
+	}
+
+	// 1. 中心化，乘整数 d 不消耗层数；c_j 的平方和是方差除以 S
+	centered := make([]*rlwe.Ciphertext, d)
+	squares := make([]*rlwe.Ciphertext, d)
+	g, gctx := utils.WithContext(ctx)
+	for i := 0; i < d; i++ {
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			evaluator := publicKeys.Evaluator.ShallowCopy()
+			ctTmp, err := evaluator.MulNew(ciphertextTensor.Ciphertexts[i], d)
+			if err != nil {
+				return err
+			}
+			if err := evaluator.Sub(ctTmp, ctSum, ctTmp); err != nil {
+				return err
+			}
+			if err := evaluator.Mul(ctTmp, 1/(float64(d)*math.Sqrt(float64(d*invSqrtInputScale))), ctTmp); err != nil {
+				return err
+			}
+			if err := encryption.Rescale(evaluator, ctTmp); err != nil {
+				return err
+			}
+			square, err := evaluator.MulRelinNew(ctTmp, ctTmp)
+			if err != nil {
+				return err
+			}
+			if err := encryption.Rescale(evaluator, square); err != nil {
+				return err
+			}
+			centered[i], squares[i] = ctTmp, square
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// 2. 方差和 1/sqrt(variance)，每个位置的值在同一个槽，所有特征共用一条密文
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ctScaledVar := squares[0]
+	for i := 1; i < d; i++ {
+		if err := publicKeys.Evaluator.Add(ctScaledVar, squares[i], ctScaledVar); err != nil {
+			return nil, err
+		}
+	}
+	ctInv, err := invSqrtCiphertext(publicKeys, ctScaledVar, invSqrt)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. R*(x - mean)*1/sqrt(variance) + B
+	newCiphertexts := make([]*rlwe.Ciphertext, d)
+	g, gctx = utils.WithContext(ctx)
+	for i := 0; i < d; i++ {
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
+			evaluator := publicKeys.Evaluator.ShallowCopy()
+			ctTmp, err := evaluator.MulNew(centered[i], layerNormR[i]*math.Sqrt(float64(d*invSqrtInputScale)))
+			if err != nil {
+				return err
+			}
+			if err := encryption.Rescale(evaluator, ctTmp); err != nil {
+				return err
+			}
+			if err := evaluator.MulRelin(ctTmp, ctInv, ctTmp); err != nil {
+				return err
+			}
+			if err := encryption.Rescale(evaluator, ctTmp); err != nil {
+				return err
+			}
+			if err := evaluator.Add(ctTmp, layerNormB[i], ctTmp); err != nil {
+				return err
+			}
+			newCiphertexts[i] = ctTmp
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &encryption.CiphertextTensor{
+		Ciphertexts: newCiphertexts,
+		NumRows:     ciphertextTensor.NumRows,
+		NumCols:     ciphertextTensor.NumCols,
+		NumDepth:    ciphertextTensor.NumDepth,
+	}, nil
+}
+
+// invSqrtCiphertext 由 ctScaledVar = v/invSqrtInputScale 计算 invSqrt.Eval(v)：多项式初始值和牛顿迭代
+// y <- (y/2)(3 - (v*y)*y)，每次迭代 3 层
+func invSqrtCiphertext(publicKeys *encryption.PublicParametersKeys, ctScaledVar *rlwe.Ciphertext, invSqrt utils.InvSqrt) (*rlwe.Ciphertext, error) {
+	evaluator := publicKeys.Evaluator
+	coeffs := make([]float64, len(invSqrt.Coeffs))
+	power := 1.0
+	for k, c := range invSqrt.Coeffs {
+		coeffs[k] = c * power
+		power *= invSqrtInputScale
+	}
+	// 单项式基不使用定义域
+	poly := bignum.NewPolynomial(bignum.Basis(0), coeffs, [2]float64{0, 1})
+	polyEval := hefloat.NewPolynomialEvaluator(*publicKeys.Params, evaluator)
+	y, err := polyEval.Evaluate(ctScaledVar, poly, publicKeys.Params.DefaultScale())
+	if err != nil {
+		return nil, err
+	}
+	if invSqrt.NewtonIterations == 0 {
+		return y, nil
+	}
+
+	ctVar, err := evaluator.MulNew(ctScaledVar, invSqrtInputScale)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < invSqrt.NewtonIterations; i++ {
+		halfY, err := evaluator.MulNew(y, 0.5)
+		if err != nil {
+			return nil, err
+		}
+		if err := encryption.Rescale(evaluator, halfY); err != nil {
+			return nil, err
+		}
+		t, err := evaluator.MulRelinNew(ctVar, y)
+		if err != nil {
+			return nil, err
+		}
+		if err := encryption.Rescale(evaluator, t); err != nil {
+			return nil, err
+		}
+		if err := evaluator.MulRelin(t, y, t); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rescale(evaluator, t); err != nil {
+			return nil, err
+		}
+		// 3 - v*y^2，乘 -1 不消耗层数
+		if err := evaluator.Mul(t, -1, t); err != nil {
+			return nil, err
+		}
+		if err := evaluator.Add(t, 3, t); err != nil {
+			return nil, err
+		}
+		if y, err = evaluator.MulRelinNew(halfY, t); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rescale(evaluator, y); err != nil {
+			return nil, err
+		}
+	}
+	return y, nil
+}
@@ -0,0 +1,96 @@
+package maths
+
+import (
+	"context"
+	"dashformer/config"
+	"dashformer/encryption"
+	"dashformer/utils"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+func TestLayerNormEncrypted(t *testing.T) {
+	ctx := context.Background()
+	invSqrt := utils.InvSqrt{Coeffs: config.DefaultCoefficients().SqrtLayer1, NewtonIterations: 1}
+	levels := LayerNormLevels(invSqrt)
+	if levels != 9 {
+		t.Fatalf("an encrypted layernorm with a degree 5 polynomial and 1 iteration takes %d levels, want 9", levels)
+	}
+	logQ := []int{55}
+	for i := 0; i < levels; i++ {
+		logQ = append(logQ, 40)
+	}
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            11,
+		LogQ:            logQ,
+		LogP:            []int{61},
+		LogDefaultScale: 40,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.GenHERealKeys(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKeys := encryption.NewPublicParametersKeys(params, keys.Pk, keys.Evk)
+	secretKeys := encryption.NewSecretParametersKeys(params, keys.Sk)
+
+	// 每个位置的特征的方差大致在多项式的定义域 [15, 100] 内
+	const d = 8
+	r := rand.New(rand.NewSource(3))
+	values := make([][][]float64, 2)
+	for i := range values {
+		values[i] = make([][]float64, 5)
+		for j := range values[i] {
+			a := 7 + 10*r.Float64()
+			values[i][j] = utils.RandomVector(r, d, a)
+		}
+	}
+	R, B := utils.RandomVector(r, d, 1), utils.RandomVector(r, d, 0.1)
+
+	X, err := encryption.EncryptTensorValue(publicKeys, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := CiphertextTensorLayerNormMultiThread(ctx, publicKeys, X, R, B, nil, invSqrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := minLevel(X) - minLevel(out); got != levels {
+		t.Errorf("the layernorm consumed %d levels, planned %d", got, levels)
+	}
+	got, err := encryption.DecryptTensorValue(secretKeys, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		for j, x := range values[i] {
+			mean, variance := 0.0, 0.0
+			for _, v := range x {
+				mean += v / d
+			}
+			for _, v := range x {
+				variance += (v - mean) * (v - mean) / d
+			}
+			inv := invSqrt.Eval(variance)
+			if math.Abs(inv*math.Sqrt(variance)-1) > 1e-2 {
+				t.Fatalf("position (%d,%d): variance %g outside the domain of the polynomial", i, j, variance)
+			}
+			for k, v := range x {
+				if want := (v-mean)*inv*R[k] + B[k]; math.Abs(got[i][j][k]-want) > 1e-4 {
+					t.Errorf("position (%d,%d) feature %d: got %g, want %g", i, j, k, got[i][j][k], want)
+				}
+			}
+		}
+	}
+
+	// 层数不够时在计算前报错
+	if _, err := CiphertextTensorLayerNormMultiThread(ctx, publicKeys, out, R, B, nil, invSqrt); !errors.Is(err, encryption.ErrLevelExhausted) {
+		t.Errorf("got %v, want a level error", err)
+	}
+}
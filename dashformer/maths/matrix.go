@@ -1,6 +1,7 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
@@ -17,7 +18,7 @@ import (
  * Compute:ctTensor(a,b,c) X ptMatrix(c,d) --> ctTensorNew(a,b,d)
  * 1CMul
  */
-func CiphertextTensorMultiplyPlaintextMatrix(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyPlaintextMatrix(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
@@ -32,12 +33,15 @@ func CiphertextTensorMultiplyPlaintextMatrix(publicKeys *encryption.PublicParame
 
 	// 实际上，密文的depths必须等于明文的rows，才能继续进行运算；而明文的cols则是运算之后的depths
 	if cipherDepth != plainRows {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", plainRows, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply plaintext matrix", Want: []int{plainRows}, Got: []int{cipherDepth}}
 	}
 
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, plainCols)
 	for i := 0; i < plainCols; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ct := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 		// ct.Scale = ciphertextTensor.Ciphertexts[0].Scale
 		for j := 0; j < plainRows; j++ {
@@ -51,12 +55,12 @@ func CiphertextTensorMultiplyPlaintextMatrix(publicKeys *encryption.PublicParame
 			ciphertextTensor.Ciphertexts[j].Scale = publicKeys.Params.DefaultScale()
 			err := publicKeys.Evaluator.MulThenAdd(ciphertextTensor.Ciphertexts[j], ptMulNumSlice, ct)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 		}
 
-		if err := publicKeys.Evaluator.Rescale(ct, ct); err != nil {
-			panic(err)
+		if err := encryption.Rescale(publicKeys.Evaluator, ct); err != nil {
+			return nil, err
 		}
 		newCiphertexts[i] = ct
 	}
@@ -76,7 +80,7 @@ func CiphertextTensorMultiplyPlaintextMatrix(publicKeys *encryption.PublicParame
  * Output: CiphertextTensor,error
  * Compute:ctTensor(a,b,c) + ptMatrix(b,c) --> ctTensorNew(a,b,c)
  */
-func CiphertextTensorAddPlaintextMatrix(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorAddPlaintextMatrix(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
 	cipherCols := ciphertextTensor.NumCols
@@ -90,7 +94,7 @@ func CiphertextTensorAddPlaintextMatrix(publicKeys *encryption.PublicParametersK
 
 	// 实际上，cipherCols必须等于plainRows; cipherDepth必须等于plainCols
 	if cipherCols != plainRows || cipherDepth != plainCols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot Add plaintext matrix: expected  (%d,%d), got (%d,%d)", plainRows, plainCols, cipherCols, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "add plaintext matrix", Want: []int{plainRows, plainCols}, Got: []int{cipherCols, cipherDepth}}
 	}
 
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherDepth)
@@ -104,7 +108,7 @@ func CiphertextTensorAddPlaintextMatrix(publicKeys *encryption.PublicParametersK
 		}
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ciphertextTensor.Ciphertexts[i], plainVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 	}
@@ -124,7 +128,7 @@ func CiphertextTensorAddPlaintextMatrix(publicKeys *encryption.PublicParametersK
  * Compute:ctTensor(a,b,c) X ptWeight(c,d) + ptBias(d) --> ctTensorNew(a,b,d)
  * 1CMul
  */
-func CiphertextTensorMultiplyWeightAndAddBias(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyWeightAndAddBias(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherRows := ciphertextTensor.NumRows
@@ -142,14 +146,14 @@ func CiphertextTensorMultiplyWeightAndAddBias(publicKeys *encryption.PublicParam
 	// fmt.Printf("Plaintext Bias Vector Length:%d\n", biasLength)
 
 	// 实际上，密文张量的depths必须等于权重矩阵的rows，才能继续进行运算；而权重矩阵的cols则是运算之后的depths需要等于biasLength
-	if cipherDepth != weightRows && weightCols != biasLength {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", weightRows, cipherDepth)
+	if cipherDepth != weightRows || weightCols != biasLength {
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply weight and add bias", Want: []int{weightRows, weightCols}, Got: []int{cipherDepth, biasLength}}
 	}
 
 	// Step 1.密文Tensor × 明文权重矩阵
-	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrix(publicKeys, ciphertextTensor, ptWeight)
+	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrix(ctx, publicKeys, ciphertextTensor, ptWeight)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Step 2. +偏置向量
@@ -157,7 +161,7 @@ func CiphertextTensorMultiplyWeightAndAddBias(publicKeys *encryption.PublicParam
 	for i := 0; i < biasLength; i++ {
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ciphertextTensorMulWeight.Ciphertexts[i], ptBias[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -181,7 +185,7 @@ func CiphertextTensorMultiplyWeightAndAddBias(publicKeys *encryption.PublicParam
 
 - 1CMul
 */
-func CiphertextTensorMultiplyClassificationAndPooling(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyClassificationAndPooling(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherRows := ciphertextTensor.NumRows
@@ -199,14 +203,14 @@ func CiphertextTensorMultiplyClassificationAndPooling(publicKeys *encryption.Pub
 	// fmt.Printf("Plaintext Bias Vector Length:%d\n", biasLength)
 
 	// 实际上，密文张量的depths必须等于权重矩阵的rows，才能继续进行运算；而权重矩阵的cols则是运算之后的depths需要等于biasLength
-	if cipherDepth != weightRows && weightCols != biasLength {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", weightRows, cipherDepth)
+	if cipherDepth != weightRows || weightCols != biasLength {
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "classification and pooling", Want: []int{weightRows, weightCols}, Got: []int{cipherDepth, biasLength}}
 	}
 
 	// Step 1. 密文Tensor × 明文权重矩阵
-	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrix(publicKeys, ciphertextTensor, ptWeight)
+	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrix(ctx, publicKeys, ciphertextTensor, ptWeight)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// // 测试解密
@@ -220,7 +224,12 @@ func CiphertextTensorMultiplyClassificationAndPooling(publicKeys *encryption.Pub
 	// Step 2. 进行pooling
 	// fmt.Println(ciphertextTensorMulWeight.NumCols)
 	for i := 0; i < ciphertextTensorMulWeight.NumDepth; i++ {
-		publicKeys.Evaluator.InnerSum(ciphertextTensorMulWeight.Ciphertexts[i], 1, ciphertextTensorMulWeight.NumCols, ciphertextTensorMulWeight.Ciphertexts[i])
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := encryption.InnerSum(publicKeys.Evaluator, ciphertextTensorMulWeight.Ciphertexts[i], 1, ciphertextTensorMulWeight.NumCols, ciphertextTensorMulWeight.Ciphertexts[i]); err != nil {
+			return nil, err
+		}
 	}
 
 	// // 测试解密
@@ -236,7 +245,7 @@ func CiphertextTensorMultiplyClassificationAndPooling(publicKeys *encryption.Pub
 	for i := 0; i < biasLength; i++ {
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ciphertextTensorMulWeight.Ciphertexts[i], ptBias[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -256,7 +265,7 @@ func CiphertextTensorMultiplyClassificationAndPooling(publicKeys *encryption.Pub
  * Compute:ctTensor(a,b,c) X [ctTensor(a,b,c)^T --> ctTensorT(a,c,b)]--> ctTensorNew(a,b,b)
  * 1CMul+1Mul
  */
-func CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor, baseSize float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(ctx context.Context, publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor, baseSize float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	cipherTensor1Rows := cipherTensor1.NumRows
@@ -272,17 +281,20 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(publicKeys *encryptio
 
 	// 判断条件
 	if cipherTensor1Cols != cipherTensor2Cols || cipherTensor1Rows != cipherTensor2Rows || cipherTensor1Depth != cipherTensor2Depth {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not multiply ctTensor1 and ctTensor2 transpose to Halevi-Shoup encodeing")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply to Halevi-Shoup encoding", Want: []int{cipherTensor1Rows, cipherTensor1Cols, cipherTensor1Depth}, Got: []int{cipherTensor2Rows, cipherTensor2Cols, cipherTensor2Depth}}
 	}
 
 	// 进行密文乘法
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensor1Cols)
 	for i := 0; i < cipherTensor1Cols; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ct := hefloat.NewCiphertext(*publicKeys.Params, cipherTensor1.Ciphertexts[0].Degree(), cipherTensor1.Ciphertexts[0].Level())
 		// 进行旋转
-		rotCiperTensor2, err := CiphertextTensorRotationByColsNew(publicKeys, cipherTensor2, i, baseSize)
+		rotCiperTensor2, err := CiphertextTensorRotationByColsNew(ctx, publicKeys, cipherTensor2, i, baseSize)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		if rotCiperTensor2.NumDepth != cipherTensor1.NumDepth {
@@ -297,12 +309,12 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(publicKeys *encryptio
 			// fmt.Println(cipherTensor1.Ciphertexts[j])
 			err = publicKeys.Evaluator.MulRelinThenAdd(rotCiperTensor2.Ciphertexts[j], cipherTensor1.Ciphertexts[j], ct)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 		}
 		// 进行rescale
-		if err := publicKeys.Evaluator.Rescale(ct, ct); err != nil {
-			panic(err)
+		if err := encryption.Rescale(publicKeys.Evaluator, ct); err != nil {
+			return nil, err
 		}
 		newCiphertexts[i] = ct
 	}
@@ -324,7 +336,7 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(publicKeys *encryptio
  	* ctTensor2 encoding by cols
  * 1CMul+1Mul
 */
-func CiphertextTensorHSMultiplyCiphertextTensor(publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorHSMultiplyCiphertextTensor(ctx context.Context, publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	// cipherTensor1Rows := cipherTensor1.NumRows
@@ -340,7 +352,7 @@ func CiphertextTensorHSMultiplyCiphertextTensor(publicKeys *encryption.PublicPar
 
 	// 判断条件H-S一定是一个方阵，tensor1.Depth等于tensor2.cols
 	if cipherTensor1Cols != cipherTensor1Depth || cipherTensor1Depth != cipherTensor2Cols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not multiply ctTensor1HS and ctTensor2 to columns encodeing")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply Halevi-Shoup encoding", Want: []int{cipherTensor1Cols, cipherTensor1Cols}, Got: []int{cipherTensor1Depth, cipherTensor2Cols}}
 	}
 
 	// 声明cipherTensor2Depth条密文
@@ -351,10 +363,13 @@ func CiphertextTensorHSMultiplyCiphertextTensor(publicKeys *encryption.PublicPar
 
 	// 进行密文乘法
 	for i := 0; i < cipherTensor2Cols; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		// 进行旋转
-		rotCiperTensor2, err := CiphertextTensorRotationByColsNew(publicKeys, cipherTensor2, i, 1)
+		rotCiperTensor2, err := CiphertextTensorRotationByColsNew(ctx, publicKeys, cipherTensor2, i, 1)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		for j := 0; j < cipherTensor2Depth; j++ {
@@ -364,16 +379,23 @@ func CiphertextTensorHSMultiplyCiphertextTensor(publicKeys *encryption.PublicPar
 			// fmt.Println(cipherTensor1.Ciphertexts[j])
 			ct, err := publicKeys.Evaluator.MulRelinNew(rotCiperTensor2.Ciphertexts[j], cipherTensor1.Ciphertexts[i])
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 
-			publicKeys.Evaluator.Add(newCiphertexts[j], ct, newCiphertexts[j])
+			if err := publicKeys.Evaluator.Add(newCiphertexts[j], ct, newCiphertexts[j]); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	// 进行rescale
 	for j := 0; j < cipherTensor2Depth; j++ {
-		publicKeys.Evaluator.Rescale(newCiphertexts[j], newCiphertexts[j])
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rescale(publicKeys.Evaluator, newCiphertexts[j]); err != nil {
+			return nil, err
+		}
 	}
 	// 返回结果
 	return &encryption.CiphertextTensor{
@@ -395,7 +417,7 @@ func CiphertextTensorHSMultiplyCiphertextTensor(publicKeys *encryption.PublicPar
  * |0 0 0|       |0 0 0|
  * 1CMul
  */
-func CiphertextTensorRotationByColsNew(publicKeys *encryption.PublicParametersKeys, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorRotationByColsNew(ctx context.Context, publicKeys *encryption.PublicParametersKeys, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensor.NumRows
@@ -429,25 +451,32 @@ func CiphertextTensorRotationByColsNew(publicKeys *encryption.PublicParametersKe
 	Slots := publicKeys.Params.MaxSlots()
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctLeft, err := publicKeys.Evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotLeftVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ctRight, err := publicKeys.Evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotRightVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// fmt.Printf("i-th:%d, rotLeft:%d, rotRight:%d\n", i, rotNumber, (rotNumber-cipherTensorCols+Slots)%Slots)
-		publicKeys.Evaluator.Rotate(ctLeft, rotNumber, ctLeft)
-		publicKeys.Evaluator.Rotate(ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight)
+		if err := encryption.Rotate(publicKeys.Evaluator, ctLeft, rotNumber, ctLeft); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rotate(publicKeys.Evaluator, ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight); err != nil {
+			return nil, err
+		}
 
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ctLeft, ctRight)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		if err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i]); err != nil {
-			panic(err)
+		if err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i]); err != nil {
+			return nil, err
 		}
 	}
 
@@ -465,42 +494,51 @@ func CiphertextTensorRotationByColsNew(publicKeys *encryption.PublicParametersKe
  * Input:  PublicParametersKeys, ctTensor *CiphertextTensor
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  */
-func CiphertextTensorReturnAvgAndVar(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor) (*rlwe.Ciphertext, *rlwe.Ciphertext, error) {
+func CiphertextTensorReturnAvgAndVar(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor) (*rlwe.Ciphertext, *rlwe.Ciphertext, error) {
 
 	ct := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
 		err := publicKeys.Evaluator.Add(ct, ciphertextTensor.Ciphertexts[i], ct)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
 	}
 
 	ctAvg, err := publicKeys.Evaluator.MulRelinNew(ct, float64(1/float64(ciphertextTensor.NumDepth)))
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	publicKeys.Evaluator.Rescale(ctAvg, ctAvg)
+	if err := encryption.Rescale(publicKeys.Evaluator, ctAvg); err != nil {
+		return nil, nil, err
+	}
 
 	ctSqure := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		ctSub, err := publicKeys.Evaluator.SubNew(ciphertextTensor.Ciphertexts[i], ctAvg)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
 
 		err = publicKeys.Evaluator.MulRelinThenAdd(ctSub, ctSub, ctSqure)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
 	}
-	publicKeys.Evaluator.Rescale(ctSqure, ctSqure)
+	if err := encryption.Rescale(publicKeys.Evaluator, ctSqure); err != nil {
+		return nil, nil, err
+	}
 
 	ctVar, err := publicKeys.Evaluator.MulRelinNew(ctSqure, float64(1/float64(ciphertextTensor.NumDepth)))
 	if err != nil {
-		panic(err)
+		return nil, nil, err
+	}
+	if err := encryption.Rescale(publicKeys.Evaluator, ctVar); err != nil {
+		return nil, nil, err
 	}
-	publicKeys.Evaluator.Rescale(ctVar, ctVar)
 
 	// fmt.Println(ctVar.Scale)
 	// fmt.Println(ctAvg.Scale)
@@ -513,50 +551,55 @@ func CiphertextTensorReturnAvgAndVar(publicKeys *encryption.PublicParametersKeys
  * Input:  PublicParametersKeys, ctTensor *CiphertextTensor, layerNormR []float64, layerNormB []float64
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  */
-func CiphertextTensorLayerNorm(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, coeff []float64, domain [2]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorLayerNorm(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, coeff []float64, domain [2]float64) (*encryption.CiphertextTensor, error) {
 
-	ctAvg, ctVar, err := CiphertextTensorReturnAvgAndVar(publicKeys, ciphertextTensor)
+	ctAvg, ctVar, err := CiphertextTensorReturnAvgAndVar(ctx, publicKeys, ciphertextTensor)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if ciphertextTensor.NumDepth != len(layerNormR) || len(layerNormB) != len(layerNormR) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not compute layernorm")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "layernorm", Want: []int{ciphertextTensor.NumDepth, ciphertextTensor.NumDepth}, Got: []int{len(layerNormR), len(layerNormB)}}
 	}
 
 	// 计算1/sqrt(ctVar)
-	ctDownSqrtVar, err := ApproximatePolynomial(publicKeys, ctVar, coeff, domain)
+	ctDownSqrtVar, err := ApproximatePolynomial(ctx, publicKeys, ctVar, coeff, domain)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// 计算layerNorm
 	newCiphertexts := make([]*rlwe.Ciphertext, ciphertextTensor.NumDepth)
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		newCiphertexts[i], err = publicKeys.Evaluator.SubNew(ciphertextTensor.Ciphertexts[i], ctAvg)
 		if err != nil {
-			panic(err)
+			return nil, err
+		}
+		if err := publicKeys.Evaluator.Mul(newCiphertexts[i], layerNormR[i], newCiphertexts[i]); err != nil {
+			return nil, err
 		}
-		publicKeys.Evaluator.Mul(newCiphertexts[i], layerNormR[i], newCiphertexts[i])
-		err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i])
+		err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 乘1/sqrt(ctVar)
 		err = publicKeys.Evaluator.MulRelin(newCiphertexts[i], ctDownSqrtVar, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i])
+		err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 加B
 		err = publicKeys.Evaluator.Add(newCiphertexts[i], layerNormB[i], newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -573,77 +616,87 @@ func CiphertextTensorLayerNorm(publicKeys *encryption.PublicParametersKeys, ciph
  * Input:  PublicParametersKeys, ctTensor *CiphertextTensor, layerNormR []float64, layerNormB []float64
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  */
-func CiphertextTensorLayerNormReduceMul(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, coeff []float64, domain [2]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorLayerNormReduceMul(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, coeff []float64, domain [2]float64) (*encryption.CiphertextTensor, error) {
 
 	// compute sum
 	ctSum := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
 		err := publicKeys.Evaluator.Add(ctSum, ciphertextTensor.Ciphertexts[i], ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
 	ctVar := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctNX, err := publicKeys.Evaluator.MulNew(ciphertextTensor.Ciphertexts[i], ciphertextTensor.NumDepth)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ctSub, err := publicKeys.Evaluator.SubNew(ctNX, ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		err = publicKeys.Evaluator.MulRelinThenAdd(ctSub, ctSub, ctVar)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
-	publicKeys.Evaluator.Rescale(ctVar, ctVar)
+	if err := encryption.Rescale(publicKeys.Evaluator, ctVar); err != nil {
+		return nil, err
+	}
 
 	if ciphertextTensor.NumDepth != len(layerNormR) || len(layerNormB) != len(layerNormR) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not compute layernorm")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "layernorm", Want: []int{ciphertextTensor.NumDepth, ciphertextTensor.NumDepth}, Got: []int{len(layerNormR), len(layerNormB)}}
 	}
 
 	// 计算1/sqrt(ctVar)
-	ctDownSqrtVar, err := ApproximatePolynomial(publicKeys, ctVar, coeff, domain)
+	ctDownSqrtVar, err := ApproximatePolynomial(ctx, publicKeys, ctVar, coeff, domain)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// 计算layerNorm
 	newCiphertexts := make([]*rlwe.Ciphertext, ciphertextTensor.NumDepth)
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctNX, err := publicKeys.Evaluator.MulNew(ciphertextTensor.Ciphertexts[i], ciphertextTensor.NumDepth)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		newCiphertexts[i], err = publicKeys.Evaluator.SubNew(ctNX, ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
-		publicKeys.Evaluator.Mul(newCiphertexts[i], layerNormR[i]*math.Sqrt(float64(ciphertextTensor.NumDepth)), newCiphertexts[i])
-		err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i])
+		if err := publicKeys.Evaluator.Mul(newCiphertexts[i], layerNormR[i]*math.Sqrt(float64(ciphertextTensor.NumDepth)), newCiphertexts[i]); err != nil {
+			return nil, err
+		}
+		err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 乘1/sqrt(ctVar)
 		err = publicKeys.Evaluator.MulRelin(newCiphertexts[i], ctDownSqrtVar, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i])
+		err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 加B
 		err = publicKeys.Evaluator.Add(newCiphertexts[i], layerNormB[i], newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -661,19 +714,19 @@ func CiphertextTensorLayerNormReduceMul(publicKeys *encryption.PublicParametersK
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  *
  */
-func CiphertextTensorLayerNormReplaceVariance(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorLayerNormReplaceVariance(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64) (*encryption.CiphertextTensor, error) {
 
 	// compute sum
 	ctSum := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
 		err := publicKeys.Evaluator.Add(ctSum, ciphertextTensor.Ciphertexts[i], ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
 	if ciphertextTensor.NumDepth != len(layerNormR) || len(layerNormB) != len(layerNormR) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not compute layernorm")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "layernorm", Want: []int{ciphertextTensor.NumDepth, ciphertextTensor.NumDepth}, Got: []int{len(layerNormR), len(layerNormB)}}
 	}
 
 	// 计算1/sqrt(ctVar)-->在此函数中，直接用明文varVector,因此这里直接跳过
@@ -681,29 +734,34 @@ func CiphertextTensorLayerNormReplaceVariance(publicKeys *encryption.PublicParam
 	// 计算layerNorm，用明文varVector代替之后，先用r*varVector/n，再与密文相乘
 	newCiphertexts := make([]*rlwe.Ciphertext, ciphertextTensor.NumDepth)
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctNX, err := publicKeys.Evaluator.MulNew(ciphertextTensor.Ciphertexts[i], ciphertextTensor.NumDepth)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		newCiphertexts[i], err = publicKeys.Evaluator.SubNew(ctNX, ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 对varVector进行放缩，也就是计算r*varVector/n
 		ptVar := utils.ScaleVector(varVector, layerNormR[i]/float64(ciphertextTensor.NumDepth))
 		// fmt.Println(layerNormR[i] / float64(ciphertextTensor.NumDepth))
 		// 进行计算
-		publicKeys.Evaluator.Mul(newCiphertexts[i], ptVar, newCiphertexts[i])
-		err = publicKeys.Evaluator.Rescale(newCiphertexts[i], newCiphertexts[i])
+		if err := publicKeys.Evaluator.Mul(newCiphertexts[i], ptVar, newCiphertexts[i]); err != nil {
+			return nil, err
+		}
+		err = encryption.Rescale(publicKeys.Evaluator, newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// 加B
 		err = publicKeys.Evaluator.Add(newCiphertexts[i], layerNormB[i], newCiphertexts[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
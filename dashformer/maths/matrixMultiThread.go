@@ -1,11 +1,11 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
 	"math"
-	"runtime"
 	"sync"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
@@ -19,7 +19,7 @@ import (
  * Compute:ctTensor(a,b,c) X ptMatrix(c,d) --> ctTensorNew(a,b,d)
  * 1CMul
  */
-func CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyPlaintextMatrixMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
@@ -34,21 +34,20 @@ func CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys *encryption.P
 
 	// 实际上，密文的depths必须等于明文的rows，才能继续进行运算；而明文的cols则是运算之后的depths
 	if cipherDepth != plainRows {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", plainRows, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply plaintext matrix", Want: []int{plainRows}, Got: []int{cipherDepth}}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	var mu sync.Mutex // 用于保护 newCiphertexts 的并发写入
 
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, plainCols)
 	for i := 0; i < plainCols; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			// 复制evaluator
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 复制cipherTensor
@@ -57,6 +56,9 @@ func CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys *encryption.P
 			ct := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 			// ct.Scale = ciphertextTensor.Ciphertexts[0].Scale
 			for j := 0; j < plainRows; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 创建一个明文向量
 				ptMulNumSlice := make([]float64, cipherRows*cipherCols)
 				for k := range ptMulNumSlice {
@@ -67,22 +69,25 @@ func CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys *encryption.P
 				// ciphertextTensor.Ciphertexts[j].Scale = publicKeys.Params.DefaultScale()
 				err := evaluator.MulThenAdd(ciphertextTensor.Ciphertexts[j], ptMulNumSlice, ct)
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
 
-			if err := evaluator.Rescale(ct, ct); err != nil {
-				panic(err)
+			if err := encryption.Rescale(evaluator, ct); err != nil {
+				return err
 			}
 			// 并发安全地写入 newCiphertexts
 			mu.Lock()
 			newCiphertexts[i] = ct
 			mu.Unlock()
-		}(i)
+			return nil
+		})
 
 	}
 	// 等待所有 goroutine 完成
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	// 返回结果
 	return &encryption.CiphertextTensor{
@@ -99,7 +104,7 @@ func CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys *encryption.P
  * Output: CiphertextTensor,error
  * Compute:ctTensor(a,b,c) + ptMatrix(b,c) --> ctTensorNew(a,b,c)
  */
-func CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorAddPlaintextMatrixMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
 	cipherCols := ciphertextTensor.NumCols
@@ -113,21 +118,19 @@ func CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys *encryption.Public
 
 	// 实际上，cipherCols必须等于plainRows; cipherDepth必须等于plainCols
 	if cipherCols != plainRows || cipherDepth != plainCols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot Add plaintext matrix: expected  (%d,%d), got (%d,%d)", plainRows, plainCols, cipherCols, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "add plaintext matrix", Want: []int{plainRows, plainCols}, Got: []int{cipherCols, cipherDepth}}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// var mu sync.Mutex // 用于保护 newCiphertexts 的并发写入
 
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherDepth)
-	var err error
 	for i := 0; i < cipherDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			// 复制evaluator
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
@@ -139,14 +142,18 @@ func CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys *encryption.Public
 				}
 			}
 
-			newCiphertexts[i], err = evaluator.AddNew(ciphertextTensor.Ciphertexts[i], plainVector)
+			ct, err := evaluator.AddNew(ciphertextTensor.Ciphertexts[i], plainVector)
 			if err != nil {
-				panic(err)
+				return err
 			}
-		}(i)
+			newCiphertexts[i] = ct
+			return nil
+		})
 	}
 	// 等待所有 goroutine 完成
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -163,7 +170,7 @@ func CiphertextTensorAddPlaintextMatrixMultiThread(publicKeys *encryption.Public
  * Compute:ctTensor(a,b,c) X ptWeight(c,d) + ptBias(d) --> ctTensorNew(a,b,d)
  * 1CMul
  */
-func CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyWeightAndAddBiasMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherRows := ciphertextTensor.NumRows
@@ -181,14 +188,14 @@ func CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys *encryption.
 	// fmt.Printf("Plaintext Bias Vector Length:%d\n", biasLength)
 
 	// 实际上，密文张量的depths必须等于权重矩阵的rows，才能继续进行运算；而权重矩阵的cols则是运算之后的depths需要等于biasLength
-	if cipherDepth != weightRows && weightCols != biasLength {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", weightRows, cipherDepth)
+	if cipherDepth != weightRows || weightCols != biasLength {
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply weight and add bias", Want: []int{weightRows, weightCols}, Got: []int{cipherDepth, biasLength}}
 	}
 
 	// Step 1.密文Tensor × 明文权重矩阵
-	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys, ciphertextTensor, ptWeight)
+	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrixMultiThread(ctx, publicKeys, ciphertextTensor, ptWeight)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// Step 2. +偏置向量
@@ -197,7 +204,7 @@ func CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys *encryption.
 		// ciphertextTensorMulWeight.Ciphertexts[i].Scale = publicKeys.Params.DefaultScale()
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ciphertextTensorMulWeight.Ciphertexts[i], ptBias[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -221,7 +228,7 @@ func CiphertextTensorMultiplyWeightAndAddBiasMultiThread(publicKeys *encryption.
 
 - 1CMul
 */
-func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptWeight [][]float64, ptBias []float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherRows := ciphertextTensor.NumRows
@@ -244,14 +251,14 @@ func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys *enc
 	// fmt.Printf("Plaintext Bias Vector Length:%d\n", biasLength)
 
 	// 实际上，密文张量的depths必须等于权重矩阵的rows，才能继续进行运算；而权重矩阵的cols则是运算之后的depths需要等于biasLength
-	if cipherDepth != weightRows && weightCols != biasLength {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", weightRows, cipherDepth)
+	if cipherDepth != weightRows || weightCols != biasLength {
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "classification and pooling", Want: []int{weightRows, weightCols}, Got: []int{cipherDepth, biasLength}}
 	}
 
 	// Step 1. 密文Tensor × 明文权重矩阵
-	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrixMultiThread(publicKeys, ciphertextTensor, ptWeight)
+	ciphertextTensorMulWeight, err := CiphertextTensorMultiplyPlaintextMatrixMultiThread(ctx, publicKeys, ciphertextTensor, ptWeight)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// // 测试解密
@@ -264,19 +271,23 @@ func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys *enc
 
 	// Step 2. 进行pooling
 	// fmt.Println(ciphertextTensorMulWeight.NumCols)
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	for i := 0; i < ciphertextTensorMulWeight.NumDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
-			evaluator.InnerSum(ciphertextTensorMulWeight.Ciphertexts[i], 1, ciphertextTensorMulWeight.NumCols, ciphertextTensorMulWeight.Ciphertexts[i])
-		}(i)
+			if err := encryption.InnerSum(evaluator, ciphertextTensorMulWeight.Ciphertexts[i], 1, ciphertextTensorMulWeight.NumCols, ciphertextTensorMulWeight.Ciphertexts[i]); err != nil {
+				return err
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	// // 测试解密
 	// valueTensor, err = encryption.DecryptTensorValue(secretKeys, ciphertextTensorMulWeight)
@@ -292,7 +303,7 @@ func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys *enc
 		ciphertextTensor.Ciphertexts[i].Scale = publicKeys.Params.DefaultScale()
 		newCiphertexts[i], err = publicKeys.Evaluator.AddNew(ciphertextTensorMulWeight.Ciphertexts[i], ptBias[i])
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
@@ -312,7 +323,7 @@ func CiphertextTensorMultiplyClassificationAndPoolingMultiThread(publicKeys *enc
  * Compute:ctTensor(a,b,c) X [ctTensor(a,b,c)^T --> ctTensorT(a,c,b)]--> ctTensorNew(a,b,b)
  * 1CMul+1Mul
  */
-func CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor, baseSize float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor, baseSize float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	cipherTensor1Rows := cipherTensor1.NumRows
@@ -328,27 +339,26 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(publicKeys
 
 	// 判断条件
 	if cipherTensor1Cols != cipherTensor2Cols || cipherTensor1Rows != cipherTensor2Rows || cipherTensor1Depth != cipherTensor2Depth {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not multiply ctTensor1 and ctTensor2 transpose to Halevi-Shoup encodeing")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply to Halevi-Shoup encoding", Want: []int{cipherTensor1Rows, cipherTensor1Cols, cipherTensor1Depth}, Got: []int{cipherTensor2Rows, cipherTensor2Cols, cipherTensor2Depth}}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	var mu sync.Mutex // 用于保护 newCiphertexts 的并发写入
 
 	// 进行密文乘法
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensor1Cols)
 	for i := 0; i < cipherTensor1Cols; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			ct := hefloat.NewCiphertext(*publicKeys.Params, cipherTensor1.Ciphertexts[0].Degree(), cipherTensor1.Ciphertexts[0].Level())
 			// 进行旋转
-			rotCiperTensor2, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, cipherTensor2, i, baseSize, publicKeys.Params.MaxSlots())
+			rotCiperTensor2, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, cipherTensor2, i, baseSize, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			if rotCiperTensor2.NumDepth != cipherTensor1.NumDepth {
@@ -357,25 +367,31 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(publicKeys
 
 			// fmt.Println(i)
 			for j := 0; j < cipherTensor1Depth; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// fmt.Printf("stop here %d\n", j)
 				// 进行乘法
 				// fmt.Println(rotCiperTensor2.Ciphertexts[j])
 				// fmt.Println(cipherTensor1.Ciphertexts[j])
 				err = evaluator.MulRelinThenAdd(rotCiperTensor2.Ciphertexts[j], cipherTensor1.Ciphertexts[j], ct)
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
 			// 进行rescale
-			if err := evaluator.Rescale(ct, ct); err != nil {
-				panic(err)
+			if err := encryption.Rescale(evaluator, ct); err != nil {
+				return err
 			}
 			mu.Lock()
 			newCiphertexts[i] = ct
 			mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	// 返回结果
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -394,7 +410,7 @@ func CiphertextTensorMultiplyCiphertextTensorToHalveiShoupMultiThread(publicKeys
  	* ctTensor2 encoding by cols
  * 1CMul+1Mul
 */
-func CiphertextTensorHSMultiplyCiphertextTensorMultiThread(publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorHSMultiplyCiphertextTensorMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	// cipherTensor1Rows := cipherTensor1.NumRows
@@ -410,7 +426,7 @@ func CiphertextTensorHSMultiplyCiphertextTensorMultiThread(publicKeys *encryptio
 
 	// 判断条件H-S一定是一个方阵，tensor1.Depth等于tensor2.cols
 	if cipherTensor1Cols != cipherTensor1Depth || cipherTensor1Depth != cipherTensor2Cols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not multiply ctTensor1HS and ctTensor2 to columns encodeing")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply Halevi-Shoup encoding", Want: []int{cipherTensor1Cols, cipherTensor1Cols}, Got: []int{cipherTensor1Depth, cipherTensor2Cols}}
 	}
 
 	// 声明cipherTensor2Depth条密文
@@ -419,47 +435,54 @@ func CiphertextTensorHSMultiplyCiphertextTensorMultiThread(publicKeys *encryptio
 		newCiphertexts[j] = hefloat.NewCiphertext(*publicKeys.Params, cipherTensor1.Ciphertexts[0].Degree(), cipherTensor1.Ciphertexts[0].Level())
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	var mu sync.Mutex
 
 	// 进行密文乘法
 	for i := 0; i < cipherTensor2Cols; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 进行旋转
-			rotCiperTensor2, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, cipherTensor2, i, 1, publicKeys.Params.MaxSlots())
+			rotCiperTensor2, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, cipherTensor2, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			localResults := make([]*rlwe.Ciphertext, cipherTensor2Depth)
 
 			for j := 0; j < cipherTensor2Depth; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 进行乘法
 				localResults[j], err = evaluator.MulRelinNew(rotCiperTensor2.Ciphertexts[j], cipherTensor1.Ciphertexts[i])
 				if err != nil {
-					// 处理错误
-					fmt.Printf("Error in multiplication: %v\n", err)
-					return
+					return err
+				}
+				if err := encryption.Rescale(evaluator, localResults[j]); err != nil {
+					return err
 				}
-				evaluator.Rescale(localResults[j], localResults[j])
 			}
 
 			// 合并局部结果
 			mu.Lock()
 			defer mu.Unlock()
 			for j := 0; j < cipherTensor2Depth; j++ {
-				evaluator.Add(newCiphertexts[j], localResults[j], newCiphertexts[j])
+				if err := evaluator.Add(newCiphertexts[j], localResults[j], newCiphertexts[j]); err != nil {
+					return err
+				}
 			}
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	// // 进行rescale
 	// for j := 0; j < cipherTensor2Depth; j++ {
@@ -481,7 +504,7 @@ func CiphertextTensorHSMultiplyCiphertextTensorMultiThread(publicKeys *encryptio
  * Compute: Q,K,V --> Attention result
  * 1CMul+1Mul
  */
-func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.PublicParametersKeys, Q, K, V *encryption.CiphertextTensor, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorQKVToAttentionWithBSGSMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, Q, K, V *encryption.CiphertextTensor, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	QCols := Q.NumCols
@@ -497,13 +520,13 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
 	VDepth := V.NumDepth
 	// 判断QKV是否一样
 	if QCols != KCols || QCols != VCols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("NumCols mismatch: Q.NumCols=%d, K.NumCols=%d, V.NumCols=%d", Q.NumCols, K.NumCols, V.NumCols)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "attention NumCols of Q, K, V", Want: []int{QCols, QCols, QCols}, Got: []int{QCols, KCols, VCols}}
 	}
 	if QDepth != KDepth || QDepth != VDepth {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("NumDepth mismatch: Q.NumDepth=%d, K.NumDepth=%d, V.NumDepth=%d", Q.NumDepth, K.NumDepth, V.NumDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "attention NumDepth of Q, K, V", Want: []int{QDepth, QDepth, QDepth}, Got: []int{QDepth, KDepth, VDepth}}
 	}
 	if QRows != KRows || QRows != VRows {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("NumRows mismatch: Q.NumRows=%d, K.NumRows=%d, V.NumRows=%d", Q.NumRows, K.NumRows, V.NumRows)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "attention NumRows of Q, K, V", Want: []int{QRows, QRows, QRows}, Got: []int{QRows, KRows, VRows}}
 	}
 
 	// 声明并初始化用于存储旋转结果的数组
@@ -511,56 +534,64 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
 	var KRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 	var VRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	var mu sync.Mutex
 
 	// 生成旋转所有的步长
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转Q
 			// fmt.Printf("Q:%d,K:%d,V:%d\n", -i*babyStep, i, i)
-			rotQ, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, Q, -i*babyStep, 1, publicKeys.Params.MaxSlots())
+			rotQ, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, Q, -i*babyStep, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			mu.Lock()
 			QRotTensor[i] = rotQ
 			mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
+	g, gctx = utils.WithContext(ctx)
 
 	// 生成旋转所有的步长
 	for i := 0; i < babyStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转K
-			rotK, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, K, i, 1/(math.Sqrt(32)*math.Sqrt(c)), publicKeys.Params.MaxSlots())
+			rotK, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, K, i, 1/(math.Sqrt(float64(KDepth))*math.Sqrt(c)), publicKeys.Params.MaxSlots())
 			// rotK, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, K, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// 旋转V
-			rotV, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, V, i, 1, publicKeys.Params.MaxSlots())
+			rotV, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, V, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			mu.Lock()
 			KRotTensor[i] = rotK
 			VRotTensor[i] = rotV
 			mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
+	g, gctx = utils.WithContext(ctx)
 
 	newCiphertexts := make([]*rlwe.Ciphertext, VDepth)
 	for k := 0; k < VDepth; k++ {
@@ -569,9 +600,10 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
 
 	// 进行BSGS To Attetion
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			localNewCiphertexts := make([]*rlwe.Ciphertext, VDepth)
@@ -585,25 +617,28 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
 				NumDepth:    VDepth,
 			}
 			for j := 0; j < babyStep; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 保证小于cols
 				if (i*babyStep + j) < QCols {
-					diagMatrix, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, QRotTensor[i], KRotTensor[j])
+					diagMatrix, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(gctx, publicKeys.Params, evaluator, QRotTensor[i], KRotTensor[j])
 					if err != nil {
-						panic(err)
+						return err
 					}
-					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(evaluator, diagMatrix, b/math.Sqrt(c), 1)
+					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(gctx, evaluator, diagMatrix, b/math.Sqrt(c), 1)
 					if err != nil {
-						panic(err)
+						return err
 					}
-					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
+					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(gctx, publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
 					if err != nil {
-						panic(err)
+						return err
 					}
 				}
 			}
-			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
+			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// 合并局部结果
@@ -614,12 +649,15 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
 				// QKVRotKi.Ciphertexts[k].Scale = publicKeys.Params.DefaultScale()
 				newCiphertexts[k], err = evaluator.AddNew(QKVRotKi.Ciphertexts[k], newCiphertexts[k])
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	// 返回结果
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -636,23 +674,28 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThread(publicKeys *encryption.Pu
  * Compute: Q,K,V --> Attention result
  * 1CMul+1Mul
  */
-func CiphertextTensorMultiplyCiphertextTensorThenAdd(param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*rlwe.Ciphertext, error) {
+func CiphertextTensorMultiplyCiphertextTensorThenAdd(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor1, cipherTensor2 *encryption.CiphertextTensor) (*rlwe.Ciphertext, error) {
 
 	// 判断QKV是否一样
 	if cipherTensor1.NumDepth != cipherTensor2.NumDepth {
-		return &rlwe.Ciphertext{}, fmt.Errorf("NumCols mismatch: cipherTensor1.NumDepth=%d, cipherTensor2.NumDepth=%d", cipherTensor1.NumDepth, cipherTensor2.NumDepth)
+		return &rlwe.Ciphertext{}, &encryption.ShapeError{Op: "multiply ciphertext tensors then add", Want: []int{cipherTensor1.NumDepth}, Got: []int{cipherTensor2.NumDepth}}
 	}
 
 	ct := rlwe.NewCiphertext(param, cipherTensor1.Ciphertexts[0].Degree(), cipherTensor1.Ciphertexts[0].Level())
 	// 进行BSGS To Attetion
 	for i := 0; i < cipherTensor1.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		err := evaluator.MulRelinThenAdd(cipherTensor1.Ciphertexts[i], cipherTensor2.Ciphertexts[i], ct)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 	}
-	evaluator.Rescale(ct, ct)
+	if err := encryption.Rescale(evaluator, ct); err != nil {
+		return nil, err
+	}
 	// 返回结果
 	return ct, nil
 }
@@ -664,18 +707,25 @@ func CiphertextTensorMultiplyCiphertextTensorThenAdd(param *hefloat.Parameters,
  * Compute: Q,K,V --> Attention result
  * 1CMul+1Mul
  */
-func CiphertextTensorMultiplyCiphertextTensorAddToRes(param *hefloat.Parameters, evaluator *hefloat.Evaluator, ct1 *rlwe.Ciphertext, cipherTensor2 *encryption.CiphertextTensor, res *encryption.CiphertextTensor) error {
+func CiphertextTensorMultiplyCiphertextTensorAddToRes(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, ct1 *rlwe.Ciphertext, cipherTensor2 *encryption.CiphertextTensor, res *encryption.CiphertextTensor) error {
 
 	// 进行BSGS To Attetion
 	for i := 0; i < cipherTensor2.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		ctTmp, err := evaluator.MulRelinNew(cipherTensor2.Ciphertexts[i], ct1)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		if err := encryption.Rescale(evaluator, ctTmp); err != nil {
+			return err
 		}
-		evaluator.Rescale(ctTmp, ctTmp)
 		//累加到res中
 		ctTmp.Scale = param.DefaultScale()
-		evaluator.Add(ctTmp, res.Ciphertexts[i], res.Ciphertexts[i])
+		if err := evaluator.Add(ctTmp, res.Ciphertexts[i], res.Ciphertexts[i]); err != nil {
+			return err
+		}
 	}
 
 	// 返回结果
@@ -693,7 +743,7 @@ func CiphertextTensorMultiplyCiphertextTensorAddToRes(param *hefloat.Parameters,
  * |0 0 0|       |0 0 0|
  * 1CMul
  */
-func CiphertextTensorRotationByColsNewMultiThread(evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64, Slots int) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorRotationByColsNewMultiThread(ctx context.Context, evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64, Slots int) (*encryption.CiphertextTensor, error) {
 
 	// Not rotate for rotNumber == 0, but also not multiply by baseSize
 	if rotNumber == 0 {
@@ -734,25 +784,32 @@ func CiphertextTensorRotationByColsNewMultiThread(evaluator *hefloat.Evaluator,
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctLeft, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotLeftVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ctRight, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotRightVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// fmt.Printf("i-th:%d, rotLeft:%d, rotRight:%d\n", i, rotNumber, (rotNumber-cipherTensorCols+Slots)%Slots)
-		evaluator.Rotate(ctLeft, rotNumber, ctLeft)
-		evaluator.Rotate(ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight)
+		if err := encryption.Rotate(evaluator, ctLeft, rotNumber, ctLeft); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rotate(evaluator, ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight); err != nil {
+			return nil, err
+		}
 
 		newCiphertexts[i], err = evaluator.AddNew(ctLeft, ctRight)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		if err = evaluator.Rescale(newCiphertexts[i], newCiphertexts[i]); err != nil {
-			panic(err)
+		if err = encryption.Rescale(evaluator, newCiphertexts[i]); err != nil {
+			return nil, err
 		}
 	}
 
@@ -765,7 +822,7 @@ func CiphertextTensorRotationByColsNewMultiThread(evaluator *hefloat.Evaluator,
 	}, nil
 }
 
-func CiphertextTensorRotationByColsNewMultiThread_bak(evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64, Slots int) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorRotationByColsNewMultiThread_bak(ctx context.Context, evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSize float64, Slots int) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensor.NumRows
@@ -799,25 +856,32 @@ func CiphertextTensorRotationByColsNewMultiThread_bak(evaluator *hefloat.Evaluat
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ctLeft, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotLeftVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ctRight, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotRightVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		// fmt.Printf("i-th:%d, rotLeft:%d, rotRight:%d\n", i, rotNumber, (rotNumber-cipherTensorCols+Slots)%Slots)
-		evaluator.Rotate(ctLeft, rotNumber, ctLeft)
-		evaluator.Rotate(ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight)
+		if err := encryption.Rotate(evaluator, ctLeft, rotNumber, ctLeft); err != nil {
+			return nil, err
+		}
+		if err := encryption.Rotate(evaluator, ctRight, (rotNumber-cipherTensorCols+Slots)%Slots, ctRight); err != nil {
+			return nil, err
+		}
 
 		newCiphertexts[i], err = evaluator.AddNew(ctLeft, ctRight)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		if err = evaluator.Rescale(newCiphertexts[i], newCiphertexts[i]); err != nil {
-			panic(err)
+		if err = encryption.Rescale(evaluator, newCiphertexts[i]); err != nil {
+			return nil, err
 		}
 	}
 
@@ -836,50 +900,49 @@ func CiphertextTensorRotationByColsNewMultiThread_bak(evaluator *hefloat.Evaluat
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  *
  */
-func CiphertextTensorLayerNormReplaceVarianceMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorLayerNormReplaceVarianceMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, layerNormR []float64, layerNormB []float64, varVector []float64) (*encryption.CiphertextTensor, error) {
 
 	// compute sum
 	ctSum := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
 		err := publicKeys.Evaluator.Add(ctSum, ciphertextTensor.Ciphertexts[i], ctSum)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 	}
 
 	// 将向量重复ciphertextTensor.NumRows次
 	varVector, err := utils.ReaptVector(varVector, ciphertextTensor.NumRows)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	if ciphertextTensor.NumDepth != len(layerNormR) || len(layerNormB) != len(layerNormR) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("can not compute layernorm")
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "layernorm", Want: []int{ciphertextTensor.NumDepth, ciphertextTensor.NumDepth}, Got: []int{len(layerNormR), len(layerNormB)}}
 	}
 
 	// 计算1/sqrt(ctVar)-->在此函数中，直接用明文varVector,因此这里直接跳过
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	var mu sync.Mutex
 
 	// 计算layerNorm，用明文varVector代替之后，先用r*varVector/n，再与密文相乘
 	newCiphertexts := make([]*rlwe.Ciphertext, ciphertextTensor.NumDepth)
 	for i := 0; i < ciphertextTensor.NumDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			ctNX, err := evaluator.MulNew(ciphertextTensor.Ciphertexts[i], ciphertextTensor.NumDepth)
 			if err != nil {
-				panic(err)
+				return err
 			}
 			ctTmp, err := evaluator.SubNew(ctNX, ctSum)
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// 对varVector进行放缩，也就是计算r*varVector/n
@@ -889,26 +952,31 @@ func CiphertextTensorLayerNormReplaceVarianceMultiThread(publicKeys *encryption.
 
 			// fmt.Println(layerNormR[i] / float64(ciphertextTensor.NumDepth))
 			// 进行计算
-			evaluator.Mul(ctTmp, ptVar, ctTmp)
-			err = evaluator.Rescale(ctTmp, ctTmp)
+			if err := evaluator.Mul(ctTmp, ptVar, ctTmp); err != nil {
+				return err
+			}
+			err = encryption.Rescale(evaluator, ctTmp)
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// 加B
 			err = evaluator.Add(ctTmp, layerNormB[i], ctTmp)
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			mu.Lock()
 			newCiphertexts[i] = ctTmp
 			mu.Unlock()
 
-		}(i)
+			return nil
+		})
 
 	}
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
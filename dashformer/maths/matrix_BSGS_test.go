@@ -1,10 +1,10 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
-	"runtime"
 	"sync"
 	"testing"
 
@@ -12,7 +12,7 @@ import (
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
 )
 
-func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryption.PublicParametersKeys, Q, K, V *encryption.CiphertextTensor, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(ctx context.Context, publicKeys *encryption.PublicParametersKeys, Q, K, V *encryption.CiphertextTensor, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量1维数
 	QCols := Q.NumCols
@@ -42,8 +42,6 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 	var KRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 	var VRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
 	// 创建一个 WaitGroup 来等待所有 goroutine 完成
 	var wg sync.WaitGroup
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
@@ -57,7 +55,7 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转Q
 			// fmt.Printf("Q:%d,K:%d,V:%d\n", -i*babyStep, i, i)
-			rotQ, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, Q, -i*babyStep, 1, publicKeys.Params.MaxSlots())
+			rotQ, err := CiphertextTensorRotationByColsNewMultiThread(ctx, evaluator, Q, -i*babyStep, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
 				panic(err)
 			}
@@ -76,12 +74,12 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转K
 			// rotK, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, K, i, 1/(math.Sqrt(32)*math.Sqrt(c)), publicKeys.Params.MaxSlots())
-			rotK, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, K, i, 1, publicKeys.Params.MaxSlots())
+			rotK, err := CiphertextTensorRotationByColsNewMultiThread(ctx, evaluator, K, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
 				panic(err)
 			}
 			// 旋转V
-			rotV, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, V, i, 1, publicKeys.Params.MaxSlots())
+			rotV, err := CiphertextTensorRotationByColsNewMultiThread(ctx, evaluator, V, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
 				panic(err)
 			}
@@ -118,7 +116,7 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 			for j := 0; j < babyStep; j++ {
 				// 保证小于cols
 				if (i*babyStep + j) < QCols {
-					diagMatrix, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, QRotTensor[i], KRotTensor[j])
+					diagMatrix, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(ctx, publicKeys.Params, evaluator, QRotTensor[i], KRotTensor[j])
 					if err != nil {
 						panic(err)
 					}
@@ -126,13 +124,13 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 					// if err != nil {
 					// 	panic(err)
 					// }
-					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(publicKeys.Params, evaluator, diagMatrix, VRotTensor[j], QKV)
+					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(ctx, publicKeys.Params, evaluator, diagMatrix, VRotTensor[j], QKV)
 					if err != nil {
 						panic(err)
 					}
 				}
 			}
-			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
+			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(ctx, evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
 				panic(err)
 			}
@@ -160,6 +158,7 @@ func CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys *encryptio
 	}, nil
 }
 func TestBSGS(t *testing.T) {
+	ctx := context.Background()
 	// 初始化参数和密钥对（假设已经定义好）
 
 	publicKeys, secretKeys, err := encryption.SetHERealParams()
@@ -240,7 +239,7 @@ func TestBSGS(t *testing.T) {
 	fmt.Printf("===========================\n")
 	fmt.Printf("\n")
 	// 测试BSGS功能
-	newCiphertextTensor2, err := CiphertextTensorRotationByColsNewMultiThread(publicKeys.Evaluator, ciphertextTensor, -1, 1, publicKeys.Params.MaxSlots())
+	newCiphertextTensor2, err := CiphertextTensorRotationByColsNewMultiThread(ctx, publicKeys.Evaluator, ciphertextTensor, -1, 1, publicKeys.Params.MaxSlots())
 	if err != nil {
 		panic(err)
 	}
@@ -259,7 +258,7 @@ func TestBSGS(t *testing.T) {
 	fmt.Printf("===========================\n")
 	fmt.Printf("\n")
 	// 测试BSGS功能
-	newCiphertextTensor1, err := CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(publicKeys, ciphertextTensor, ciphertextTensor, ciphertextTensor, 7, 8, 0.96, 200)
+	newCiphertextTensor1, err := CiphertextTensorQKVToAttentionWithBSGSMultiThreadCopy(ctx, publicKeys, ciphertextTensor, ciphertextTensor, ciphertextTensor, 7, 8, 0.96, 200)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,7 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 )
 
 func TestEncryptAndDecryptTensorValue(t *testing.T) {
+	ctx := context.Background()
 	// 初始化参数和密钥对（假设已经定义好）
 
 	publicKeys, secretKeys, err := encryption.SetHERealParams()
@@ -57,7 +59,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("===============================================\n")
 	fmt.Printf("\n")
 	// 进行明文×密文计算1
-	newCiphertextTensor1, err := CiphertextTensorMultiplyPlaintextMatrix(publicKeys, ciphertextTensor, plainMatrixValue2)
+	newCiphertextTensor1, err := CiphertextTensorMultiplyPlaintextMatrix(ctx, publicKeys, ciphertextTensor, plainMatrixValue2)
 	if err != nil {
 		panic(err)
 	}
@@ -76,7 +78,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor add plain matrix\n")
 	fmt.Printf("==========================================\n")
 	fmt.Printf("\n")
-	newCiphertextTensorAdd1, err := CiphertextTensorAddPlaintextMatrix(publicKeys, ciphertextTensor, plainMatrixValue1)
+	newCiphertextTensorAdd1, err := CiphertextTensorAddPlaintextMatrix(ctx, publicKeys, ciphertextTensor, plainMatrixValue1)
 	if err != nil {
 		panic(err)
 	}
@@ -95,7 +97,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor multiply plain weight matrix and add plain bias vector\n")
 	fmt.Printf("================================================================================\n")
 	fmt.Printf("\n")
-	newCiphertextTensorMulWeightAndAddBias, err := CiphertextTensorMultiplyWeightAndAddBias(publicKeys, ciphertextTensor, plainMatrixValue2, plainVectorValue1)
+	newCiphertextTensorMulWeightAndAddBias, err := CiphertextTensorMultiplyWeightAndAddBias(ctx, publicKeys, ciphertextTensor, plainMatrixValue2, plainVectorValue1)
 	if err != nil {
 		panic(err)
 	}
@@ -114,7 +116,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor Rotation by cols\n")
 	fmt.Printf("==========================================\n")
 	fmt.Printf("\n")
-	newCiphertextTensorRot, err := CiphertextTensorRotationByColsNew(publicKeys, ciphertextTensor, 1, 10)
+	newCiphertextTensorRot, err := CiphertextTensorRotationByColsNew(ctx, publicKeys, ciphertextTensor, 1, 10)
 	if err != nil {
 		panic(err)
 	}
@@ -133,7 +135,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor Multiply ciphertext tensor to Halevi-Shoup\n")
 	fmt.Printf("====================================================================\n")
 	fmt.Printf("\n")
-	newCiphertextTensorToHaleviShoup, err := CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(publicKeys, ciphertextTensor, ciphertextTensor, 10)
+	newCiphertextTensorToHaleviShoup, err := CiphertextTensorMultiplyCiphertextTensorToHalveiShoup(ctx, publicKeys, ciphertextTensor, ciphertextTensor, 10)
 	if err != nil {
 		panic(err)
 	}
@@ -152,7 +154,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor H-S Multiply ciphertext tensor to columns\n")
 	fmt.Printf("===================================================================\n")
 	fmt.Printf("\n")
-	newCiphertextColumns, err := CiphertextTensorHSMultiplyCiphertextTensor(publicKeys, newCiphertextTensorToHaleviShoup, ciphertextTensor)
+	newCiphertextColumns, err := CiphertextTensorHSMultiplyCiphertextTensor(ctx, publicKeys, newCiphertextTensorToHaleviShoup, ciphertextTensor)
 	if err != nil {
 		panic(err)
 	}
@@ -171,7 +173,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("Testing ciphertext tensor avg and variance\n")
 	fmt.Printf("==========================================\n")
 	fmt.Printf("\n")
-	ctAvg, ctVar, err := CiphertextTensorReturnAvgAndVar(publicKeys, ciphertextTensor)
+	ctAvg, ctVar, err := CiphertextTensorReturnAvgAndVar(ctx, publicKeys, ciphertextTensor)
 	if err != nil {
 		panic(err)
 	}
@@ -196,7 +198,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 		3.15551268e-08,
 		-8.73491970e-11,
 	}
-	newCiphertextLayerNorm, err := CiphertextTensorLayerNorm(publicKeys, ciphertextTensor, plainVectorValue2, plainVectorValue1, sqrtLayerCoefficients1, [2]float64{20, 120})
+	newCiphertextLayerNorm, err := CiphertextTensorLayerNorm(ctx, publicKeys, ciphertextTensor, plainVectorValue2, plainVectorValue1, sqrtLayerCoefficients1, [2]float64{20, 120})
 	if err != nil {
 		panic(err)
 	}
@@ -216,7 +218,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("============================================\n")
 	fmt.Printf("\n")
 	fmt.Printf("Ciphertext Tensor Layernorm Level:%d\n", ciphertextTensor.Ciphertexts[0].Level())
-	newCiphertextLayerNormReduceMul, err := CiphertextTensorLayerNormReduceMul(publicKeys, ciphertextTensor, plainVectorValue2, plainVectorValue1, sqrtLayerCoefficients1, [2]float64{20, 120})
+	newCiphertextLayerNormReduceMul, err := CiphertextTensorLayerNormReduceMul(ctx, publicKeys, ciphertextTensor, plainVectorValue2, plainVectorValue1, sqrtLayerCoefficients1, [2]float64{20, 120})
 	if err != nil {
 		panic(err)
 	}
@@ -237,7 +239,7 @@ func TestEncryptAndDecryptTensorValue(t *testing.T) {
 	fmt.Printf("\n")
 	fmt.Printf("Ciphertext Tensor Level:%d\n", ciphertextTensor.Ciphertexts[0].Level())
 
-	newCiphertextTensorPoolingAndClassification, err := CiphertextTensorMultiplyClassificationAndPooling(publicKeys, ciphertextTensor, plainMatrixValue2, plainVectorValue1)
+	newCiphertextTensorPoolingAndClassification, err := CiphertextTensorMultiplyClassificationAndPooling(ctx, publicKeys, ciphertextTensor, plainMatrixValue2, plainVectorValue1)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,7 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 )
 
 func TestReLU(t *testing.T) {
+	ctx := context.Background()
 	// 初始化参数和密钥对
 
 	publicKeys, secretKeys, err := encryption.SetHERealParams()
@@ -67,7 +69,7 @@ func TestReLU(t *testing.T) {
 		-7.02786376e-09,
 	}
 	//进行密文ReLU
-	newCiphertextReLU_1, err := ApproximatePolynomialCipherTensorMultiThread(publicKeys, ciphertextTensor, reluCoefficients, [2]float64{0, 1})
+	newCiphertextReLU_1, err := ApproximatePolynomialCipherTensorMultiThread(ctx, publicKeys, ciphertextTensor, reluCoefficients, [2]float64{0, 1})
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,90 @@
+package maths
+
+import (
+	"dashformer/encryption"
+	"fmt"
+	"sort"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+/*
+ * DashformerRotations
+ * Input:  seqLen (NumCols of the input tensor), babyStep, giantStep, slots int
+ * Output: []int, error
+ * Compute: the rotation steps used by GenerateCipherTensorRot, CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread
+ *          (rotations of V and of the partial results) and CipherTensorPoolingAndAddConstantMultiThread (InnerSum)
+ *          for the given seqLen and babyStep/giantStep.
+ *          Each rotation by columns r rotates the left part by r and the right part by (r-seqLen+slots)%slots.
+ */
+func DashformerRotations(seqLen, babyStep, giantStep, slots int) ([]int, error) {
+	if seqLen <= 0 || seqLen > slots {
+		return nil, fmt.Errorf("the sequence length %d must be in [1, %d]", seqLen, slots)
+	}
+	if babyStep <= 0 || giantStep <= 0 {
+		return nil, fmt.Errorf("babyStep %d and giantStep %d must be positive", babyStep, giantStep)
+	}
+	if babyStep*giantStep < seqLen {
+		return nil, fmt.Errorf("babyStep*giantStep = %d does not cover the sequence length %d", babyStep*giantStep, seqLen)
+	}
+
+	set := make(map[int]bool)
+	addRotationByCols := func(rotNumber int) {
+		rotNumber = ((rotNumber % seqLen) + seqLen) % seqLen
+		set[rotNumber] = true
+		set[(rotNumber-seqLen+slots)%slots] = true
+	}
+
+	for i := 0; i < giantStep; i++ {
+		// GenerateCipherTensorRot: X0 旋转 -i*babyStep
+		addRotationByCols(-i * babyStep)
+		// CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread: 结果旋转 i*babyStep (i=0 时不旋转)
+		if i > 0 {
+			addRotationByCols(i * babyStep)
+		}
+	}
+	// GenerateCipherTensorRot 中 X0 与 CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread 中 V 旋转 i (i=0 时不旋转)
+	for i := 1; i < babyStep; i++ {
+		addRotationByCols(i)
+	}
+	// CipherTensorPoolingAndAddConstantMultiThread: InnerSum(ct, 1, seqLen)
+	for _, rot := range encryption.InnerSumRotations(1, seqLen) {
+		set[rot] = true
+	}
+
+	// 旋转 0 是恒等变换，不需要密钥
+	delete(set, 0)
+	rotations := make([]int, 0, len(set))
+	for rot := range set {
+		rotations = append(rotations, rot)
+	}
+	sort.Ints(rotations)
+	return rotations, nil
+}
+
+/*
+ * DashformerGaloisElements
+ * Input:  params hefloat.Parameters, seqLen, babyStep, giantStep int
+ * Output: []uint64, error
+ * Compute: the Galois elements of DashformerRotations, without duplicates
+ */
+func DashformerGaloisElements(params hefloat.Parameters, seqLen, babyStep, giantStep int) ([]uint64, error) {
+	rotations, err := DashformerRotations(seqLen, babyStep, giantStep, params.MaxSlots())
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[uint64]bool)
+	for _, galEl := range params.GaloisElements(rotations) {
+		set[galEl] = true
+	}
+	// 恒等变换不需要密钥
+	delete(set, 1)
+
+	galEls := make([]uint64, 0, len(set))
+	for galEl := range set {
+		galEls = append(galEls, galEl)
+	}
+	sort.Slice(galEls, func(i, j int) bool { return galEls[i] < galEls[j] })
+	return galEls, nil
+}
@@ -0,0 +1,116 @@
+package maths
+
+import (
+	"context"
+	"dashformer/encryption"
+	"sync"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+// recordingKeySet 记录计算过程中请求的 Galois 元素
+type recordingKeySet struct {
+	rlwe.EvaluationKeySet
+	mu        sync.Mutex
+	requested map[uint64]bool
+}
+
+func (r *recordingKeySet) GetGaloisKey(galEl uint64) (*rlwe.GaloisKey, error) {
+	r.mu.Lock()
+	r.requested[galEl] = true
+	r.mu.Unlock()
+	return r.EvaluationKeySet.GetGaloisKey(galEl)
+}
+
+func TestDashformerGaloisElements(t *testing.T) {
+	ctx := context.Background()
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            12,
+		LogQ:            []int{45, 35, 35, 35, 35, 35, 35, 35, 35, 35},
+		LogP:            []int{45, 45},
+		LogDefaultScale: 35,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seqLen, babyStep, giantStep := 10, 3, 4
+	galEls, err := DashformerGaloisElements(params, seqLen, babyStep, giantStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kgen := rlwe.NewKeyGenerator(params)
+	sk, pk := kgen.GenKeyPairNew()
+	evk := &recordingKeySet{
+		EvaluationKeySet: rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(sk), kgen.GenGaloisKeysNew(galEls, sk)...),
+		requested:        make(map[uint64]bool),
+	}
+	publicKeys := encryption.NewPublicParametersKeys(params, pk, evk)
+
+	// 2 条序列，长度 seqLen，输入深度 2，注意力头维度 2
+	inputDepth, headDepth := 2, 2
+	plainTensorValue := make([][][]float64, 2)
+	for i := range plainTensorValue {
+		plainTensorValue[i] = make([][]float64, seqLen)
+		for j := range plainTensorValue[i] {
+			plainTensorValue[i][j] = make([]float64, inputDepth)
+			plainTensorValue[i][j][(i+j)%inputDepth] = 1
+		}
+	}
+	X0, err := encryption.EncryptTensorValue(publicKeys, plainTensorValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newMat := func(rows, cols int) [][]float64 {
+		mat := make([][]float64, rows)
+		for i := range mat {
+			mat[i] = make([]float64, cols)
+			for j := range mat[i] {
+				mat[i][j] = 0.01 * float64((i+2*j)%5)
+			}
+		}
+		return mat
+	}
+
+	X0RotTensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight, err := GenerateCipherTensorRot(ctx, publicKeys, X0, babyStep, giantStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attention, err := CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(ctx, publicKeys, X0, X0RotTensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight,
+		newMat(inputDepth, inputDepth), newMat(inputDepth, seqLen), newMat(seqLen, inputDepth), newMat(seqLen, seqLen),
+		newMat(inputDepth, headDepth), newMat(seqLen, headDepth), babyStep, giantStep, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CipherTensorPoolingAndAddConstantMultiThread(ctx, publicKeys, attention, make([]float64, headDepth)); err != nil {
+		t.Fatal(err)
+	}
+
+	planned := make(map[uint64]bool)
+	for _, galEl := range galEls {
+		planned[galEl] = true
+	}
+	for galEl := range evk.requested {
+		if !planned[galEl] {
+			t.Errorf("Galois element %d is used but not planned", galEl)
+		}
+	}
+	for galEl := range planned {
+		if !evk.requested[galEl] {
+			t.Errorf("Galois element %d is planned but never used", galEl)
+		}
+	}
+}
+
+func TestDashformerRotationsErrors(t *testing.T) {
+	if _, err := DashformerRotations(50, 7, 7, 8192); err == nil {
+		t.Error("expected an error when babyStep*giantStep does not cover the sequence")
+	}
+	if _, err := DashformerRotations(50, 7, 8, 32); err == nil {
+		t.Error("expected an error when the sequence does not fit in the slots")
+	}
+}
@@ -1,11 +1,11 @@
 package maths
 
 import (
+	"context"
 	"dashformer/encryption"
 	"dashformer/utils"
 	"fmt"
 	"math"
-	"runtime"
 	"sync"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
@@ -18,12 +18,12 @@ import (
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  * compute: diag(beforeVec) X ctTensor X rearMat
  */
-func PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, beforeVec []float64, rearMat [][]float64) (*encryption.CiphertextTensor, error) {
+func PlainVecMulCipherTensorMulPlainMatMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, beforeVec []float64, rearMat [][]float64) (*encryption.CiphertextTensor, error) {
 
 	// 将向量重复ciphertextTensor.NumRows次
 	beforeVec, err := utils.ReaptVector(beforeVec, ciphertextTensor.NumRows)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	// 返回维数
@@ -39,27 +39,29 @@ func PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys *encryption.Public
 
 	// 实际上，密文的depths必须等于明文的rows，才能继续进行运算；而明文的cols则是运算之后的depths
 	if cipherDepth != plainRows {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", plainRows, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply plaintext matrix", Want: []int{plainRows}, Got: []int{cipherDepth}}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// var mu sync.Mutex // 用于保护 newCiphertexts 的并发写入
 
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, plainCols)
 	for i := 0; i < plainCols; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			// 复制evaluator
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			ct := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 			// ct.Scale = ciphertextTensor.Ciphertexts[0].Scale
 			for j := 0; j < plainRows; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 创建一个明文向量
 				ptMulNumSlice := make([]float64, cipherRows*cipherCols)
 				for k := range ptMulNumSlice {
@@ -72,22 +74,25 @@ func PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys *encryption.Public
 				ciphertextTensor.Ciphertexts[j].Scale = publicKeys.Params.DefaultScale()
 				err := evaluator.MulThenAdd(ciphertextTensor.Ciphertexts[j], plainVecScale, ct)
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
 
-			if err := evaluator.Rescale(ct, ct); err != nil {
-				panic(err)
+			if err := encryption.Rescale(evaluator, ct); err != nil {
+				return err
 			}
 			// 并发安全地写入 newCiphertexts
 			// mu.Lock()
 			newCiphertexts[i] = ct
 			// mu.Unlock()
-		}(i)
+			return nil
+		})
 
 	}
 	// 等待所有 goroutine 完成
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	// 返回结果
 	return &encryption.CiphertextTensor{
@@ -104,7 +109,7 @@ func PlainVecMulCipherTensorMulPlainMatMultiThread(publicKeys *encryption.Public
 - Output: CiphertextTensor,error
 - 1CMul
 */
-func CipherTensorPoolingAndAddConstantMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptBias []float64) (*encryption.CiphertextTensor, error) {
+func CipherTensorPoolingAndAddConstantMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, ptBias []float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherRows := ciphertextTensor.NumRows
@@ -112,32 +117,36 @@ func CipherTensorPoolingAndAddConstantMultiThread(publicKeys *encryption.PublicP
 	cipherDepth := ciphertextTensor.NumDepth
 
 	if cipherDepth != len(ptBias) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", len(ptBias), cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "pooling and add constant", Want: []int{len(ptBias)}, Got: []int{cipherDepth}}
 	}
 
 	// Step 2. 进行pooling
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherDepth)
-	var err error
 	for i := 0; i < cipherDepth; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
-			evaluator.InnerSum(ciphertextTensor.Ciphertexts[i], 1, ciphertextTensor.NumCols, ciphertextTensor.Ciphertexts[i])
+			if err := encryption.InnerSum(evaluator, ciphertextTensor.Ciphertexts[i], 1, ciphertextTensor.NumCols, ciphertextTensor.Ciphertexts[i]); err != nil {
+				return err
+			}
 			ciphertextTensor.Ciphertexts[i].Scale = publicKeys.Params.DefaultScale()
-			newCiphertexts[i], err = evaluator.AddNew(ciphertextTensor.Ciphertexts[i], ptBias[i])
+			ct, err := evaluator.AddNew(ciphertextTensor.Ciphertexts[i], ptBias[i])
 			if err != nil {
-				panic(err)
+				return err
 			}
-		}(i)
+			newCiphertexts[i] = ct
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
 	// 返回结果
 	return &encryption.CiphertextTensor{
@@ -154,7 +163,7 @@ func CipherTensorPoolingAndAddConstantMultiThread(publicKeys *encryption.PublicP
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  * Compute: ciphertextTensor X rearMat + addMat
  */
-func CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, rearMat [][]float64, addMat [][]float64) (*encryption.CiphertextTensor, error) {
+func CipherTensorMulPlainMatAndAddPlainMatMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, ciphertextTensor *encryption.CiphertextTensor, rearMat [][]float64, addMat [][]float64) (*encryption.CiphertextTensor, error) {
 
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
@@ -179,34 +188,36 @@ func CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys *encryption.Pub
 		}
 		vec, err := utils.ReaptVector(vec, cipherRows)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		addVector[i] = vec
 	}
 
 	// 实际上，密文的depths必须等于明文的rows，才能继续进行运算；而明文的cols则是运算之后的depths
 	if cipherDepth != plainRows {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot multiply plaintext matrix: expected depth %d, got %d", plainRows, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply plaintext matrix", Want: []int{plainRows}, Got: []int{cipherDepth}}
 	}
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	var mu sync.Mutex // 用于保护 newCiphertexts 的并发写入
 
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, plainCols)
 	for i := 0; i < plainCols; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			// 复制evaluator
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			ct := hefloat.NewCiphertext(*publicKeys.Params, ciphertextTensor.Ciphertexts[0].Degree(), ciphertextTensor.Ciphertexts[0].Level())
 			// ct.Scale = ciphertextTensor.Ciphertexts[0].Scale
 			for j := 0; j < plainRows; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 创建一个明文向量
 				ptMulNumSlice := make([]float64, cipherRows*cipherCols)
 				for k := range ptMulNumSlice {
@@ -217,25 +228,28 @@ func CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys *encryption.Pub
 				// ciphertextTensor.Ciphertexts[j].Scale = publicKeys.Params.DefaultScale()
 				err := evaluator.MulThenAdd(ciphertextTensor.Ciphertexts[j], ptMulNumSlice, ct)
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
 
-			if err := evaluator.Rescale(ct, ct); err != nil {
-				panic(err)
+			if err := encryption.Rescale(evaluator, ct); err != nil {
+				return err
 			}
 			if err := evaluator.Add(ct, addVector[i], ct); err != nil {
-				panic(err)
+				return err
 			}
 			// 并发安全地写入 newCiphertexts
 			mu.Lock()
 			newCiphertexts[i] = ct
 			mu.Unlock()
-		}(i)
+			return nil
+		})
 
 	}
 	// 等待所有 goroutine 完成
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
 
 	// 返回结果
 	return &encryption.CiphertextTensor{
@@ -252,12 +266,12 @@ func CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys *encryption.Pub
  * Output: *rlwe.Ciphertext, *rlwe.Ciphertext, error
  * Compute: ciphertextTensor X rearMat + addMat
  */
-func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, X0Tensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight []*encryption.CiphertextTensor, WQWKT, WQBKT, BQWKT, BQBKT [][]float64,
+func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, X0Tensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight []*encryption.CiphertextTensor, WQWKT, WQBKT, BQWKT, BQBKT [][]float64,
 	X0_rear_V, Constant_V [][]float64, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
 
-	V, err := CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys, X0, X0_rear_V, Constant_V)
+	V, err := CipherTensorMulPlainMatAndAddPlainMatMultiThread(ctx, publicKeys, X0, X0_rear_V, Constant_V)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	VCols := V.NumCols
@@ -270,12 +284,10 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 	// var X0TRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 	var VRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
-	// var mu sync.Mutex
+	var mu sync.Mutex
 
 	// // 生成旋转所有的步长
 	// for i := 0; i < giantStep; i++ {
@@ -299,9 +311,10 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 
 	// 生成旋转所有的步长
 	for i := 0; i < babyStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转K
 
@@ -310,17 +323,21 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 			// 	panic(err)
 			// }
 			// 旋转V
-			rotV, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, V, i, 1, publicKeys.Params.MaxSlots())
+			rotV, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, V, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// mu.Lock()
 			// X0TRotTensor[i] = rotX0T
 			VRotTensor[i] = rotV
 			// mu.Unlock()
-		}(i)
+			return nil
+		})
 	}
-	wg.Wait()
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	g, gctx = utils.WithContext(ctx)
 
 	newCiphertexts := make([]*rlwe.Ciphertext, VDepth)
 	for k := 0; k < VDepth; k++ {
@@ -329,9 +346,10 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 
 	// 进行BSGS To Attetion
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			localNewCiphertexts := make([]*rlwe.Ciphertext, VDepth)
@@ -344,25 +362,28 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 				NumCols:     VCols,
 				NumDepth:    VDepth,
 			}
-			X0TensorWQWKT, err := CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(publicKeys.Params, evaluator, X0RotTensorLeft[i], X0RotTensorRight[i], WQWKT, -i*babyStep, publicKeys.Params.MaxSlots())
+			X0TensorWQWKT, err := CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(gctx, publicKeys.Params, evaluator, X0RotTensorLeft[i], X0RotTensorRight[i], WQWKT, -i*babyStep, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
-			X0TensorWQWKTAdd, err := CiphertextTensorAddPlaintextMatrixWithEvaluator(evaluator, X0TensorWQWKT, rotateMatrixColumns(BQWKT, -i*babyStep))
+			X0TensorWQWKTAdd, err := CiphertextTensorAddPlaintextMatrixWithEvaluator(gctx, evaluator, X0TensorWQWKT, rotateMatrixColumns(BQWKT, -i*babyStep))
 			if err != nil {
-				panic(err)
+				return err
 			}
 			for j := 0; j < babyStep; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 保证小于cols
 				if (i*babyStep + j) < VCols {
 					// 得到diagMatrix
-					diagMatrix_1, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, X0TensorWQWKTAdd, X0TRotTensor[j])
+					diagMatrix_1, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(gctx, publicKeys.Params, evaluator, X0TensorWQWKTAdd, X0TRotTensor[j])
 					if err != nil {
-						panic(err)
+						return err
 					}
-					digaMatrix_2, err := CiphertextTensorMultiplyPlainMatThenAdd(publicKeys.Params, evaluator, X0Tensor[i], rotateMatrixRows(WQBKT, j))
+					digaMatrix_2, err := CiphertextTensorMultiplyPlainMatThenAdd(gctx, publicKeys.Params, evaluator, X0Tensor[i], rotateMatrixRows(WQBKT, j))
 					if err != nil {
-						panic(err)
+						return err
 					}
 					// diagMatrix_3, err := PlainMatMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, rotateMatrixColumns(BQWKT, -i*babyStep), X0TRotTensor[j])
 					// if err != nil {
@@ -370,7 +391,7 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 					// }
 					diagMatrix, err := evaluator.AddNew(diagMatrix_1, digaMatrix_2)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					// err = evaluator.Add(diagMatrix, diagMatrix_3, diagMatrix)
 					// if err != nil {
@@ -379,47 +400,50 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 					// 获得明文BQBKT项的第i条对角线
 					diagPlain, err := GetDiagRotVector(BQBKT, i*babyStep+j, -i*babyStep)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					diagPlain, err = utils.ReaptVector(diagPlain, VRows)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					err = evaluator.Add(diagMatrix, diagPlain, diagMatrix)
 					if err != nil {
-						panic(err)
+						return err
 					}
 
 					// softmax
-					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(evaluator, diagMatrix, b/math.Sqrt(c), 1)
+					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(gctx, evaluator, diagMatrix, b/math.Sqrt(c), 1)
 					if err != nil {
-						panic(err)
+						return err
 					}
-					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
+					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(gctx, publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
 					if err != nil {
-						panic(err)
+						return err
 					}
 				}
 			}
-			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
+			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// 合并局部结果
-			// mu.Lock()
-			// defer mu.Unlock()
+			mu.Lock()
+			defer mu.Unlock()
 			for k := 0; k < QKVRotKi.NumDepth; k++ {
 				// newCiphertexts[k].Scale = publicKeys.Params.DefaultScale()
 				// QKVRotKi.Ciphertexts[k].Scale = publicKeys.Params.DefaultScale()
 				newCiphertexts[k], err = evaluator.AddNew(QKVRotKi.Ciphertexts[k], newCiphertexts[k])
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	// 返回结果
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -429,12 +453,12 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread(publicKeys *encryption.P
 	}, nil
 }
 
-func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, X0Tensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight []*encryption.CiphertextTensor, WQWKT, WQBKT, BQWKT, BQBKT [][]float64,
+func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, X0Tensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight []*encryption.CiphertextTensor, WQWKT, WQBKT, BQWKT, BQBKT [][]float64,
 	X0_rear_V, Constant_V [][]float64, babyStep, giantStep int, b, c float64) (*encryption.CiphertextTensor, error) {
 
-	V, err := CipherTensorMulPlainMatAndAddPlainMatMultiThread(publicKeys, X0, X0_rear_V, Constant_V)
+	V, err := CipherTensorMulPlainMatAndAddPlainMatMultiThread(ctx, publicKeys, X0, X0_rear_V, Constant_V)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	VCols := V.NumCols
@@ -447,10 +471,8 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 	// var X0TRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 	var VRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 
@@ -476,9 +498,10 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 
 	// 生成旋转所有的步长
 	for i := 0; i < babyStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转K
 
@@ -487,17 +510,21 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 			// 	panic(err)
 			// }
 			// 旋转V
-			rotV, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, V, i, 1, publicKeys.Params.MaxSlots())
+			rotV, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, V, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// mu.Lock()
 			// X0TRotTensor[i] = rotX0T
 			VRotTensor[i] = rotV
 			// mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
+	g, gctx = utils.WithContext(ctx)
 
 	newCiphertexts := make([]*rlwe.Ciphertext, VDepth)
 	for k := 0; k < VDepth; k++ {
@@ -506,9 +533,10 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 
 	// 进行BSGS To Attetion
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 
 			localNewCiphertexts := make([]*rlwe.Ciphertext, VDepth)
@@ -521,29 +549,32 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 				NumCols:     VCols,
 				NumDepth:    VDepth,
 			}
-			X0TensorWQWKT, err := CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(publicKeys.Params, evaluator, X0RotTensorLeft[i], X0RotTensorRight[i], WQWKT, -i*babyStep, publicKeys.Params.MaxSlots())
+			X0TensorWQWKT, err := CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(gctx, publicKeys.Params, evaluator, X0RotTensorLeft[i], X0RotTensorRight[i], WQWKT, -i*babyStep, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// X0Tensor, err := CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(publicKeys.Params, evaluator, X0RotTensorLeft[i], X0RotTensorRight[i], -i*babyStep, publicKeys.Params.MaxSlots())
 			// if err != nil {
 			// 	panic(err)
 			// }
 			for j := 0; j < babyStep; j++ {
+				if err := gctx.Err(); err != nil {
+					return err
+				}
 				// 保证小于cols
 				if (i*babyStep + j) < VCols {
 					// 得到diagMatrix
-					X0TensorWQWKTAdd, err := CiphertextTensorAddPlaintextMatrixWithEvaluator(evaluator, X0TensorWQWKT, rotateMatrixColumns(BQWKT, -i*babyStep))
+					X0TensorWQWKTAdd, err := CiphertextTensorAddPlaintextMatrixWithEvaluator(gctx, evaluator, X0TensorWQWKT, rotateMatrixColumns(BQWKT, -i*babyStep))
 					if err != nil {
-						panic(err)
+						return err
 					}
-					diagMatrix_1, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, X0TensorWQWKTAdd, X0TRotTensor[j])
+					diagMatrix_1, err := CiphertextTensorMultiplyCiphertextTensorThenAdd(gctx, publicKeys.Params, evaluator, X0TensorWQWKTAdd, X0TRotTensor[j])
 					if err != nil {
-						panic(err)
+						return err
 					}
-					digaMatrix_2, err := CiphertextTensorMultiplyPlainMatThenAdd(publicKeys.Params, evaluator, X0Tensor[i], rotateMatrixRows(WQBKT, j))
+					digaMatrix_2, err := CiphertextTensorMultiplyPlainMatThenAdd(gctx, publicKeys.Params, evaluator, X0Tensor[i], rotateMatrixRows(WQBKT, j))
 					if err != nil {
-						panic(err)
+						return err
 					}
 					// diagMatrix_3, err := PlainMatMultiplyCiphertextTensorThenAdd(publicKeys.Params, evaluator, rotateMatrixColumns(BQWKT, -i*babyStep), X0TRotTensor[j])
 					// if err != nil {
@@ -551,7 +582,7 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 					// }
 					diagMatrix, err := evaluator.AddNew(diagMatrix_1, digaMatrix_2)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					// err = evaluator.Add(diagMatrix, diagMatrix_3, diagMatrix)
 					// if err != nil {
@@ -560,31 +591,31 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 					// 获得明文BQBKT项的第i条对角线
 					diagPlain, err := GetDiagRotVector(BQBKT, i*babyStep+j, -i*babyStep)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					diagPlain, err = utils.ReaptVector(diagPlain, VRows)
 					if err != nil {
-						panic(err)
+						return err
 					}
 					err = evaluator.Add(diagMatrix, diagPlain, diagMatrix)
 					if err != nil {
-						panic(err)
+						return err
 					}
 
 					// softmax
-					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(evaluator, diagMatrix, b/math.Sqrt(c), 1)
+					diagMatrixSoftMax, err := ApproximateSoftmaxCiphertext(gctx, evaluator, diagMatrix, b/math.Sqrt(c), 1)
 					if err != nil {
-						panic(err)
+						return err
 					}
-					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
+					err = CiphertextTensorMultiplyCiphertextTensorAddToRes(gctx, publicKeys.Params, evaluator, diagMatrixSoftMax, VRotTensor[j], QKV)
 					if err != nil {
-						panic(err)
+						return err
 					}
 				}
 			}
-			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
+			QKVRotKi, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, QKV, babyStep*i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
 			// 合并局部结果
@@ -595,12 +626,15 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
 				// QKVRotKi.Ciphertexts[k].Scale = publicKeys.Params.DefaultScale()
 				newCiphertexts[k], err = evaluator.AddNew(QKVRotKi.Ciphertexts[k], newCiphertexts[k])
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
-	wg.Wait()
 	// 返回结果
 	return &encryption.CiphertextTensor{
 		Ciphertexts: newCiphertexts,
@@ -618,7 +652,7 @@ func CipherTensorUnfoldX0ToAttentionWithBSGSMultiThread_bak(publicKeys *encrypti
  *         CiphertextTensorLeft    [C,D,E,0,0,|0,A,B]
  *         CiphertextTensorRight   [0,0,0,A,B,|C,D,E]
  */
-func CipherTensorRotationByColsNotAddMultiThread(evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSzie float64, Slots int) (*encryption.CiphertextTensor, *encryption.CiphertextTensor, error) {
+func CipherTensorRotationByColsNotAddMultiThread(ctx context.Context, evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, rotNumber int, baseSzie float64, Slots int) (*encryption.CiphertextTensor, *encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensor.NumRows
@@ -654,6 +688,9 @@ func CipherTensorRotationByColsNotAddMultiThread(evaluator *hefloat.Evaluator, c
 	newCiphertextsLeft := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	newCiphertextsRight := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		// ctLeft, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotLeftVector)
 		// if err != nil {
 		// 	panic(err)
@@ -664,13 +701,13 @@ func CipherTensorRotationByColsNotAddMultiThread(evaluator *hefloat.Evaluator, c
 		// }
 
 		// fmt.Printf("i-th:%d, rotLeft:%d, rotRight:%d\n", i, rotNumber, (rotNumber-cipherTensorCols+Slots)%Slots)
-		ctLeft, err := evaluator.RotateNew(cipherTensor.Ciphertexts[i], rotNumber)
+		ctLeft, err := encryption.RotateNew(evaluator, cipherTensor.Ciphertexts[i], rotNumber)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
-		ctRight, err := evaluator.RotateNew(cipherTensor.Ciphertexts[i], (rotNumber-cipherTensorCols+Slots)%Slots)
+		ctRight, err := encryption.RotateNew(evaluator, cipherTensor.Ciphertexts[i], (rotNumber-cipherTensorCols+Slots)%Slots)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
 		}
 		newCiphertextsLeft[i] = ctLeft
 		newCiphertextsRight[i] = ctRight
@@ -698,7 +735,7 @@ func CipherTensorRotationByColsNotAddMultiThread(evaluator *hefloat.Evaluator, c
  *         CiphertextTensorLeft    [C,D,E,0,0,|0,A,B]
  *         CiphertextTensorRight   [0,0,0,A,B,|C,D,E]
  */
-func CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensorLeft, cipherTensorRight *encryption.CiphertextTensor, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
+func CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensorLeft, cipherTensorRight *encryption.CiphertextTensor, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensorLeft.NumRows
@@ -717,6 +754,9 @@ func CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(param *hefloat
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ct := hefloat.NewCiphertext(*param, cipherTensorLeft.Ciphertexts[0].Degree(), cipherTensorLeft.Ciphertexts[0].Level())
 
 		baseSize := 1.0
@@ -724,22 +764,22 @@ func CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(param *hefloat
 
 		ctLeft, err := evaluator.MulRelinNew(cipherTensorLeft.Ciphertexts[i], rotLeftVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ctRight, err := evaluator.MulRelinNew(cipherTensorRight.Ciphertexts[i], rotRightVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		res, err := evaluator.AddNew(ctLeft, ctRight)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		if err = evaluator.Rescale(res, res); err != nil {
-			panic(err)
+		if err = encryption.Rescale(evaluator, res); err != nil {
+			return nil, err
 		}
 		if err = evaluator.Add(ct, res, ct); err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		newCiphertexts[i] = ct
@@ -762,7 +802,7 @@ func CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(param *hefloat
  *         CiphertextTensorLeft    [C,D,E,0,0,|0,A,B]
  *         CiphertextTensorRight   [0,0,0,A,B,|C,D,E]
  */
-func CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensorLeft, cipherTensorRight *encryption.CiphertextTensor, PlainMat [][]float64, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
+func CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensorLeft, cipherTensorRight *encryption.CiphertextTensor, PlainMat [][]float64, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensorLeft.NumRows
@@ -779,7 +819,7 @@ func CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(param *hefloat.Par
 
 	// 判断条件，明文矩阵是一个方阵且Depth==rows
 	if cipherTensorDepth != len(PlainMat) {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the Plain Matrix %d is not equal cipherTensor Depth %d", cipherTensorDepth, len(PlainMat))
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "multiply plaintext matrix", Want: []int{cipherTensorDepth}, Got: []int{len(PlainMat)}}
 	}
 
 	// fmt.Println(rotLeftVector)
@@ -787,30 +827,33 @@ func CipherTensorMulPlainMatWithLeftAndRightTensorMultiThread(param *hefloat.Par
 	// 进行计算
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ct := hefloat.NewCiphertext(*param, cipherTensorLeft.Ciphertexts[0].Degree(), cipherTensorLeft.Ciphertexts[0].Level())
 		for j := 0; j < cipherTensorDepth; j++ {
 			rotLeftVector, rotRightVector := GeneratePlainVecLeftAndRight(cipherTensorRows, cipherTensorCols, rotNumber, PlainMat[j][i])
 			ctLeft, err := evaluator.MulRelinNew(cipherTensorLeft.Ciphertexts[j], rotLeftVector)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			ctRight, err := evaluator.MulRelinNew(cipherTensorRight.Ciphertexts[j], rotRightVector)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			// fmt.Println("Alread Mul")
 			ctLeft.Scale = ctRight.Scale
 			res, err := evaluator.AddNew(ctLeft, ctRight)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-			if err = evaluator.Rescale(res, res); err != nil {
-				panic(err)
+			if err = encryption.Rescale(evaluator, res); err != nil {
+				return nil, err
 			}
 			// fmt.Println("Alread Add")
 			res.Scale = ct.Scale
 			if err = evaluator.Add(ct, res, ct); err != nil {
-				panic(err)
+				return nil, err
 			}
 		}
 		newCiphertexts[i] = ct
@@ -950,35 +993,40 @@ func rotateMatrixRows(Mat [][]float64, rotNumber int) [][]float64 {
  * Compute: Q,K,V --> Attention result
  * 1CMul+1Mul
  */
-func PlainMatMultiplyCiphertextTensorThenAdd(param *hefloat.Parameters, evaluator *hefloat.Evaluator, plainMat [][]float64, cipherTensor2 *encryption.CiphertextTensor) (*rlwe.Ciphertext, error) {
+func PlainMatMultiplyCiphertextTensorThenAdd(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, plainMat [][]float64, cipherTensor2 *encryption.CiphertextTensor) (*rlwe.Ciphertext, error) {
 
 	// 确定进行密文矩阵×明文矩阵的维数
 	plainRows := len(plainMat)
 	plainCols := len(plainMat[0])
 	// 判断QKV是否一样
 	if plainCols != cipherTensor2.NumDepth {
-		return &rlwe.Ciphertext{}, fmt.Errorf("NumCols mismatch: plainCols=%d, cipherTensor2.NumDepth=%d", plainCols, cipherTensor2.NumDepth)
+		return &rlwe.Ciphertext{}, &encryption.ShapeError{Op: "plaintext matrix × ciphertext tensor", Want: []int{plainCols}, Got: []int{cipherTensor2.NumDepth}}
 	}
 
 	ct := hefloat.NewCiphertext(*param, cipherTensor2.Ciphertexts[0].Degree(), cipherTensor2.Ciphertexts[0].Level())
 	// 进行BSGS To Attetion
 	for i := 0; i < cipherTensor2.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		plainVec := make([]float64, plainRows)
 		for j := 0; j < plainRows; j++ {
 			plainVec[j] = plainMat[j][i]
 		}
 		plainVec, err := utils.ReaptVector(plainVec, cipherTensor2.NumRows)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		err = evaluator.MulRelinThenAdd(cipherTensor2.Ciphertexts[i], plainVec, ct)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 	}
-	evaluator.Rescale(ct, ct)
+	if err := encryption.Rescale(evaluator, ct); err != nil {
+		return nil, err
+	}
 	// 返回结果
 	return ct, nil
 }
@@ -990,35 +1038,40 @@ func PlainMatMultiplyCiphertextTensorThenAdd(param *hefloat.Parameters, evaluato
  * Compute: Q,K,V --> Attention result
  * 1CMul+1Mul
  */
-func CiphertextTensorMultiplyPlainMatThenAdd(param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor1 *encryption.CiphertextTensor, plainMat [][]float64) (*rlwe.Ciphertext, error) {
+func CiphertextTensorMultiplyPlainMatThenAdd(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor1 *encryption.CiphertextTensor, plainMat [][]float64) (*rlwe.Ciphertext, error) {
 
 	// 确定进行密文矩阵×明文矩阵的维数
 	plainRows := len(plainMat)
 	plainCols := len(plainMat[0])
 	// 判断QKV是否一样
 	if plainRows != cipherTensor1.NumDepth {
-		return &rlwe.Ciphertext{}, fmt.Errorf("NumCols mismatch: plainCols=%d, cipherTensor2.NumDepth=%d", plainRows, cipherTensor1.NumDepth)
+		return &rlwe.Ciphertext{}, &encryption.ShapeError{Op: "ciphertext tensor × plaintext matrix", Want: []int{plainRows}, Got: []int{cipherTensor1.NumDepth}}
 	}
 
 	ct := hefloat.NewCiphertext(*param, cipherTensor1.Ciphertexts[0].Degree(), cipherTensor1.Ciphertexts[0].Level())
 	// 进行BSGS To Attetion
 	for i := 0; i < cipherTensor1.NumDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		plainVec := make([]float64, plainCols)
 		for j := 0; j < plainCols; j++ {
 			plainVec[j] = plainMat[i][j]
 		}
 		plainVec, err := utils.ReaptVector(plainVec, cipherTensor1.NumRows)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 		err = evaluator.MulRelinThenAdd(cipherTensor1.Ciphertexts[i], plainVec, ct)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 	}
-	evaluator.Rescale(ct, ct)
+	if err := encryption.Rescale(evaluator, ct); err != nil {
+		return nil, err
+	}
 	// 返回结果
 	return ct, nil
 }
@@ -1027,7 +1080,7 @@ func GetDiagRotVector(mat [][]float64, i_th int, rotNumber int) ([]float64, erro
 	matRows := len(mat)
 	matCols := len(mat[0])
 	if matRows != matCols {
-		return []float64{}, fmt.Errorf("matrix is not a square matrix (%d,%d)", matRows, matCols)
+		return []float64{}, &encryption.ShapeError{Op: "diagonal of square matrix", Want: []int{matRows, matRows}, Got: []int{matRows, matCols}}
 	}
 	resVec := make([]float64, matRows)
 	rotNumber = (rotNumber + matRows) % matRows
@@ -1037,40 +1090,39 @@ func GetDiagRotVector(mat [][]float64, i_th int, rotNumber int) ([]float64, erro
 	return resVec, nil
 }
 
-func GenerateCipherTensorRot(publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, babyStep, giantStep int) ([]*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, error) {
+func GenerateCipherTensorRot(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, babyStep, giantStep int) ([]*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, error) {
 	// 声明并初始化用于存储旋转结果的数组
 	var X0RotTensorLeft = make([]*encryption.CiphertextTensor, giantStep)
 	var X0RotTensorRight = make([]*encryption.CiphertextTensor, giantStep)
 	var X0RotTensor = make([]*encryption.CiphertextTensor, giantStep)
 	var X0TRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 
 	// 生成旋转所有的步长
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转Q
 			// fmt.Printf("Q:%d,K:%d,V:%d\n", -i*babyStep, i, i)
-			rotX0Left, rotX0Right, err := CipherTensorRotationByColsNotAddMultiThread(evaluator, X0, -i*babyStep, 1, publicKeys.Params.MaxSlots())
+			rotX0Left, rotX0Right, err := CipherTensorRotationByColsNotAddMultiThread(gctx, evaluator, X0, -i*babyStep, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
-			rotX0, err := CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(publicKeys.Params, evaluator, rotX0Left, rotX0Right, -i*babyStep, publicKeys.Params.MaxSlots())
+			rotX0, err := CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(gctx, publicKeys.Params, evaluator, rotX0Left, rotX0Right, -i*babyStep, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			if i < babyStep {
-				rotX0T, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, X0, i, 1, publicKeys.Params.MaxSlots())
+				rotX0T, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, X0, i, 1, publicKeys.Params.MaxSlots())
 				if err != nil {
-					panic(err)
+					return err
 				}
 				X0TRotTensor[i] = rotX0T
 			}
@@ -1079,47 +1131,49 @@ func GenerateCipherTensorRot(publicKeys *encryption.PublicParametersKeys, X0 *en
 			X0RotTensorRight[i] = rotX0Right
 			X0RotTensor[i] = rotX0
 			// mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, nil, nil, err
 	}
-	wg.Wait()
 
 	return X0RotTensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight, nil
 }
 
-func GenerateCipherTensorRot_bak(publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, babyStep, giantStep int) ([]*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, error) {
+func GenerateCipherTensorRot_bak(ctx context.Context, publicKeys *encryption.PublicParametersKeys, X0 *encryption.CiphertextTensor, babyStep, giantStep int) ([]*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, []*encryption.CiphertextTensor, error) {
 	// 声明并初始化用于存储旋转结果的数组
 	var X0RotTensorLeft = make([]*encryption.CiphertextTensor, giantStep)
 	var X0RotTensorRight = make([]*encryption.CiphertextTensor, giantStep)
 	var X0RotTensor = make([]*encryption.CiphertextTensor, giantStep)
 	var X0TRotTensor = make([]*encryption.CiphertextTensor, babyStep)
 
-	// 设置最大使用的操作系统线程数为 8
-	runtime.GOMAXPROCS(4)
-	// 创建一个 WaitGroup 来等待所有 goroutine 完成
-	var wg sync.WaitGroup
+	// 等待所有 goroutine 完成，返回第一个错误
+	g, gctx := utils.WithContext(ctx)
 	// 使用一个互斥锁来保护对 newCiphertexts 的并发访问
 	// var mu sync.Mutex
 
 	// 生成旋转所有的步长
 	for i := 0; i < giantStep; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
+		g.Go(func() error {
+			if err := gctx.Err(); err != nil {
+				return err
+			}
 			evaluator := publicKeys.Evaluator.ShallowCopy()
 			// 旋转Q
 			// fmt.Printf("Q:%d,K:%d,V:%d\n", -i*babyStep, i, i)
-			rotX0Left, rotX0Right, err := CipherTensorRotationByColsNotAddMultiThread(evaluator, X0, -i*babyStep, 1, publicKeys.Params.MaxSlots())
+			rotX0Left, rotX0Right, err := CipherTensorRotationByColsNotAddMultiThread(gctx, evaluator, X0, -i*babyStep, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
-			rotX0, err := CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(publicKeys.Params, evaluator, rotX0Left, rotX0Right, -i*babyStep, publicKeys.Params.MaxSlots())
+			rotX0, err := CipherTensorMulPlainMatAll1WithLeftAndRightTensorMultiThread(gctx, publicKeys.Params, evaluator, rotX0Left, rotX0Right, -i*babyStep, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 
-			rotX0T, err := CiphertextTensorRotationByColsNewMultiThread(evaluator, X0, i, 1, publicKeys.Params.MaxSlots())
+			rotX0T, err := CiphertextTensorRotationByColsNewMultiThread(gctx, evaluator, X0, i, 1, publicKeys.Params.MaxSlots())
 			if err != nil {
-				panic(err)
+				return err
 			}
 			// mu.Lock()
 			X0RotTensorLeft[i] = rotX0Left
@@ -1129,9 +1183,12 @@ func GenerateCipherTensorRot_bak(publicKeys *encryption.PublicParametersKeys, X0
 				X0TRotTensor[i] = rotX0T
 			}
 			// mu.Unlock()
-		}(i)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, nil, nil, err
 	}
-	wg.Wait()
 	return X0RotTensor, X0TRotTensor, X0RotTensorLeft, X0RotTensorRight, nil
 }
 
@@ -1146,7 +1203,7 @@ func GenerateCipherTensorRot_bak(publicKeys *encryption.PublicParametersKeys, X0
  * |0 0 0|       |0 0 0|
  * 1CMul
  */
-func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, baseVector []float64, plainMat [][]float64, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
+func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(ctx context.Context, param *hefloat.Parameters, evaluator *hefloat.Evaluator, cipherTensor *encryption.CiphertextTensor, baseVector []float64, plainMat [][]float64, rotNumber int, Slots int) (*encryption.CiphertextTensor, error) {
 
 	// 返回密文张量维数
 	cipherTensorRows := cipherTensor.NumRows
@@ -1166,12 +1223,15 @@ func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(param *hefl
 	}
 
 	if len(baseVector) != cipherTensorCols || plainRows != cipherTensorDepth {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the baseVector length %d is not equal cipherTensorCols %d", len(baseVector), cipherTensorCols)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "rotate and multiply plaintext matrix", Want: []int{cipherTensorCols, cipherTensorDepth}, Got: []int{len(baseVector), plainRows}}
 	}
 
 	newCiphertextsLeft := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	newCiphertextsRight := make([]*rlwe.Ciphertext, cipherTensorDepth)
 	for i := 0; i < cipherTensorDepth; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		// ctLeft, err := evaluator.MulRelinNew(cipherTensor.Ciphertexts[i], rotLeftVector)
 		// if err != nil {
 		// 	panic(err)
@@ -1182,13 +1242,13 @@ func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(param *hefl
 		// }
 
 		// fmt.Printf("i-th:%d, rotLeft:%d, rotRight:%d\n", i, rotNumber, (rotNumber-cipherTensorCols+Slots)%Slots)
-		ctLeft, err := evaluator.RotateNew(cipherTensor.Ciphertexts[i], rotNumber)
+		ctLeft, err := encryption.RotateNew(evaluator, cipherTensor.Ciphertexts[i], rotNumber)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		ctRight, err := evaluator.RotateNew(cipherTensor.Ciphertexts[i], (rotNumber-cipherTensorCols+Slots)%Slots)
+		ctRight, err := encryption.RotateNew(evaluator, cipherTensor.Ciphertexts[i], (rotNumber-cipherTensorCols+Slots)%Slots)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		newCiphertextsLeft[i] = ctLeft
 		newCiphertextsRight[i] = ctRight
@@ -1197,33 +1257,36 @@ func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(param *hefl
 	// 先生成明文向量
 	newCiphertexts := make([]*rlwe.Ciphertext, plainCols)
 	for i := 0; i < plainCols; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		ct := hefloat.NewCiphertext(*param, newCiphertextsLeft[0].Degree(), newCiphertextsLeft[0].Level())
 		for j := 0; j < cipherTensorDepth; j++ {
 			rotLeftVector, rotRightVector, err := GeneratePlainVecLeftAndRightWithVec(cipherTensorRows, cipherTensorCols, rotNumber, baseVector, plainMat[j][i])
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			ctLeft, err := evaluator.MulRelinNew(newCiphertextsLeft[j], rotLeftVector)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			ctRight, err := evaluator.MulRelinNew(newCiphertextsRight[j], rotRightVector)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			// fmt.Println("Alread Mul")
 			ctLeft.Scale = ctRight.Scale
 			res, err := evaluator.AddNew(ctLeft, ctRight)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-			if err = evaluator.Rescale(res, res); err != nil {
-				panic(err)
+			if err = encryption.Rescale(evaluator, res); err != nil {
+				return nil, err
 			}
 			// fmt.Println("Alread Add")
 			res.Scale = ct.Scale
 			if err = evaluator.Add(ct, res, ct); err != nil {
-				panic(err)
+				return nil, err
 			}
 		}
 		newCiphertexts[i] = ct
@@ -1242,7 +1305,7 @@ func PlainVecCipherTensorMulPlainMatWithRotationByColsNewMultiThread(param *hefl
 	}, nil
 }
 
-func CiphertextTensorAddPlaintextMatrixWithEvaluator(evaluator *hefloat.Evaluator, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
+func CiphertextTensorAddPlaintextMatrixWithEvaluator(ctx context.Context, evaluator *hefloat.Evaluator, ciphertextTensor *encryption.CiphertextTensor, plainSlice [][]float64) (*encryption.CiphertextTensor, error) {
 	// 返回维数
 	cipherRows := ciphertextTensor.NumRows
 	cipherCols := ciphertextTensor.NumCols
@@ -1256,7 +1319,7 @@ func CiphertextTensorAddPlaintextMatrixWithEvaluator(evaluator *hefloat.Evaluato
 
 	// 实际上，cipherCols必须等于plainRows; cipherDepth必须等于plainCols
 	if cipherCols != plainRows || cipherDepth != plainCols {
-		return &encryption.CiphertextTensor{}, fmt.Errorf("the ciphertext tensor cannot Add plaintext matrix: expected  (%d,%d), got (%d,%d)", plainRows, plainCols, cipherCols, cipherDepth)
+		return &encryption.CiphertextTensor{}, &encryption.ShapeError{Op: "add plaintext matrix", Want: []int{plainRows, plainCols}, Got: []int{cipherCols, cipherDepth}}
 	}
 
 	newCiphertexts := make([]*rlwe.Ciphertext, cipherDepth)
@@ -1270,7 +1333,7 @@ func CiphertextTensorAddPlaintextMatrixWithEvaluator(evaluator *hefloat.Evaluato
 		}
 		newCiphertexts[i], err = evaluator.AddNew(ciphertextTensor.Ciphertexts[i], plainVector)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
 	}
@@ -0,0 +1,361 @@
+// Package polyfit 为密文计算中的激活函数拟合多项式：区间 [a, b] 上的 Chebyshev 插值或 Remez 最佳一致逼近，
+// 结果是 maths.ApproximatePolynomialCipherTensorMultiThread 使用的单项式基系数 (从 x^0 开始，变量为 x 本身)。
+package polyfit
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Activation 是可以拟合的函数：默认的区间和次数，以及系数在 model.json 中的用途 (为空时只报告系数)
+type Activation struct {
+	Name     string
+	Func     func(float64) float64
+	Interval [2]float64
+	Degree   int
+	Role     string
+}
+
+// activations 的默认区间和次数和发布模型的近似一致：ReLU 的定义域和 main.go 中的 reluDomain 相同，
+// inverse sqrt 是 LayerNorm 的 1/sqrt(variance)
+var activations = []Activation{
+	{"relu", func(x float64) float64 { return math.Max(x, 0) }, [2]float64{-50, 40}, 6, "relu_coefficients"},
+	{"gelu", func(x float64) float64 { return 0.5 * x * (1 + math.Erf(x/math.Sqrt2)) }, [2]float64{-50, 40}, 6, "relu_coefficients"},
+	{"exp", math.Exp, [2]float64{-8, 0}, 6, ""},
+	{"invsqrt", func(x float64) float64 { return 1 / math.Sqrt(x) }, [2]float64{15, 100}, 5, "sqrt_layer1_coefficients"},
+}
+
+// LookupActivation 返回名为 name 的函数
+func LookupActivation(name string) (Activation, error) {
+	for _, a := range activations {
+		if a.Name == name {
+			return a, nil
+		}
+	}
+	return Activation{}, fmt.Errorf("unknown activation %q, use %s", name, strings.Join(ActivationNames(), ", "))
+}
+
+// ActivationNames 返回可以拟合的函数名
+func ActivationNames() []string {
+	names := make([]string, len(activations))
+	for i, a := range activations {
+		names[i] = a.Name
+	}
+	return names
+}
+
+// checkInterval 检查区间和次数，invsqrt 等函数须在区间上有定义
+func checkInterval(f func(float64) float64, a, b float64, degree int) error {
+	if !(a < b) {
+		return fmt.Errorf("the interval [%g, %g] is empty", a, b)
+	}
+	if degree < 1 {
+		return fmt.Errorf("the degree must be at least 1, got %d", degree)
+	}
+	for _, x := range []float64{a, (a + b) / 2, b} {
+		if y := f(x); math.IsNaN(y) || math.IsInf(y, 0) {
+			return fmt.Errorf("the function is not defined at %g", x)
+		}
+	}
+	return nil
+}
+
+/*
+ * Chebyshev
+ * Input:  f,区间 [a, b],degree
+ * Output: 单项式基系数 Slice[] (degree+1 个),error
+ * Compute: 在 degree+1 个 Chebyshev 节点上插值 f，接近最佳一致逼近，光滑的函数误差随次数指数下降
+ */
+func Chebyshev(f func(float64) float64, a, b float64, degree int) ([]float64, error) {
+	if err := checkInterval(f, a, b, degree); err != nil {
+		return nil, err
+	}
+	n := degree + 1
+	values := make([]float64, n)
+	nodes := make([]float64, n)
+	for k := range nodes {
+		nodes[k] = math.Cos(math.Pi * (float64(k) + 0.5) / float64(n))
+		values[k] = f(fromUnit(nodes[k], a, b))
+	}
+	cheb := make([]float64, n)
+	for j := range cheb {
+		for k, t := range nodes {
+			cheb[j] += values[k] * chebyshevT(j, t)
+		}
+		cheb[j] *= 2 / float64(n)
+	}
+	cheb[0] /= 2
+	return toMonomial(cheb, a, b), nil
+}
+
+// remezIterations 是 Remez 交换的最多次数，remezGrid 是寻找误差极值的均匀网格的点数
+const (
+	remezIterations = 100
+	remezGrid       = 20001
+)
+
+/*
+ * Remez
+ * Input:  f,区间 [a, b],degree
+ * Output: 单项式基系数 Slice[] (degree+1 个),error
+ * Compute: Remez 交换求最佳一致逼近：在 degree+2 个参考点上解 p(x_i) + (-1)^i E = f(x_i)，
+ *          把参考点换成误差的交替极值点，直到各极值的绝对值相同；ReLU 这样不光滑的函数也适用。
+ *          返回各次迭代中最大误差最小的多项式
+ */
+func Remez(f func(float64) float64, a, b float64, degree int) ([]float64, error) {
+	if err := checkInterval(f, a, b, degree); err != nil {
+		return nil, err
+	}
+	n := degree + 1
+	// 初始参考点是 T_{degree+1} 的极值点
+	reference := make([]float64, n+1)
+	for i := range reference {
+		reference[i] = -math.Cos(math.Pi * float64(i) / float64(n))
+	}
+	grid := make([]float64, remezGrid)
+	gridValues := make([]float64, remezGrid)
+	for i := range grid {
+		grid[i] = -1 + 2*float64(i)/float64(remezGrid-1)
+		gridValues[i] = f(fromUnit(grid[i], a, b))
+	}
+
+	var best []float64
+	bestError := math.Inf(1)
+	for iter := 0; iter < remezIterations; iter++ {
+		// Chebyshev 基下的线性方程组，最后一个未知数是 E
+		system := make([][]float64, n+1)
+		rhs := make([]float64, n+1)
+		for i, t := range reference {
+			system[i] = make([]float64, n+1)
+			for j := 0; j < n; j++ {
+				system[i][j] = chebyshevT(j, t)
+			}
+			system[i][n] = 1 - 2*float64(i%2)
+			rhs[i] = f(fromUnit(t, a, b))
+		}
+		solution, err := solve(system, rhs)
+		if err != nil {
+			break
+		}
+		cheb := solution[:n]
+
+		errs := make([]float64, remezGrid)
+		maxError := 0.0
+		for i, t := range grid {
+			errs[i] = gridValues[i] - chebyshevSum(cheb, t)
+			maxError = math.Max(maxError, math.Abs(errs[i]))
+		}
+		if maxError < bestError {
+			best, bestError = append([]float64(nil), cheb...), maxError
+		}
+
+		extrema := alternatingExtrema(errs, n+1)
+		if len(extrema) < n+1 {
+			break
+		}
+		minExtremum := math.Inf(1)
+		for i, k := range extrema {
+			reference[i] = grid[k]
+			minExtremum = math.Min(minExtremum, math.Abs(errs[k]))
+		}
+		// 各极值相差不到 1e-6 时已是 (网格上的) 最佳逼近
+		if maxError-minExtremum <= 1e-6*maxError {
+			break
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("the Remez exchange failed for degree %d on [%g, %g]", degree, a, b)
+	}
+	return toMonomial(best, a, b), nil
+}
+
+// alternatingExtrema 返回误差在每个同号区间上绝对值最大的点的下标，相邻的点误差符号相反；
+// 多于 count 个时从两端去掉较小的极值
+func alternatingExtrema(errs []float64, count int) []int {
+	var extrema []int
+	for i, e := range errs {
+		if e == 0 {
+			continue
+		}
+		if len(extrema) > 0 {
+			last := extrema[len(extrema)-1]
+			if (errs[last] > 0) == (e > 0) {
+				if math.Abs(e) > math.Abs(errs[last]) {
+					extrema[len(extrema)-1] = i
+				}
+				continue
+			}
+		}
+		extrema = append(extrema, i)
+	}
+	for len(extrema) > count {
+		if math.Abs(errs[extrema[0]]) < math.Abs(errs[extrema[len(extrema)-1]]) {
+			extrema = extrema[1:]
+		} else {
+			extrema = extrema[:len(extrema)-1]
+		}
+	}
+	return extrema
+}
+
+// solve 用部分主元的 Gauss 消元解 m x = rhs (会修改 m 和 rhs)
+func solve(m [][]float64, rhs []float64) ([]float64, error) {
+	n := len(rhs)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if m[pivot][col] == 0 {
+			return nil, fmt.Errorf("singular system")
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		rhs[col], rhs[pivot] = rhs[pivot], rhs[col]
+		for row := col + 1; row < n; row++ {
+			factor := m[row][col] / m[col][col]
+			for k := col; k < n; k++ {
+				m[row][k] -= factor * m[col][k]
+			}
+			rhs[row] -= factor * rhs[col]
+		}
+	}
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := rhs[row]
+		for k := row + 1; k < n; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+	return x, nil
+}
+
+// fromUnit 把 [-1, 1] 中的 t 映射到 [a, b]
+func fromUnit(t, a, b float64) float64 {
+	return (b-a)/2*t + (a+b)/2
+}
+
+// chebyshevT 返回 T_j(t)
+func chebyshevT(j int, t float64) float64 {
+	prev, cur := 1.0, t
+	if j == 0 {
+		return prev
+	}
+	for k := 1; k < j; k++ {
+		prev, cur = cur, 2*t*cur-prev
+	}
+	return cur
+}
+
+// chebyshevSum 返回 Σ cheb[j] T_j(t)
+func chebyshevSum(cheb []float64, t float64) float64 {
+	sum := 0.0
+	for j, c := range cheb {
+		sum += c * chebyshevT(j, t)
+	}
+	return sum
+}
+
+// toMonomial 把 [a, b] 上的 Chebyshev 基系数 (变量 t = (2x-a-b)/(b-a)) 转换为 x 的单项式基系数
+func toMonomial(cheb []float64, a, b float64) []float64 {
+	n := len(cheb)
+	// t 的单项式基：T_0 = 1, T_1 = t, T_{k+1} = 2t T_k - T_{k-1}
+	inT := make([]float64, n)
+	prev, cur := make([]float64, n), make([]float64, n)
+	prev[0] = 1
+	if n > 1 {
+		cur[1] = 1
+	}
+	for j, c := range cheb {
+		var tj []float64
+		switch j {
+		case 0:
+			tj = prev
+		case 1:
+			tj = cur
+		default:
+			next := make([]float64, n)
+			for k := 0; k < n-1; k++ {
+				next[k+1] += 2 * cur[k]
+			}
+			for k := range next {
+				next[k] -= prev[k]
+			}
+			prev, cur = cur, next
+			tj = cur
+		}
+		for k := range inT {
+			inT[k] += c * tj[k]
+		}
+	}
+
+	// 代入 t = alpha x + beta (Horner)
+	alpha, beta := 2/(b-a), -(a+b)/(b-a)
+	inX := make([]float64, n)
+	for k := n - 1; k >= 0; k-- {
+		next := make([]float64, n)
+		for i, c := range inX {
+			// inX 的次数小于 n-1-k，乘 t 后不超过 n-1
+			if i+1 < n {
+				next[i+1] += alpha * c
+			}
+			next[i] += beta * c
+		}
+		next[0] += inT[k]
+		inX = next
+	}
+	return inX
+}
+
+// Evaluate 返回 Σ coeffs[k] x^k
+func Evaluate(coeffs []float64, x float64) float64 {
+	y := 0.0
+	for k := len(coeffs) - 1; k >= 0; k-- {
+		y = y*x + coeffs[k]
+	}
+	return y
+}
+
+// Errors 是多项式在区间上的绝对误差
+type Errors struct {
+	Max   float64
+	MaxAt float64
+	Mean  float64
+}
+
+// errorSamples 是 MeasureErrors 在区间上均匀取的点数
+const errorSamples = 10001
+
+// MeasureErrors 返回 coeffs 在 [a, b] 上均匀的 errorSamples 个点处和 f 的最大和平均绝对误差
+func MeasureErrors(f func(float64) float64, coeffs []float64, a, b float64) Errors {
+	var e Errors
+	for i := 0; i < errorSamples; i++ {
+		x := a + (b-a)*float64(i)/float64(errorSamples-1)
+		absError := math.Abs(f(x) - Evaluate(coeffs, x))
+		if absError > e.Max {
+			e.Max, e.MaxAt = absError, x
+		}
+		e.Mean += absError
+	}
+	e.Mean /= errorSamples
+	return e
+}
+
+// Methods 返回拟合的方法名
+func Methods() []string {
+	return []string{"chebyshev", "remez"}
+}
+
+// Fit 用 method (chebyshev 或 remez) 拟合 f
+func Fit(method string, f func(float64) float64, a, b float64, degree int) ([]float64, error) {
+	switch method {
+	case "chebyshev":
+		return Chebyshev(f, a, b, degree)
+	case "remez":
+		return Remez(f, a, b, degree)
+	}
+	return nil, fmt.Errorf("unknown method %q, use %s", method, strings.Join(Methods(), " or "))
+}
@@ -0,0 +1,94 @@
+package polyfit
+
+import (
+	"math"
+	"testing"
+
+	"dashformer/config"
+)
+
+func TestChebyshevPolynomial(t *testing.T) {
+	// 次数足够时插值还原多项式本身
+	want := []float64{1, -2, 0.5, 0.25}
+	got, err := Chebyshev(func(x float64) float64 { return Evaluate(want, x) }, -3, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range want {
+		if math.Abs(got[k]-want[k]) > 1e-9 {
+			t.Errorf("coefficient %d: got %g, want %g", k, got[k], want[k])
+		}
+	}
+}
+
+func TestRemez(t *testing.T) {
+	// 最佳一致逼近的误差不超过 Chebyshev 插值，且比发布模型的 ReLU 系数小
+	for _, name := range ActivationNames() {
+		act, err := LookupActivation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, b := act.Interval[0], act.Interval[1]
+		cheb, err := Fit("chebyshev", act.Func, a, b, act.Degree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remez, err := Fit("remez", act.Func, a, b, act.Degree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(remez) != act.Degree+1 {
+			t.Errorf("%s: %d coefficients", name, len(remez))
+		}
+		chebErrors, remezErrors := MeasureErrors(act.Func, cheb, a, b), MeasureErrors(act.Func, remez, a, b)
+		if remezErrors.Max > chebErrors.Max*1.001 {
+			t.Errorf("%s: remez error %g above chebyshev %g", name, remezErrors.Max, chebErrors.Max)
+		}
+		t.Logf("%s: chebyshev %g, remez %g", name, chebErrors.Max, remezErrors.Max)
+	}
+
+	relu, _ := LookupActivation("relu")
+	coeffs, err := Remez(relu.Func, -50, 40, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fitted := MeasureErrors(relu.Func, coeffs, -50, 40)
+	released := MeasureErrors(relu.Func, config.DefaultCoefficients().Relu, -50, 40)
+	if fitted.Max > 1.2 || fitted.Max > released.Max {
+		t.Errorf("relu max error %g, released coefficients %g", fitted.Max, released.Max)
+	}
+
+	// 误差在区间的两端取到最大值 (等振荡)
+	exp := func(x float64) float64 { return math.Exp(x) }
+	coeffs, err = Remez(exp, -1, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := MeasureErrors(exp, coeffs, -1, 1)
+	for _, x := range []float64{-1, 1} {
+		if d := math.Abs(exp(x) - Evaluate(coeffs, x)); math.Abs(d-e.Max) > 1e-3*e.Max {
+			t.Errorf("error %g at %g, max %g", d, x, e.Max)
+		}
+	}
+}
+
+func TestFitErrors(t *testing.T) {
+	invsqrt, _ := LookupActivation("invsqrt")
+	for _, tc := range []struct {
+		method string
+		a, b   float64
+		degree int
+	}{
+		{"remez", -1, 10, 3},
+		{"remez", 10, 1, 3},
+		{"chebyshev", 1, 10, 0},
+		{"least-squares", 1, 10, 3},
+	} {
+		if _, err := Fit(tc.method, invsqrt.Func, tc.a, tc.b, tc.degree); err == nil {
+			t.Errorf("%s on [%g, %g] degree %d: no error", tc.method, tc.a, tc.b, tc.degree)
+		}
+	}
+	if _, err := LookupActivation("tanh"); err == nil {
+		t.Error("tanh: no error")
+	}
+}
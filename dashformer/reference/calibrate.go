@@ -0,0 +1,270 @@
+package reference
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"dashformer/utils"
+)
+
+// CalibrationPercentiles 是 Range 记录的百分位数
+var CalibrationPercentiles = []float64{0.1, 1, 50, 99, 99.9}
+
+// Range 是一组值的范围和 CalibrationPercentiles 处的百分位数
+type Range struct {
+	Min         float64
+	Max         float64
+	Percentiles []float64
+}
+
+// newRange 返回 values 的 Range，百分位数在排序后的值之间线性插值
+func newRange(values []float64) Range {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	r := Range{Min: sorted[0], Max: sorted[len(sorted)-1], Percentiles: make([]float64, len(CalibrationPercentiles))}
+	for i, p := range CalibrationPercentiles {
+		pos := p / 100 * float64(len(sorted)-1)
+		lo := int(pos)
+		hi := min(lo+1, len(sorted)-1)
+		r.Percentiles[i] = sorted[lo] + (pos-float64(lo))*(sorted[hi]-sorted[lo])
+	}
+	return r
+}
+
+// Widen 返回两端各加宽 margin × (Max - Min) 的区间
+func (r Range) Widen(margin float64) [2]float64 {
+	w := margin * (r.Max - r.Min)
+	return [2]float64{r.Min - w, r.Max + w}
+}
+
+// SoftmaxProposal 是一个注意力头的 softmax 常数，近似的权重为 (x+b)^2/c
+type SoftmaxProposal struct {
+	B float64
+	C float64
+	// 近似的权重和 softmax 的 L1 距离 (每行的平均)，使用模型当前的常数和建议的常数
+	CurrentError  float64
+	ProposedError float64
+}
+
+// BlockCalibration 是一个 Transformer 块在校准序列上的中间值范围和建议的 softmax 常数
+type BlockCalibration struct {
+	Block int
+	// 每个头的注意力分数 q·k/sqrt(d_k)
+	Scores []Range
+	// FFN1 的输出，ReLU 多项式的输入
+	PreActivations Range
+	// LayerNorm1/2 输入的方差，1/sqrt 多项式的输入
+	Variances [2]Range
+	Softmax   []SoftmaxProposal
+}
+
+// Calibration 是 Calibrate 的结果
+type Calibration struct {
+	Mode      Mode
+	Margin    float64
+	Sequences int
+	Blocks    []BlockCalibration
+	// 所有块共用 ReLU 多项式和 LayerNorm1/2 的 1/sqrt 多项式，定义域覆盖所有块
+	ReluDomain    [2]float64
+	InvSqrtDomain [2][2]float64
+}
+
+/*
+ * Calibrate
+ * Input:  DashformerModelParameters,one-hot 校准序列 Slice[][][] (序列数 × 序列长度 × 词表大小),Mode,margin
+ * Output: Calibration,error
+ * Compute: 在 mode 的前向传播中记录每个块的注意力分数、ReLU 的输入和 LayerNorm 输入的方差的范围和百分位数；
+ *          建议的定义域是 [min, max] 两端各加宽 margin × (max - min) (方差的下界缩小为 min/(1+margin))，
+ *          softmax 常数 b 不小于加宽后分数的下界的相反数 (使 (x+b)^2 在分数的范围内单调)，
+ *          在此之上使近似的权重和 softmax 的 L1 距离最小 (见 fitSoftmaxShift)，
+ *          c 为每行 Σ(x+b)^2 的平均，使近似的权重每行的和平均为 1
+ */
+func Calibrate(dashModelParam utils.DashformerModelParameters, input [][][]float64, mode Mode, margin float64) (Calibration, error) {
+	if err := checkShapes(dashModelParam); err != nil {
+		return Calibration{}, err
+	}
+	if len(input) == 0 {
+		return Calibration{}, fmt.Errorf("no sequence to calibrate on")
+	}
+	if margin < 0 {
+		return Calibration{}, fmt.Errorf("the margin must not be negative, got %g", margin)
+	}
+
+	numBlocks, heads := dashModelParam.NumBlocks(), len(dashModelParam.QueryWeightAttentionMatrixs)
+	// 每个块每个头的分数按行保存，用于计算 c 和误差
+	scoreRows := make([][][][]float64, numBlocks)
+	preActivations := make([][]float64, numBlocks)
+	variances := make([][2][]float64, numBlocks)
+	for k := range scoreRows {
+		scoreRows[k] = make([][][]float64, heads)
+	}
+	for i, sequence := range input {
+		_, err := forwardSequence(dashModelParam, sequence, mode, func(k int, trace *blockTrace) {
+			for h, scores := range trace.scores {
+				scoreRows[k][h] = append(scoreRows[k][h], scores...)
+			}
+			for _, row := range trace.preActivations {
+				preActivations[k] = append(preActivations[k], row...)
+			}
+			for l := range trace.variances {
+				variances[k][l] = append(variances[k][l], trace.variances[l]...)
+			}
+		})
+		if err != nil {
+			return Calibration{}, fmt.Errorf("sequence %d: %v", i, err)
+		}
+	}
+
+	c := Calibration{Mode: mode, Margin: margin, Sequences: len(input), Blocks: make([]BlockCalibration, numBlocks)}
+	for k := range c.Blocks {
+		// Approximate 的多项式在定义域外增长很快，溢出时没有可以建议的范围
+		names := []string{"relu input", "layernorm1 variance", "layernorm2 variance"}
+		values := [][]float64{preActivations[k], variances[k][0], variances[k][1]}
+		for h := range scoreRows[k] {
+			names = append(names, fmt.Sprintf("attention score of head %d", h))
+			values = append(values, slices.Concat(scoreRows[k][h]...))
+		}
+		for i, v := range values {
+			if slices.ContainsFunc(v, func(x float64) bool { return math.IsNaN(x) || math.IsInf(x, 0) }) {
+				return Calibration{}, fmt.Errorf("block %d: the %s overflows in the %v forward pass", k, names[i], mode)
+			}
+		}
+		block := dashModelParam.Block(k)
+		bc := BlockCalibration{Block: k, PreActivations: newRange(preActivations[k])}
+		for l := range bc.Variances {
+			bc.Variances[l] = newRange(variances[k][l])
+		}
+		for h := 0; h < heads; h++ {
+			scores := newRange(slices.Concat(scoreRows[k][h]...))
+			exact := make([][]float64, len(scoreRows[k][h]))
+			for i, row := range scoreRows[k][h] {
+				exact[i] = slices.Clone(row)
+				softmax(exact[i])
+			}
+			b := fitSoftmaxShift(scoreRows[k][h], exact, math.Max(0, -scores.Widen(margin)[0]), scores.Max-scores.Min)
+			proposal := SoftmaxProposal{B: b, C: meanRowSquares(scoreRows[k][h], b)}
+			proposal.CurrentError = softmaxError(scoreRows[k][h], exact, block.SoftMaxB[h], block.SoftMaxC[h])
+			proposal.ProposedError = softmaxError(scoreRows[k][h], exact, proposal.B, proposal.C)
+			bc.Scores = append(bc.Scores, scores)
+			bc.Softmax = append(bc.Softmax, proposal)
+		}
+		c.Blocks[k] = bc
+
+		relu := bc.PreActivations.Widen(margin)
+		if k == 0 {
+			c.ReluDomain = relu
+		}
+		c.ReluDomain = [2]float64{math.Min(c.ReluDomain[0], relu[0]), math.Max(c.ReluDomain[1], relu[1])}
+		for l, v := range bc.Variances {
+			domain := [2]float64{v.Min / (1 + margin), v.Widen(margin)[1]}
+			if k == 0 {
+				c.InvSqrtDomain[l] = domain
+			}
+			c.InvSqrtDomain[l] = [2]float64{math.Min(c.InvSqrtDomain[l][0], domain[0]), math.Max(c.InvSqrtDomain[l][1], domain[1])}
+		}
+	}
+	return c, nil
+}
+
+// meanRowSquares 返回每行 Σ(x+b)^2 的平均
+func meanRowSquares(rows [][]float64, b float64) float64 {
+	total := 0.0
+	for _, row := range rows {
+		for _, x := range row {
+			total += (x + b) * (x + b)
+		}
+	}
+	return total / float64(len(rows))
+}
+
+// softmaxError 返回近似的权重 (x+b)^2/c 和 softmax 的权重 exact 的 L1 距离，每行的平均
+func softmaxError(rows, exact [][]float64, b, c float64) float64 {
+	total := 0.0
+	for i, row := range rows {
+		for j, x := range row {
+			total += math.Abs((x+b)*(x+b)/c - exact[i][j])
+		}
+	}
+	return total / float64(len(rows))
+}
+
+// softmaxShiftSteps 是 fitSoftmaxShift 粗搜索的点数
+const softmaxShiftSteps = 100
+
+// fitSoftmaxShift 在 [lower, lower + 4 + 2*spread] 上搜索使 softmaxError 最小的 b (c 取 meanRowSquares)：
+// 先在等距的点上搜索，再在最好的点两侧用黄金分割法细化。分数接近 0 时 (x+2)^2/4 和 exp(x) 的一阶展开相同，
+// 分数的范围大时最好的 b 接近下界
+func fitSoftmaxShift(rows, exact [][]float64, lower, spread float64) float64 {
+	cost := func(b float64) float64 {
+		return softmaxError(rows, exact, b, meanRowSquares(rows, b))
+	}
+	step := (4 + 2*spread) / softmaxShiftSteps
+	best, bestCost := lower, cost(lower)
+	for i := 1; i <= softmaxShiftSteps; i++ {
+		if c := cost(lower + float64(i)*step); c < bestCost {
+			best, bestCost = lower+float64(i)*step, c
+		}
+	}
+
+	lo, hi := math.Max(lower, best-step), best+step
+	ratio := (math.Sqrt(5) - 1) / 2
+	x1, x2 := hi-ratio*(hi-lo), lo+ratio*(hi-lo)
+	c1, c2 := cost(x1), cost(x2)
+	for i := 0; i < 30; i++ {
+		if c1 < c2 {
+			hi, x2, c2 = x2, x1, c1
+			x1 = hi - ratio*(hi-lo)
+			c1 = cost(x1)
+		} else {
+			lo, x1, c1 = x1, x2, c2
+			x2 = lo + ratio*(hi-lo)
+			c2 = cost(x2)
+		}
+	}
+	if b := (lo + hi) / 2; cost(b) < bestCost {
+		return b
+	}
+	return best
+}
+
+// PrintCalibration 把每个块的中间值范围、建议的定义域和 softmax 常数写到 w
+func PrintCalibration(w io.Writer, c Calibration) {
+	percentiles := make([]string, len(CalibrationPercentiles))
+	for i, p := range CalibrationPercentiles {
+		percentiles[i] = fmt.Sprintf("p%g", p)
+	}
+	fmt.Fprintf(w, "# %d sequences, %v forward pass, margin %g\n", c.Sequences, c.Mode, c.Margin)
+	fmt.Fprintf(w, "block\tvalue\tmin\t%s\tmax\n", strings.Join(percentiles, "\t"))
+	printRange := func(block int, name string, r Range) {
+		values := make([]string, len(r.Percentiles))
+		for i, v := range r.Percentiles {
+			values[i] = fmt.Sprintf("%.6g", v)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.6g\t%s\t%.6g\n", block, name, r.Min, strings.Join(values, "\t"), r.Max)
+	}
+	for _, b := range c.Blocks {
+		for h, r := range b.Scores {
+			printRange(b.Block, fmt.Sprintf("attention_score_head%d", h), r)
+		}
+		printRange(b.Block, "relu_input", b.PreActivations)
+		printRange(b.Block, "layernorm1_variance", b.Variances[0])
+		printRange(b.Block, "layernorm2_variance", b.Variances[1])
+	}
+
+	fmt.Fprintln(w, "# softmax (x+b)^2/c: proposed constants, mean L1 distance of a row of weights to softmax with them and with the current ones")
+	fmt.Fprintln(w, "block\thead\tb\tc\terror\tcurrent_error")
+	for _, b := range c.Blocks {
+		for h, p := range b.Softmax {
+			fmt.Fprintf(w, "%d\t%d\t%.6g\t%.6g\t%.6g\t%.6g\n", b.Block, h, p.B, p.C, p.ProposedError, p.CurrentError)
+		}
+	}
+
+	fmt.Fprintln(w, "# proposed domains")
+	fmt.Fprintf(w, "relu\t[%.6g, %.6g]\n", c.ReluDomain[0], c.ReluDomain[1])
+	for l, d := range c.InvSqrtDomain {
+		fmt.Fprintf(w, "layernorm%d_inv_sqrt\t[%.6g, %.6g]\n", l+1, d[0], d[1])
+	}
+}
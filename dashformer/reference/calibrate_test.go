@@ -0,0 +1,90 @@
+package reference
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"dashformer/utils"
+)
+
+func TestRange(t *testing.T) {
+	values := make([]float64, 1001)
+	for i := range values {
+		values[i] = float64(1000 - i)
+	}
+	r := newRange(values)
+	if r.Min != 0 || r.Max != 1000 {
+		t.Errorf("range [%g, %g], want [0, 1000]", r.Min, r.Max)
+	}
+	for i, p := range CalibrationPercentiles {
+		if math.Abs(r.Percentiles[i]-10*p) > 1e-9 {
+			t.Errorf("p%g: got %g, want %g", p, r.Percentiles[i], 10*p)
+		}
+	}
+	if got := r.Widen(0.1); got != [2]float64{-100, 1100} {
+		t.Errorf("widened to %v", got)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	r := rand.New(rand.NewSource(9))
+	shape := testShape()
+	dash := utils.RandomModel(r, shape, 0.1)
+	var input [][][]float64
+	for i := 0; i < 3; i++ {
+		input = append(input, randomSequence(r, shape.SeqLength, shape.VocabSize))
+	}
+
+	c, err := Calibrate(dash, input, Approximate, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Blocks) != 1 || len(c.Blocks[0].Scores) != shape.Heads || len(c.Blocks[0].Softmax) != shape.Heads {
+		t.Fatalf("got %d blocks, %+v", len(c.Blocks), c.Blocks)
+	}
+	block := c.Blocks[0]
+	pre := block.PreActivations
+	if c.ReluDomain[0] >= pre.Min || c.ReluDomain[1] <= pre.Max || pre.Percentiles[2] < pre.Min || pre.Percentiles[2] > pre.Max {
+		t.Errorf("relu domain %v for the pre-activations %+v", c.ReluDomain, pre)
+	}
+	for l, v := range block.Variances {
+		if d := c.InvSqrtDomain[l]; d[0] <= 0 || d[0] >= v.Min || d[1] <= v.Max {
+			t.Errorf("layernorm %d: domain %v for the variances %+v", l+1, d, v)
+		}
+	}
+	for h, p := range block.Softmax {
+		// (x+b)^2 单调：b 不小于分数下界的相反数；建议的常数比随机的常数更接近 softmax
+		if p.B < -block.Scores[h].Min || p.C <= 0 || p.ProposedError >= p.CurrentError {
+			t.Errorf("head %d: scores %+v, proposal %+v", h, block.Scores[h], p)
+		}
+	}
+
+	// 第一个块的注意力分数不依赖近似
+	exact, err := Calibrate(dash, input, Exact, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exact.Blocks[0].Scores[0].Max != block.Scores[0].Max {
+		t.Errorf("exact scores %+v, approximate %+v", exact.Blocks[0].Scores[0], block.Scores[0])
+	}
+
+	var out bytes.Buffer
+	PrintCalibration(&out, c)
+	for _, want := range []string{"attention_score_head3", "relu_input", "layernorm2_variance", "relu\t["} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report has no %q:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := Calibrate(dash, input, Approximate, -1); err == nil {
+		t.Error("negative margin: no error")
+	}
+	// 多项式溢出时报告溢出的值
+	dash.ReluCoefficients = []float64{0, 0, 1e308}
+	if _, err := Calibrate(dash, input, Approximate, 0.1); err == nil || !strings.Contains(err.Error(), "overflows") {
+		t.Errorf("got %v, want an overflow", err)
+	}
+}
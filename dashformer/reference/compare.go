@@ -0,0 +1,157 @@
+package reference
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// ErrorBins 是每个类别的误差直方图的分界：第 i 格统计 ErrorBins[i-1] <= |误差| < ErrorBins[i] 的序列数
+var ErrorBins = []float64{1e-4, 1e-3, 1e-2, 1e-1, 1, 10}
+
+// SequenceReport 是一条序列的密文结果和明文结果的比较
+type SequenceReport struct {
+	MaxAbsError    float64
+	Class          int  // 密文结果的 argmax
+	ReferenceClass int  // 明文结果的 argmax
+	Top1           bool // Class == ReferenceClass
+	TopK           bool // ReferenceClass 在密文结果的前 K 个类别中
+}
+
+// Report 比较密文计算和明文参考实现的 logits
+type Report struct {
+	K          int
+	Sequences  []SequenceReport
+	Histograms [][]int // 每个类别 len(ErrorBins)+1 格
+}
+
+/*
+ * Compare
+ * Input:  密文结果的 logits Slice[][],明文结果的 logits Slice[][],k
+ * Output: Report,error
+ * Compute: 每条序列的最大绝对误差、top-1 和 top-k 是否一致，以及每个类别的绝对误差直方图
+ */
+func Compare(logits, referenceLogits [][]float64, k int) (Report, error) {
+	if len(logits) != len(referenceLogits) {
+		return Report{}, fmt.Errorf("%d results but %d reference results", len(logits), len(referenceLogits))
+	}
+	if len(logits) == 0 {
+		return Report{}, fmt.Errorf("no sequence to compare")
+	}
+	numClasses := len(referenceLogits[0])
+	if k <= 0 || k > numClasses {
+		return Report{}, fmt.Errorf("top-k must be between 1 and %d, got %d", numClasses, k)
+	}
+
+	report := Report{K: k, Sequences: make([]SequenceReport, len(logits)), Histograms: make([][]int, numClasses)}
+	for c := range report.Histograms {
+		report.Histograms[c] = make([]int, len(ErrorBins)+1)
+	}
+	for i := range logits {
+		if len(logits[i]) != numClasses || len(referenceLogits[i]) != numClasses {
+			return Report{}, fmt.Errorf("sequence %d: the result has %d classes, the reference %d", i, len(logits[i]), len(referenceLogits[i]))
+		}
+		seq := SequenceReport{ReferenceClass: ArgMax(referenceLogits[i])}
+		for c := range logits[i] {
+			absError := math.Abs(logits[i][c] - referenceLogits[i][c])
+			seq.MaxAbsError = math.Max(seq.MaxAbsError, absError)
+			report.Histograms[c][errorBin(absError)]++
+		}
+		top := TopK(logits[i], k)
+		seq.Class = top[0]
+		seq.Top1 = seq.Class == seq.ReferenceClass
+		for _, c := range top {
+			seq.TopK = seq.TopK || c == seq.ReferenceClass
+		}
+		report.Sequences[i] = seq
+	}
+	return report, nil
+}
+
+// errorBin 返回 absError 所在的直方图格
+func errorBin(absError float64) int {
+	return sort.Search(len(ErrorBins), func(i int) bool { return absError < ErrorBins[i] })
+}
+
+// ArgMax 返回最大值的下标
+func ArgMax(v []float64) int {
+	best := 0
+	for i := range v {
+		if v[i] > v[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// TopK 返回最大的 k 个值的下标，从大到小排列
+func TopK(v []float64, k int) []int {
+	index := make([]int, len(v))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool { return v[index[a]] > v[index[b]] })
+	return index[:min(k, len(v))]
+}
+
+// MaxAbsError 返回所有序列的最大绝对误差
+func (r Report) MaxAbsError() float64 {
+	maxError := 0.0
+	for _, seq := range r.Sequences {
+		maxError = math.Max(maxError, seq.MaxAbsError)
+	}
+	return maxError
+}
+
+// Top1Agreement 返回 argmax 一致的序列比例
+func (r Report) Top1Agreement() float64 {
+	count := 0
+	for _, seq := range r.Sequences {
+		if seq.Top1 {
+			count++
+		}
+	}
+	return float64(count) / float64(len(r.Sequences))
+}
+
+// TopKAgreement 返回明文 argmax 在密文结果前 K 个类别中的序列比例
+func (r Report) TopKAgreement() float64 {
+	count := 0
+	for _, seq := range r.Sequences {
+		if seq.TopK {
+			count++
+		}
+	}
+	return float64(count) / float64(len(r.Sequences))
+}
+
+// Print 把每条序列的结果、汇总和每个类别的误差直方图写到 w
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "sequence\tmax_abs_error\tclass\treference_class\ttop1\ttop%d\n", r.K)
+	for i, seq := range r.Sequences {
+		fmt.Fprintf(w, "%d\t%.6g\t%d\t%d\t%t\t%t\n", i, seq.MaxAbsError, seq.Class, seq.ReferenceClass, seq.Top1, seq.TopK)
+	}
+	fmt.Fprintf(w, "\n%d sequences: max abs error %.6g, top-1 agreement %.2f%%, top-%d agreement %.2f%%\n\n",
+		len(r.Sequences), r.MaxAbsError(), 100*r.Top1Agreement(), r.K, 100*r.TopKAgreement())
+
+	fmt.Fprint(w, "|error| per class")
+	for i := 0; i <= len(ErrorBins); i++ {
+		switch {
+		case i == 0:
+			fmt.Fprintf(w, "\t<%g", ErrorBins[0])
+		case i == len(ErrorBins):
+			fmt.Fprintf(w, "\t>=%g", ErrorBins[i-1])
+		default:
+			fmt.Fprintf(w, "\t[%g,%g)", ErrorBins[i-1], ErrorBins[i])
+		}
+	}
+	fmt.Fprintln(w)
+	for c, histogram := range r.Histograms {
+		fmt.Fprintf(w, "class %d", c)
+		for _, count := range histogram {
+			fmt.Fprintf(w, "\t%d", count)
+		}
+		fmt.Fprintln(w)
+	}
+}
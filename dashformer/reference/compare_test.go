@@ -0,0 +1,56 @@
+package reference
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	reference := [][]float64{
+		{0.1, 0.9, 0.5},
+		{0.7, 0.2, 0.6},
+	}
+	logits := [][]float64{
+		{0.1, 0.9, 0.5005}, // 同样的 argmax，误差 5e-4
+		{0.55, 0.2, 0.6},   // argmax 变成 2，明文的 argmax 0 排第二
+	}
+
+	report, err := Compare(logits, reference, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Sequences[0].Top1 || report.Sequences[1].Top1 || !report.Sequences[1].TopK {
+		t.Errorf("unexpected agreement: %+v", report.Sequences)
+	}
+	if report.Sequences[1].Class != 2 || report.Sequences[1].ReferenceClass != 0 {
+		t.Errorf("unexpected classes: %+v", report.Sequences[1])
+	}
+	if report.Top1Agreement() != 0.5 || report.TopKAgreement() != 1 {
+		t.Errorf("agreement %f, %f", report.Top1Agreement(), report.TopKAgreement())
+	}
+	if e := report.MaxAbsError(); e < 0.1499 || e > 0.1501 {
+		t.Errorf("max abs error %f", e)
+	}
+
+	// 类别 2：误差 5e-4 在 [1e-4,1e-3)，误差 0 在第一格；类别 0：误差 0.15 在 [0.1,1)
+	if report.Histograms[2][0] != 1 || report.Histograms[2][1] != 1 || report.Histograms[0][4] != 1 {
+		t.Errorf("unexpected histograms: %v", report.Histograms)
+	}
+	if errorBin(1e-4) != 1 || errorBin(100) != len(ErrorBins) {
+		t.Errorf("bin edges")
+	}
+
+	var buf bytes.Buffer
+	report.Print(&buf)
+	if !strings.Contains(buf.String(), "top-1 agreement 50.00%") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+
+	if _, err := Compare(logits, reference[:1], 2); err == nil {
+		t.Error("expected an error for a different number of sequences")
+	}
+	if _, err := Compare(logits, reference, 4); err == nil {
+		t.Error("expected an error for k larger than the classes")
+	}
+}
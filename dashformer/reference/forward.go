@@ -0,0 +1,269 @@
+// Package reference 在明文 (float64) 上计算 Dashformer 的前向传播，用来和密文计算的结果比较：
+// Exact 模式是原始模型，Approximate 模式使用和密文计算相同的近似，
+// 两者的差是近似误差，Approximate 和解密结果的差是 CKKS 的误差 (或者 bug)。
+package reference
+
+import (
+	"fmt"
+	"math"
+
+	"dashformer/utils"
+)
+
+// Mode 选择前向传播中 softmax、LayerNorm 和 ReLU 的计算方式
+type Mode int
+
+const (
+	// Exact 是原始模型：softmax、逐 token 的 LayerNorm 和 ReLU
+	Exact Mode = iota
+	// Approximate 和密文计算一致：注意力权重为 (x/sqrt(d_k)+b)^2/c (不归一化)，
+	// LayerNorm 使用预先计算的 1/sqrt(variance) (LayerNormSqrtVariance1/2)，或者 LayerNormInvSqrt 选择的多项式和牛顿迭代，
+	// ReLU 使用多项式 ReluCoefficients
+	Approximate
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Exact:
+		return "exact"
+	case Approximate:
+		return "approximate"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// LayerNormEpsilon 是 Exact 模式中 LayerNorm 加在方差上的常数 (和训练时的 Keras LayerNormalization 相同)
+const LayerNormEpsilon = 1e-6
+
+/*
+ * Forward
+ * Input:  DashformerModelParameters,one-hot 序列 Slice[][][] (序列数 × 序列长度 × 词表大小),Mode
+ * Output: logits Slice[][] (序列数 × 类别数),error
+ * Compute: 对每条序列计算 Dashformer：embedding + 位置编码, 每个 Transformer 块 (多头注意力, combine, LayerNorm1,
+ *          FFN (ReLU), LayerNorm2), 对所有位置求和 (和密文计算一样是求和), 分类层
+ */
+func Forward(dashModelParam utils.DashformerModelParameters, input [][][]float64, mode Mode) ([][]float64, error) {
+	if err := checkShapes(dashModelParam); err != nil {
+		return nil, err
+	}
+	if mode != Exact && mode != Approximate {
+		return nil, fmt.Errorf("unknown mode %v", mode)
+	}
+
+	logits := make([][]float64, len(input))
+	for i, sequence := range input {
+		out, err := ForwardSequence(dashModelParam, sequence, mode)
+		if err != nil {
+			return nil, fmt.Errorf("sequence %d: %v", i, err)
+		}
+		logits[i] = out
+	}
+	return logits, nil
+}
+
+/*
+ * ForwardSequence
+ * Input:  DashformerModelParameters,one-hot 序列 Slice[][] (序列长度 × 词表大小),Mode
+ * Output: logits Slice[],error
+ * Compute: 一条序列的 Dashformer 前向传播，见 Forward
+ */
+func ForwardSequence(dashModelParam utils.DashformerModelParameters, sequence [][]float64, mode Mode) ([]float64, error) {
+	return forwardSequence(dashModelParam, sequence, mode, nil)
+}
+
+// blockTrace 记录一个 Transformer 块的中间值 (见 forwardSequence)
+type blockTrace struct {
+	// 每个头的注意力分数 (q·k/sqrt(d_k)，softmax 之前)，序列长度 × 序列长度
+	scores [][][]float64
+	// FFN1 的输出 (ReLU 的输入)，序列长度 × FFN 宽度
+	preActivations [][]float64
+	// LayerNorm1/2 输入的每个位置的方差
+	variances [2][]float64
+}
+
+// forwardSequence 计算 ForwardSequence，observe 不为 nil 时在每个块之后以块的中间值调用 observe
+func forwardSequence(dashModelParam utils.DashformerModelParameters, sequence [][]float64, mode Mode, observe func(block int, trace *blockTrace)) ([]float64, error) {
+	seqLength, vocabSize := len(dashModelParam.EncodingMatrix), len(dashModelParam.EmbeddingMatrix)
+	if len(sequence) != seqLength {
+		return nil, fmt.Errorf("sequence has %d tokens, the model takes %d", len(sequence), seqLength)
+	}
+	for j := range sequence {
+		if len(sequence[j]) != vocabSize {
+			return nil, fmt.Errorf("token %d has %d values, the embedding has %d rows", j, len(sequence[j]), vocabSize)
+		}
+	}
+
+	// embedding + 位置编码
+	x := addMatrix(matMul(sequence, dashModelParam.EmbeddingMatrix), dashModelParam.EncodingMatrix)
+
+	out := x
+	for k := 0; k < dashModelParam.NumBlocks(); k++ {
+		var trace *blockTrace
+		if observe != nil {
+			trace = &blockTrace{}
+		}
+		out = transformerBlock(dashModelParam.Block(k), out, dashModelParam.ReluCoefficients, dashModelParam.LayerNormInvSqrt, mode, trace)
+		if observe != nil {
+			observe(k, trace)
+		}
+	}
+
+	// 对所有位置求和
+	pooled := make([]float64, len(out[0]))
+	for i := range out {
+		for j := range out[i] {
+			pooled[j] += out[i][j]
+		}
+	}
+
+	logits := matMul([][]float64{pooled}, dashModelParam.ClassifierWeightMatrix)[0]
+	for k := range logits {
+		logits[k] += dashModelParam.ClassifierBiasVector[k]
+	}
+	return logits, nil
+}
+
+// transformerBlock 计算一个 Transformer 块：多头注意力, combine, LayerNorm1, FFN (ReLU), LayerNorm2；
+// trace 不为 nil 时记录注意力分数、ReLU 的输入和 LayerNorm1/2 输入的方差
+func transformerBlock(block utils.TransformerBlock, x [][]float64, reluCoeffs []float64, invSqrt [2]utils.InvSqrt, mode Mode, trace *blockTrace) [][]float64 {
+	// 多头注意力，各个头的输出按列拼接后乘 combine 矩阵
+	heads := make([][][]float64, len(block.QueryWeightAttentionMatrixs))
+	if trace != nil {
+		trace.scores = make([][][]float64, len(heads))
+	}
+	for h := range heads {
+		heads[h] = attentionHead(block, x, h, mode, trace)
+	}
+	attention := addBias(matMul(concatColumns(heads), block.CombineWeightMatrixs), block.CombineBiasVectors)
+
+	residual1 := addMatrix(x, attention)
+	if trace != nil {
+		trace.variances[0] = rowVariances(residual1)
+	}
+	out1 := layerNorm(residual1, block.LayerNormVectorR1, block.LayerNormVectorB1, block.LayerNormSqrtVariance1, invSqrt[0], mode)
+
+	hidden := addBias(matMul(out1, block.FeedForwardWeightMatrix1), block.FeedForwardBiasVector1)
+	if trace != nil {
+		trace.preActivations = copyMatrix(hidden)
+	}
+	for i := range hidden {
+		for j := range hidden[i] {
+			hidden[i][j] = relu(hidden[i][j], reluCoeffs, mode)
+		}
+	}
+	ffn := addBias(matMul(hidden, block.FeedForwardWeightMatrix2), block.FeedForwardBiasVector2)
+
+	residual2 := addMatrix(out1, ffn)
+	if trace != nil {
+		trace.variances[1] = rowVariances(residual2)
+	}
+	return layerNorm(residual2, block.LayerNormVectorR2, block.LayerNormVectorB2, block.LayerNormSqrtVariance2, invSqrt[1], mode)
+}
+
+// attentionHead 计算第 h 个注意力头，Approximate 模式的权重和 maths.ApproximateSoftmaxCiphertext 相同；
+// trace 不为 nil 时记录 softmax 之前的分数
+func attentionHead(block utils.TransformerBlock, x [][]float64, h int, mode Mode, trace *blockTrace) [][]float64 {
+	q := addBias(matMul(x, block.QueryWeightAttentionMatrixs[h]), block.QueryBiasAttentionVectors[h])
+	k := addBias(matMul(x, block.KeyWeightAttentionMatrixs[h]), block.KeyBiasAttentionVectors[h])
+	v := addBias(matMul(x, block.ValueWeightAttentionMatrixs[h]), block.ValueBiasAttentionVectors[h])
+
+	scale := 1 / math.Sqrt(float64(len(q[0])))
+	b, c := block.SoftMaxB[h], block.SoftMaxC[h]
+
+	weights := matMul(q, transpose(k))
+	for i := range weights {
+		for j := range weights[i] {
+			weights[i][j] *= scale
+		}
+	}
+	if trace != nil {
+		trace.scores[h] = copyMatrix(weights)
+	}
+	for i := range weights {
+		if mode == Approximate {
+			for j := range weights[i] {
+				s := weights[i][j] + b
+				weights[i][j] = s * s / c
+			}
+		} else {
+			softmax(weights[i])
+		}
+	}
+	return matMul(weights, v)
+}
+
+// layerNorm 对每个位置的特征做 LayerNorm；Approximate 模式使用 invSqrt 近似的 1/sqrt(variance)，
+// invSqrt 为零值时使用第 i 个位置预先计算的 1/sqrt(variance)
+func layerNorm(x [][]float64, r, beta, sqrtVariance []float64, invSqrt utils.InvSqrt, mode Mode) [][]float64 {
+	out := make([][]float64, len(x))
+	variances := rowVariances(x)
+	for i := range x {
+		mean := rowMean(x[i])
+
+		var inv float64
+		switch {
+		case mode == Exact:
+			inv = 1 / math.Sqrt(variances[i]+LayerNormEpsilon)
+		case invSqrt.Encrypted():
+			inv = invSqrt.Eval(variances[i])
+		default:
+			inv = sqrtVariance[i]
+		}
+
+		out[i] = make([]float64, len(x[i]))
+		for j, v := range x[i] {
+			out[i][j] = (v-mean)*inv*r[j] + beta[j]
+		}
+	}
+	return out
+}
+
+// rowMean 返回一个位置的特征的均值
+func rowMean(row []float64) float64 {
+	mean := 0.0
+	for _, v := range row {
+		mean += v
+	}
+	return mean / float64(len(row))
+}
+
+// rowVariances 返回每个位置的特征的方差 (除以特征数)
+func rowVariances(x [][]float64) []float64 {
+	variances := make([]float64, len(x))
+	for i := range x {
+		mean := rowMean(x[i])
+		for _, v := range x[i] {
+			variances[i] += (v - mean) * (v - mean)
+		}
+		variances[i] /= float64(len(x[i]))
+	}
+	return variances
+}
+
+// relu 在 Approximate 模式下计算多项式 Σ coeffs[k] x^k (和 maths.ApproximatePolynomial 使用的单项式基相同)
+func relu(x float64, coeffs []float64, mode Mode) float64 {
+	if mode == Approximate {
+		y := 0.0
+		for k := len(coeffs) - 1; k >= 0; k-- {
+			y = y*x + coeffs[k]
+		}
+		return y
+	}
+	return math.Max(x, 0)
+}
+
+// softmax 原地计算向量的 softmax
+func softmax(v []float64) {
+	maxValue := math.Inf(-1)
+	for _, x := range v {
+		maxValue = math.Max(maxValue, x)
+	}
+	sum := 0.0
+	for i, x := range v {
+		v[i] = math.Exp(x - maxValue)
+		sum += v[i]
+	}
+	for i := range v {
+		v[i] /= sum
+	}
+}
@@ -0,0 +1,239 @@
+package reference
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"dashformer/coefficient"
+	"dashformer/utils"
+)
+
+// testShape 是发布的模型的维数，FFN 窄一些以加快测试
+func testShape() utils.ModelShape {
+	shape := utils.DefaultModelShape()
+	shape.FFNDim = 64
+	return shape
+}
+
+func randomSequence(r *rand.Rand, seqLength, vocabSize int) [][]float64 {
+	sequence := make([][]float64, seqLength)
+	for j := range sequence {
+		sequence[j] = make([]float64, vocabSize)
+		sequence[j][r.Intn(vocabSize)] = 1
+	}
+	return sequence
+}
+
+// foldedForward 按 evalUnfoldDashformerWithBSGSMultiTread 的步骤在明文上计算展开后的系数，
+// 结果乘以 OutputScale 后和解密后的 valueTensor[i][0] 对应
+func foldedForward(t *testing.T, dash utils.DashformerModelParameters, x0 [][]float64) []float64 {
+	coeffDash, coeffQKV, coeffSqmax, err := coefficient.GenerateCoefficient(dash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var heads [][][]float64
+	for h := range coeffSqmax.Item_1 {
+		scores := addMatrix(addMatrix(matMul(matMul(x0, coeffSqmax.Item_1[h]), transpose(x0)), matMul(x0, coeffSqmax.Item_2[h])),
+			addMatrix(matMul(coeffSqmax.Item_3[h], transpose(x0)), coeffSqmax.Item_4[h]))
+		for i := range scores {
+			for j := range scores[i] {
+				s := scores[i][j] + dash.SoftMaxB[h]/math.Sqrt(dash.SoftMaxC[h])
+				scores[i][j] = s * s
+			}
+		}
+		heads = append(heads, matMul(scores, addMatrix(matMul(x0, coeffQKV.A_V[h]), coeffQKV.Constant_V[h])))
+	}
+	head := concatColumns(heads)
+
+	scaleRows := func(v []float64, m [][]float64) [][]float64 {
+		for i := range m {
+			for j := range m[i] {
+				m[i][j] *= v[i]
+			}
+		}
+		return m
+	}
+	beforeRelu := addMatrix(addMatrix(scaleRows(coeffDash.Head_before_relu, matMul(head, coeffDash.Head_rear_relu)),
+		scaleRows(coeffDash.X0_before_relu, matMul(x0, coeffDash.X0_rear_relu))), coeffDash.Constant_Relu)
+	for i := range beforeRelu {
+		for j := range beforeRelu[i] {
+			beforeRelu[i][j] = relu(beforeRelu[i][j], dash.ReluCoefficients, Approximate)
+		}
+	}
+	beforePooling := addMatrix(addMatrix(scaleRows(coeffDash.Relu_before, matMul(beforeRelu, coeffDash.Relu_rear)),
+		scaleRows(coeffDash.Head_before, matMul(head, coeffDash.Head_rear))),
+		scaleRows(coeffDash.X0_before, matMul(x0, coeffDash.X0_rear)))
+
+	result := append([]float64(nil), coeffDash.Constant_Dash...)
+	for i := range beforePooling {
+		for k := range result {
+			result[k] += beforePooling[i][k]
+		}
+	}
+	return utils.ScaleVector(result, coeffDash.OutputScale)
+}
+
+func TestApproximateMatchesCoefficients(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dash := utils.RandomModel(r, testShape(), 0.1)
+	classifier := dash.ClassifierWeightMatrix[0][0]
+	sigma := dash.LayerNormSqrtVariance1[0]
+
+	sequence := randomSequence(r, 50, 25)
+	have, err := ForwardSequence(dash, sequence, Approximate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := foldedForward(t, dash, sequence)
+
+	// GenerateCoefficient 不能修改模型参数
+	if dash.ClassifierWeightMatrix[0][0] != classifier || dash.LayerNormSqrtVariance1[0] != sigma {
+		t.Fatal("GenerateCoefficient modified the model parameters")
+	}
+
+	for k := range have {
+		if w := want[k]; math.Abs(have[k]-w) > 1e-6*math.Max(1, math.Abs(w)) {
+			t.Fatalf("logit %d: got %f, coefficients give %f", k, have[k], w)
+		}
+	}
+
+	// 配置的 OutputScale 代替推出的上界，解密后的 logits 不变
+	configured := dash
+	configured.OutputScale = 1000
+	if coeffDash, _, _, err := coefficient.GenerateCoefficient(configured); err != nil || coeffDash.OutputScale != 1000 {
+		t.Fatalf("got output scale %g, %v, want 1000", coeffDash.OutputScale, err)
+	}
+	for k, w := range foldedForward(t, configured, sequence) {
+		if math.Abs(have[k]-w) > 1e-6*math.Max(1, math.Abs(w)) {
+			t.Fatalf("logit %d with output scale 1000: got %f, coefficients give %f", k, have[k], w)
+		}
+	}
+
+	exact, err := ForwardSequence(dash, sequence, Exact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := true
+	for k := range exact {
+		same = same && exact[k] == have[k]
+	}
+	if same {
+		t.Error("exact and approximate modes give the same logits")
+	}
+
+	// LogitBound 是 Exact 模式 logits 的上界，分类层缩小后密文中的 logits 在 [-1, 1] 内
+	for k := range exact {
+		if bound := dash.LogitBound(); math.Abs(exact[k]) > bound {
+			t.Errorf("logit %d: |%f| exceeds the bound %f", k, exact[k], bound)
+		}
+	}
+}
+
+// 其他维数的模型 (8 头 × 8 宽，序列长度 100) 折叠后的系数仍和明文前向传播一致
+func TestOtherShapeMatchesCoefficients(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	shape := utils.ModelShape{SeqLength: 100, VocabSize: 21, EmbedDim: 64, Heads: 8, HeadSize: 8, FFNDim: 32, NumClasses: 10, Blocks: 1}
+	dash := utils.RandomModel(r, shape, 0.1)
+
+	sequence := randomSequence(r, shape.SeqLength, shape.VocabSize)
+	have, err := ForwardSequence(dash, sequence, Approximate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := foldedForward(t, dash, sequence)
+	if len(have) != shape.NumClasses || len(want) != shape.NumClasses {
+		t.Fatalf("got %d and %d logits, want %d", len(have), len(want), shape.NumClasses)
+	}
+	for k := range have {
+		if w := want[k]; math.Abs(have[k]-w) > 1e-6*math.Max(1, math.Abs(w)) {
+			t.Fatalf("logit %d: got %f, coefficients give %f", k, have[k], w)
+		}
+	}
+}
+
+// 多个块的模型依次计算每个块，展开的系数只能折叠一个块
+func TestBlocks(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	shape := testShape()
+	dash := utils.RandomModel(r, shape, 0.1)
+	sequence := randomSequence(r, shape.SeqLength, shape.VocabSize)
+	one, err := ForwardSequence(dash, sequence, Approximate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个块和第一个相同时，结果等于第一个块的输出再经过一次这个块
+	dash.Shape.Blocks = 2
+	dash.SetBlock(1, dash.Block(0))
+	two, err := ForwardSequence(dash, sequence, Approximate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := addMatrix(matMul(sequence, dash.EmbeddingMatrix), dash.EncodingMatrix)
+	for k := 0; k < 2; k++ {
+		x = transformerBlock(dash.Block(0), x, dash.ReluCoefficients, dash.LayerNormInvSqrt, Approximate, nil)
+	}
+	pooled := make([]float64, len(x[0]))
+	for i := range x {
+		for j := range x[i] {
+			pooled[j] += x[i][j]
+		}
+	}
+	want := addBias(matMul([][]float64{pooled}, dash.ClassifierWeightMatrix), dash.ClassifierBiasVector)[0]
+	for k := range want {
+		if math.Abs(two[k]-want[k]) > 1e-9 || two[k] == one[k] {
+			t.Errorf("logit %d: got %f with two blocks, want %f (one block: %f)", k, two[k], want[k], one[k])
+		}
+	}
+
+	if _, _, _, err := coefficient.GenerateCoefficient(dash); err == nil {
+		t.Error("expected an error when folding two blocks")
+	}
+
+	// 第二个块的维数也要检查
+	dash.Layers[0].CombineBiasVectors = dash.Layers[0].CombineBiasVectors[1:]
+	if _, err := Forward(dash, [][][]float64{sequence}, Approximate); err == nil || !strings.Contains(err.Error(), "Layers[0].CombineBiasVectors") {
+		t.Errorf("got %v, want an error naming Layers[0].CombineBiasVectors", err)
+	}
+}
+
+func TestExactSoftmaxAndLayerNorm(t *testing.T) {
+	v := []float64{1, 2, 3, 1000}
+	softmax(v)
+	if math.Abs(v[3]-1) > 1e-12 || v[0] < 0 {
+		t.Errorf("softmax overflow: %v", v)
+	}
+
+	x := [][]float64{{1, 2, 3, 4}}
+	out := layerNorm(x, []float64{1, 1, 1, 1}, []float64{0, 0, 0, 0}, nil, utils.InvSqrt{}, Exact)
+	mean, variance := 0.0, 0.0
+	for _, o := range out[0] {
+		mean += o / 4
+	}
+	for _, o := range out[0] {
+		variance += (o - mean) * (o - mean) / 4
+	}
+	if math.Abs(mean) > 1e-12 || math.Abs(variance-1) > 1e-5 {
+		t.Errorf("layer norm: mean %g, variance %g", mean, variance)
+	}
+}
+
+func TestForwardShapeErrors(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	dash := utils.RandomModel(r, testShape(), 0.1)
+
+	if _, err := Forward(dash, [][][]float64{randomSequence(r, 49, 25)}, Exact); err == nil {
+		t.Error("expected an error for a short sequence")
+	}
+	if _, err := Forward(dash, [][][]float64{randomSequence(r, 50, 24)}, Exact); err == nil {
+		t.Error("expected an error for a wrong vocabulary size")
+	}
+
+	dash.FeedForwardBiasVector2 = dash.FeedForwardBiasVector2[:10]
+	if _, err := Forward(dash, [][][]float64{randomSequence(r, 50, 25)}, Exact); err == nil {
+		t.Error("expected an error for a wrong bias size")
+	}
+}
@@ -0,0 +1,87 @@
+package reference
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"dashformer/utils"
+)
+
+// LayerNormReport 比较一个 LayerNorm 使用的 1/sqrt(variance) 和由它的输入精确计算的值
+type LayerNormReport struct {
+	Block  int
+	Layer  int    // 1 或 2
+	Method string // precomputed 或 encrypted (见 utils.InvSqrt)
+	// 所有序列所有位置的方差的范围
+	MinVariance float64
+	MaxVariance float64
+	// 1/sqrt(variance) 的相对误差
+	MaxRelError  float64
+	MeanRelError float64
+}
+
+/*
+ * LayerNormAccuracy
+ * Input:  DashformerModelParameters,one-hot 序列 Slice[][][] (序列数 × 序列长度 × 词表大小)
+ * Output: 每个块的 LayerNorm1 和 LayerNorm2 的 LayerNormReport,error
+ * Compute: 计算 Approximate 前向传播，在每个 LayerNorm 比较使用的 1/sqrt(variance) (预先计算的值，
+ *          或者 LayerNormInvSqrt 的多项式和牛顿迭代) 和 1/sqrt(输入的方差 + LayerNormEpsilon)
+ */
+func LayerNormAccuracy(dashModelParam utils.DashformerModelParameters, input [][][]float64) ([]LayerNormReport, error) {
+	if err := checkShapes(dashModelParam); err != nil {
+		return nil, err
+	}
+	if len(input) == 0 {
+		return nil, fmt.Errorf("no sequence to compare")
+	}
+
+	reports := make([]LayerNormReport, 2*dashModelParam.NumBlocks())
+	for k := 0; k < dashModelParam.NumBlocks(); k++ {
+		for l, invSqrt := range dashModelParam.LayerNormInvSqrt {
+			method := "precomputed"
+			if invSqrt.Encrypted() {
+				method = "encrypted"
+			}
+			reports[2*k+l] = LayerNormReport{Block: k, Layer: l + 1, Method: method, MinVariance: math.Inf(1), MaxVariance: math.Inf(-1)}
+		}
+	}
+	for i, sequence := range input {
+		_, err := forwardSequence(dashModelParam, sequence, Approximate, func(k int, trace *blockTrace) {
+			block := dashModelParam.Block(k)
+			precomputed := [2][]float64{block.LayerNormSqrtVariance1, block.LayerNormSqrtVariance2}
+			for l, invSqrt := range dashModelParam.LayerNormInvSqrt {
+				r := &reports[2*k+l]
+				for j, v := range trace.variances[l] {
+					inv := precomputed[l][j]
+					if invSqrt.Encrypted() {
+						inv = invSqrt.Eval(v)
+					}
+					exact := 1 / math.Sqrt(v+LayerNormEpsilon)
+					relError := math.Abs(inv-exact) / exact
+					r.MinVariance = math.Min(r.MinVariance, v)
+					r.MaxVariance = math.Max(r.MaxVariance, v)
+					r.MaxRelError = math.Max(r.MaxRelError, relError)
+					r.MeanRelError += relError
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("sequence %d: %v", i, err)
+		}
+	}
+	// 每个 LayerNorm 对每条序列的每个位置计算一次
+	count := float64(len(input) * len(dashModelParam.EncodingMatrix))
+	for i := range reports {
+		reports[i].MeanRelError /= count
+	}
+	return reports, nil
+}
+
+// PrintLayerNormReports 把每个 LayerNorm 的方差范围和 1/sqrt(variance) 的相对误差写到 w
+func PrintLayerNormReports(w io.Writer, reports []LayerNormReport) {
+	fmt.Fprintln(w, "block\tlayernorm\tmethod\tmin_variance\tmax_variance\tmax_rel_error\tmean_rel_error")
+	for _, r := range reports {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%.6g\t%.6g\t%.6g\t%.6g\n", r.Block, r.Layer, r.Method, r.MinVariance, r.MaxVariance, r.MaxRelError, r.MeanRelError)
+	}
+}
@@ -0,0 +1,52 @@
+package reference
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"dashformer/utils"
+)
+
+func TestLayerNormAccuracy(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	shape := testShape()
+	dash := utils.RandomModel(r, shape, 0.1)
+	input := [][][]float64{randomSequence(r, shape.SeqLength, shape.VocabSize), randomSequence(r, shape.SeqLength, shape.VocabSize)}
+
+	reports, err := LayerNormAccuracy(dash, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Layer != 1 || reports[1].Layer != 2 || reports[0].Method != "precomputed" {
+		t.Fatalf("got %+v, want layernorm 1 and 2 of block 0, precomputed", reports)
+	}
+
+	// 初始值 1/sqrt(最大方差) 不大于精确值，牛顿迭代收敛到 1/sqrt(variance)
+	for l := range dash.LayerNormInvSqrt {
+		dash.LayerNormInvSqrt[l] = utils.InvSqrt{Coeffs: []float64{1 / math.Sqrt(reports[l].MaxVariance)}, NewtonIterations: 40}
+	}
+	encrypted, err := LayerNormAccuracy(dash, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// LayerNorm1 的输入不变，LayerNorm2 的输入随 LayerNorm1 变化；误差来自 LayerNormEpsilon
+	if encrypted[0].MinVariance != reports[0].MinVariance || encrypted[0].MaxVariance != reports[0].MaxVariance {
+		t.Errorf("layernorm 1: the variances changed from %+v to %+v", reports[0], encrypted[0])
+	}
+	for _, report := range encrypted {
+		if report.Method != "encrypted" || report.MaxRelError > 1e-5 {
+			t.Errorf("layernorm %d: got %+v, want a converged 1/sqrt", report.Layer, report)
+		}
+	}
+
+	// Approximate 的 LayerNorm 使用牛顿迭代后和 Exact 相同 (方差 1.25)
+	invSqrt := utils.InvSqrt{Coeffs: []float64{0.5}, NewtonIterations: 10}
+	got := layerNorm([][]float64{{1, 2, 3, 4}}, []float64{1, 1, 1, 1}, []float64{0, 0, 0, 0}, nil, invSqrt, Approximate)
+	want := layerNorm([][]float64{{1, 2, 3, 4}}, []float64{1, 1, 1, 1}, []float64{0, 0, 0, 0}, nil, utils.InvSqrt{}, Exact)
+	for j := range want[0] {
+		if math.Abs(got[0][j]-want[0][j]) > 1e-5 {
+			t.Errorf("feature %d: got %g, want %g", j, got[0][j], want[0][j])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package reference
+
+import (
+	"fmt"
+
+	"dashformer/utils"
+)
+
+// matMul 返回 a × b
+func matMul(a, b [][]float64) [][]float64 {
+	out := make([][]float64, len(a))
+	for i := range a {
+		out[i] = make([]float64, len(b[0]))
+		for k, aik := range a[i] {
+			if aik == 0 {
+				continue
+			}
+			for j, bkj := range b[k] {
+				out[i][j] += aik * bkj
+			}
+		}
+	}
+	return out
+}
+
+// addMatrix 返回 a + b
+func addMatrix(a, b [][]float64) [][]float64 {
+	out := make([][]float64, len(a))
+	for i := range a {
+		out[i] = make([]float64, len(a[i]))
+		for j := range a[i] {
+			out[i][j] = a[i][j] + b[i][j]
+		}
+	}
+	return out
+}
+
+// addBias 原地把 bias 加到 a 的每一行上，返回 a
+func addBias(a [][]float64, bias []float64) [][]float64 {
+	for i := range a {
+		for j := range a[i] {
+			a[i][j] += bias[j]
+		}
+	}
+	return a
+}
+
+func transpose(a [][]float64) [][]float64 {
+	out := make([][]float64, len(a[0]))
+	for j := range out {
+		out[j] = make([]float64, len(a))
+		for i := range a {
+			out[j][i] = a[i][j]
+		}
+	}
+	return out
+}
+
+// copyMatrix 返回 a 的副本
+func copyMatrix(a [][]float64) [][]float64 {
+	out := make([][]float64, len(a))
+	for i := range a {
+		out[i] = append([]float64(nil), a[i]...)
+	}
+	return out
+}
+
+// concatColumns 把行数相同的矩阵按列拼接
+func concatColumns(mats [][][]float64) [][]float64 {
+	out := make([][]float64, len(mats[0]))
+	for i := range out {
+		for _, m := range mats {
+			out[i] = append(out[i], m[i]...)
+		}
+	}
+	return out
+}
+
+// checkShapes 检查模型参数的维数是否一致，错误信息中给出不匹配的参数
+func checkShapes(dashModelParam utils.DashformerModelParameters) error {
+	checkMatrix := func(name string, m [][]float64, rows, cols int) error {
+		if len(m) != rows {
+			return fmt.Errorf("%s has %d rows, want %d", name, len(m), rows)
+		}
+		for i := range m {
+			if len(m[i]) != cols {
+				return fmt.Errorf("%s row %d has %d columns, want %d", name, i, len(m[i]), cols)
+			}
+		}
+		return nil
+	}
+	checkVector := func(name string, v []float64, n int) error {
+		if len(v) != n {
+			return fmt.Errorf("%s has %d values, want %d", name, len(v), n)
+		}
+		return nil
+	}
+
+	if len(dashModelParam.EmbeddingMatrix) == 0 || len(dashModelParam.EmbeddingMatrix[0]) == 0 || len(dashModelParam.EncodingMatrix) == 0 {
+		return fmt.Errorf("empty embedding or encoding matrix")
+	}
+	if len(dashModelParam.ClassifierWeightMatrix) == 0 || len(dashModelParam.ClassifierWeightMatrix[0]) == 0 ||
+		len(dashModelParam.FeedForwardWeightMatrix1) == 0 || len(dashModelParam.FeedForwardWeightMatrix1[0]) == 0 {
+		return fmt.Errorf("empty feed forward or classifier matrix")
+	}
+	vocabSize, dModel := len(dashModelParam.EmbeddingMatrix), len(dashModelParam.EmbeddingMatrix[0])
+	seqLength := len(dashModelParam.EncodingMatrix)
+	numHeads := len(dashModelParam.QueryWeightAttentionMatrixs)
+	headDim := dModel / numHeads
+	dFF := len(dashModelParam.FeedForwardWeightMatrix1[0])
+	numClasses := len(dashModelParam.ClassifierWeightMatrix[0])
+
+	errs := []error{
+		checkMatrix("EmbeddingMatrix", dashModelParam.EmbeddingMatrix, vocabSize, dModel),
+		checkMatrix("EncodingMatrix", dashModelParam.EncodingMatrix, seqLength, dModel),
+	}
+	for k := 0; k < dashModelParam.NumBlocks(); k++ {
+		// 第一个块的参数是 DashformerModelParameters 的字段，其余的在 Layers 中
+		block, prefix := dashModelParam.Block(k), ""
+		if k > 0 {
+			prefix = fmt.Sprintf("Layers[%d].", k-1)
+		}
+		if len(block.QueryWeightAttentionMatrixs) != numHeads {
+			errs = append(errs, fmt.Errorf("%sQueryWeightAttentionMatrixs has %d heads, want %d", prefix, len(block.QueryWeightAttentionMatrixs), numHeads))
+			continue
+		}
+		for h := 0; h < numHeads; h++ {
+			errs = append(errs,
+				checkMatrix(fmt.Sprintf("%sQueryWeightAttentionMatrixs[%d]", prefix, h), block.QueryWeightAttentionMatrixs[h], dModel, headDim),
+				checkVector(fmt.Sprintf("%sQueryBiasAttentionVectors[%d]", prefix, h), block.QueryBiasAttentionVectors[h], headDim),
+				checkMatrix(fmt.Sprintf("%sKeyWeightAttentionMatrixs[%d]", prefix, h), block.KeyWeightAttentionMatrixs[h], dModel, headDim),
+				checkVector(fmt.Sprintf("%sKeyBiasAttentionVectors[%d]", prefix, h), block.KeyBiasAttentionVectors[h], headDim),
+				checkMatrix(fmt.Sprintf("%sValueWeightAttentionMatrixs[%d]", prefix, h), block.ValueWeightAttentionMatrixs[h], dModel, headDim),
+				checkVector(fmt.Sprintf("%sValueBiasAttentionVectors[%d]", prefix, h), block.ValueBiasAttentionVectors[h], headDim),
+			)
+		}
+		errs = append(errs,
+			checkMatrix(prefix+"CombineWeightMatrixs", block.CombineWeightMatrixs, numHeads*headDim, dModel),
+			checkVector(prefix+"CombineBiasVectors", block.CombineBiasVectors, dModel),
+			checkVector(prefix+"LayerNormVectorR1", block.LayerNormVectorR1, dModel),
+			checkVector(prefix+"LayerNormVectorB1", block.LayerNormVectorB1, dModel),
+			checkVector(prefix+"LayerNormVectorR2", block.LayerNormVectorR2, dModel),
+			checkVector(prefix+"LayerNormVectorB2", block.LayerNormVectorB2, dModel),
+			checkVector(prefix+"LayerNormSqrtVariance1", block.LayerNormSqrtVariance1, seqLength),
+			checkVector(prefix+"LayerNormSqrtVariance2", block.LayerNormSqrtVariance2, seqLength),
+			checkMatrix(prefix+"FeedForwardWeightMatrix1", block.FeedForwardWeightMatrix1, dModel, dFF),
+			checkVector(prefix+"FeedForwardBiasVector1", block.FeedForwardBiasVector1, dFF),
+			checkMatrix(prefix+"FeedForwardWeightMatrix2", block.FeedForwardWeightMatrix2, dFF, dModel),
+			checkVector(prefix+"FeedForwardBiasVector2", block.FeedForwardBiasVector2, dModel),
+		)
+	}
+	errs = append(errs,
+		checkMatrix("ClassifierWeightMatrix", dashModelParam.ClassifierWeightMatrix, dModel, numClasses),
+		checkVector("ClassifierBiasVector", dashModelParam.ClassifierBiasVector, numClasses),
+	)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
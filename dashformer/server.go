@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"dashformer/config"
+	"dashformer/encryption"
+	"dashformer/maths"
+	"dashformer/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+/*
+ * HTTP API of `dashformer serve`
+ *   GET    /params                   CKKS parameters of the server (hefloat.Parameters.MarshalBinary)
+ *   POST   /sessions                 body: evaluation-key bundle (evk.bin written by keygen) -> {"session_id": "..."}
+ *   POST   /sessions/{id}/eval       body: serialized CiphertextBatch or CiphertextTensor (input.ct) -> serialized CiphertextBatch (result.ct)
+ *   DELETE /sessions/{id}            forget the evaluation keys of a session
+ *
+ * The API has no authentication: any client that reaches it can register keys and run evaluations.
+ * Request bodies are bounded by the size of the keys and ciphertexts the server can use, the number of sessions
+ * by maxSessions, and sessions unused for sessionTTL are dropped.
+ */
+
+// serverLimits 限制不可信客户端可以让服务端读取和保存的数据
+type serverLimits struct {
+	// POST /sessions 和 POST /sessions/{id}/eval 的请求体的最大字节数
+	maxKeysBytes  int64
+	maxBatchBytes int64
+	// 同时保存的会话数和会话的最长空闲时间
+	maxSessions int
+	sessionTTL  time.Duration
+}
+
+// session 是一个客户端的计算密钥和最后一次使用的时间
+type session struct {
+	keys     *encryption.PublicParametersKeys
+	lastUsed time.Time
+}
+
+// inferenceServer 持有启动时准备好的密文计算 (模型和系数)，以及每个会话的计算密钥
+type inferenceServer struct {
+	params         hefloat.Parameters
+	dashModelParam utils.DashformerModelParameters
+	eval           *evaluation
+	parallelShards int
+	// 一次请求中密文计算的最长时间，0 表示不限制
+	timeout time.Duration
+
+	limits serverLimits
+
+	mu       sync.Mutex
+	sessions map[string]*session
+
+	// 一次密文计算已经占满所有核并需要数 GB 内存，请求按顺序计算；
+	// 用容量为 1 的 channel 代替互斥锁，排队的请求在客户端断开或超时时可以放弃等待
+	evalSem chan struct{}
+}
+
+func newInferenceServer(params hefloat.Parameters, cfg config.Config, maxShards, maxSessions int, sessionTTL time.Duration) (*inferenceServer, error) {
+	dashModelParam, err := readModel(cfg)
+	if err != nil {
+		return nil, err
+	}
+	eval, err := newEvaluation(cfg, dashModelParam)
+	if err != nil {
+		return nil, err
+	}
+	// 层数不够时不启动服务
+	// 客户端只上传 evk.bin，服务端不自举
+	if err := eval.checkLevels(params, nil); err != nil {
+		return nil, err
+	}
+	limits, err := newServerLimits(params, cfg, dashModelParam.Shape, maxShards)
+	if err != nil {
+		return nil, err
+	}
+	limits.maxSessions, limits.sessionTTL = maxSessions, sessionTTL
+
+	return &inferenceServer{
+		params:         params,
+		dashModelParam: dashModelParam,
+		eval:           eval,
+		parallelShards: cfg.ParallelShards,
+		timeout:        time.Duration(cfg.Timeout),
+		limits:         limits,
+		sessions:       make(map[string]*session),
+		evalSem:        make(chan struct{}, 1),
+	}, nil
+}
+
+/*
+ * newServerLimits
+ * Input:  params hefloat.Parameters, cfg config.Config, 模型的维数 shape, 一次请求的最大分片数 maxShards
+ * Output: serverLimits (不含会话的限制), error
+ * Compute: 计算密钥最多有 keygen 生成的 Galois 密钥 (Dashformer 用到的或 -50..50 的全部旋转) 和重线性化密钥，
+ *          每个分片有 VocabSize 条最高层的密文；文件头的大小给出 headerSlack 的余量
+ */
+func newServerLimits(params hefloat.Parameters, cfg config.Config, shape utils.ModelShape, maxShards int) (serverLimits, error) {
+	const headerSlack = 1 << 16
+	galEls, err := maths.DashformerGaloisElements(params, shape.SeqLength, cfg.BabyStep, cfg.GiantStep)
+	if err != nil {
+		return serverLimits{}, err
+	}
+	numKeys := max(len(galEls), len(encryption.DefaultGaloisElements(params))) + 1
+	keySize := rlwe.NewGaloisKey(params).BinarySize()
+	ciphertextSize := rlwe.NewCiphertext(params, 1, params.MaxLevel()).BinarySize()
+	return serverLimits{
+		maxKeysBytes:  int64(numKeys)*int64(keySize) + headerSlack,
+		maxBatchBytes: int64(maxShards)*int64(shape.VocabSize)*int64(ciphertextSize) + headerSlack,
+	}, nil
+}
+
+// readBodyError 返回读取请求体出错时的 HTTP 状态码，超过 http.MaxBytesReader 的限制返回 413
+func readBodyError(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// expireSessions 删除空闲超过 sessionTTL 的会话，调用时须持有 s.mu
+func (s *inferenceServer) expireSessions(now time.Time) {
+	if s.limits.sessionTTL <= 0 {
+		return
+	}
+	for id, sess := range s.sessions {
+		if now.Sub(sess.lastUsed) > s.limits.sessionTTL {
+			delete(s.sessions, id)
+			log.Printf("session %s expired", id)
+		}
+	}
+}
+
+func (s *inferenceServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /params", s.handleParams)
+	mux.HandleFunc("POST /sessions", s.handleRegister)
+	mux.HandleFunc("POST /sessions/{id}/eval", s.handleEval)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleDelete)
+	return mux
+}
+
+func (s *inferenceServer) handleParams(w http.ResponseWriter, r *http.Request) {
+	data, err := s.params.MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// handleRegister 读取客户端的计算密钥 (重线性化密钥和 Galois 密钥)，返回会话 id
+func (s *inferenceServer) handleRegister(w http.ResponseWriter, r *http.Request) {
+	// 会话已满时不读取密钥
+	s.mu.Lock()
+	s.expireSessions(time.Now())
+	full := len(s.sessions) >= s.limits.maxSessions
+	s.mu.Unlock()
+	if full {
+		http.Error(w, fmt.Sprintf("the server holds %d sessions, delete one or retry later", s.limits.maxSessions), http.StatusServiceUnavailable)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, s.limits.maxKeysBytes)
+	params, evk, err := encryption.ReadEvaluationKeys(bufio.NewReader(body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("reading evaluation keys: %v", err), readBodyError(err))
+		return
+	}
+	if !params.Equal(&s.params) {
+		http.Error(w, "the evaluation keys were generated with other CKKS parameters than the server's (see GET /params)", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	// 读取密钥期间其他请求可能已经占满会话
+	if len(s.sessions) >= s.limits.maxSessions {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("the server holds %d sessions, delete one or retry later", s.limits.maxSessions), http.StatusServiceUnavailable)
+		return
+	}
+	s.sessions[id] = &session{keys: encryption.NewPublicParametersKeys(params, nil, evk), lastUsed: time.Now()}
+	s.mu.Unlock()
+	log.Printf("session %s registered with %d Galois keys", id, len(evk.GaloisKeys))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"session_id": id})
+}
+
+// handleEval 对上传的密文分片运行配置的密文计算 (见 evaluation)，返回加密的 logits
+func (s *inferenceServer) handleEval(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	s.expireSessions(time.Now())
+	sess, ok := s.sessions[id]
+	if ok {
+		sess.lastUsed = time.Now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown session %q", id), http.StatusNotFound)
+		return
+	}
+	publicKeys := sess.keys
+
+	batch := new(encryption.CiphertextBatch)
+	body := http.MaxBytesReader(w, r.Body, s.limits.maxBatchBytes)
+	if _, err := batch.ReadFrom(bufio.NewReader(body)); err != nil {
+		http.Error(w, fmt.Sprintf("reading ciphertext batch: %v", err), readBodyError(err))
+		return
+	}
+	if err := batch.CheckParameters(s.params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(batch.Shards) == 0 {
+		http.Error(w, "the ciphertext batch is empty", http.StatusBadRequest)
+		return
+	}
+	for i, shard := range batch.Shards {
+		if err := s.checkShape(shard); err != nil {
+			http.Error(w, fmt.Sprintf("shard %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// 客户端断开连接或超过 timeout 时取消计算，释放密文占用的内存
+	ctx := r.Context()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+
+	select {
+	case s.evalSem <- struct{}{}:
+	case <-ctx.Done():
+		log.Printf("session %s: gave up waiting for the evaluation: %v", id, ctx.Err())
+		http.Error(w, ctx.Err().Error(), evalErrorStatus(ctx.Err()))
+		return
+	}
+	startTime := time.Now()
+	result, err := evalBatch(ctx, publicKeys, batch, s.eval, s.parallelShards)
+	<-s.evalSem
+	// 计算可能比 sessionTTL 长，结束时再记录一次使用
+	s.mu.Lock()
+	sess.lastUsed = time.Now()
+	s.mu.Unlock()
+	if err != nil {
+		log.Printf("session %s: %v", id, err)
+		http.Error(w, err.Error(), evalErrorStatus(err))
+		return
+	}
+	log.Printf("session %s: %d sequences in %d shards evaluated in %s", id, batch.NumRows(), len(batch.Shards), time.Since(startTime))
+
+	if err := result.SetParameters(s.params); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	bw := bufio.NewWriter(w)
+	if _, err := result.WriteTo(bw); err != nil {
+		log.Printf("session %s: writing result: %v", id, err)
+		return
+	}
+	bw.Flush()
+}
+
+func (s *inferenceServer) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	_, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown session %q", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkShape 检查输入分片与模型的输入维数一致、密文的层数够计算，避免在密文计算中途出错
+func (s *inferenceServer) checkShape(ciphertextTensor *encryption.CiphertextTensor) error {
+	if seqLength := s.dashModelParam.Shape.SeqLength; ciphertextTensor.NumCols != seqLength {
+		return fmt.Errorf("the sequences must be padded to %d positions, got %d", seqLength, ciphertextTensor.NumCols)
+	}
+	if inputDepth := s.dashModelParam.Shape.VocabSize; ciphertextTensor.NumDepth != inputDepth {
+		return fmt.Errorf("the tokens must be one-hot encoded with depth %d, got %d", inputDepth, ciphertextTensor.NumDepth)
+	}
+	if slots := ciphertextTensor.NumRows * ciphertextTensor.NumCols; ciphertextTensor.NumRows <= 0 || slots > s.params.MaxSlots() {
+		return fmt.Errorf("%d sequences do not fit in %d slots", ciphertextTensor.NumRows, s.params.MaxSlots())
+	}
+	// 层数不够的密文会占用计算直到中途出错
+	need := s.eval.inputLevel(s.params)
+	for i, ct := range ciphertextTensor.Ciphertexts {
+		if err := encryption.CheckLevel(fmt.Sprintf("ciphertext %d", i), ct, need); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newSessionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// runServe 启动 HTTP 推理服务，服务端只持有客户端上传的计算密钥
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags := config.AddFlags(fs)
+	addr := fs.String("addr", ":8080", "address to listen on; the API has no authentication, expose it to trusted clients only")
+	maxShards := fs.Int("max-shards", 16, "largest number of shards in one evaluation request")
+	maxSessions := fs.Int("max-sessions", 16, "largest number of sessions held at once, each holds the evaluation keys of a client")
+	sessionTTL := fs.Duration("session-ttl", time.Hour, "drop the sessions unused for this long, 0 to keep them until DELETE")
+	fs.Parse(args)
+	if *maxShards < 1 || *maxSessions < 1 || *sessionTTL < 0 {
+		return fmt.Errorf("-max-shards and -max-sessions must be positive and -session-ttl not negative")
+	}
+	cfg, err := loadConfig(flags, config.ModelDir)
+	if err != nil {
+		return err
+	}
+
+	params, err := presetParams(cfg.Preset)
+	if err != nil {
+		return err
+	}
+	server, err := newInferenceServer(params, cfg, *maxShards, *maxSessions, *sessionTTL)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("dashformer serving on %s without authentication, for trusted clients only", *addr)
+	log.Printf("  - requests up to %d MB of evaluation keys and %d MB of ciphertexts, %d sessions idle for at most %s",
+		server.limits.maxKeysBytes/1048576, server.limits.maxBatchBytes/1048576, *maxSessions, *sessionTTL)
+	return http.ListenAndServe(*addr, server.handler())
+}
+
+// evalErrorStatus 返回密文计算出错时的 HTTP 状态码：密文形状或层数不对、会话的密钥缺少旋转密钥是客户端的问题，
+// 超过 timeout 返回 503
+func evalErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, encryption.ErrShapeMismatch) || errors.Is(err, encryption.ErrMissingRotationKey) || errors.Is(err, encryption.ErrLevelExhausted):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dashformer/encryption"
+	"dashformer/utils"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+)
+
+func TestServerRejectsLowLevelCiphertexts(t *testing.T) {
+	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{LogN: 12, LogQ: []int{40, 30, 30}, LogP: []int{40}, LogDefaultScale: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kgen := rlwe.NewKeyGenerator(params)
+	_, pk := kgen.GenKeyPairNew()
+	publicKeys := encryption.NewPublicParametersKeys(params, pk, nil)
+
+	shape := utils.ModelShape{SeqLength: 2, VocabSize: 3}
+	s := &inferenceServer{
+		params:         params,
+		dashModelParam: utils.DashformerModelParameters{Shape: shape},
+		eval:           &evaluation{mode: "unfolded"},
+		limits:         serverLimits{maxKeysBytes: 1 << 20, maxBatchBytes: 1 << 24, maxSessions: 1, sessionTTL: time.Hour},
+		sessions:       map[string]*session{"s": {keys: publicKeys, lastUsed: time.Now()}},
+		evalSem:        make(chan struct{}, 1),
+	}
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	shard, err := encryption.EncryptTensorValue(publicKeys, [][][]float64{{{1, 0, 0}, {0, 1, 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// encrypt 加密在最高层，unfolded 计算需要全部层数
+	if err := s.checkShape(shard); err != nil {
+		t.Fatalf("ciphertexts at the top level: %v", err)
+	}
+	for _, ct := range shard.Ciphertexts {
+		publicKeys.Evaluator.DropLevel(ct, 1)
+	}
+	batch := &encryption.CiphertextBatch{Shards: []*encryption.CiphertextTensor{shard}}
+	if err := batch.SetParameters(params); err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	if _, err := batch.WriteTo(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(srv.URL+"/sessions/s/eval", "application/octet-stream", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var msg bytes.Buffer
+	msg.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(msg.String(), "level") {
+		t.Errorf("got %s %q, want 400 and a level error", resp.Status, msg.String())
+	}
+	// 计算中途层数不够也是客户端的问题
+	if status := evalErrorStatus(&encryption.LevelError{Op: "rescale", Level: 0, Need: 1}); status != http.StatusBadRequest {
+		t.Errorf("level error answers %d, want 400", status)
+	}
+	// 拒绝的请求不占用计算
+	if len(s.evalSem) != 0 {
+		t.Error("the evaluation slot is taken")
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+// TransformerBlock 是一个 Transformer 块的参数：多头注意力、combine、LayerNorm1、FFN 和 LayerNorm2，
+// 字段和 DashformerModelParameters 中的同名字段相同
+type TransformerBlock struct {
+	QueryWeightAttentionMatrixs [][][]float64
+	QueryBiasAttentionVectors   [][]float64
+	KeyWeightAttentionMatrixs   [][][]float64
+	KeyBiasAttentionVectors     [][]float64
+	ValueWeightAttentionMatrixs [][][]float64
+	ValueBiasAttentionVectors   [][]float64
+
+	CombineWeightMatrixs [][]float64
+	CombineBiasVectors   []float64
+
+	LayerNormVectorR1 []float64
+	LayerNormVectorB1 []float64
+	LayerNormVectorR2 []float64
+	LayerNormVectorB2 []float64
+
+	FeedForwardWeightMatrix1 [][]float64
+	FeedForwardBiasVector1   []float64
+	FeedForwardWeightMatrix2 [][]float64
+	FeedForwardBiasVector2   []float64
+
+	LayerNormSqrtVariance1 []float64
+	LayerNormSqrtVariance2 []float64
+
+	SoftMaxB []float64
+	SoftMaxC []float64
+}
+
+// NumBlocks 返回模型的 Transformer 块数：第一个块在 DashformerModelParameters 的字段中，其余在 Layers 中
+func (d *DashformerModelParameters) NumBlocks() int {
+	return 1 + len(d.Layers)
+}
+
+// Block 返回第 i 个 Transformer 块 (从 0 开始)
+func (d *DashformerModelParameters) Block(i int) TransformerBlock {
+	if i > 0 {
+		return d.Layers[i-1]
+	}
+	return TransformerBlock{
+		QueryWeightAttentionMatrixs: d.QueryWeightAttentionMatrixs,
+		QueryBiasAttentionVectors:   d.QueryBiasAttentionVectors,
+		KeyWeightAttentionMatrixs:   d.KeyWeightAttentionMatrixs,
+		KeyBiasAttentionVectors:     d.KeyBiasAttentionVectors,
+		ValueWeightAttentionMatrixs: d.ValueWeightAttentionMatrixs,
+		ValueBiasAttentionVectors:   d.ValueBiasAttentionVectors,
+
+		CombineWeightMatrixs: d.CombineWeightMatrixs,
+		CombineBiasVectors:   d.CombineBiasVectors,
+
+		LayerNormVectorR1: d.LayerNormVectorR1,
+		LayerNormVectorB1: d.LayerNormVectorB1,
+		LayerNormVectorR2: d.LayerNormVectorR2,
+		LayerNormVectorB2: d.LayerNormVectorB2,
+
+		FeedForwardWeightMatrix1: d.FeedForwardWeightMatrix1,
+		FeedForwardBiasVector1:   d.FeedForwardBiasVector1,
+		FeedForwardWeightMatrix2: d.FeedForwardWeightMatrix2,
+		FeedForwardBiasVector2:   d.FeedForwardBiasVector2,
+
+		LayerNormSqrtVariance1: d.LayerNormSqrtVariance1,
+		LayerNormSqrtVariance2: d.LayerNormSqrtVariance2,
+
+		SoftMaxB: d.SoftMaxB,
+		SoftMaxC: d.SoftMaxC,
+	}
+}
+
+// SetBlock 设置第 i 个 Transformer 块，i 等于 NumBlocks() 时在最后添加一个块
+func (d *DashformerModelParameters) SetBlock(i int, b TransformerBlock) {
+	if i > 0 {
+		if i-1 == len(d.Layers) {
+			d.Layers = append(d.Layers, b)
+		} else {
+			d.Layers[i-1] = b
+		}
+		return
+	}
+	d.QueryWeightAttentionMatrixs, d.QueryBiasAttentionVectors = b.QueryWeightAttentionMatrixs, b.QueryBiasAttentionVectors
+	d.KeyWeightAttentionMatrixs, d.KeyBiasAttentionVectors = b.KeyWeightAttentionMatrixs, b.KeyBiasAttentionVectors
+	d.ValueWeightAttentionMatrixs, d.ValueBiasAttentionVectors = b.ValueWeightAttentionMatrixs, b.ValueBiasAttentionVectors
+	d.CombineWeightMatrixs, d.CombineBiasVectors = b.CombineWeightMatrixs, b.CombineBiasVectors
+	d.LayerNormVectorR1, d.LayerNormVectorB1 = b.LayerNormVectorR1, b.LayerNormVectorB1
+	d.LayerNormVectorR2, d.LayerNormVectorB2 = b.LayerNormVectorR2, b.LayerNormVectorB2
+	d.FeedForwardWeightMatrix1, d.FeedForwardBiasVector1 = b.FeedForwardWeightMatrix1, b.FeedForwardBiasVector1
+	d.FeedForwardWeightMatrix2, d.FeedForwardBiasVector2 = b.FeedForwardWeightMatrix2, b.FeedForwardBiasVector2
+	d.LayerNormSqrtVariance1, d.LayerNormSqrtVariance2 = b.LayerNormSqrtVariance1, b.LayerNormSqrtVariance2
+	d.SoftMaxB, d.SoftMaxC = b.SoftMaxB, b.SoftMaxC
+}
@@ -1,21 +1,27 @@
 package utils
 
 import (
+	"dashformer/config"
 	"fmt"
+	"math"
 	"reflect"
 )
 
-// 定义 DashformerModelParameters 结构体
+// 定义 DashformerModelParameters 结构体，注意力参数每个头一个矩阵 (向量)；
+// 注意力到 LayerNorm2 的字段是第一个 Transformer 块，其余的块在 Layers 中 (见 Block)
 type DashformerModelParameters struct {
+	// 模型的维数 (见 ReadModelShape)
+	Shape ModelShape
+
 	EmbeddingMatrix [][]float64
 	EncodingMatrix  [][]float64
 
-	QueryWeightAttentionMatrixs [4][][]float64
-	QueryBiasAttentionVectors   [4][]float64
-	KeyWeightAttentionMatrixs   [4][][]float64
-	KeyBiasAttentionVectors     [4][]float64
-	ValueWeightAttentionMatrixs [4][][]float64
-	ValueBiasAttentionVectors   [4][]float64
+	QueryWeightAttentionMatrixs [][][]float64
+	QueryBiasAttentionVectors   [][]float64
+	KeyWeightAttentionMatrixs   [][][]float64
+	KeyBiasAttentionVectors     [][]float64
+	ValueWeightAttentionMatrixs [][][]float64
+	ValueBiasAttentionVectors   [][]float64
 
 	CombineWeightMatrixs [][]float64
 	CombineBiasVectors   []float64
@@ -40,8 +46,17 @@ type DashformerModelParameters struct {
 	LayerNormSqrtVariance1 []float64
 	LayerNormSqrtVariance2 []float64
 
-	SoftMaxB [4]float64
-	SoftMaxC [4]float64
+	SoftMaxB []float64
+	SoftMaxC []float64
+
+	// 第 2 个起的 Transformer 块，Shape.Blocks 大于 1 时才有
+	Layers []TransformerBlock
+
+	// 分类层系数缩小的倍数，解密后的结果乘以它得到 logits；0 表示使用 LogitBound (见 ResultScale)
+	OutputScale float64
+
+	// LayerNorm1 和 LayerNorm2 中 1/sqrt(variance) 的计算方式，所有块相同；零值使用 LayerNormSqrtVariance1/2 (见 SetLayerNorm)
+	LayerNormInvSqrt [2]InvSqrt
 }
 
 // 生成 embeddingMatrix 的赋值函数
@@ -55,32 +70,32 @@ func (d *DashformerModelParameters) SetEncodingMatrix(value [][]float64) {
 }
 
 // 生成 queryWeightAttentionMatrixs 的赋值函数
-func (d *DashformerModelParameters) SetQueryWeightAttentionMatrixs(value [4][][]float64) {
+func (d *DashformerModelParameters) SetQueryWeightAttentionMatrixs(value [][][]float64) {
 	d.QueryWeightAttentionMatrixs = value
 }
 
 // 生成 queryBiasAttentionVectors 的赋值函数
-func (d *DashformerModelParameters) SetQueryBiasAttentionVectors(value [4][]float64) {
+func (d *DashformerModelParameters) SetQueryBiasAttentionVectors(value [][]float64) {
 	d.QueryBiasAttentionVectors = value
 }
 
 // 生成 keyWeightAttentionMatrixs 的赋值函数
-func (d *DashformerModelParameters) SetKeyWeightAttentionMatrixs(value [4][][]float64) {
+func (d *DashformerModelParameters) SetKeyWeightAttentionMatrixs(value [][][]float64) {
 	d.KeyWeightAttentionMatrixs = value
 }
 
 // 生成 keyBiasAttentionVectors 的赋值函数
-func (d *DashformerModelParameters) SetKeyBiasAttentionVectors(value [4][]float64) {
+func (d *DashformerModelParameters) SetKeyBiasAttentionVectors(value [][]float64) {
 	d.KeyBiasAttentionVectors = value
 }
 
 // 生成 valueWeightAttentionMatrixs 的赋值函数
-func (d *DashformerModelParameters) SetValueWeightAttentionMatrixs(value [4][][]float64) {
+func (d *DashformerModelParameters) SetValueWeightAttentionMatrixs(value [][][]float64) {
 	d.ValueWeightAttentionMatrixs = value
 }
 
 // 生成 valueBiasAttentionVectors 的赋值函数
-func (d *DashformerModelParameters) SetValueBiasAttentionVectors(value [4][]float64) {
+func (d *DashformerModelParameters) SetValueBiasAttentionVectors(value [][]float64) {
 	d.ValueBiasAttentionVectors = value
 }
 
@@ -145,6 +160,56 @@ func (d *DashformerModelParameters) SetClassifierBiasVector(value []float64) {
 }
 
 // 获取字段的维数
+// 生成近似系数的赋值函数，用配置中的系数替换模型的系数，配置中为空的系数保持不变
+func (d *DashformerModelParameters) SetApproximationCoefficients(value config.Coefficients) {
+	if len(value.Relu) > 0 {
+		d.ReluCoefficients = value.Relu
+	}
+	if len(value.SqrtLayer1) > 0 {
+		d.SqrtLayerCoefficients1 = value.SqrtLayer1
+	}
+	if len(value.SqrtLayer2) > 0 {
+		d.SqrtLayerCoefficients2 = value.SqrtLayer2
+	}
+	// softmax 的常数用于每个块
+	if len(value.SoftMaxB) > 0 {
+		d.SoftMaxB = value.SoftMaxB
+		d.SoftMaxC = value.SoftMaxC
+		for i := range d.Layers {
+			d.Layers[i].SoftMaxB = value.SoftMaxB
+			d.Layers[i].SoftMaxC = value.SoftMaxC
+		}
+	}
+}
+
+// LogitBound 返回 logits 绝对值的上界：最后一个块的 LayerNorm2 归一化后每个位置的向量长度为 sqrt(d)，
+// 由 Cauchy-Schwarz，第 c 类每个位置的贡献不超过 sqrt(d)*|gamma⊙W[:,c]| + |beta·W[:,c]|，
+// 对所有位置求和后加上 |bias[c]|，取各类的最大值
+func (d *DashformerModelParameters) LogitBound() float64 {
+	last := d.Block(d.NumBlocks() - 1)
+	seqLength, embedDim := len(d.EncodingMatrix), len(last.LayerNormVectorR2)
+	bound := 0.0
+	for c := range d.ClassifierBiasVector {
+		norm, shift := 0.0, 0.0
+		for j := 0; j < embedDim; j++ {
+			w := d.ClassifierWeightMatrix[j][c]
+			norm += last.LayerNormVectorR2[j] * last.LayerNormVectorR2[j] * w * w
+			shift += last.LayerNormVectorB2[j] * w
+		}
+		classBound := float64(seqLength)*(math.Sqrt(float64(embedDim)*norm)+math.Abs(shift)) + math.Abs(d.ClassifierBiasVector[c])
+		bound = math.Max(bound, classBound)
+	}
+	return bound
+}
+
+// ResultScale 返回分类层系数缩小的倍数：OutputScale，未设置时为 LogitBound，使密文中的 logits 落在 [-1, 1] 内
+func (d *DashformerModelParameters) ResultScale() float64 {
+	if d.OutputScale > 0 {
+		return d.OutputScale
+	}
+	return d.LogitBound()
+}
+
 func getDimensions(value reflect.Value) []int {
 	var dimensions []int
 
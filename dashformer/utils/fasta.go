@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FASTA 文件的扩展名
+var fastaExtensions = map[string]bool{".fa": true, ".fasta": true, ".faa": true, ".fas": true}
+
+// ReadSequences 读取输入序列并按 policy 编码为模型的输入。
+// FASTA 文件同时返回每条记录的 ID，比赛格式 (见 ReadExampleData) 没有 ID
+func ReadSequences(filePath string, tokenizer map[string]int, policy SequencePolicy) (*Sequences, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ids []string
+	var residues [][]string
+	reader := bufio.NewReader(file)
+	if isFasta(filePath, reader) {
+		ids, residues, err = readFastaResidues(reader)
+	} else {
+		residues, err = readExampleResidues(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSequences(ids, residues, tokenizer, policy)
+}
+
+// isFasta 按扩展名判断，其他扩展名的文件以 '>' 或 ';' 开头时也按 FASTA 读取
+func isFasta(filePath string, reader *bufio.Reader) bool {
+	if fastaExtensions[strings.ToLower(filepath.Ext(filePath))] {
+		return true
+	}
+	first, err := reader.Peek(1)
+	return err == nil && (first[0] == '>' || first[0] == ';')
+}
+
+// ReadFasta 读取 FASTA 格式的蛋白质序列，返回记录 ID 和 one-hot 编码的三维张量，不补齐、不截断，未知残基报错
+func ReadFasta(r io.Reader, tokenizer map[string]int) ([]string, [][][]float64, error) {
+	ids, residues, err := readFastaResidues(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	sequences, err := EncodeSequences(ids, residues, tokenizer, SequencePolicy{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ids, sequences.Data, nil
+}
+
+// readFastaResidues 读取 FASTA 记录的 ID 和残基。
+// 记录 ID 是 '>' 之后第一个空白之前的部分，序列可以分成多行，';' 开头的注释行、空行和结尾的 '*' 被忽略
+func readFastaResidues(r io.Reader) ([]string, [][]string, error) {
+	var ids []string
+	var residues [][]string
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, ">") {
+			fields := strings.Fields(line[1:])
+			if len(fields) == 0 {
+				return nil, nil, fmt.Errorf("line %d: FASTA header without a record ID", lineNum)
+			}
+			if n := len(residues); n > 0 && len(residues[n-1]) == 0 {
+				return nil, nil, fmt.Errorf("record %s has no residues", ids[n-1])
+			}
+			ids = append(ids, fields[0])
+			residues = append(residues, []string{})
+			continue
+		}
+		if len(ids) == 0 {
+			return nil, nil, fmt.Errorf("line %d: residues before the first FASTA header", lineNum)
+		}
+
+		n := len(residues) - 1
+		for _, residue := range strings.TrimSuffix(line, "*") {
+			if residue == ' ' || residue == '\t' {
+				continue
+			}
+			residues[n] = append(residues[n], string(residue))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("no FASTA record")
+	}
+	if n := len(residues); len(residues[n-1]) == 0 {
+		return nil, nil, fmt.Errorf("record %s has no residues", ids[n-1])
+	}
+	return ids, residues, nil
+}
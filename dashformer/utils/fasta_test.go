@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadFasta(t *testing.T) {
+	tokenizer := map[string]int{"a": 1, "c": 2, "d": 3}
+	input := `; comment
+>sp|P1|FIRST first record
+ACD
+da*
+
+>P2
+c
+`
+	ids, data, err := ReadFasta(strings.NewReader(input), tokenizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sp|P1|FIRST", "P2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got IDs %v, want %v", ids, want)
+	}
+	if len(data) != 2 || len(data[0]) != 5 || len(data[1]) != 1 {
+		t.Fatalf("unexpected sequence lengths")
+	}
+	// 第 4 个残基是 d，one-hot 向量长度为词表大小加 1
+	if want := []float64{0, 0, 0, 1}; !reflect.DeepEqual(data[0][3], want) {
+		t.Errorf("got %v, want %v", data[0][3], want)
+	}
+
+	for _, bad := range []string{"ACD\n", ">\nACD\n", ">P1\n>P2\nA\n", ">P1\nAXD\n", ""} {
+		if _, _, err := ReadFasta(strings.NewReader(bad), tokenizer); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+
+	// 比赛格式没有 ID
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "sequences.list")
+	if err := os.WriteFile(listFile, []byte("a c d,1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sequences, err := ReadSequences(listFile, tokenizer, SequencePolicy{})
+	if err != nil || sequences.IDs != nil || sequences.RowIDs() != nil || len(sequences.Data) != 1 {
+		t.Errorf("list format: got %v, %v", sequences, err)
+	}
+	fastaFile := filepath.Join(dir, "sequences.txt")
+	if err := os.WriteFile(fastaFile, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if sequences, err = ReadSequences(fastaFile, tokenizer, SequencePolicy{}); err != nil || len(sequences.IDs) != 2 {
+		t.Errorf("FASTA without extension: got %v, %v", sequences, err)
+	}
+}
+
+func TestSequencePolicy(t *testing.T) {
+	tokenizer := map[string]int{"a": 1, "c": 2, "d": 3, "x": 4}
+	ids := []string{"short", "exact", "long", "unknown"}
+	residues := [][]string{
+		{"a", "c"},
+		{"a", "c", "d", "a"},
+		{"a", "c", "d", "a", "c", "d", "a"},
+		{"a", "B", "z", "u"},
+	}
+	// 长度 4，窗口步长 2：长序列的窗口从 0、2、3 开始
+	policy := SequencePolicy{Length: 4, Long: LongWindow, Stride: 2, OOV: "x"}
+	sequences, err := EncodeSequences(ids, residues, tokenizer, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{0, 1, 2, 2, 2, 3}; !reflect.DeepEqual(sequences.Origin, want) {
+		t.Fatalf("got origins %v, want %v", sequences.Origin, want)
+	}
+	if want := []string{"short", "exact", "long/1-4", "long/3-6", "long/4-7", "unknown"}; !reflect.DeepEqual(sequences.RowIDs(), want) {
+		t.Errorf("got row IDs %v, want %v", sequences.RowIDs(), want)
+	}
+	for i, row := range sequences.Data {
+		if len(row) != 4 {
+			t.Errorf("row %d has length %d", i, len(row))
+		}
+	}
+	// 补齐的位置是编号 0，未知残基是 x 的编号
+	if sequences.Data[0][3][0] != 1 || sequences.Data[5][1][4] != 1 || sequences.Data[5][3][4] != 1 {
+		t.Error("padding or unknown residues not encoded as expected")
+	}
+	if len(sequences.Warnings) != 3 {
+		t.Errorf("got warnings %q, want 3", sequences.Warnings)
+	}
+
+	policy.Long = LongTruncate
+	if sequences, err = EncodeSequences(ids, residues, tokenizer, policy); err != nil || len(sequences.Data) != 4 || sequences.RowIDs()[2] != "long" {
+		t.Errorf("truncate: got %v, %v", sequences, err)
+	}
+	policy.Long = LongError
+	if _, err := EncodeSequences(ids, residues, tokenizer, policy); err == nil || !strings.Contains(err.Error(), "record long") {
+		t.Errorf("error policy: got %v", err)
+	}
+	policy.Long, policy.OOV = LongTruncate, ""
+	if _, err := EncodeSequences(ids, residues, tokenizer, policy); err == nil || !strings.Contains(err.Error(), "record unknown") {
+		t.Errorf("no OOV residue: got %v", err)
+	}
+}
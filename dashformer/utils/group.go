@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// workers 是所有 Group 共享的工作 goroutine 池，同时运行的 goroutine 最多为池的大小
+var workers atomic.Pointer[chan struct{}]
+
+func init() {
+	SetWorkers(runtime.NumCPU())
+}
+
+// SetWorkers 设置工作 goroutine 池的大小，n <= 0 时使用 CPU 核数。
+// 不修改 GOMAXPROCS，已经在运行的 goroutine 仍占用原来的池
+func SetWorkers(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	slots := make(chan struct{}, n)
+	workers.Store(&slots)
+}
+
+// Workers 返回工作 goroutine 池的大小
+func Workers() int {
+	return cap(*workers.Load())
+}
+
+// Group 等待一组 goroutine 结束并返回第一个错误，用法和 golang.org/x/sync/errgroup 相同。
+// goroutine 中的 panic 也作为错误返回，不会让整个进程退出。
+// goroutine 从共享的工作池中取得空位，池满时 f 在调用 Go 的 goroutine 中直接运行，
+// 因此嵌套的 Group 不会因为等待空位而死锁
+type Group struct {
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	errOnce sync.Once
+	err     error
+}
+
+// WithContext 返回一个新的 Group 和由 ctx 派生的 context，
+// 第一个 goroutine 出错或 Wait 返回时取消该 context，其余 goroutine 应检查它并尽早返回
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// Go 在工作池有空位时在新的 goroutine 中运行 f，否则直接运行 f
+func (g *Group) Go(f func() error) {
+	slots := *workers.Load()
+	select {
+	case slots <- struct{}{}:
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer func() { <-slots }()
+			g.run(f)
+		}()
+	default:
+		g.run(f)
+	}
+}
+
+func (g *Group) run(f func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			g.setError(fmt.Errorf("panic: %v", r))
+		}
+	}()
+	if err := f(); err != nil {
+		g.setError(err)
+	}
+}
+
+// Wait 等待所有 goroutine 结束，返回第一个错误
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return g.err
+}
+
+func (g *Group) setError(err error) {
+	g.errOnce.Do(func() {
+		g.err = err
+		if g.cancel != nil {
+			g.cancel()
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroupWorkers(t *testing.T) {
+	defer SetWorkers(0)
+	SetWorkers(2)
+	if Workers() != 2 {
+		t.Fatalf("got %d workers, want 2", Workers())
+	}
+
+	// 嵌套的 Group 在池满时直接运行，不会死锁；调用者自己也算一个 goroutine
+	var running, peak atomic.Int32
+	var outer Group
+	for i := 0; i < 8; i++ {
+		outer.Go(func() error {
+			var inner Group
+			for j := 0; j < 8; j++ {
+				inner.Go(func() error {
+					n := running.Add(1)
+					for {
+						p := peak.Load()
+						if n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					running.Add(-1)
+					return nil
+				})
+			}
+			return inner.Wait()
+		})
+	}
+	if err := outer.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if p := peak.Load(); p > int32(Workers())+1 {
+		t.Errorf("%d goroutines ran at the same time, want at most %d", p, Workers()+1)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 )
 
@@ -52,13 +53,29 @@ func ScaleVector(vector []float64, multiplier float64) []float64 {
 	return newVector
 }
 
-// 定义一个通用的函数，对向量中的每个元素乘以一个值
+// 定义一个通用的函数，对矩阵中的每个元素乘以一个值，返回新的矩阵，不修改 mat
 func ScaleMatrix(mat [][]float64, multiplier float64) [][]float64 {
-	//newMat := make([][]float64, len(mat))
-	for i := range len(mat) {
-		for j := range len(mat[0]) {
-			mat[i][j] = mat[i][j] * multiplier
-		}
+	newMat := make([][]float64, len(mat))
+	for i := range mat {
+		newMat[i] = ScaleVector(mat[i], multiplier)
+	}
+	return newMat
+}
+
+// Softmax 返回 x 的 softmax，先减去最大值避免溢出
+func Softmax(x []float64) []float64 {
+	maxValue := math.Inf(-1)
+	for _, v := range x {
+		maxValue = math.Max(maxValue, v)
+	}
+	result := make([]float64, len(x))
+	sum := 0.0
+	for i, v := range x {
+		result[i] = math.Exp(v - maxValue)
+		sum += result[i]
+	}
+	for i := range result {
+		result[i] /= sum
 	}
-	return mat
+	return result
 }
@@ -0,0 +1,40 @@
+package utils
+
+import "dashformer/config"
+
+// InvSqrt 选择 LayerNorm 中 1/sqrt(variance) 的计算方式：Coeffs 为空时使用模型中预先计算的 LayerNormSqrtVariance，
+// 否则在密文上计算方差 v，以多项式 Coeffs (单项式基，从 x^0 开始) 的值为初始值 y，再做 NewtonIterations 次牛顿迭代
+// y <- y(3 - v*y^2)/2
+type InvSqrt struct {
+	Coeffs           []float64
+	NewtonIterations int
+}
+
+// Encrypted 返回是否在密文上计算方差和 1/sqrt(variance)
+func (s InvSqrt) Encrypted() bool {
+	return len(s.Coeffs) > 0
+}
+
+// Eval 在明文上按密文计算的步骤近似 1/sqrt(v)：多项式初始值和牛顿迭代
+func (s InvSqrt) Eval(v float64) float64 {
+	y := 0.0
+	for k := len(s.Coeffs) - 1; k >= 0; k-- {
+		y = y*v + s.Coeffs[k]
+	}
+	for i := 0; i < s.NewtonIterations; i++ {
+		y = 0.5 * y * (3 - v*y*y)
+	}
+	return y
+}
+
+// SetLayerNorm 按配置选择 LayerNorm1 和 LayerNorm2 的计算方式 (LayerNormInvSqrt)，
+// encrypted 使用 SqrtLayerCoefficients1/2，须在 SetApproximationCoefficients 之后调用
+func (d *DashformerModelParameters) SetLayerNorm(value config.LayerNorm) {
+	d.LayerNormInvSqrt = [2]InvSqrt{}
+	if value.Layer1 == "encrypted" {
+		d.LayerNormInvSqrt[0] = InvSqrt{Coeffs: d.SqrtLayerCoefficients1, NewtonIterations: value.NewtonIterations}
+	}
+	if value.Layer2 == "encrypted" {
+		d.LayerNormInvSqrt[1] = InvSqrt{Coeffs: d.SqrtLayerCoefficients2, NewtonIterations: value.NewtonIterations}
+	}
+}
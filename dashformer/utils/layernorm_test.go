@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"dashformer/config"
+	"math"
+	"testing"
+)
+
+func TestInvSqrt(t *testing.T) {
+	coefficients := config.DefaultCoefficients()
+
+	// 发布模型的 sqrt_layer1 多项式在 [15, 100] 上的相对误差约 2.4%，每次牛顿迭代把相对误差 e 变为约 1.5e^2
+	for iterations, bound := range []float64{3e-2, 1e-3, 1e-5} {
+		s := InvSqrt{Coeffs: coefficients.SqrtLayer1, NewtonIterations: iterations}
+		for v := 15.0; v <= 100; v += 0.5 {
+			if relError := math.Abs(s.Eval(v)*math.Sqrt(v) - 1); relError > bound {
+				t.Fatalf("%d iterations: relative error %g at variance %g, want <= %g", iterations, relError, v, bound)
+			}
+		}
+	}
+
+	var d DashformerModelParameters
+	d.SqrtLayerCoefficients1, d.SqrtLayerCoefficients2 = coefficients.SqrtLayer1, coefficients.SqrtLayer2
+	d.SetLayerNorm(config.LayerNorm{Layer1: "precomputed", Layer2: "encrypted", NewtonIterations: 2})
+	if d.LayerNormInvSqrt[0].Encrypted() || !d.LayerNormInvSqrt[1].Encrypted() || d.LayerNormInvSqrt[1].NewtonIterations != 2 {
+		t.Errorf("got %+v, want layernorm2 only encrypted with 2 iterations", d.LayerNormInvSqrt)
+	}
+	if d.LayerNormInvSqrt[1].Coeffs[0] != coefficients.SqrtLayer2[0] {
+		t.Error("layernorm2 should use the sqrt_layer2 coefficients")
+	}
+}
@@ -0,0 +1,585 @@
+package utils
+
+import (
+	"dashformer/config"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ModelManifestName 是模型目录中描述模型的文件，没有这个文件时使用 DefaultModelManifest(DefaultModelShape())
+const ModelManifestName = "model.json"
+
+// ModelManifest 是 model.json 的内容：模型的维数和每个参数张量的来源
+type ModelManifest struct {
+	Shape   ModelShape   `json:"shape"`
+	Tensors []TensorSpec `json:"tensors"`
+}
+
+// TensorSpec 描述一个参数张量：从 File 的第 Offset 行 (从 0 开始) 起读取，或直接由 Values 给出。
+// 矩阵每行一行，向量每个值一行
+type TensorSpec struct {
+	// 张量的用途，见 TensorRoles
+	Role   string    `json:"role"`
+	File   string    `json:"file,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Shape  []int     `json:"shape"`
+	DType  string    `json:"dtype,omitempty"`
+	Values []float64 `json:"values,omitempty"`
+}
+
+// 张量的数据类型，float32 的值读取后舍入到 float32 的精度
+const (
+	DTypeFloat64 = "float64"
+	DTypeFloat32 = "float32"
+)
+
+// tensorRole 是一种张量的期望维数 (0 表示任意长度)、发布模型中的位置，以及它在 DashformerModelParameters 中的字段
+type tensorRole struct {
+	role  string
+	shape func(s ModelShape) []int
+	// 发布模型中的文件和起始行；file 为空的近似常数默认值来自 config.DefaultCoefficients，
+	// 维数不符时 (例如头数不是 4) 须在清单或配置中给出
+	file   string
+	offset func(s ModelShape) int
+	// block 为 true 的张量每个 Transformer 块一个，set 写入 b；其余的张量写入 d
+	block bool
+	set   func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64)
+}
+
+// TensorRoles 返回 model.json 中可用的张量用途，按读取顺序排列；
+// 第 k 个 (从 0 开始) Transformer 块的张量在 k > 0 时加上前缀 "block<k>."，见 BlockRole
+func TensorRoles() []string {
+	roles := make([]string, len(tensorRoles))
+	for i, r := range tensorRoles {
+		roles[i] = r.role
+	}
+	return roles
+}
+
+var tensorRoles = []tensorRole{
+	{"embedding", func(s ModelShape) []int { return []int{s.VocabSize, s.EmbedDim} },
+		"embedding_Embedding_weights.txt", atLine(0), false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) { d.EmbeddingMatrix = m }},
+	{"positional_encoding", func(s ModelShape) []int { return []int{s.SeqLength, s.EmbedDim} },
+		"positional_encoding_Lookup.txt", atLine(0), false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) { d.EncodingMatrix = m }},
+
+	{"query_weight", attentionWeight, "transformer_block_Query_weights.txt", atLine(0), true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.QueryWeightAttentionMatrixs = splitHeads(m, d.Shape)
+		}},
+	{"query_bias", attentionBias, "transformer_block_Query_weights.txt", afterEmbedDim, true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.QueryBiasAttentionVectors = splitHeadVector(column(m), d.Shape)
+		}},
+	{"key_weight", attentionWeight, "transformer_block_Key_weights.txt", atLine(0), true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.KeyWeightAttentionMatrixs = splitHeads(m, d.Shape)
+		}},
+	{"key_bias", attentionBias, "transformer_block_Key_weights.txt", afterEmbedDim, true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.KeyBiasAttentionVectors = splitHeadVector(column(m), d.Shape)
+		}},
+	{"value_weight", attentionWeight, "transformer_block_Value_weights.txt", atLine(0), true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.ValueWeightAttentionMatrixs = splitHeads(m, d.Shape)
+		}},
+	{"value_bias", attentionBias, "transformer_block_Value_weights.txt", afterEmbedDim, true,
+		func(d *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.ValueBiasAttentionVectors = splitHeadVector(column(m), d.Shape)
+		}},
+
+	{"combine_weight", func(s ModelShape) []int { return []int{s.Heads * s.HeadSize, s.EmbedDim} },
+		"transformer_block_CombineHead_weights.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) { b.CombineWeightMatrixs = m }},
+	{"combine_bias", embedVector, "transformer_block_CombineHead_weights.txt", func(s ModelShape) int { return s.Heads * s.HeadSize }, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.CombineBiasVectors = column(m)
+		}},
+
+	{"layernorm1_gamma", embedVector, "transformer_block_LayerNorm1_weights.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormVectorR1 = column(m)
+		}},
+	{"layernorm1_beta", embedVector, "transformer_block_LayerNorm1_weights.txt", afterEmbedDim, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormVectorB1 = column(m)
+		}},
+	{"layernorm2_gamma", embedVector, "transformer_block_LayerNorm2_weights.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormVectorR2 = column(m)
+		}},
+	{"layernorm2_beta", embedVector, "transformer_block_LayerNorm2_weights.txt", afterEmbedDim, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormVectorB2 = column(m)
+		}},
+
+	{"ffn1_weight", func(s ModelShape) []int { return []int{s.EmbedDim, s.FFNDim} },
+		"transformer_block_FFN_weights.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) { b.FeedForwardWeightMatrix1 = m }},
+	{"ffn1_bias", func(s ModelShape) []int { return []int{s.FFNDim} },
+		"transformer_block_FFN_weights.txt", afterEmbedDim, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.FeedForwardBiasVector1 = column(m)
+		}},
+	{"ffn2_weight", func(s ModelShape) []int { return []int{s.FFNDim, s.EmbedDim} },
+		"transformer_block_FFN_weights.txt", func(s ModelShape) int { return s.EmbedDim + s.FFNDim }, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) { b.FeedForwardWeightMatrix2 = m }},
+	{"ffn2_bias", embedVector, "transformer_block_FFN_weights.txt", func(s ModelShape) int { return s.EmbedDim + 2*s.FFNDim }, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.FeedForwardBiasVector2 = column(m)
+		}},
+
+	{"layernorm1_inv_sqrt_variance", seqVector, "layerNorm1_Reciprocal_SqrtVariance.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormSqrtVariance1 = column(m)
+		}},
+	{"layernorm2_inv_sqrt_variance", seqVector, "layerNorm2_Reciprocal_SqrtVariance.txt", atLine(0), true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) {
+			b.LayerNormSqrtVariance2 = column(m)
+		}},
+
+	// softmax 的 b 和 c 每个头一个
+	{"softmax_b", headVector, "", nil, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) { b.SoftMaxB = column(m) }},
+	{"softmax_c", headVector, "", nil, true,
+		func(_ *DashformerModelParameters, b *TransformerBlock, m [][]float64) { b.SoftMaxC = column(m) }},
+
+	{"classifier_weight", func(s ModelShape) []int { return []int{s.EmbedDim, s.NumClasses} },
+		"Dense_Classifier_DenseClassifier_weights.txt", atLine(0), false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) { d.ClassifierWeightMatrix = m }},
+	{"classifier_bias", func(s ModelShape) []int { return []int{s.NumClasses} },
+		"Dense_Classifier_DenseClassifier_weights.txt", afterEmbedDim, false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) {
+			d.ClassifierBiasVector = column(m)
+		}},
+
+	// 近似常数：多项式系数从 x^0 开始，所有块共用
+	{"relu_coefficients", anyLength, "", nil, false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) { d.ReluCoefficients = column(m) }},
+	{"sqrt_layer1_coefficients", anyLength, "", nil, false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) {
+			d.SqrtLayerCoefficients1 = column(m)
+		}},
+	{"sqrt_layer2_coefficients", anyLength, "", nil, false,
+		func(d *DashformerModelParameters, _ *TransformerBlock, m [][]float64) {
+			d.SqrtLayerCoefficients2 = column(m)
+		}},
+}
+
+// modelTensor 是模型中的一个张量：tensorRole 用于第 block 个块时的名字和发布模型中的文件
+type modelTensor struct {
+	tensorRole
+	name  string
+	block int
+}
+
+// modelTensors 返回 shape 的所有张量：块之前的张量，每个块的张量 (按块排列)，块之后的张量
+func modelTensors(shape ModelShape) []modelTensor {
+	var tensors []modelTensor
+	expanded := false
+	for _, r := range tensorRoles {
+		if !r.block {
+			tensors = append(tensors, modelTensor{tensorRole: r, name: r.role})
+			continue
+		}
+		if expanded {
+			continue
+		}
+		expanded = true
+		for k := 0; k < shape.Blocks; k++ {
+			for _, br := range tensorRoles {
+				if br.block {
+					t := modelTensor{tensorRole: br, name: BlockRole(br.role, k), block: k}
+					t.file = blockFile(br.file, k)
+					tensors = append(tensors, t)
+				}
+			}
+		}
+	}
+	return tensors
+}
+
+// BlockRole 返回第 k 个 (从 0 开始) Transformer 块的张量用途：第一个块没有前缀，其余为 "block<k>.<role>"
+func BlockRole(role string, k int) string {
+	if k == 0 {
+		return role
+	}
+	return fmt.Sprintf("block%d.%s", k, role)
+}
+
+// blockFile 返回第 k 个块的张量在发布模型中的文件，和 Keras 给后面的层的命名一致：
+// transformer_block_Query_weights.txt 变为 transformer_block_<k>_Query_weights.txt，
+// layerNorm1_Reciprocal_SqrtVariance.txt 变为 transformer_block_<k>_layerNorm1_Reciprocal_SqrtVariance.txt
+func blockFile(file string, k int) string {
+	if k == 0 || file == "" {
+		return file
+	}
+	prefix := fmt.Sprintf("transformer_block_%d_", k)
+	if rest, ok := strings.CutPrefix(file, "transformer_block_"); ok {
+		return prefix + rest
+	}
+	return prefix + file
+}
+
+func attentionWeight(s ModelShape) []int { return []int{s.EmbedDim, s.Heads * s.HeadSize} }
+func attentionBias(s ModelShape) []int   { return []int{s.Heads * s.HeadSize} }
+func embedVector(s ModelShape) []int     { return []int{s.EmbedDim} }
+func seqVector(s ModelShape) []int       { return []int{s.SeqLength} }
+func headVector(s ModelShape) []int      { return []int{s.Heads} }
+func anyLength(s ModelShape) []int       { return []int{0} }
+
+func atLine(line int) func(s ModelShape) int { return func(ModelShape) int { return line } }
+func afterEmbedDim(s ModelShape) int         { return s.EmbedDim }
+
+// splitHeads 按列将 rows × (Heads*HeadSize) 的矩阵分给每个头
+func splitHeads(m [][]float64, shape ModelShape) [][][]float64 {
+	heads := make([][][]float64, shape.Heads)
+	for h := range heads {
+		heads[h] = make([][]float64, len(m))
+		for i := range m {
+			heads[h][i] = m[i][h*shape.HeadSize : (h+1)*shape.HeadSize]
+		}
+	}
+	return heads
+}
+
+// splitHeadVector 将长度为 Heads*HeadSize 的偏置分给每个头
+func splitHeadVector(v []float64, shape ModelShape) [][]float64 {
+	heads := make([][]float64, shape.Heads)
+	for h := range heads {
+		heads[h] = v[h*shape.HeadSize : (h+1)*shape.HeadSize]
+	}
+	return heads
+}
+
+// column 将每行一个值的矩阵转换为向量
+func column(m [][]float64) []float64 {
+	v := make([]float64, len(m))
+	for i := range m {
+		v[i] = m[i][0]
+	}
+	return v
+}
+
+// DefaultModelManifest 返回发布模型的文件布局：每种张量在原来的文件和位置，近似常数为 config.DefaultCoefficients
+// (维数和 shape 不符的近似常数不列出，softmax 的常数用于每个块)
+func DefaultModelManifest(shape ModelShape) ModelManifest {
+	coefficients := config.DefaultCoefficients()
+	values := map[string][]float64{
+		"relu_coefficients":        coefficients.Relu,
+		"sqrt_layer1_coefficients": coefficients.SqrtLayer1,
+		"sqrt_layer2_coefficients": coefficients.SqrtLayer2,
+		"softmax_b":                coefficients.SoftMaxB,
+		"softmax_c":                coefficients.SoftMaxC,
+	}
+
+	manifest := ModelManifest{Shape: shape}
+	for _, r := range modelTensors(shape) {
+		spec := TensorSpec{Role: r.name, Shape: r.shape(shape), DType: DTypeFloat64}
+		if r.file != "" {
+			spec.File, spec.Offset = r.file, r.offset(shape)
+		} else {
+			spec.Values = values[r.role]
+			spec.Shape = []int{len(spec.Values)}
+			if spec.check(r.shape(shape)) != nil {
+				continue
+			}
+		}
+		manifest.Tensors = append(manifest.Tensors, spec)
+	}
+	return manifest
+}
+
+// ReadModelManifest 读取 fileDir/ModelManifestName：缺少的维数取 DefaultModelShape 的值，
+// 没有列出的张量取 DefaultModelManifest 的位置；没有这个文件时返回发布模型的清单。
+// 返回的清单已通过 Validate
+func ReadModelManifest(fileDir string) (ModelManifest, error) {
+	shape := DefaultModelShape()
+	path := filepath.Join(fileDir, ModelManifestName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultModelManifest(shape), nil
+	}
+	if err != nil {
+		return ModelManifest{}, err
+	}
+
+	var manifest ModelManifest
+	manifest.Shape = shape
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ModelManifest{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if manifest, err = manifest.withDefaults(); err != nil {
+		return ModelManifest{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// withDefaults 检查维数，添加没有列出的张量 (发布模型中的位置) 并检查清单
+func (m ModelManifest) withDefaults() (ModelManifest, error) {
+	if err := m.Shape.Validate(); err != nil {
+		return ModelManifest{}, err
+	}
+
+	// 没有列出的张量使用发布模型中的位置，但维数 (例如 softmax_b 的头数) 须和 Shape 一致
+	listed := make(map[string]bool)
+	for _, spec := range m.Tensors {
+		listed[spec.Role] = true
+	}
+	tensors := append([]TensorSpec(nil), m.Tensors...)
+	for _, spec := range DefaultModelManifest(m.Shape).Tensors {
+		if !listed[spec.Role] {
+			tensors = append(tensors, spec)
+		}
+	}
+	m.Tensors = tensors
+	if err := m.Validate(); err != nil {
+		return ModelManifest{}, err
+	}
+	return m, nil
+}
+
+// PolynomialRoles 返回多项式系数的张量用途 (所有块共用，任意长度，从 x^0 开始)
+func PolynomialRoles() []string {
+	var roles []string
+	for _, r := range tensorRoles {
+		if !r.block && r.file == "" && r.shape(DefaultModelShape())[0] == 0 {
+			roles = append(roles, r.role)
+		}
+	}
+	return roles
+}
+
+/*
+ * SetManifestCoefficients
+ * Input:  模型目录 fileDir,多项式系数的用途 role (见 PolynomialRoles),系数 Slice[]
+ * Output: model.json 的路径,error
+ * Compute: 把 fileDir/ModelManifestName 中 role 的张量换成 values，见 SetManifestTensors
+ */
+func SetManifestCoefficients(fileDir, role string, values []float64) (string, error) {
+	if !slices.Contains(PolynomialRoles(), role) {
+		return "", fmt.Errorf("%q is not a polynomial, use %s", role, strings.Join(PolynomialRoles(), ", "))
+	}
+	return SetManifestTensors(fileDir, []TensorSpec{{Role: role, Shape: []int{len(values)}, DType: DTypeFloat64, Values: values}})
+}
+
+/*
+ * SetManifestTensors
+ * Input:  模型目录 fileDir,张量 specs
+ * Output: model.json 的路径,error
+ * Compute: 把 fileDir/ModelManifestName 中同一用途的张量换成 specs 中的张量 (没有时添加)，其余内容不变；
+ *          没有 model.json 时写入只含 specs 的清单 (其余张量取发布模型的位置)。写入前检查整个清单
+ */
+func SetManifestTensors(fileDir string, specs []TensorSpec) (string, error) {
+	path := filepath.Join(fileDir, ModelManifestName)
+	manifest := ModelManifest{Shape: DefaultModelShape()}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return path, fmt.Errorf("%s: %v", path, err)
+		}
+	case !os.IsNotExist(err):
+		return path, err
+	}
+
+	for _, spec := range specs {
+		replaced := false
+		for i := range manifest.Tensors {
+			if manifest.Tensors[i].Role == spec.Role {
+				manifest.Tensors[i], replaced = spec, true
+			}
+		}
+		if !replaced {
+			manifest.Tensors = append(manifest.Tensors, spec)
+		}
+	}
+	if _, err := manifest.withDefaults(); err != nil {
+		return path, fmt.Errorf("%s: %v", path, err)
+	}
+
+	data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return path, err
+	}
+	return path, os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadModelShape 返回 ReadModelManifest 读到的维数
+func ReadModelShape(fileDir string) (ModelShape, error) {
+	manifest, err := ReadModelManifest(fileDir)
+	return manifest.Shape, err
+}
+
+// Validate 检查每种张量 (近似常数可以不给出) 至多出现一次，维数和 Shape 一致，并且由文件或 Values 之一给出
+func (m ModelManifest) Validate() error {
+	var errs []string
+	specs := make(map[string]TensorSpec)
+	for _, spec := range m.Tensors {
+		if _, ok := specs[spec.Role]; ok {
+			errs = append(errs, fmt.Sprintf("tensor %s is listed twice", spec.Role))
+		}
+		specs[spec.Role] = spec
+	}
+
+	known := make(map[string]bool)
+	for _, r := range modelTensors(m.Shape) {
+		known[r.name] = true
+		spec, ok := specs[r.name]
+		if !ok && r.file == "" {
+			continue
+		}
+		if !ok {
+			errs = append(errs, fmt.Sprintf("tensor %s is missing", r.name))
+			continue
+		}
+		if err := spec.check(r.shape(m.Shape)); err != nil {
+			errs = append(errs, fmt.Sprintf("tensor %s: %v", r.name, err))
+		}
+	}
+	for _, spec := range m.Tensors {
+		if !known[spec.Role] {
+			errs = append(errs, fmt.Sprintf("unknown tensor role %q for %d blocks, want one of %s (with the prefix block<k>. for the block k > 0)",
+				spec.Role, m.Shape.Blocks, strings.Join(TensorRoles(), ", ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid model manifest:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// check 检查张量的维数 (want 中的 0 表示任意正长度)、数据类型和来源
+func (spec TensorSpec) check(want []int) error {
+	fits := len(spec.Shape) == len(want)
+	for i := 0; fits && i < len(want); i++ {
+		fits = spec.Shape[i] > 0 && (want[i] == 0 || spec.Shape[i] == want[i])
+	}
+	if !fits {
+		return fmt.Errorf("shape %v, the model shape needs %v", spec.Shape, want)
+	}
+	switch spec.DType {
+	case "", DTypeFloat64, DTypeFloat32:
+	default:
+		return fmt.Errorf("dtype must be float64 or float32, got %q", spec.DType)
+	}
+	if (spec.File == "") == (spec.Values == nil) {
+		return fmt.Errorf("give either file or values")
+	}
+	if spec.Offset < 0 {
+		return fmt.Errorf("offset must not be negative, got %d", spec.Offset)
+	}
+	if spec.Values != nil && len(spec.Values) != spec.size() {
+		return fmt.Errorf("%d values for shape %v", len(spec.Values), spec.Shape)
+	}
+	return nil
+}
+
+// size 返回张量的元素个数
+func (spec TensorSpec) size() int {
+	n := 1
+	for _, dim := range spec.Shape {
+		n *= dim
+	}
+	return n
+}
+
+// Files 返回清单中的参数文件，按第一次出现的顺序排列
+func (m ModelManifest) Files() []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, spec := range m.Tensors {
+		if spec.File != "" && !seen[spec.File] {
+			seen[spec.File] = true
+			files = append(files, spec.File)
+		}
+	}
+	return files
+}
+
+// read 读取张量，返回矩阵 (向量为每行一个值的矩阵)；lines 是 spec.File 的所有行
+func (spec TensorSpec) read(lines []string) ([][]float64, error) {
+	bitSize := 64
+	if spec.DType == DTypeFloat32 {
+		bitSize = 32
+	}
+	cols := 1
+	if len(spec.Shape) == 2 {
+		cols = spec.Shape[1]
+	}
+
+	if spec.Values != nil {
+		m := make([][]float64, spec.Shape[0])
+		for i := range m {
+			m[i] = make([]float64, cols)
+			for j := range m[i] {
+				m[i][j] = spec.Values[i*cols+j]
+				if bitSize == 32 {
+					m[i][j] = float64(float32(m[i][j]))
+				}
+			}
+		}
+		return m, nil
+	}
+
+	end := spec.Offset + spec.Shape[0]
+	if end > len(lines) {
+		return nil, fmt.Errorf("lines %d to %d, the file has %d lines", spec.Offset+1, end, len(lines))
+	}
+	return parseMatrix(lines[spec.Offset:end], cols, spec.Offset+1, bitSize)
+}
+
+// Load 按清单读取 fileDir 中的参数文件，错误信息给出出错的张量和文件。
+// 每个文件须恰好被它的张量读完，多出的行通常说明维数或位置写错了；清单中没有的近似常数为空
+func (m ModelManifest) Load(fileDir string) (DashformerModelParameters, error) {
+	dash := DashformerModelParameters{Shape: m.Shape}
+	specs := make(map[string]TensorSpec)
+	for _, spec := range m.Tensors {
+		specs[spec.Role] = spec
+	}
+
+	files := make(map[string][]string)
+	for _, name := range m.Files() {
+		lines, err := readLines(filepath.Join(fileDir, name))
+		if err != nil {
+			return dash, fmt.Errorf("reading %s: %v", filepath.Join(fileDir, name), err)
+		}
+		files[name] = lines
+	}
+
+	used := make(map[string]int)
+	blocks := make([]TransformerBlock, m.Shape.Blocks)
+	for _, r := range modelTensors(m.Shape) {
+		spec, ok := specs[r.name]
+		if !ok {
+			continue
+		}
+		values, err := spec.read(files[spec.File])
+		if err != nil {
+			if spec.File != "" {
+				return dash, fmt.Errorf("tensor %s (%s): %v", r.name, filepath.Join(fileDir, spec.File), err)
+			}
+			return dash, fmt.Errorf("tensor %s: %v", r.name, err)
+		}
+		if spec.File != "" {
+			used[spec.File] = max(used[spec.File], spec.Offset+spec.Shape[0])
+		}
+		r.set(&dash, &blocks[r.block], values)
+	}
+	for k, b := range blocks {
+		dash.SetBlock(k, b)
+	}
+
+	for _, name := range m.Files() {
+		if n := len(files[name]); n != used[name] {
+			return dash, fmt.Errorf("%s has %d lines, its tensors end at line %d", filepath.Join(fileDir, name), n, used[name])
+		}
+	}
+	return dash, nil
+}
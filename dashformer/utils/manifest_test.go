@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeModel 按清单在 dir 中写入参数文件，第 k 个张量的值为 k + 0.01*(元素序号)
+func writeModel(t *testing.T, dir string, manifest ModelManifest) {
+	lines := make(map[string][]string)
+	for k, spec := range manifest.Tensors {
+		if spec.File == "" {
+			continue
+		}
+		cols := 1
+		if len(spec.Shape) == 2 {
+			cols = spec.Shape[1]
+		}
+		for len(lines[spec.File]) < spec.Offset+spec.Shape[0] {
+			lines[spec.File] = append(lines[spec.File], "")
+		}
+		for i := 0; i < spec.Shape[0]; i++ {
+			row := make([]string, cols)
+			for j := range row {
+				row[j] = fmt.Sprint(float64(k) + 0.01*float64(i*cols+j))
+			}
+			lines[spec.File][spec.Offset+i] = strings.Join(row, " ")
+		}
+	}
+	for name, l := range lines {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Join(l, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeManifest(t *testing.T, dir string, manifest any) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ModelManifestName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModelManifest(t *testing.T) {
+	dir := t.TempDir()
+	shape := ModelShape{SeqLength: 6, VocabSize: 5, EmbedDim: 4, Heads: 2, HeadSize: 2, FFNDim: 3, NumClasses: 2, Blocks: 1}
+
+	// 只给出维数时使用发布模型的文件布局
+	writeModel(t, dir, DefaultModelManifest(shape))
+	writeManifest(t, dir, map[string]any{"shape": shape})
+	if err := CheckModelParameterFiles(dir); err != nil {
+		t.Fatal(err)
+	}
+	dash, err := ReadModelParameterFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dash.QueryWeightAttentionMatrixs) != 2 || len(dash.QueryWeightAttentionMatrixs[1]) != 4 || len(dash.QueryWeightAttentionMatrixs[1][0]) != 2 {
+		t.Fatalf("query weights %v", dash.QueryWeightAttentionMatrixs)
+	}
+	// query_weight 是第 2 个张量，第 1 个头取每行的后两列
+	if got := dash.QueryWeightAttentionMatrixs[1][0]; got[0] != 2.02 || got[1] != 2.03 {
+		t.Errorf("got %v, want [2.02 2.03]", got)
+	}
+	if len(dash.FeedForwardBiasVector2) != 4 || len(dash.LayerNormSqrtVariance2) != 6 || len(dash.ClassifierBiasVector) != 2 {
+		t.Errorf("got %d, %d and %d values", len(dash.FeedForwardBiasVector2), len(dash.LayerNormSqrtVariance2), len(dash.ClassifierBiasVector))
+	}
+	// 发布模型的 softmax 常数是 4 个头的，2 个头的模型不使用它们
+	if dash.SoftMaxB != nil || len(dash.ReluCoefficients) == 0 {
+		t.Errorf("softmax_b %v, relu coefficients %v", dash.SoftMaxB, dash.ReluCoefficients)
+	}
+
+	// 近似常数写在清单中，float32 的值舍入到 float32
+	writeManifest(t, dir, map[string]any{"shape": shape, "tensors": []TensorSpec{
+		{Role: "softmax_b", Shape: []int{2}, Values: []float64{1.1, 0.9}, DType: DTypeFloat32},
+		{Role: "relu_coefficients", Shape: []int{3}, Values: []float64{0.5, 0.25, 0.125}},
+	}})
+	if dash, err = ReadModelParameterFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{float64(float32(1.1)), float64(float32(0.9))}; !reflect.DeepEqual(dash.SoftMaxB, want) {
+		t.Errorf("softmax_b %v, want %v", dash.SoftMaxB, want)
+	}
+	if !reflect.DeepEqual(dash.ReluCoefficients, []float64{0.5, 0.25, 0.125}) {
+		t.Errorf("relu coefficients %v", dash.ReluCoefficients)
+	}
+
+	// 维数不符的错误给出张量
+	for _, tc := range []struct {
+		tensors []TensorSpec
+		want    string
+	}{
+		{[]TensorSpec{{Role: "ffn1_weight", File: "transformer_block_FFN_weights.txt", Shape: []int{4, 5}}}, "tensor ffn1_weight: shape [4 5], the model shape needs [4 3]"},
+		{[]TensorSpec{{Role: "softmax_c", Shape: []int{4}, Values: []float64{1, 2, 3, 4}}}, "tensor softmax_c: shape [4], the model shape needs [2]"},
+		{[]TensorSpec{{Role: "attention", Shape: []int{1}, Values: []float64{1}}}, `unknown tensor role "attention"`},
+		{[]TensorSpec{{Role: "relu_coefficients", Shape: []int{2}, Values: []float64{1}}}, "tensor relu_coefficients: 1 values for shape [2]"},
+	} {
+		writeManifest(t, dir, map[string]any{"shape": shape, "tensors": tc.tensors})
+		if err := CheckModelParameterFiles(dir); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want %q", err, tc.want)
+		}
+	}
+
+	// 文件中的值和清单不符时给出张量和文件
+	writeManifest(t, dir, map[string]any{"shape": shape, "tensors": []TensorSpec{
+		{Role: "classifier_bias", File: "Dense_Classifier_DenseClassifier_weights.txt", Offset: 3, Shape: []int{2}},
+	}})
+	if _, err := ReadModelParameterFile(dir); err == nil || !strings.Contains(err.Error(), "tensor classifier_bias") {
+		t.Errorf("got %v, want an error naming classifier_bias", err)
+	}
+}
+
+func TestModelManifestBlocks(t *testing.T) {
+	dir := t.TempDir()
+	shape := ModelShape{SeqLength: 6, VocabSize: 5, EmbedDim: 4, Heads: 2, HeadSize: 2, FFNDim: 3, NumClasses: 2, Blocks: 3}
+
+	// 后面的块在 transformer_block_<k>_ 开头的文件中
+	manifest := DefaultModelManifest(shape)
+	specs := make(map[string]TensorSpec)
+	for _, spec := range manifest.Tensors {
+		specs[spec.Role] = spec
+	}
+	for role, file := range map[string]string{
+		"query_weight":                        "transformer_block_Query_weights.txt",
+		"block1.query_weight":                 "transformer_block_1_Query_weights.txt",
+		"block2.ffn2_bias":                    "transformer_block_2_FFN_weights.txt",
+		"block2.layernorm1_inv_sqrt_variance": "transformer_block_2_layerNorm1_Reciprocal_SqrtVariance.txt",
+	} {
+		if specs[role].File != file {
+			t.Errorf("%s is in %q, want %q", role, specs[role].File, file)
+		}
+	}
+
+	writeModel(t, dir, manifest)
+	writeManifest(t, dir, map[string]any{"shape": shape, "tensors": []TensorSpec{
+		{Role: "block2.softmax_b", Shape: []int{2}, Values: []float64{1.5, 2.5}},
+	}})
+	dash, err := ReadModelParameterFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dash.NumBlocks() != 3 {
+		t.Fatalf("%d blocks, want 3", dash.NumBlocks())
+	}
+	for k := 0; k < 3; k++ {
+		b := dash.Block(k)
+		if len(b.QueryWeightAttentionMatrixs) != 2 || len(b.FeedForwardBiasVector2) != 4 || len(b.LayerNormSqrtVariance2) != 6 {
+			t.Errorf("block %d has the wrong shape", k)
+		}
+	}
+	// 每个块的张量不同
+	if q0, q1 := dash.Block(0).QueryWeightAttentionMatrixs[0][0][0], dash.Block(1).QueryWeightAttentionMatrixs[0][0][0]; q0 == q1 {
+		t.Errorf("blocks 0 and 1 have the same query weight %v", q0)
+	}
+	if !reflect.DeepEqual(dash.Block(2).SoftMaxB, []float64{1.5, 2.5}) || dash.Block(1).SoftMaxB != nil {
+		t.Errorf("softmax_b %v and %v", dash.Block(1).SoftMaxB, dash.Block(2).SoftMaxB)
+	}
+
+	// 块 3 不存在
+	writeManifest(t, dir, map[string]any{"shape": shape, "tensors": []TensorSpec{
+		{Role: "block3.softmax_b", Shape: []int{2}, Values: []float64{1, 2}},
+	}})
+	if err := CheckModelParameterFiles(dir); err == nil || !strings.Contains(err.Error(), `unknown tensor role "block3.softmax_b"`) {
+		t.Errorf("got %v, want an error for block3.softmax_b", err)
+	}
+}
+
+func TestSetManifestCoefficients(t *testing.T) {
+	dir := t.TempDir()
+	if got := PolynomialRoles(); !reflect.DeepEqual(got, []string{"relu_coefficients", "sqrt_layer1_coefficients", "sqrt_layer2_coefficients"}) {
+		t.Errorf("polynomial roles %v", got)
+	}
+
+	// 没有 model.json 时写入只含系数的清单，其余张量取发布模型的位置
+	path, err := SetManifestCoefficients(dir, "relu_coefficients", []float64{0.5, 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := ReadModelManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Shape != DefaultModelShape() {
+		t.Errorf("shape %+v", manifest.Shape)
+	}
+	for _, spec := range manifest.Tensors {
+		if spec.Role == "relu_coefficients" && !reflect.DeepEqual(spec.Values, []float64{0.5, 0.25}) {
+			t.Errorf("relu_coefficients %+v", spec)
+		}
+	}
+
+	// 已有的清单只替换给出的张量
+	shape := ModelShape{SeqLength: 6, VocabSize: 5, EmbedDim: 4, Heads: 2, HeadSize: 2, FFNDim: 3, NumClasses: 2, Blocks: 1}
+	writeManifest(t, dir, map[string]any{"shape": shape, "tensors": []TensorSpec{
+		{Role: "softmax_b", Shape: []int{2}, Values: []float64{1.1, 0.9}},
+		{Role: "relu_coefficients", Shape: []int{1}, Values: []float64{1}},
+	}})
+	if _, err := SetManifestCoefficients(dir, "relu_coefficients", []float64{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetManifestCoefficients(dir, "sqrt_layer2_coefficients", []float64{0.3}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written ModelManifest
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Shape != shape || len(written.Tensors) != 3 {
+		t.Fatalf("written manifest %+v", written)
+	}
+	if got := written.Tensors[1]; got.Role != "relu_coefficients" || !reflect.DeepEqual(got.Values, []float64{1, 2, 3}) || !reflect.DeepEqual(got.Shape, []int{3}) {
+		t.Errorf("relu_coefficients %+v", got)
+	}
+
+	// 其他近似常数用 SetManifestTensors 写入
+	if _, err := SetManifestTensors(dir, []TensorSpec{
+		{Role: "softmax_b", Shape: []int{2}, Values: []float64{2, 2.5}},
+		{Role: "softmax_c", Shape: []int{2}, Values: []float64{30, 40}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	dash, err := ReadModelManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, spec := range dash.Tensors {
+		if spec.Role == "softmax_c" && !reflect.DeepEqual(spec.Values, []float64{30, 40}) {
+			t.Errorf("softmax_c %+v", spec)
+		}
+	}
+	if _, err := SetManifestTensors(dir, []TensorSpec{{Role: "block1.softmax_b", Shape: []int{2}, Values: []float64{1, 1}}}); err == nil {
+		t.Error("block1.softmax_b of a one-block model: no error")
+	}
+
+	// 只能写多项式系数，清单无效时不写入
+	if _, err := SetManifestCoefficients(dir, "softmax_b", []float64{1, 1}); err == nil {
+		t.Error("softmax_b: no error")
+	}
+	writeManifest(t, dir, map[string]any{"shape": ModelShape{}})
+	if _, err := SetManifestCoefficients(dir, "relu_coefficients", []float64{1}); err == nil {
+		t.Error("invalid shape: no error")
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import "math/rand"
+
+// RandomMatrix 返回 rows × cols 的矩阵，元素在 [-scale, scale] 上均匀分布
+func RandomMatrix(r *rand.Rand, rows, cols int, scale float64) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = RandomVector(r, cols, scale)
+	}
+	return m
+}
+
+// RandomVector 返回 n 维向量，元素在 [-scale, scale] 上均匀分布
+func RandomVector(r *rand.Rand, n int, scale float64) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = (2*r.Float64() - 1) * scale
+	}
+	return v
+}
+
+/*
+ * RandomModel
+ * Input:  随机数生成器 r,ModelShape,权重的范围 weightScale
+ * Output: DashformerModelParameters
+ * Compute: 生成维数为 shape 的随机参数，用于测试。shape.Blocks 个块的参数各不相同：
+ *          embedding 和位置编码在 [-0.5, 0.5] 上，权重在 [-weightScale, weightScale] 上，偏置在 [-0.1, 0.1] 上，
+ *          LayerNorm 的 R 在 [-1, 1] 上，1/sqrt(方差) 在 [0.5, 1.5] 上，softmax 常数 b 在 [1, 2] 上、c 在 [50, 100] 上，
+ *          ReLU 多项式为 0.2 + 0.5x + 0.1x^2
+ */
+func RandomModel(r *rand.Rand, shape ModelShape, weightScale float64) DashformerModelParameters {
+	d, seqLength := shape.EmbedDim, shape.SeqLength
+	dash := DashformerModelParameters{Shape: shape}
+	dash.EmbeddingMatrix = RandomMatrix(r, shape.VocabSize, d, 0.5)
+	dash.EncodingMatrix = RandomMatrix(r, seqLength, d, 0.5)
+	for k := 0; k < max(shape.Blocks, 1); k++ {
+		var b TransformerBlock
+		for h := 0; h < shape.Heads; h++ {
+			b.QueryWeightAttentionMatrixs = append(b.QueryWeightAttentionMatrixs, RandomMatrix(r, d, shape.HeadSize, weightScale))
+			b.QueryBiasAttentionVectors = append(b.QueryBiasAttentionVectors, RandomVector(r, shape.HeadSize, 0.1))
+			b.KeyWeightAttentionMatrixs = append(b.KeyWeightAttentionMatrixs, RandomMatrix(r, d, shape.HeadSize, weightScale))
+			b.KeyBiasAttentionVectors = append(b.KeyBiasAttentionVectors, RandomVector(r, shape.HeadSize, 0.1))
+			b.ValueWeightAttentionMatrixs = append(b.ValueWeightAttentionMatrixs, RandomMatrix(r, d, shape.HeadSize, weightScale))
+			b.ValueBiasAttentionVectors = append(b.ValueBiasAttentionVectors, RandomVector(r, shape.HeadSize, 0.1))
+			b.SoftMaxB = append(b.SoftMaxB, 1+r.Float64())
+			b.SoftMaxC = append(b.SoftMaxC, 50+50*r.Float64())
+		}
+		b.CombineWeightMatrixs = RandomMatrix(r, d, d, weightScale)
+		b.CombineBiasVectors = RandomVector(r, d, 0.1)
+		b.LayerNormVectorR1 = RandomVector(r, d, 1)
+		b.LayerNormVectorB1 = RandomVector(r, d, 0.1)
+		b.LayerNormVectorR2 = RandomVector(r, d, 1)
+		b.LayerNormVectorB2 = RandomVector(r, d, 0.1)
+		b.FeedForwardWeightMatrix1 = RandomMatrix(r, d, shape.FFNDim, weightScale)
+		b.FeedForwardBiasVector1 = RandomVector(r, shape.FFNDim, 0.1)
+		b.FeedForwardWeightMatrix2 = RandomMatrix(r, shape.FFNDim, d, weightScale)
+		b.FeedForwardBiasVector2 = RandomVector(r, d, 0.1)
+		b.LayerNormSqrtVariance1 = RandomVector(r, seqLength, 0.5)
+		b.LayerNormSqrtVariance2 = RandomVector(r, seqLength, 0.5)
+		for i := 0; i < seqLength; i++ {
+			b.LayerNormSqrtVariance1[i] += 1
+			b.LayerNormSqrtVariance2[i] += 1
+		}
+		dash.SetBlock(k, b)
+	}
+	dash.ClassifierWeightMatrix = RandomMatrix(r, d, shape.NumClasses, weightScale)
+	dash.ClassifierBiasVector = RandomVector(r, shape.NumClasses, 0.1)
+	dash.ReluCoefficients = []float64{0.2, 0.5, 0.1}
+	return dash
+}
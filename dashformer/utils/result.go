@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 结果文件的格式
+const (
+	ResultText      = "txt"   // output.txt：每行一条序列的 logits，以制表符分隔 (见 WriteResultToFile)
+	ResultJSONLines = "jsonl" // output.jsonl：每行一个 Result 的 JSON 对象
+	ResultCSV       = "csv"   // output.csv：带表头，每行一条序列的 ID、类别、logits 和概率
+)
+
+// ResultFileName 返回 format 格式的结果文件名
+func ResultFileName(format string) string {
+	return "output." + format
+}
+
+// Result 是一条序列的预测结果，概率为 logits 的 softmax，只在数据方解密后计算
+type Result struct {
+	ID            string    `json:"id"`
+	Class         int       `json:"class"`
+	Label         string    `json:"label,omitempty"`
+	Logits        []float64 `json:"logits"`
+	Probabilities []float64 `json:"probabilities"`
+}
+
+// NewResults 由每条序列的 logits 生成结果：ids 为 nil 时 ID 为行号 (从 1 开始)，labels 不为 nil 时给出类别名
+func NewResults(ids []string, logits [][]float64, labels []string) ([]Result, error) {
+	if ids != nil && len(ids) != len(logits) {
+		return nil, fmt.Errorf("%d IDs for %d results", len(ids), len(logits))
+	}
+	results := make([]Result, len(logits))
+	for i, l := range logits {
+		if labels != nil && len(labels) != len(l) {
+			return nil, fmt.Errorf("%d labels for %d classes", len(labels), len(l))
+		}
+		r := Result{ID: fmt.Sprint(i + 1), Logits: l, Probabilities: Softmax(l)}
+		if ids != nil {
+			r.ID = ids[i]
+		}
+		for c := range l {
+			if l[c] > l[r.Class] {
+				r.Class = c
+			}
+		}
+		if labels != nil {
+			r.Label = labels[r.Class]
+		}
+		results[i] = r
+	}
+	return results, nil
+}
+
+// WriteResults 将结果按 format 写入 fileDir/ResultFileName(format)，返回写入的文件路径。
+// ResultText 保持原来的 output.txt 格式，不使用 labels
+func WriteResults(fileDir, format string, ids []string, logits [][]float64, labels []string) (string, error) {
+	path := filepath.Join(fileDir, ResultFileName(format))
+	if format == ResultText {
+		return path, WriteResultToFile(fileDir, ids, logits)
+	}
+	if format != ResultJSONLines && format != ResultCSV {
+		return "", fmt.Errorf("unknown result format %q, want txt, jsonl or csv", format)
+	}
+
+	results, err := NewResults(ids, logits, labels)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if format == ResultJSONLines {
+		enc := json.NewEncoder(w)
+		for _, r := range results {
+			if err := enc.Encode(r); err != nil {
+				return "", err
+			}
+		}
+	} else if err := writeResultsCSV(w, results, labels); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return path, file.Close()
+}
+
+// writeResultsCSV 写入表头 id,class[,label],logit_<类别>...,probability_<类别>...，类别名为 labels 或类别编号
+func writeResultsCSV(w *bufio.Writer, results []Result, labels []string) error {
+	numClasses := 0
+	if len(results) > 0 {
+		numClasses = len(results[0].Logits)
+	}
+	className := func(c int) string {
+		if labels != nil {
+			return labels[c]
+		}
+		return strconv.Itoa(c)
+	}
+
+	cw := csv.NewWriter(w)
+	header := []string{"id", "class"}
+	if labels != nil {
+		header = append(header, "label")
+	}
+	for c := 0; c < numClasses; c++ {
+		header = append(header, "logit_"+className(c))
+	}
+	for c := 0; c < numClasses; c++ {
+		header = append(header, "probability_"+className(c))
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range results {
+		record := []string{r.ID, strconv.Itoa(r.Class)}
+		if labels != nil {
+			record = append(record, r.Label)
+		}
+		for _, v := range r.Logits {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		for _, v := range r.Probabilities {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadLabels 读取类别名文件，每行一个类别名，按类别编号排列，忽略空行和 # 开头的行
+func ReadLabels(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var labels []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels = append(labels, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%s: no labels", path)
+	}
+	return labels, nil
+}
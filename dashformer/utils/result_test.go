@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteResults(t *testing.T) {
+	dir := t.TempDir()
+	logits := [][]float64{{1, 3}, {2, 0}}
+	labels := []string{"cytoplasm", "nucleus"}
+
+	// JSON Lines：没有 ID 时用行号，概率为 softmax
+	path, err := WriteResults(dir, ResultJSONLines, nil, logits, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var results []Result
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 2 || results[0].ID != "1" || results[0].Class != 1 || results[0].Label != "nucleus" || results[1].Label != "cytoplasm" {
+		t.Fatalf("got %+v", results)
+	}
+	if p := 1 / (1 + math.Exp(-2)); math.Abs(results[0].Probabilities[1]-p) > 1e-12 || !reflect.DeepEqual(results[0].Logits, logits[0]) {
+		t.Errorf("got %+v, want probability %g", results[0], p)
+	}
+
+	// CSV：表头用类别名
+	path, err = WriteResults(dir, ResultCSV, []string{"P1", "P2"}, logits, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if want := "id,class,label,logit_cytoplasm,logit_nucleus,probability_cytoplasm,probability_nucleus"; len(lines) != 3 || lines[0] != want {
+		t.Fatalf("got %q, want header %q", lines, want)
+	}
+	if !strings.HasPrefix(lines[2], "P2,0,cytoplasm,2,0,") {
+		t.Errorf("got %q", lines[2])
+	}
+
+	// txt 保持原来的格式
+	if path, err = WriteResults(dir, ResultText, nil, logits, nil); err != nil || filepath.Base(path) != "output.txt" {
+		t.Fatalf("got %s, %v", path, err)
+	}
+	if data, err = os.ReadFile(path); err != nil || string(data) != "1\t3\n2\t0\n" {
+		t.Errorf("got %q, %v", data, err)
+	}
+
+	if _, err := WriteResults(dir, ResultCSV, nil, logits, labels[:1]); err == nil {
+		t.Error("expected an error for missing labels")
+	}
+	if _, err := WriteResults(dir, "xml", nil, logits, nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	labelsPath := filepath.Join(dir, "labels.txt")
+	if err := os.WriteFile(labelsPath, []byte("# classes\ncytoplasm\n\nnucleus\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if read, err := ReadLabels(labelsPath); err != nil || !reflect.DeepEqual(read, labels) {
+		t.Errorf("got %v, %v, want %v", read, err, labels)
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 长序列的处理方式
+const (
+	LongTruncate = "truncate" // 只保留前 Length 个残基
+	LongWindow   = "window"   // 按 Stride 滑动长度为 Length 的窗口，每个窗口一行
+	LongError    = "error"    // 报错
+)
+
+// SequencePolicy 决定输入序列如何变成模型需要的固定长度，以及如何处理 tokenizer 中没有的残基
+type SequencePolicy struct {
+	// 模型的序列长度 (位置编码的长度)，0 表示保持原长度
+	Length int
+	// 短序列在末尾补的词编号，Keras tokenizer 中 0 保留给补齐
+	PadIndex int
+	// 长序列的处理方式：LongTruncate、LongWindow 或 LongError
+	Long string
+	// LongWindow 相邻窗口起点的距离
+	Stride int
+	// tokenizer 中没有的残基按残基 OOV 编码，为空时报错
+	OOV string
+}
+
+// SequenceLayout 记录模型输入的每一行来自哪条输入序列的哪一段，用于把每行的结果写回输入序列
+type SequenceLayout struct {
+	// 每条输入序列的记录 ID，比赛格式为 nil
+	IDs []string
+	// 第 i 行来自第 Origin[i] 条输入序列的残基 [Starts[i], Ends[i]) (从 0 开始，补齐的位置不算)
+	Origin []int
+	Starts []int
+	Ends   []int
+}
+
+// Sequences 是按 SequencePolicy 编码好的模型输入
+type Sequences struct {
+	SequenceLayout
+	// one-hot 编码的三维张量，每行一个窗口
+	Data [][][]float64
+	// 补齐、截断和替换未知残基的警告，不影响计算
+	Warnings []string
+}
+
+// NumSequences 返回输入序列的条数
+func (l *SequenceLayout) NumSequences() int {
+	if len(l.Origin) == 0 {
+		return 0
+	}
+	return l.Origin[len(l.Origin)-1] + 1
+}
+
+// windowed 判断第 i 行所在的序列是否分成了多个窗口
+func (l *SequenceLayout) windowed(i int) bool {
+	o := l.Origin[i]
+	return (i > 0 && l.Origin[i-1] == o) || (i+1 < len(l.Origin) && l.Origin[i+1] == o)
+}
+
+// sequenceID 返回第 o 条输入序列的 ID，没有 ID 的输入用行号 (从 1 开始)
+func (l *SequenceLayout) sequenceID(o int) string {
+	if l.IDs != nil {
+		return l.IDs[o]
+	}
+	return fmt.Sprint(o + 1)
+}
+
+// RowIDs 返回每一行的 ID：没有 ID 的输入用行号 (从 1 开始)，
+// 分成多个窗口的序列在 ID 后加上窗口的残基范围，如 P12345/26-75。
+// 输入没有 ID 且每条序列只有一行时返回 nil
+func (l *SequenceLayout) RowIDs() []string {
+	if l.IDs == nil && len(l.Origin) == l.NumSequences() {
+		return nil
+	}
+	rowIDs := make([]string, len(l.Origin))
+	for i, o := range l.Origin {
+		rowIDs[i] = l.sequenceID(o)
+		if l.windowed(i) {
+			rowIDs[i] += fmt.Sprintf("/%d-%d", l.Starts[i]+1, l.Ends[i])
+		}
+	}
+	return rowIDs
+}
+
+// EncodeSequences 按 policy 将残基序列编码为 one-hot 张量，ids 为 nil 时警告和错误中用行号表示序列
+func EncodeSequences(ids []string, residues [][]string, tokenizer map[string]int, policy SequencePolicy) (*Sequences, error) {
+	vocabSize := len(tokenizer) + 1
+	if policy.Length > 0 && (policy.PadIndex < 0 || policy.PadIndex >= vocabSize) {
+		return nil, fmt.Errorf("pad index %d is not in the vocabulary of %d tokens", policy.PadIndex, vocabSize)
+	}
+	// OOV 残基只在出现未知残基时才需要在 tokenizer 中
+	oovIndex, hasOOV := tokenizer[strings.ToLower(policy.OOV)]
+
+	sequences := &Sequences{SequenceLayout: SequenceLayout{IDs: ids}}
+	name := func(i int) string {
+		if ids != nil {
+			return "record " + ids[i]
+		}
+		return fmt.Sprintf("line %d", i+1)
+	}
+	for i, seq := range residues {
+		nums := make([]int, len(seq))
+		var unknown []string
+		for j, residue := range seq {
+			num, exists := tokenizer[strings.ToLower(residue)]
+			if !exists {
+				if policy.OOV == "" {
+					return nil, fmt.Errorf("%s: residue %q at position %d is not in the tokenizer", name(i), residue, j+1)
+				}
+				if !hasOOV {
+					return nil, fmt.Errorf("%s: residue %q at position %d is not in the tokenizer, nor the OOV residue %q", name(i), residue, j+1, policy.OOV)
+				}
+				num = oovIndex
+				unknown = append(unknown, residue)
+			}
+			nums[j] = num
+		}
+		if len(unknown) > 0 {
+			sequences.Warnings = append(sequences.Warnings, fmt.Sprintf("%s: %d unknown residues (%s) read as %s", name(i), len(unknown), strings.Join(unknown, " "), policy.OOV))
+		}
+
+		starts := []int{0}
+		switch {
+		case policy.Length <= 0 || len(nums) == policy.Length:
+		case len(nums) < policy.Length:
+			sequences.Warnings = append(sequences.Warnings, fmt.Sprintf("%s: %d residues padded to %d", name(i), len(nums), policy.Length))
+			for len(nums) < policy.Length {
+				nums = append(nums, policy.PadIndex)
+			}
+		case policy.Long == LongWindow:
+			starts = windowStarts(len(nums), policy.Length, policy.Stride)
+			sequences.Warnings = append(sequences.Warnings, fmt.Sprintf("%s: %d residues split into %d windows", name(i), len(nums), len(starts)))
+		case policy.Long == LongError:
+			return nil, fmt.Errorf("%s: %d residues, longer than the %d of the model", name(i), len(nums), policy.Length)
+		default:
+			sequences.Warnings = append(sequences.Warnings, fmt.Sprintf("%s: %d residues truncated to %d", name(i), len(nums), policy.Length))
+		}
+
+		for _, start := range starts {
+			end := len(nums)
+			if policy.Length > 0 {
+				end = start + policy.Length
+			}
+			sequences.Data = append(sequences.Data, oneHotSequence(nums[start:end], vocabSize))
+			sequences.Origin = append(sequences.Origin, i)
+			sequences.Starts = append(sequences.Starts, start)
+			sequences.Ends = append(sequences.Ends, min(end, len(seq)))
+		}
+	}
+	return sequences, nil
+}
+
+// windowStarts 返回长度为 n 的序列中长度为 length 的窗口的起点，相邻起点相距 stride，最后一个窗口与序列末尾对齐
+func windowStarts(n, length, stride int) []int {
+	if stride <= 0 {
+		stride = length
+	}
+	var starts []int
+	for start := 0; start+length < n; start += stride {
+		starts = append(starts, start)
+	}
+	return append(starts, n-length)
+}
+
+// oneHotSequence 将一条序列的词编号转换为 one-hot 编码，每个向量的长度为 vocabSize
+func oneHotSequence(nums []int, vocabSize int) [][]float64 {
+	oneHotMatrix := make([][]float64, len(nums))
+	for i, num := range nums {
+		oneHotVec := make([]float64, vocabSize) // 生成one-hot向量
+		oneHotVec[num] = 1.0
+		oneHotMatrix[i] = oneHotVec
+	}
+	return oneHotMatrix
+}
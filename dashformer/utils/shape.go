@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ModelShape 是模型的维数，参数文件按这些维数读取
+type ModelShape struct {
+	// 序列长度 (位置编码的行数)
+	SeqLength int `json:"seq_length"`
+	// 词表大小 (embedding 的行数，tokenizer 的词数加 1)
+	VocabSize int `json:"vocab_size"`
+	// embedding 宽度，等于 Heads*HeadSize
+	EmbedDim int `json:"embed_dim"`
+	Heads    int `json:"heads"`
+	HeadSize int `json:"head_size"`
+	// FFN 隐藏层宽度
+	FFNDim     int `json:"ffn_dim"`
+	NumClasses int `json:"num_classes"`
+	// Transformer 块数，所有块的维数相同
+	Blocks int `json:"blocks"`
+}
+
+// DefaultModelShape 返回发布的 Dashformer 模型的维数
+func DefaultModelShape() ModelShape {
+	return ModelShape{
+		SeqLength:  50,
+		VocabSize:  25,
+		EmbedDim:   128,
+		Heads:      4,
+		HeadSize:   32,
+		FFNDim:     256,
+		NumClasses: 25,
+		Blocks:     1,
+	}
+}
+
+// Validate 检查维数为正且 EmbedDim 等于 Heads*HeadSize
+func (s ModelShape) Validate() error {
+	var errs []string
+	for _, dim := range []struct {
+		name  string
+		value int
+	}{
+		{"seq_length", s.SeqLength}, {"vocab_size", s.VocabSize}, {"embed_dim", s.EmbedDim}, {"heads", s.Heads},
+		{"head_size", s.HeadSize}, {"ffn_dim", s.FFNDim}, {"num_classes", s.NumClasses}, {"blocks", s.Blocks},
+	} {
+		if dim.value <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be positive, got %d", dim.name, dim.value))
+		}
+	}
+	if s.Heads*s.HeadSize != s.EmbedDim {
+		errs = append(errs, fmt.Sprintf("heads %d × head_size %d must equal embed_dim %d", s.Heads, s.HeadSize, s.EmbedDim))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid model shape: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
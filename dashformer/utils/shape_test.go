@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadModelShape(t *testing.T) {
+	dir := t.TempDir()
+
+	// 没有 model.json 时使用发布模型的维数
+	if shape, err := ReadModelShape(dir); err != nil || shape != DefaultModelShape() {
+		t.Fatalf("got %+v, %v, want the default shape", shape, err)
+	}
+
+	// 缺少的字段取默认值
+	path := filepath.Join(dir, ModelManifestName)
+	if err := os.WriteFile(path, []byte(`{"shape": {"seq_length": 100, "heads": 8, "head_size": 16}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultModelShape()
+	want.SeqLength, want.Heads, want.HeadSize = 100, 8, 16
+	if shape, err := ReadModelShape(dir); err != nil || shape != want {
+		t.Fatalf("got %+v, %v, want %+v", shape, err, want)
+	}
+
+	// heads × head_size 必须等于 embed_dim
+	if err := os.WriteFile(path, []byte(`{"shape": {"heads": 3}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadModelShape(dir); err == nil {
+		t.Error("expected an error for heads × head_size != embed_dim")
+	}
+}
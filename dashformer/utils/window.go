@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 多个窗口的 logits 合成一条序列的 logits 的方式
+const (
+	AggregateNone      = "none"      // 不合并，每个窗口一行
+	AggregateMean      = "mean"      // 各窗口 logits 的平均
+	AggregateMax       = "max"       // 每一类取各窗口 logits 的最大值
+	AggregateAttention = "attention" // 以各窗口最大 logit 的 softmax 为权重的加权平均，置信度高的窗口权重大
+)
+
+// Aggregate 按 method 合并同一条输入序列各窗口的 logits，返回每条输入序列的 ID 和 logits。
+// 没有 ID 的输入返回的 ID 为 nil；AggregateNone 返回 RowIDs 和原来的 logits
+func (l *SequenceLayout) Aggregate(logits [][]float64, method string) ([]string, [][]float64, error) {
+	if len(logits) != len(l.Origin) {
+		return nil, nil, fmt.Errorf("%d results for %d rows of the input", len(logits), len(l.Origin))
+	}
+	switch method {
+	case AggregateNone:
+		return l.RowIDs(), logits, nil
+	case AggregateMean, AggregateMax, AggregateAttention:
+	default:
+		return nil, nil, fmt.Errorf("unknown aggregation %q, want none, mean, max or attention", method)
+	}
+
+	aggregated := make([][]float64, 0, l.NumSequences())
+	for start := 0; start < len(logits); {
+		end := start + 1
+		for end < len(logits) && l.Origin[end] == l.Origin[start] {
+			end++
+		}
+		aggregated = append(aggregated, aggregateWindows(logits[start:end], method))
+		start = end
+	}
+	return l.IDs, aggregated, nil
+}
+
+// aggregateWindows 合并一条序列各窗口的 logits
+func aggregateWindows(windows [][]float64, method string) []float64 {
+	if len(windows) == 1 {
+		return windows[0]
+	}
+
+	weights := make([]float64, len(windows))
+	switch method {
+	case AggregateMax:
+		result := append([]float64(nil), windows[0]...)
+		for _, w := range windows[1:] {
+			for c := range result {
+				result[c] = math.Max(result[c], w[c])
+			}
+		}
+		return result
+	case AggregateAttention:
+		// 窗口的得分是它的最大 logit，权重为得分的 softmax
+		scores := make([]float64, len(windows))
+		for i, w := range windows {
+			scores[i] = w[0]
+			for _, v := range w {
+				scores[i] = math.Max(scores[i], v)
+			}
+		}
+		copy(weights, Softmax(scores))
+	default:
+		for i := range weights {
+			weights[i] = 1 / float64(len(windows))
+		}
+	}
+
+	result := make([]float64, len(windows[0]))
+	for i, w := range windows {
+		for c := range result {
+			result[c] += weights[i] * w[c]
+		}
+	}
+	return result
+}
+
+/*
+ * 序列布局文件：encrypt 写入，decrypt 读取，只留在数据方
+ * 每行对应模型输入的一行，以制表符分隔：记录 ID (比赛格式为空)、输入序列编号、首个残基、最后一个残基 (从 1 开始)
+ */
+
+// WriteSequenceLayout 将 l 写入 path
+func WriteSequenceLayout(path string, l *SequenceLayout) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	fmt.Fprintln(w, "# id\tsequence\tstart\tend")
+	for i, o := range l.Origin {
+		id := ""
+		if l.IDs != nil {
+			id = l.IDs[o]
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", id, o+1, l.Starts[i]+1, l.Ends[i])
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// ReadSequenceLayout 读取 WriteSequenceLayout 写入的序列布局
+func ReadSequenceLayout(path string) (*SequenceLayout, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	l := &SequenceLayout{}
+	var ids []string
+	hasIDs := false
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s line %d: want 4 tab-separated fields, got %d", path, lineNum, len(fields))
+		}
+		var nums [3]int
+		for j := range nums {
+			if nums[j], err = strconv.Atoi(fields[j+1]); err != nil {
+				return nil, fmt.Errorf("%s line %d: %v", path, lineNum, err)
+			}
+		}
+		o := nums[0] - 1
+		switch {
+		case o == l.NumSequences():
+			ids = append(ids, fields[0])
+			hasIDs = hasIDs || fields[0] != ""
+		case len(l.Origin) == 0 || o != l.Origin[len(l.Origin)-1]:
+			return nil, fmt.Errorf("%s line %d: sequence %d out of order", path, lineNum, nums[0])
+		}
+		l.Origin = append(l.Origin, o)
+		l.Starts = append(l.Starts, nums[1]-1)
+		l.Ends = append(l.Ends, nums[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if hasIDs {
+		l.IDs = ids
+	}
+	return l, nil
+}
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateWindows(t *testing.T) {
+	// 第 2 条序列分成 2 个窗口
+	layout := &SequenceLayout{
+		IDs:    []string{"P1", "P2"},
+		Origin: []int{0, 1, 1},
+		Starts: []int{0, 0, 25},
+		Ends:   []int{30, 50, 75},
+	}
+	logits := [][]float64{{1, 2}, {0, 4}, {2, 0}}
+
+	for _, tc := range []struct {
+		method string
+		want   []float64
+	}{
+		{AggregateMean, []float64{1, 2}},
+		{AggregateMax, []float64{2, 4}},
+		// 得分 4 和 2，权重 e^2/(e^2+1) 和 1/(e^2+1)
+		{AggregateAttention, []float64{2 / (math.Exp(2) + 1), 4 * math.Exp(2) / (math.Exp(2) + 1)}},
+	} {
+		ids, aggregated, err := layout.Aggregate(logits, tc.method)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids, layout.IDs) || len(aggregated) != 2 || !reflect.DeepEqual(aggregated[0], logits[0]) {
+			t.Fatalf("%s: got %v %v", tc.method, ids, aggregated)
+		}
+		for c := range tc.want {
+			if math.Abs(aggregated[1][c]-tc.want[c]) > 1e-12 {
+				t.Errorf("%s: got %v, want %v", tc.method, aggregated[1], tc.want)
+			}
+		}
+	}
+
+	ids, aggregated, err := layout.Aggregate(logits, AggregateNone)
+	if want := []string{"P1", "P2/1-50", "P2/26-75"}; err != nil || !reflect.DeepEqual(ids, want) || len(aggregated) != 3 {
+		t.Errorf("none: got %v, %v, want %v", ids, err, want)
+	}
+	if _, _, err := layout.Aggregate(logits[:2], AggregateMean); err == nil {
+		t.Error("expected an error for missing results")
+	}
+
+	// 布局文件读回相同的布局，比赛格式没有 ID
+	path := filepath.Join(t.TempDir(), "input.ids")
+	for _, l := range []*SequenceLayout{layout, {Origin: []int{0, 0, 1}, Starts: []int{0, 10, 0}, Ends: []int{50, 60, 50}}} {
+		if err := WriteSequenceLayout(path, l); err != nil {
+			t.Fatal(err)
+		}
+		read, err := ReadSequenceLayout(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, l) {
+			t.Errorf("got %+v, want %+v", read, l)
+		}
+	}
+}